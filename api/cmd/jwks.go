@@ -0,0 +1,18 @@
+package main
+
+import (
+	"interviews/pkg/logger"
+	"net/http"
+)
+
+func (app *application) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "public, max-age=300")
+
+	err := app.helper.WriteJSON(w, http.StatusOK, envelope{"keys": app.keys.JWKS()}, headers)
+	if err != nil {
+		app.logger.ErrorCtx(err, logger.Ctx{
+			"msg": "Unable to write jwks response",
+		})
+	}
+}
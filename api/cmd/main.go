@@ -6,13 +6,25 @@ import (
 	_ "github.com/lib/pq"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+	"interviews/internal/apikeys"
+	"interviews/internal/audit"
 	"interviews/internal/auth"
+	"interviews/internal/billing"
 	"interviews/internal/config"
 	"interviews/internal/courses"
+	"interviews/internal/jobs"
 	"interviews/internal/login"
+	"interviews/internal/mailer"
+	"interviews/internal/metrics"
+	"interviews/internal/oidc"
+	"interviews/internal/orgs"
+	"interviews/internal/password"
+	"interviews/internal/profile"
 	data "interviews/internal/users"
 	"interviews/pkg"
 	clogger "interviews/pkg/logger"
+	"net/http"
 	"strings"
 	"sync"
 )
@@ -22,6 +34,12 @@ type application struct {
 	logger     *clogger.Logger
 	courses    *courses.Courses
 	login      *login.Login
+	apiKeys    *apikeys.APIKeys
+	orgs       *orgs.Orgs
+	profile    *profile.Profile
+	billing    *billing.Billing
+	keys       *auth.KeySet
+	metrics    *metrics.Metrics
 	middleware *MiddleWare
 	helper     pkg.Helper
 	db         *mongo.Client
@@ -41,7 +59,54 @@ func main() {
 
 	clog.Info("Starting Application")
 
+	keys, err := auth.LoadKeySet(*cfg)
+	if err != nil {
+		clog.Error(err)
+
+		return
+	}
+
+	passwords := password.Policy{
+		MinLength:  cfg.Password.MinLength,
+		MinClasses: cfg.Password.MinClasses,
+	}
+
+	argon2id := password.DefaultArgon2id
+	argon2id.Memory = cfg.Password.Argon2Memory
+	argon2id.Iterations = cfg.Password.Argon2Iterations
+	argon2id.Parallelism = cfg.Password.Argon2Parallelism
+
+	err = argon2id.Validate()
+	if err != nil {
+		clog.Error(err)
+
+		return
+	}
+
+	hasher := password.NewChain(argon2id, password.Bcrypt{Cost: bcrypt.DefaultCost})
+
+	if cfg.Password.BreachedListFile != "" {
+		passwords.Breached, err = password.LoadBreachedList(cfg.Password.BreachedListFile)
+		if err != nil {
+			clog.Error(err)
+
+			return
+		}
+
+		clog.InfoCtx("loaded breached password list", clogger.Ctx{
+			"hashes": passwords.Breached.Len(),
+		})
+	}
+
+	providers, err := oidc.LoadProviderConfigs(cfg.OIDC.Providers, cfg.FrontendURL)
+	if err != nil {
+		clog.Error(err)
+
+		return
+	}
+
 	flag.IntVar(&cfg.Port, "port", cfg.Port, "API server port")
+	flag.IntVar(&cfg.MetricsPort, "metrics-port", cfg.MetricsPort, "Internal metrics server port")
 	flag.StringVar(&cfg.Env, "env", cfg.Env, "Environment (development|staging|production)")
 
 	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated)", func(val string) error {
@@ -50,8 +115,12 @@ func main() {
 		return nil
 	})
 
+	// prometheus metrics, the mongo commands are timed as well
+	appMetrics := metrics.New()
+
 	// mongo database connection
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.MongoConfig.Url))
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.MongoConfig.Url).
+		SetMonitor(appMetrics.CommandMonitor()))
 	if err != nil {
 		clog.Error(err)
 	}
@@ -62,26 +131,146 @@ func main() {
 		}
 	}()
 
-	// courses
-	courseCollection := client.Database(cfg.MongoConfig.DBName).Collection(cfg.MongoConfig.CourseCollection)
-	courseRepo := courses.NewCourseRepository(client, courseCollection)
-	courseService := courses.NewCoursesService(courseRepo)
+	// a session per device the user is logged in on
+	sessionCollection := client.Database(cfg.MongoConfig.DBName).Collection(cfg.MongoConfig.SessionCollection)
+	sessionRepo := auth.NewSessionRepository(client, sessionCollection)
+
+	err = sessionRepo.CreateIndexes(ctx)
+	if err != nil {
+		clog.Error(err)
+
+		return
+	}
 
 	//jwt token
 	tokenCollection := client.Database(cfg.MongoConfig.DBName).Collection(cfg.MongoConfig.TokenCollection)
-	tokenRepo := auth.NewTokenRepository(client, tokenCollection)
+	tokenRepo := auth.NewTokenRepository(client, tokenCollection, sessionRepo, keys, *cfg)
+
+	// one-time tokens sent by email
+	scopedTokenCollection := client.Database(cfg.MongoConfig.DBName).Collection(cfg.MongoConfig.ScopedTokenCollection)
+	scopedTokenRepo := auth.NewScopedTokenRepository(client, scopedTokenCollection)
+
+	err = scopedTokenRepo.CreateIndexes(ctx)
+	if err != nil {
+		clog.Error(err)
+
+		return
+	}
+
+	// external identity providers
+	oidcStateCollection := client.Database(cfg.MongoConfig.DBName).Collection(cfg.MongoConfig.OIDCStateCollection)
+	oidcStateRepo := oidc.NewStateRepository(client, oidcStateCollection)
+	oidcService := oidc.NewService(providers, oidcStateRepo, &http.Client{Timeout: cfg.OIDC.HTTPTimeout},
+		cfg.TokenConfig.ClockSkew, cfg.OIDC.StateTTL)
+	oidcService.StartRefresh(ctx)
+
+	// mail
+	mail := mailer.New(cfg.Smtp.Host, cfg.Smtp.Port, cfg.Smtp.Username, cfg.Smtp.Password, cfg.Smtp.Sender)
 
 	// user
 	userCollection := client.Database(cfg.MongoConfig.DBName).Collection(cfg.UserConfig.UserCollection)
-	userRepo := data.NewUserRepository(client, userCollection, tokenRepo)
+	userRepo := data.NewUserRepository(client, userCollection)
+
+	// older versions stored the plaintext password next to the hash
+	purged, err := userRepo.PurgePlaintextPasswords(ctx)
+	if err != nil {
+		clog.Error(err)
+	} else if purged > 0 {
+		clog.InfoCtx("removed stored plaintext passwords", clogger.Ctx{
+			"users": purged,
+		})
+	}
+
+	// security relevant actions
+	auditCollection := client.Database(cfg.MongoConfig.DBName).Collection(cfg.MongoConfig.AuditCollection)
+	auditRepo := audit.NewAuditRepository(client, auditCollection)
+
+	// api keys for internal services
+	apiKeyCollection := client.Database(cfg.MongoConfig.DBName).Collection(cfg.MongoConfig.APIKeyCollection)
+	apiKeyRepo := apikeys.NewAPIKeyRepository(client, apiKeyCollection)
+	apiKeyService := apikeys.NewAPIKeysService(apiKeyRepo, *cfg)
+
+	// organizations buying access for their teams
+	orgCollection := client.Database(cfg.MongoConfig.DBName).Collection(cfg.MongoConfig.OrgCollection)
+	orgMemberCollection := client.Database(cfg.MongoConfig.DBName).Collection(cfg.MongoConfig.OrgMemberCollection)
+	orgInviteCollection := client.Database(cfg.MongoConfig.DBName).Collection(cfg.MongoConfig.OrgInviteCollection)
+	orgRepo := orgs.NewOrgRepository(client, orgCollection)
+	orgMemberRepo := orgs.NewMemberRepository(client, orgMemberCollection)
+	orgService := orgs.NewOrgsService(orgRepo, orgMemberRepo,
+		orgs.NewInviteRepository(client, orgInviteCollection), mail, *cfg)
+
+	// older versions counted the members of an org on every join
+	counted, err := orgService.CountMembers(ctx)
+	if err != nil {
+		clog.Error(err)
+	} else if counted > 0 {
+		clog.InfoCtx("counted org members", clogger.Ctx{
+			"orgs": counted,
+		})
+	}
+
+	// subscription plans and who can access the paid content
+	planCollection := client.Database(cfg.MongoConfig.DBName).Collection(cfg.MongoConfig.PlanCollection)
+	subscriptionCollection := client.Database(cfg.MongoConfig.DBName).Collection(cfg.MongoConfig.SubscriptionCollection)
+	planRepo := billing.NewPlanRepository(client, planCollection)
+	subscriptionRepo := billing.NewSubscriptionRepository(client, subscriptionCollection)
+	couponCollection := client.Database(cfg.MongoConfig.DBName).Collection(cfg.MongoConfig.CouponCollection)
+	redemptionCollection := client.Database(cfg.MongoConfig.DBName).Collection(cfg.MongoConfig.RedemptionCollection)
+	redemptionRepo := billing.NewRedemptionRepository(client, redemptionCollection)
+
+	err = redemptionRepo.CreateIndexes(ctx)
+	if err != nil {
+		clog.Error(err)
+
+		return
+	}
+
+	paymentEventCollection := client.Database(cfg.MongoConfig.DBName).Collection(cfg.MongoConfig.PaymentEventCollection)
+	paymentProvider := billing.NewStripeClient(cfg.Payments.ProviderURL, cfg.Payments.SecretKey,
+		&http.Client{Timeout: cfg.Payments.HTTPTimeout})
+	billingService := billing.NewBillingService(planRepo, subscriptionRepo,
+		orgMemberRepo, orgRepo, userRepo, billing.NewCouponRepository(client, couponCollection),
+		redemptionRepo, paymentProvider,
+		billing.NewWebhookEventRepository(client, paymentEventCollection), mail, *cfg)
+	entitlements := billing.NewEntitlements(subscriptionRepo, planRepo, orgMemberRepo, orgRepo)
 
 	// login
 	loginCollection := client.Database(cfg.MongoConfig.DBName).Collection(cfg.MongoConfig.LoginCollection)
-	loginRepo := login.NewLoginRepository(client, loginCollection)
-	loginService := login.NewLoginService(loginRepo, tokenRepo, userRepo)
+	loginRepo := login.NewLoginRepository(client, loginCollection, hasher)
+	attemptCollection := client.Database(cfg.MongoConfig.DBName).Collection(cfg.MongoConfig.LoginAttemptCollection)
+	attemptsRepo := login.NewAttemptsRepository(client, attemptCollection)
+
+	err = attemptsRepo.CreateIndexes(ctx)
+	if err != nil {
+		clog.Error(err)
+
+		return
+	}
+	loginService := login.NewLoginService(loginRepo, tokenRepo, userRepo, scopedTokenRepo, attemptsRepo, sessionRepo, auditRepo, oidcService, billingService, mail, passwords, *cfg)
+
+	// courses
+	courseCollection := client.Database(cfg.MongoConfig.DBName).Collection(cfg.MongoConfig.CourseCollection)
+	courseRepo := courses.NewCourseRepository(client, courseCollection)
+	courseService := courses.NewCoursesService(courseRepo, entitlements)
+
+	// profile of the logged in user
+	exportCollection := client.Database(cfg.MongoConfig.DBName).Collection(cfg.MongoConfig.DataExportCollection)
+	profileService := profile.NewProfileService(userRepo, scopedTokenRepo, tokenRepo, sessionRepo,
+		orgMemberRepo, orgRepo, orgs.NewInviteRepository(client, orgInviteCollection), subscriptionRepo,
+		redemptionRepo, attemptsRepo, profile.NewExportRepository(client, exportCollection), auditRepo, mail, *cfg)
+
+	// erases accounts once their deletion grace period is over and finishes
+	// interrupted email changes
+	go profileService.StartDeletionWorker(ctx)
+
+	// jobs that must only run on one instance at a time
+	jobCollection := client.Database(cfg.MongoConfig.DBName).Collection(cfg.MongoConfig.JobCollection)
+	scheduler := jobs.NewScheduler(jobs.NewJobRepository(client, jobCollection), cfg.Jobs.PollInterval, cfg.Jobs.Lease)
+	scheduler.Register("trials", cfg.Trials.JobInterval, billingService.ProcessTrials)
+	go scheduler.Start(ctx)
 
 	// middleware
-	middleware := NewMiddleware(*cfg, data.UsersContext{}, *userRepo, *tokenRepo)
+	middleware := NewMiddleware(*cfg, data.UsersContext{}, *userRepo, *tokenRepo, *sessionRepo, *apiKeyRepo, auditRepo, appMetrics)
 
 	app := &application{
 		config:     cfg,
@@ -90,6 +279,12 @@ func main() {
 		courses:    courseService,
 		middleware: middleware,
 		login:      loginService,
+		apiKeys:    apiKeyService,
+		orgs:       orgService,
+		profile:    profileService,
+		billing:    billingService,
+		keys:       keys,
+		metrics:    appMetrics,
 	}
 
 	err = app.serve()
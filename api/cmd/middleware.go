@@ -3,20 +3,22 @@ package main
 import (
 	"context"
 	"errors"
-	"expvar"
 	"fmt"
+	"interviews/internal/apikeys"
+	"interviews/internal/audit"
 	"interviews/internal/auth"
 	"interviews/internal/config"
+	"interviews/internal/metrics"
 	data "interviews/internal/users"
 	"interviews/pkg"
 	log "interviews/pkg/logger"
 	"net/http"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/felixge/httpsnoop"
+	"github.com/gorilla/mux"
 	"github.com/tomasen/realip"
 	"golang.org/x/time/rate"
 )
@@ -33,21 +35,29 @@ type MiddleWare struct {
 	userCtx   data.UsersContext
 	users     data.UserRepo
 	tokenRepo auth.TokenRepository
+	sessions  auth.SessionRepository
+	apiKeys   apikeys.APIKeyRepo
+	audit     *audit.Repository
+	metrics   *metrics.Metrics
 	e         pkg.CustomErrors
 }
 
 func (app *MiddleWare) Authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := context.Background()
-		clog := log.GetLoggerFromContext(ctx)
 
 		w.Header().Add("Vary", "Authorization")
+		w.Header().Add("Vary", "X-API-Key")
+
+		if apiKey := r.Header.Get("X-API-Key"); apiKey != "" {
+			app.authenticateAPIKey(w, r, next, apiKey)
+			return
+		}
 
 		authorizationHeader := r.Header.Get("Authorization")
 
 		if authorizationHeader == "" {
-			r = app.userCtx.ContextSetUser(r, data.AnonymousUser)
-			next.ServeHTTP(w, r)
+			app.authenticateCookie(w, r, next)
 			return
 		}
 
@@ -57,26 +67,183 @@ func (app *MiddleWare) Authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		token := headerParts[1]
+		r, ok := app.authenticateToken(ctx, w, r, headerParts[1])
+		if !ok {
+			app.e.InvalidAuthenticationTokenResponse(w, r)
+			return
+		}
 
-		email, err := app.tokenRepo.GetEmailFromJWT(token)
-		if err != nil {
-			//TODO: add a log here
+		if app.userCtx.ContextGetUser(r).Suspended {
+			app.e.AccountSuspendedResponse(w, r)
 			return
 		}
 
-		user, err := app.users.GetByEmail(email)
-		if err != nil {
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticateCookie lets browsers in with the access token of the session
+// cookie. Browsers send the cookie on cross-site requests as well, so
+// state-changing requests also have to echo the CSRF cookie in a header.
+func (app *MiddleWare) authenticateCookie(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	ctx := context.Background()
+
+	if !app.cfg.Cookies.Enabled {
+		r = app.userCtx.ContextSetUser(r, data.AnonymousUser)
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	w.Header().Add("Vary", "Cookie")
+
+	cookie, err := r.Cookie(app.cfg.Cookies.Name)
+	if err != nil || cookie.Value == "" {
+		r = app.userCtx.ContextSetUser(r, data.AnonymousUser)
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	if !auth.SafeMethod(r.Method) && !auth.ValidCSRF(r, app.cfg) {
+		app.e.InvalidCSRFTokenResponse(w, r)
+		return
+	}
+
+	authenticated, ok := app.authenticateToken(ctx, w, r, cookie.Value)
+	if !ok {
+		// an expired cookie mustn't keep the user from logging in again
+		auth.ClearSessionCookies(w, app.cfg)
+		r = app.userCtx.ContextSetUser(r, data.AnonymousUser)
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	if app.userCtx.ContextGetUser(authenticated).Suspended {
+		app.e.AccountSuspendedResponse(w, authenticated)
+		return
+	}
+
+	next.ServeHTTP(w, authenticated)
+}
+
+// authenticateToken checks the access token and its session and stores the
+// user in the request context.
+func (app *MiddleWare) authenticateToken(ctx context.Context, w http.ResponseWriter, r *http.Request,
+	token string) (*http.Request, bool) {
+	clog := log.GetLoggerFromContext(ctx)
+
+	claims, err := app.tokenRepo.ValidateBearerToken(token)
+	if err != nil {
+		clog.WarnCtx("rejected bearer token", log.Ctx{
+			"error": err.Error(),
+		})
+		return r, false
+	}
+
+	user, err := app.users.GetByEmail(claims.Email)
+	if err != nil {
+		clog.ErrorCtx(err, log.Ctx{
+			"msg": "error getting user by email",
+		})
+		return r, false
+	}
+
+	// tokens issued before a password reset are no longer accepted
+	if claims.IssuedAt < user.SessionsRevokedAt.Unix() {
+		return r, false
+	}
+
+	// signing out a device deletes its session
+	session, err := app.sessions.Get(ctx, claims.SessionID)
+	if err != nil || session.Email != user.Email {
+		if err != nil && !errors.Is(err, auth.ErrSessionNotFound) {
 			clog.ErrorCtx(err, log.Ctx{
-				"msg": "error getting user by email",
+				"msg": "error getting session",
 			})
-			return
 		}
+		return r, false
+	}
 
-		r = app.userCtx.ContextSetUser(r, user)
+	err = app.sessions.Touch(ctx, session, realip.FromRequest(r))
+	if err != nil {
+		clog.ErrorCtx(err, log.Ctx{
+			"msg": "error updating session",
+		})
+	}
 
-		next.ServeHTTP(w, r)
+	r = app.userCtx.ContextSetUser(r, user)
+	r = app.userCtx.ContextSetAuthMethods(r, claims.AuthMethods)
+	r = app.userCtx.ContextSetSessionID(r, session.ID)
+
+	if claims.Actor != nil {
+		// the admin may have lost the permission since the token was issued
+		actor, err := app.users.GetByEmail(claims.Actor.Subject)
+		if err != nil || !actor.HasPermission(data.PermissionUsersImpersonate) {
+			return r, false
+		}
+
+		r = app.userCtx.ContextSetActor(r, actor)
+
+		w.Header().Set("X-Impersonated-By", actor.Email)
+
+		if !auth.SafeMethod(r.Method) {
+			app.recordImpersonatedRequest(ctx, r, actor, user)
+		}
+	}
+
+	return r, true
+}
+
+// recordImpersonatedRequest keeps a trail of what an admin changed while
+// acting as the user.
+func (app *MiddleWare) recordImpersonatedRequest(ctx context.Context, r *http.Request, actor, user *data.User) {
+	device := auth.DeviceFromRequest(r)
+
+	err := app.audit.Record(ctx, audit.Event{
+		Action:    audit.ActionImpersonatedRequest,
+		Actor:     actor.Email,
+		Subject:   user.Email,
+		IP:        device.IP,
+		UserAgent: device.UserAgent,
+		Details: map[string]string{
+			"method": r.Method,
+			"path":   r.URL.Path,
+		},
+	})
+	if err != nil {
+		log.ErrorCtx(err, log.Ctx{
+			"msg": "unable to write audit log",
+		})
+	}
+}
+
+// authenticateAPIKey lets internal services in as a service principal that
+// only has the permissions of its key.
+func (app *MiddleWare) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, plaintext string) {
+	ctx := context.Background()
+	clog := log.GetLoggerFromContext(ctx)
+
+	key, err := app.apiKeys.Authenticate(ctx, plaintext)
+	if err != nil {
+		if !errors.Is(err, apikeys.ErrInvalidKey) {
+			clog.ErrorCtx(err, log.Ctx{
+				"msg": "error authenticating api key",
+			})
+		}
+		app.e.InvalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	r = app.userCtx.ContextSetUser(r, &data.User{
+		Name:      key.Name,
+		Activated: true,
+		Service: &data.ServicePrincipal{
+			KeyID:       key.ID.Hex(),
+			Name:        key.Name,
+			Permissions: key.Permissions,
+		},
 	})
+
+	next.ServeHTTP(w, r)
 }
 
 func (app *MiddleWare) RecoverPanic(next http.Handler) http.Handler {
@@ -135,6 +302,7 @@ func (app *MiddleWare) RateLimit(next http.Handler) http.Handler {
 
 			if !clients[ip].limiter.Allow() {
 				mu.Unlock()
+				app.metrics.RateLimited()
 				app.e.RateLimitExceededResponse(w, r)
 				return
 			}
@@ -159,10 +327,17 @@ func (app *MiddleWare) requireAuthenticatedUser(next http.HandlerFunc) http.Hand
 	})
 }
 
+// RequireActivatedUser guards endpoints acting on the user's own account, so
+// services authenticated with an API key are turned away.
 func (app *MiddleWare) RequireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
 	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.userCtx.ContextGetUser(r)
 
+		if user.IsService() {
+			app.e.NotPermittedResponse(w, r)
+			return
+		}
+
 		if !user.Activated {
 			app.e.InactiveAccountResponse(w, r)
 			return
@@ -174,6 +349,85 @@ func (app *MiddleWare) RequireActivatedUser(next http.HandlerFunc) http.HandlerF
 	return app.requireAuthenticatedUser(fn)
 }
 
+// RequireRealUser turns away admins impersonating the user, for operations on
+// the account that only its owner may perform.
+func (app *MiddleWare) RequireRealUser(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.userCtx.ContextGetActor(r) != nil {
+			app.e.ImpersonationNotAllowedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequirePermission only lets activated users through whose role grants the
+// permission, or services whose API key does. Impersonated requests never
+// get in, so an admin can't borrow the permissions of the user.
+func (app *MiddleWare) RequirePermission(permission string, next http.HandlerFunc) http.HandlerFunc {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.userCtx.ContextGetUser(r)
+
+		if app.userCtx.ContextGetActor(r) != nil {
+			app.e.ImpersonationNotAllowedResponse(w, r)
+			return
+		}
+
+		if !user.HasPermission(permission) {
+			app.e.NotPermittedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+
+	user := app.RequireActivatedUser(app.RequireTwoFactor(fn))
+	service := app.requireAuthenticatedUser(fn)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.userCtx.ContextGetUser(r).IsService() {
+			service.ServeHTTP(w, r)
+			return
+		}
+
+		user.ServeHTTP(w, r)
+	})
+}
+
+// RequireTwoFactor rejects users who can edit courses unless their token was
+// issued after a two-factor code was checked.
+func (app *MiddleWare) RequireTwoFactor(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.userCtx.ContextGetUser(r)
+
+		if app.twoFactorRequired(user) && !hasAuthMethod(app.userCtx.ContextGetAuthMethods(r), auth.AuthMethodOTP) {
+			app.e.TwoFactorRequiredResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (app *MiddleWare) twoFactorRequired(user *data.User) bool {
+	if user.IsAnonymous() {
+		return false
+	}
+
+	return user.HasPermission(data.PermissionCoursesWrite)
+}
+
+func hasAuthMethod(methods []string, method string) bool {
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (app *MiddleWare) EnableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Vary", "Origin")
@@ -188,11 +442,17 @@ func (app *MiddleWare) EnableCORS(next http.Handler) http.Handler {
 			for i := range app.cfg.Cors.TrustedOrigins {
 				if origin == app.cfg.Cors.TrustedOrigins[i] {
 					w.Header().Set("Access-Control-Allow-Origin", origin)
+					w.Header().Set("Access-Control-Expose-Headers", "X-Impersonated-By")
+
+					// the session cookie is only sent along with credentials allowed
+					if app.cfg.Cookies.Enabled {
+						w.Header().Set("Access-Control-Allow-Credentials", "true")
+					}
 
 					if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
 
 						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
-						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, "+auth.CSRFHeader)
 						w.WriteHeader(http.StatusOK)
 
 						return
@@ -207,35 +467,39 @@ func (app *MiddleWare) EnableCORS(next http.Handler) http.Handler {
 	})
 }
 
-func (app *MiddleWare) Metrics(next http.Handler) http.Handler {
-	totalRequestsReceived := expvar.NewInt("total_requests_received")
-	totalResponsesSent := expvar.NewInt("total_responses_sent")
-	totalProcessingTimeMicroseconds := expvar.NewInt("total_processing_time_μs")
-
-	totalResponsesSentByStatus := expvar.NewMap("total_responses_sent_by_status")
-
+// Metrics records the duration of every request by the template of the route
+// it matched. Requests that match no route are recorded as unmatched, the raw
+// paths would create a series per scanned url.
+func (app *MiddleWare) Metrics(router *mux.Router, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unmatched"
 
-		totalRequestsReceived.Add(1)
-
-		metrics := httpsnoop.CaptureMetrics(next, w, r)
-
-		totalResponsesSent.Add(1)
+		var match mux.RouteMatch
+		if router.Match(r, &match) && match.Route != nil {
+			if template, err := match.Route.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
 
-		totalProcessingTimeMicroseconds.Add(metrics.Duration.Microseconds())
+		m := httpsnoop.CaptureMetrics(next, w, r)
 
-		totalResponsesSentByStatus.Add(strconv.Itoa(metrics.Code), 1)
+		app.metrics.ObserveRequest(route, r.Method, m.Code, m.Duration)
 	})
 }
 
 func NewMiddleware(cfg config.Config,
 	userCtx data.UsersContext, users data.UserRepo,
-	tokenRepo auth.TokenRepository) *MiddleWare {
+	tokenRepo auth.TokenRepository, sessions auth.SessionRepository, apiKeys apikeys.APIKeyRepo,
+	audit *audit.Repository, metrics *metrics.Metrics) *MiddleWare {
 	return &MiddleWare{
 		cfg:       cfg,
 		userCtx:   userCtx,
 		users:     users,
 		tokenRepo: tokenRepo,
+		sessions:  sessions,
+		apiKeys:   apiKeys,
+		audit:     audit,
+		metrics:   metrics,
 		e:         pkg.CustomErrors{},
 	}
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"github.com/gorilla/mux"
+	data "interviews/internal/users"
 	"net/http"
 )
 
@@ -10,20 +11,110 @@ func (app *application) routes() http.Handler {
 
 	// health check
 	router.HandleFunc("/v1/healthcheck", app.healthcheckHandler).Methods("GET")
+	// signing keys
+	router.HandleFunc("/.well-known/jwks.json", app.jwksHandler).Methods("GET")
 	// courses
 	router.HandleFunc("/v1/courses", app.courses.CoursesAllHandler).Methods("POST")
 	router.HandleFunc("/v1/course/{id}", app.courses.CoursesIdHandler).Methods("GET")
-	router.HandleFunc("/v1/create-course", app.courses.CreateCourseHandler).Methods("POST")
-	router.HandleFunc("/v1/update-course", app.courses.UpdateCourseHandler).Methods("PUT")
-	router.HandleFunc("/v1/delete-course/{id}", app.courses.DeleteCourseHandler).Methods("DELETE")
+	router.HandleFunc("/v1/course/{id}/access", app.courses.CourseAccessHandler).Methods("GET")
+	router.HandleFunc("/v1/create-course", app.middleware.RequirePermission(data.PermissionCoursesWrite, app.courses.CreateCourseHandler)).Methods("POST")
+	router.HandleFunc("/v1/update-course", app.middleware.RequirePermission(data.PermissionCoursesWrite, app.courses.UpdateCourseHandler)).Methods("PUT")
+	router.HandleFunc("/v1/delete-course/{id}", app.middleware.RequirePermission(data.PermissionCoursesWrite, app.courses.DeleteCourseHandler)).Methods("DELETE")
 
 	// login
 	router.HandleFunc("/v1/login", app.login.LoginHandler).Methods("POST")
+	router.HandleFunc("/v1/login/magic-link", app.login.MagicLinkHandler).Methods("POST")
+	router.HandleFunc("/v1/login/magic-link/exchange", app.login.MagicLinkExchangeHandler).Methods("POST")
+	router.HandleFunc("/v1/login/2fa", app.login.LoginTwoFactorHandler).Methods("POST")
 	router.HandleFunc("/v1/google", app.login.LoginGoogleHandler).Methods("POST")
+	router.HandleFunc("/v1/oidc/{provider}/authorize", app.login.OIDCAuthorizeHandler).Methods("GET")
+	router.HandleFunc("/v1/oidc/{provider}/callback", app.login.OIDCCallbackHandler).Methods("POST")
+	router.HandleFunc("/v1/oidc/{provider}/token", app.login.OIDCTokenLoginHandler).Methods("POST")
 	router.HandleFunc("/v1/logout", app.login.LogoutHandler).Methods("POST")
+	router.HandleFunc("/v1/forgot-password", app.login.ForgotPasswordHandler).Methods("POST")
 	router.HandleFunc("/v1/password-reset", app.login.PasswordResetHandler).Methods("POST")
 	router.HandleFunc("/v1/create-account", app.login.CreateAccountHandler).Methods("POST")
+	router.HandleFunc("/v1/users/activated", app.login.ActivateUserHandler).Methods("PUT")
+	router.HandleFunc("/v1/users/activation", app.login.ResendActivationHandler).Methods("POST")
 
-	return app.middleware.Metrics(app.middleware.RecoverPanic(
+	// profile
+	router.HandleFunc("/v1/me", app.middleware.RequireActivatedUser(app.profile.GetMeHandler)).Methods("GET")
+	router.HandleFunc("/v1/me", app.middleware.RequireActivatedUser(app.profile.UpdateMeHandler)).Methods("PATCH")
+	router.HandleFunc("/v1/me/email", app.middleware.RequireActivatedUser(app.middleware.RequireRealUser(app.profile.ChangeEmailHandler))).Methods("POST")
+	router.HandleFunc("/v1/me/email/confirm", app.profile.ConfirmEmailHandler).Methods("PUT")
+
+	// personal data
+	router.HandleFunc("/v1/me", app.middleware.RequireActivatedUser(app.middleware.RequireRealUser(app.profile.DeleteMeHandler))).Methods("DELETE")
+	router.HandleFunc("/v1/me/deletion", app.middleware.RequireActivatedUser(app.middleware.RequireRealUser(app.profile.CancelDeletionHandler))).Methods("DELETE")
+	router.HandleFunc("/v1/me/export", app.middleware.RequireActivatedUser(app.middleware.RequireRealUser(app.profile.RequestExportHandler))).Methods("POST")
+	router.HandleFunc("/v1/me/exports/{id}", app.middleware.RequireActivatedUser(app.middleware.RequireRealUser(app.profile.GetExportHandler))).Methods("GET")
+	router.HandleFunc("/v1/me/exports/{id}/download", app.middleware.RequireActivatedUser(app.middleware.RequireRealUser(app.profile.DownloadExportHandler))).Methods("GET")
+
+	// two-factor authentication
+	router.HandleFunc("/v1/me/2fa/enroll", app.middleware.RequireActivatedUser(app.middleware.RequireRealUser(app.login.EnrollTwoFactorHandler))).Methods("POST")
+	router.HandleFunc("/v1/me/2fa/confirm", app.middleware.RequireActivatedUser(app.middleware.RequireRealUser(app.login.ConfirmTwoFactorHandler))).Methods("POST")
+
+	// devices the user is logged in on
+	router.HandleFunc("/v1/me/sessions", app.middleware.RequireActivatedUser(app.login.ListSessionsHandler)).Methods("GET")
+	router.HandleFunc("/v1/me/sessions", app.middleware.RequireActivatedUser(app.middleware.RequireRealUser(app.login.DeleteOtherSessionsHandler))).Methods("DELETE")
+	router.HandleFunc("/v1/me/sessions/{id}", app.middleware.RequireActivatedUser(app.middleware.RequireRealUser(app.login.DeleteSessionHandler))).Methods("DELETE")
+
+	// linked identity provider accounts
+	router.HandleFunc("/v1/me/identities/{provider}", app.middleware.RequireActivatedUser(app.middleware.RequireRealUser(app.login.LinkIdentityHandler))).Methods("POST")
+
+	// organizations
+	router.HandleFunc("/v1/me/orgs", app.middleware.RequireActivatedUser(app.orgs.ListMyOrgsHandler)).Methods("GET")
+	router.HandleFunc("/v1/orgs/invites/accept", app.middleware.RequireActivatedUser(app.middleware.RequireRealUser(app.orgs.AcceptInviteHandler))).Methods("POST")
+	router.HandleFunc("/v1/orgs/{id}", app.middleware.RequireActivatedUser(app.orgs.GetOrgHandler)).Methods("GET")
+	router.HandleFunc("/v1/orgs/{id}/invites", app.middleware.RequireActivatedUser(app.middleware.RequireRealUser(app.orgs.InviteHandler))).Methods("POST")
+	router.HandleFunc("/v1/orgs/{id}/invites/{invite}", app.middleware.RequireActivatedUser(app.middleware.RequireRealUser(app.orgs.RevokeInviteHandler))).Methods("DELETE")
+	router.HandleFunc("/v1/orgs/{id}/members/role", app.middleware.RequireActivatedUser(app.middleware.RequireRealUser(app.orgs.UpdateMemberRoleHandler))).Methods("PUT")
+	router.HandleFunc("/v1/orgs/{id}/members/{email}", app.middleware.RequireActivatedUser(app.middleware.RequireRealUser(app.orgs.RemoveMemberHandler))).Methods("DELETE")
+
+	// plans and subscriptions
+	router.HandleFunc("/v1/plans", app.billing.ListPlansHandler).Methods("GET")
+	router.HandleFunc("/v1/me/subscriptions", app.middleware.RequireActivatedUser(app.billing.MySubscriptionsHandler)).Methods("GET")
+	router.HandleFunc("/v1/billing/checkout", app.middleware.RequireActivatedUser(app.middleware.RequireRealUser(app.billing.CheckoutHandler))).Methods("POST")
+	router.HandleFunc("/v1/billing/webhook", app.billing.WebhookHandler).Methods("POST")
+
+	// admin
+	router.HandleFunc("/v1/admin/users/unlock", app.middleware.RequirePermission(data.PermissionUsersAdmin, app.login.UnlockAccountHandler)).Methods("POST")
+	router.HandleFunc("/v1/admin/api-keys", app.middleware.RequirePermission(data.PermissionUsersAdmin, app.apiKeys.CreateAPIKeyHandler)).Methods("POST")
+	router.HandleFunc("/v1/admin/api-keys", app.middleware.RequirePermission(data.PermissionUsersAdmin, app.apiKeys.ListAPIKeysHandler)).Methods("GET")
+	router.HandleFunc("/v1/admin/api-keys/{id}", app.middleware.RequirePermission(data.PermissionUsersAdmin, app.apiKeys.RevokeAPIKeyHandler)).Methods("DELETE")
+	router.HandleFunc("/v1/admin/users/sessions", app.middleware.RequirePermission(data.PermissionUsersAdmin, app.login.UserSessionsHandler)).Methods("GET")
+	router.HandleFunc("/v1/admin/users/sessions", app.middleware.RequirePermission(data.PermissionUsersAdmin, app.login.DeleteUserSessionsHandler)).Methods("DELETE")
+	router.HandleFunc("/v1/admin/users/impersonate", app.middleware.RequirePermission(data.PermissionUsersImpersonate, app.login.ImpersonateHandler)).Methods("POST")
+	router.HandleFunc("/v1/admin/orgs", app.middleware.RequirePermission(data.PermissionUsersAdmin, app.orgs.CreateOrgHandler)).Methods("POST")
+	router.HandleFunc("/v1/admin/orgs/{id}", app.middleware.RequirePermission(data.PermissionUsersAdmin, app.orgs.UpdateOrgHandler)).Methods("PATCH")
+	router.HandleFunc("/v1/admin/users/role", app.middleware.RequirePermission(data.PermissionUsersAdmin, app.login.UpdateRoleHandler)).Methods("PUT")
+	router.HandleFunc("/v1/admin/users", app.middleware.RequirePermission(data.PermissionUsersAdmin, app.login.ListUsersHandler)).Methods("GET")
+	router.HandleFunc("/v1/admin/users/suspend", app.middleware.RequirePermission(data.PermissionUsersAdmin, app.login.SuspendUserHandler)).Methods("POST")
+	router.HandleFunc("/v1/admin/users/unsuspend", app.middleware.RequirePermission(data.PermissionUsersAdmin, app.login.UnsuspendUserHandler)).Methods("POST")
+	router.HandleFunc("/v1/admin/users/password-reset", app.middleware.RequirePermission(data.PermissionUsersAdmin, app.login.ForcePasswordResetHandler)).Methods("POST")
+	router.HandleFunc("/v1/admin/users/bulk", app.middleware.RequirePermission(data.PermissionUsersAdmin, app.login.BulkUserActionHandler)).Methods("POST")
+	router.HandleFunc("/v1/admin/plans", app.middleware.RequirePermission(data.PermissionUsersAdmin, app.billing.CreatePlanHandler)).Methods("POST")
+	router.HandleFunc("/v1/admin/plans/{code}", app.middleware.RequirePermission(data.PermissionUsersAdmin, app.billing.UpdatePlanHandler)).Methods("PATCH")
+	router.HandleFunc("/v1/admin/subscriptions", app.middleware.RequirePermission(data.PermissionUsersAdmin, app.billing.CreateSubscriptionHandler)).Methods("POST")
+	router.HandleFunc("/v1/admin/subscriptions/{id}/cancel", app.middleware.RequirePermission(data.PermissionUsersAdmin, app.billing.CancelSubscriptionHandler)).Methods("POST")
+	router.HandleFunc("/v1/admin/coupons", app.middleware.RequirePermission(data.PermissionUsersAdmin, app.billing.CreateCouponHandler)).Methods("POST")
+	router.HandleFunc("/v1/admin/coupons", app.middleware.RequirePermission(data.PermissionUsersAdmin, app.billing.ListCouponsHandler)).Methods("GET")
+	router.HandleFunc("/v1/admin/coupons/{code}", app.middleware.RequirePermission(data.PermissionUsersAdmin, app.billing.GetCouponHandler)).Methods("GET")
+	router.HandleFunc("/v1/admin/coupons/{code}", app.middleware.RequirePermission(data.PermissionUsersAdmin, app.billing.UpdateCouponHandler)).Methods("PATCH")
+	router.HandleFunc("/v1/admin/coupons/{code}", app.middleware.RequirePermission(data.PermissionUsersAdmin, app.billing.DeleteCouponHandler)).Methods("DELETE")
+	router.HandleFunc("/v1/admin/coupons/{code}/redemptions", app.middleware.RequirePermission(data.PermissionUsersAdmin, app.billing.CouponRedemptionsHandler)).Methods("GET")
+
+	return app.middleware.Metrics(router, app.middleware.RecoverPanic(
 		app.middleware.EnableCORS(app.middleware.RateLimit(app.middleware.Authenticate(router)))))
 }
+
+// metricsRoutes are served on the internal port, so the metrics can't be
+// scraped from outside.
+func (app *application) metricsRoutes() http.Handler {
+	router := mux.NewRouter()
+
+	// prometheus
+	router.Handle("/metrics", app.metrics.Handler()).Methods("GET")
+
+	return router
+}
@@ -22,6 +22,14 @@ func (app *application) serve() error {
 		WriteTimeout: 30 * time.Second,
 	}
 
+	metricsSrv := &http.Server{
+		Addr:         fmt.Sprintf(":%d", app.config.MetricsPort),
+		Handler:      app.metricsRoutes(),
+		IdleTimeout:  time.Minute,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+
 	shutdownError := make(chan error)
 
 	go func() {
@@ -35,7 +43,12 @@ func (app *application) serve() error {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
-		err := srv.Shutdown(ctx)
+		err := metricsSrv.Shutdown(ctx)
+		if err != nil {
+			app.logger.Error(err)
+		}
+
+		err = srv.Shutdown(ctx)
 		if err != nil {
 			shutdownError <- err
 		}
@@ -55,6 +68,17 @@ func (app *application) serve() error {
 		"env":  app.config.Env,
 	})
 
+	go func() {
+		app.logger.PrintInfo("starting metrics server", map[string]string{
+			"addr": metricsSrv.Addr,
+		})
+
+		err := metricsSrv.ListenAndServe()
+		if !errors.Is(err, http.ErrServerClosed) {
+			app.logger.Error(err)
+		}
+	}()
+
 	err := srv.ListenAndServe()
 	if !errors.Is(err, http.ErrServerClosed) {
 		app.logger.Error(err)
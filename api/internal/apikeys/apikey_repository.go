@@ -0,0 +1,177 @@
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"strings"
+	"time"
+)
+
+const (
+	keyPrefix = "ik_"
+	// lastUsedAt is written at most this often so busy keys don't cost a
+	// write per request
+	lastUsedInterval = time.Minute
+)
+
+var (
+	ErrInvalidKey  = errors.New("invalid or expired api key")
+	ErrKeyNotFound = errors.New("api key not found")
+)
+
+// APIKey lets an internal service call the API without a user login. Only the
+// SHA-256 hash of the key is stored; the plaintext is shown once on creation.
+type APIKey struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name        string             `json:"name" bson:"name"`
+	Plaintext   string             `json:"key,omitempty" bson:"-"`
+	Hint        string             `json:"hint" bson:"hint"`
+	Hash        []byte             `json:"-" bson:"hash"`
+	Permissions []string           `json:"permissions" bson:"permissions"`
+	CreatedBy   string             `json:"created_by" bson:"createdBy"`
+	CreatedAt   time.Time          `json:"created_at" bson:"createdAt"`
+	Expiry      time.Time          `json:"expiry" bson:"expiry"`
+	LastUsedAt  *time.Time         `json:"last_used_at" bson:"lastUsedAt"`
+	RevokedAt   *time.Time         `json:"revoked_at,omitempty" bson:"revokedAt"`
+}
+
+type APIKeyRepo struct {
+	client     *mongo.Client
+	collection *mongo.Collection
+}
+
+func hashKey(plaintext string) []byte {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	return hash[:]
+}
+
+func generateKey(name string, permissions []string, expiry time.Time, createdBy string) (*APIKey, error) {
+	randomBytes := make([]byte, 32)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext := keyPrefix + strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes))
+
+	return &APIKey{
+		Name:        name,
+		Plaintext:   plaintext,
+		Hint:        plaintext[:len(keyPrefix)+4],
+		Hash:        hashKey(plaintext),
+		Permissions: permissions,
+		CreatedBy:   createdBy,
+		CreatedAt:   time.Now(),
+		Expiry:      expiry,
+	}, nil
+}
+
+func (m *APIKeyRepo) New(ctx context.Context, name string, permissions []string, expiry time.Time, createdBy string) (*APIKey, error) {
+	key, err := generateKey(name, permissions, expiry, createdBy)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := m.collection.InsertOne(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if id, ok := res.InsertedID.(primitive.ObjectID); ok {
+		key.ID = id
+	}
+
+	return key, nil
+}
+
+// Authenticate returns the unrevoked, unexpired key matching the plaintext and
+// records that it was used.
+func (m *APIKeyRepo) Authenticate(ctx context.Context, plaintext string) (*APIKey, error) {
+	if !strings.HasPrefix(plaintext, keyPrefix) {
+		return nil, ErrInvalidKey
+	}
+
+	now := time.Now()
+
+	filter := bson.M{
+		"hash":      hashKey(plaintext),
+		"revokedAt": nil,
+		"expiry":    bson.M{"$gt": now},
+	}
+
+	var key APIKey
+
+	err := m.collection.FindOne(ctx, filter).Decode(&key)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrInvalidKey
+		}
+
+		return nil, err
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= lastUsedInterval {
+		_, err = m.collection.UpdateOne(ctx, bson.M{"_id": key.ID}, bson.M{"$set": bson.M{"lastUsedAt": now}})
+		if err != nil {
+			return nil, err
+		}
+
+		key.LastUsedAt = &now
+	}
+
+	return &key, nil
+}
+
+func (m *APIKeyRepo) List(ctx context.Context) ([]APIKey, error) {
+	opts := options.Find().SetSort(bson.M{"createdAt": -1})
+
+	cursor, err := m.collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []APIKey{}
+
+	err = cursor.All(ctx, &keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (m *APIKeyRepo) Revoke(ctx context.Context, id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrKeyNotFound
+	}
+
+	res, err := m.collection.UpdateOne(ctx,
+		bson.M{"_id": objectID, "revokedAt": nil},
+		bson.M{"$set": bson.M{"revokedAt": time.Now()}})
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrKeyNotFound
+	}
+
+	return nil
+}
+
+func NewAPIKeyRepository(client *mongo.Client, collection *mongo.Collection) *APIKeyRepo {
+	return &APIKeyRepo{
+		client:     client,
+		collection: collection,
+	}
+}
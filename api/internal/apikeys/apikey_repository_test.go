@@ -0,0 +1,98 @@
+package apikeys
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestAPIKeyRepo_Authenticate(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	key, err := generateKey("reporting", []string{"courses:write"}, time.Now().Add(time.Hour), "admin@test.com")
+	require.NoError(t, err)
+
+	id := primitive.NewObjectID()
+
+	testCases := []struct {
+		name       string
+		plaintext  string
+		lastUsedAt time.Time
+		found      bool
+		// whether lastUsedAt is written
+		touched bool
+		err     error
+	}{
+		{
+			name:      "valid key",
+			plaintext: key.Plaintext,
+			found:     true,
+			touched:   true,
+		},
+		{
+			name:       "recently used key",
+			plaintext:  key.Plaintext,
+			lastUsedAt: time.Now().Add(-10 * time.Second),
+			found:      true,
+		},
+		{
+			name:      "revoked, expired or unknown key",
+			plaintext: key.Plaintext,
+			err:       ErrInvalidKey,
+		},
+		{
+			name:      "not an api key",
+			plaintext: "Bearer something",
+			err:       ErrInvalidKey,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		mt.Run(tc.name, func(mt *mtest.T) {
+			var docs []bson.D
+			if tc.found {
+				doc := bson.D{
+					{Key: "_id", Value: id},
+					{Key: "name", Value: "reporting"},
+					{Key: "hash", Value: key.Hash},
+					{Key: "permissions", Value: bson.A{"courses:write"}},
+				}
+				if !tc.lastUsedAt.IsZero() {
+					doc = append(doc, bson.E{Key: "lastUsedAt", Value: tc.lastUsedAt})
+				}
+				docs = append(docs, doc)
+			}
+
+			mt.AddMockResponses(mtest.CreateCursorResponse(0, "mock.api_keys", mtest.FirstBatch, docs...))
+			if tc.touched {
+				mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 1}, {Key: "nModified", Value: 1}})
+			}
+
+			repo := NewAPIKeyRepository(mt.Client, mt.Coll)
+			got, err := repo.Authenticate(context.Background(), tc.plaintext)
+			assert.ErrorIs(mt, err, tc.err)
+
+			if tc.err == nil {
+				assert.Equal(mt, id, got.ID)
+				assert.Equal(mt, []string{"courses:write"}, got.Permissions)
+				assert.NotNil(mt, got.LastUsedAt)
+			}
+
+			started := mt.GetAllStartedEvents()
+			if tc.touched {
+				require.Len(mt, started, 2)
+				assert.Equal(mt, "update", started[1].CommandName)
+			} else if tc.found {
+				assert.Len(mt, started, 1)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package apikeys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"interviews/internal/config"
+	data "interviews/internal/users"
+	"interviews/pkg"
+	validator "interviews/pkg/vaildator"
+	"net/http"
+	"time"
+)
+
+type Repository interface {
+	New(ctx context.Context, name string, permissions []string, expiry time.Time, createdBy string) (*APIKey, error)
+	List(ctx context.Context) ([]APIKey, error)
+	Revoke(ctx context.Context, id string) error
+}
+
+type APIKeys struct {
+	helper  pkg.Helper
+	e       pkg.CustomErrors
+	repo    Repository
+	userCtx data.UsersContext
+	cfg     config.Config
+}
+
+type envelope map[string]any
+
+// CreateAPIKeyHandler creates a key for a service. The plaintext key is only
+// part of this response.
+func (a *APIKeys) CreateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	user := a.userCtx.ContextGetUser(r)
+
+	// keys can't be used to mint more keys
+	if user.IsService() {
+		a.e.NotPermittedResponse(w, r)
+
+		return
+	}
+
+	var input struct {
+		Name        string     `json:"name"`
+		Permissions []string   `json:"permissions"`
+		Expiry      *time.Time `json:"expiry"`
+	}
+
+	err := a.helper.ReadJSON(w, r, &input)
+	if err != nil {
+		a.e.BadRequestResponse(w, r, err)
+
+		return
+	}
+
+	now := time.Now()
+
+	expiry := now.Add(a.cfg.APIKeys.DefaultTTL)
+	if input.Expiry != nil {
+		expiry = *input.Expiry
+	}
+
+	v := validator.New()
+
+	v.Check(input.Name != "", "name", "must be provided")
+	v.Check(len(input.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(len(input.Permissions) > 0, "permissions", "must contain at least one permission")
+	v.Check(validator.Unique(input.Permissions), "permissions", "must not contain duplicate values")
+
+	for _, p := range input.Permissions {
+		v.Check(data.ValidPermission(p), "permissions", fmt.Sprintf("%q is not a permission", p))
+	}
+
+	v.Check(expiry.After(now), "expiry", "must be in the future")
+	v.Check(!expiry.After(now.Add(a.cfg.APIKeys.MaxTTL)), "expiry",
+		fmt.Sprintf("must not be more than %s from now", a.cfg.APIKeys.MaxTTL))
+
+	if !v.Valid() {
+		a.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	key, err := a.repo.New(ctx, input.Name, input.Permissions, expiry, user.Email)
+	if err != nil {
+		a.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = a.helper.WriteJSON(w, http.StatusCreated, envelope{"api_key": key}, nil)
+	if err != nil {
+		a.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (a *APIKeys) ListAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	keys, err := a.repo.List(ctx)
+	if err != nil {
+		a.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = a.helper.WriteJSON(w, http.StatusOK, envelope{"api_keys": keys}, nil)
+	if err != nil {
+		a.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (a *APIKeys) RevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	err := a.repo.Revoke(ctx, mux.Vars(r)["id"])
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrKeyNotFound):
+			a.e.NotFoundResponse(w, r)
+		default:
+			a.e.ServerErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	err = a.helper.WriteJSON(w, http.StatusOK, envelope{"message": "api key revoked"}, nil)
+	if err != nil {
+		a.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+func NewAPIKeysService(repo Repository, cfg config.Config) *APIKeys {
+	return &APIKeys{
+		helper:  pkg.Helper{},
+		e:       pkg.CustomErrors{},
+		repo:    repo,
+		userCtx: data.UsersContext{},
+		cfg:     cfg,
+	}
+}
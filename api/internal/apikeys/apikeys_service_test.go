@@ -0,0 +1,178 @@
+package apikeys
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"interviews/internal/config"
+	data "interviews/internal/users"
+	"interviews/internal/users/userstest"
+)
+
+type MockRepository struct {
+	keys []APIKey
+}
+
+func (m *MockRepository) New(ctx context.Context, name string, permissions []string, expiry time.Time, createdBy string) (*APIKey, error) {
+	key, err := generateKey(name, permissions, expiry, createdBy)
+	if err != nil {
+		return nil, err
+	}
+
+	key.ID = primitive.NewObjectID()
+	m.keys = append(m.keys, *key)
+
+	return key, nil
+}
+
+func (m *MockRepository) List(ctx context.Context) ([]APIKey, error) {
+	return m.keys, nil
+}
+
+func (m *MockRepository) Revoke(ctx context.Context, id string) error {
+	for i := range m.keys {
+		if m.keys[i].ID.Hex() == id && m.keys[i].RevokedAt == nil {
+			now := time.Now()
+			m.keys[i].RevokedAt = &now
+
+			return nil
+		}
+	}
+
+	return ErrKeyNotFound
+}
+
+func newTestAPIKeys(repo Repository) *APIKeys {
+	var cfg config.Config
+	cfg.APIKeys.DefaultTTL = 90 * 24 * time.Hour
+	cfg.APIKeys.MaxTTL = 365 * 24 * time.Hour
+
+	return NewAPIKeysService(repo, cfg)
+}
+
+func TestAPIKeys_CreateAPIKeyHandler(t *testing.T) {
+	admin := &data.User{Email: "admin@test.com", Role: data.RoleAdmin, Activated: true}
+
+	testCases := []struct {
+		name       string
+		user       *data.User
+		body       map[string]any
+		statusCode int
+	}{
+		{
+			name:       "Key Created",
+			user:       admin,
+			body:       map[string]any{"name": "reporting", "permissions": []string{data.PermissionCoursesWrite}},
+			statusCode: http.StatusCreated,
+		},
+		{
+			name:       "Unknown Permission",
+			user:       admin,
+			body:       map[string]any{"name": "reporting", "permissions": []string{"courses:delete"}},
+			statusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "No Permissions",
+			user:       admin,
+			body:       map[string]any{"name": "reporting"},
+			statusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name: "Expiry Too Far Out",
+			user: admin,
+			body: map[string]any{
+				"name":        "reporting",
+				"permissions": []string{data.PermissionCoursesWrite},
+				"expiry":      time.Now().Add(2 * 365 * 24 * time.Hour),
+			},
+			statusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name: "Expired",
+			user: admin,
+			body: map[string]any{
+				"name":        "reporting",
+				"permissions": []string{data.PermissionCoursesWrite},
+				"expiry":      time.Now().Add(-time.Hour),
+			},
+			statusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name: "Keys Can't Create Keys",
+			user: &data.User{Activated: true, Service: &data.ServicePrincipal{
+				Name:        "hr",
+				Permissions: []string{data.PermissionUsersAdmin},
+			}},
+			body:       map[string]any{"name": "reporting", "permissions": []string{data.PermissionUsersAdmin}},
+			statusCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &MockRepository{}
+			a := newTestAPIKeys(repo)
+
+			recorder := httptest.NewRecorder()
+			a.CreateAPIKeyHandler(recorder, userstest.NewRequest(t, http.MethodPost, "/v1/admin/api-keys", tc.user, tc.body))
+
+			assert.Equal(t, tc.statusCode, recorder.Code)
+
+			if tc.statusCode != http.StatusCreated {
+				assert.Empty(t, repo.keys)
+				return
+			}
+
+			var res struct {
+				APIKey struct {
+					Key    string    `json:"key"`
+					Hint   string    `json:"hint"`
+					Expiry time.Time `json:"expiry"`
+				} `json:"api_key"`
+			}
+			require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+
+			require.Len(t, repo.keys, 1)
+			assert.Equal(t, hashKey(res.APIKey.Key), repo.keys[0].Hash, "only the hash is stored")
+			assert.Equal(t, "admin@test.com", repo.keys[0].CreatedBy)
+			assert.True(t, len(res.APIKey.Key) > 40)
+			assert.Equal(t, res.APIKey.Key[:len(res.APIKey.Hint)], res.APIKey.Hint)
+			assert.WithinDuration(t, time.Now().Add(90*24*time.Hour), res.APIKey.Expiry, time.Minute)
+		})
+	}
+}
+
+func TestAPIKeys_RevokeAPIKeyHandler(t *testing.T) {
+	repo := &MockRepository{}
+	a := newTestAPIKeys(repo)
+
+	key, err := repo.New(context.Background(), "hr", []string{data.PermissionUsersAdmin}, time.Now().Add(time.Hour), "admin@test.com")
+	require.NoError(t, err)
+
+	admin := &data.User{Email: "admin@test.com", Role: data.RoleAdmin, Activated: true}
+
+	revoke := func(id string) int {
+		req := userstest.NewRequest(t, http.MethodDelete, "/v1/admin/api-keys/"+id, admin, nil)
+		req = mux.SetURLVars(req, map[string]string{"id": id})
+
+		recorder := httptest.NewRecorder()
+		a.RevokeAPIKeyHandler(recorder, req)
+
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusOK, revoke(key.ID.Hex()))
+	assert.NotNil(t, repo.keys[0].RevokedAt)
+
+	assert.Equal(t, http.StatusNotFound, revoke(key.ID.Hex()))
+	assert.Equal(t, http.StatusNotFound, revoke("not-an-id"))
+}
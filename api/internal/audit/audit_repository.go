@@ -0,0 +1,116 @@
+package audit
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	ActionMagicLinkRequested = "magic_link.requested"
+	ActionMagicLinkLogin     = "magic_link.login"
+	ActionMagicLinkRejected  = "magic_link.rejected"
+
+	ActionImpersonationStarted = "impersonation.started"
+	ActionImpersonatedRequest  = "impersonation.request"
+
+	ActionUserSuspended           = "user.suspended"
+	ActionUserUnsuspended         = "user.unsuspended"
+	ActionUserPasswordResetForced = "user.password_reset_forced"
+	ActionUserRoleChanged         = "user.role_changed"
+
+	ActionDataExportRequested = "privacy.export_requested"
+	ActionDeletionScheduled   = "privacy.deletion_scheduled"
+	ActionDeletionCancelled   = "privacy.deletion_cancelled"
+	ActionAccountErased       = "privacy.account_erased"
+)
+
+// Event records a security relevant action. Entries are never deleted, they
+// only follow the user to a new address or get anonymized when they are erased.
+type Event struct {
+	ID     primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Action string             `json:"action" bson:"action"`
+	// who did it, empty when the request wasn't authenticated
+	Actor string `json:"actor,omitempty" bson:"actor,omitempty"`
+	// the account the action concerns
+	Subject   string            `json:"subject,omitempty" bson:"subject,omitempty"`
+	IP        string            `json:"ip" bson:"ip"`
+	UserAgent string            `json:"user_agent" bson:"userAgent"`
+	Details   map[string]string `json:"details,omitempty" bson:"details,omitempty"`
+	CreatedAt time.Time         `json:"created_at" bson:"createdAt"`
+}
+
+type Repository struct {
+	client     *mongo.Client
+	collection *mongo.Collection
+}
+
+func (r *Repository) Record(ctx context.Context, event Event) error {
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+
+	_, err := r.collection.InsertOne(ctx, event)
+
+	return err
+}
+
+// ListForUser returns the entries the user is the actor or the subject of,
+// newest first.
+func (r *Repository) ListForUser(ctx context.Context, email string) ([]Event, error) {
+	filter := bson.M{"$or": bson.A{bson.M{"actor": email}, bson.M{"subject": email}}}
+
+	cursor, err := r.collection.Find(ctx, filter, options.Find().SetSort(bson.M{"createdAt": -1}))
+	if err != nil {
+		return nil, err
+	}
+
+	events := []Event{}
+
+	err = cursor.All(ctx, &events)
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// Anonymize replaces the email of an erased user with a pseudonym. The entries
+// themselves are kept as a record of what happened, and the IP and user agent
+// are dropped since they could identify the user as well.
+func (r *Repository) Anonymize(ctx context.Context, email, pseudonym string) error {
+	_, err := r.collection.UpdateMany(ctx, bson.M{"actor": email},
+		bson.M{"$set": bson.M{"actor": pseudonym, "ip": "", "userAgent": ""}})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.collection.UpdateMany(ctx, bson.M{"subject": email},
+		bson.M{"$set": bson.M{"subject": pseudonym, "ip": "", "userAgent": ""}})
+
+	return err
+}
+
+// ChangeEmail moves the entries of the user to their new address, so they stay
+// part of their data export and are anonymized when they are erased.
+func (r *Repository) ChangeEmail(ctx context.Context, email, newEmail string) error {
+	_, err := r.collection.UpdateMany(ctx, bson.M{"actor": email}, bson.M{"$set": bson.M{"actor": newEmail}})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.collection.UpdateMany(ctx, bson.M{"subject": email}, bson.M{"$set": bson.M{"subject": newEmail}})
+
+	return err
+}
+
+func NewAuditRepository(client *mongo.Client, collection *mongo.Collection) *Repository {
+	return &Repository{
+		client:     client,
+		collection: collection,
+	}
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var (
+	ErrTokenNotYetValid = errors.New("token is not valid yet")
+	ErrInvalidIssuer    = errors.New("token issuer is invalid")
+	ErrInvalidAudience  = errors.New("token audience is invalid")
+	ErrMissingClaims    = errors.New("token is missing required claims")
+)
+
+// Claims are the claims of every access token issued by this service.
+type Claims struct {
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+	SessionID string `json:"sid"`
+	// AuthMethods lists how the user proved their identity, e.g. pwd and otp
+	AuthMethods []string `json:"amr,omitempty"`
+	// Scope is only set on tokens that are not access tokens, e.g. the
+	// challenge token of a login that still needs the second factor
+	Scope string `json:"scope,omitempty"`
+	// Actor is only set on impersonation tokens and names the admin acting as
+	// the user, see the act claim of RFC 8693
+	Actor *Actor `json:"act,omitempty"`
+	jwt.StandardClaims
+}
+
+type Actor struct {
+	Subject string `json:"sub"`
+}
+
+// ClaimsValidator checks the registered claims of a token whose signature has
+// already been verified. The Leeway is applied to exp, nbf and iat to absorb
+// clock skew between instances.
+type ClaimsValidator struct {
+	Issuer   string
+	Audience string
+	Leeway   time.Duration
+	now      func() time.Time
+}
+
+func (v *ClaimsValidator) Validate(c *Claims) error {
+	now := time.Now
+	if v.now != nil {
+		now = v.now
+	}
+
+	t := now()
+	leeway := int64(v.Leeway / time.Second)
+
+	if c.Subject == "" || c.Email == "" || c.ExpiresAt == 0 || c.IssuedAt == 0 {
+		return ErrMissingClaims
+	}
+
+	if c.Issuer != v.Issuer {
+		return ErrInvalidIssuer
+	}
+
+	if c.Audience != v.Audience {
+		return ErrInvalidAudience
+	}
+
+	if t.Unix() > c.ExpiresAt+leeway {
+		return ErrExpiredToken
+	}
+
+	if c.NotBefore != 0 && t.Unix() < c.NotBefore-leeway {
+		return ErrTokenNotYetValid
+	}
+
+	if t.Unix() < c.IssuedAt-leeway {
+		return ErrTokenNotYetValid
+	}
+
+	return nil
+}
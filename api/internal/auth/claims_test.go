@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClaimsValidator_Validate(t *testing.T) {
+	now := time.Date(2023, 8, 1, 12, 0, 0, 0, time.UTC)
+
+	validator := &ClaimsValidator{
+		Issuer:   "interviews-api",
+		Audience: "go-trakteer",
+		Leeway:   30 * time.Second,
+		now:      func() time.Time { return now },
+	}
+
+	valid := func() Claims {
+		return Claims{
+			Email: "test@test.com",
+			StandardClaims: jwt.StandardClaims{
+				Subject:   "test@test.com",
+				Issuer:    "interviews-api",
+				Audience:  "go-trakteer",
+				IssuedAt:  now.Add(-time.Minute).Unix(),
+				NotBefore: now.Add(-time.Minute).Unix(),
+				ExpiresAt: now.Add(time.Hour).Unix(),
+			},
+		}
+	}
+
+	testCases := []struct {
+		name   string
+		modify func(c *Claims)
+		err    error
+	}{
+		{
+			name:   "valid token",
+			modify: func(c *Claims) {},
+		},
+		{
+			name:   "token minted for another app",
+			modify: func(c *Claims) { c.Audience = "another-app" },
+			err:    ErrInvalidAudience,
+		},
+		{
+			name:   "token from another issuer",
+			modify: func(c *Claims) { c.Issuer = "https://evil.example.com" },
+			err:    ErrInvalidIssuer,
+		},
+		{
+			name:   "expired token",
+			modify: func(c *Claims) { c.ExpiresAt = now.Add(-time.Minute).Unix() },
+			err:    ErrExpiredToken,
+		},
+		{
+			name:   "expired within clock skew",
+			modify: func(c *Claims) { c.ExpiresAt = now.Add(-10 * time.Second).Unix() },
+		},
+		{
+			name:   "not valid yet",
+			modify: func(c *Claims) { c.NotBefore = now.Add(time.Minute).Unix() },
+			err:    ErrTokenNotYetValid,
+		},
+		{
+			name:   "not before within clock skew",
+			modify: func(c *Claims) { c.NotBefore = now.Add(10 * time.Second).Unix() },
+		},
+		{
+			name:   "missing subject",
+			modify: func(c *Claims) { c.Subject = "" },
+			err:    ErrMissingClaims,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			claims := valid()
+			tc.modify(&claims)
+
+			err := validator.Validate(&claims)
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+
+	"interviews/internal/config"
+)
+
+// CSRFHeader carries the double-submit token on state-changing requests that
+// are authenticated with the session cookie.
+const CSRFHeader = "X-CSRF-Token"
+
+// SetSessionCookies stores the access token in an HttpOnly cookie and a new
+// CSRF token in a cookie scripts can read. The CSRF token is returned so it
+// can also be sent in the body to frontends on another origin.
+func SetSessionCookies(w http.ResponseWriter, cfg config.Config, token string) (string, error) {
+	randomBytes := make([]byte, 32)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	csrf := base64.RawURLEncoding.EncodeToString(randomBytes)
+	maxAge := time.Duration(cfg.TokenConfig.Expires) * time.Hour
+
+	http.SetCookie(w, sessionCookie(cfg, cfg.Cookies.Name, token, maxAge, true))
+	http.SetCookie(w, sessionCookie(cfg, cfg.Cookies.CSRFName, csrf, maxAge, false))
+
+	return csrf, nil
+}
+
+func ClearSessionCookies(w http.ResponseWriter, cfg config.Config) {
+	http.SetCookie(w, sessionCookie(cfg, cfg.Cookies.Name, "", -1, true))
+	http.SetCookie(w, sessionCookie(cfg, cfg.Cookies.CSRFName, "", -1, false))
+}
+
+// ValidCSRF reports whether the request echoes the CSRF cookie in the header.
+func ValidCSRF(r *http.Request, cfg config.Config) bool {
+	cookie, err := r.Cookie(cfg.Cookies.CSRFName)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+
+	header := r.Header.Get(CSRFHeader)
+
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(header)) == 1
+}
+
+// SafeMethod reports whether the method doesn't change state and so doesn't
+// need a CSRF token.
+func SafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+
+	return false
+}
+
+func sessionCookie(cfg config.Config, name, value string, maxAge time.Duration, httpOnly bool) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   cfg.Cookies.Domain,
+		Secure:   cfg.Cookies.Secure,
+		HttpOnly: httpOnly,
+		SameSite: sameSite(cfg.Cookies.SameSite),
+	}
+
+	if maxAge < 0 {
+		cookie.MaxAge = -1
+	} else {
+		cookie.MaxAge = int(maxAge.Seconds())
+	}
+
+	return cookie
+}
+
+func sameSite(mode string) http.SameSite {
+	switch strings.ToLower(mode) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	}
+
+	return http.SameSiteLaxMode
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"interviews/internal/config"
+)
+
+func cookieConfig() config.Config {
+	var cfg config.Config
+	cfg.TokenConfig.Expires = 24
+	cfg.Cookies.Enabled = true
+	cfg.Cookies.Name = "access_token"
+	cfg.Cookies.CSRFName = "csrf_token"
+	cfg.Cookies.Secure = true
+	cfg.Cookies.SameSite = "strict"
+
+	return cfg
+}
+
+func TestSetSessionCookies(t *testing.T) {
+	cfg := cookieConfig()
+
+	recorder := httptest.NewRecorder()
+	csrf, err := SetSessionCookies(recorder, cfg, "jwt")
+	require.NoError(t, err)
+	assert.NotEmpty(t, csrf)
+
+	cookies := map[string]*http.Cookie{}
+	for _, c := range recorder.Result().Cookies() {
+		cookies[c.Name] = c
+	}
+
+	access := cookies["access_token"]
+	if assert.NotNil(t, access) {
+		assert.Equal(t, "jwt", access.Value)
+		assert.True(t, access.HttpOnly)
+		assert.True(t, access.Secure)
+		assert.Equal(t, http.SameSiteStrictMode, access.SameSite)
+		assert.Equal(t, 24*60*60, access.MaxAge)
+	}
+
+	csrfCookie := cookies["csrf_token"]
+	if assert.NotNil(t, csrfCookie) {
+		assert.Equal(t, csrf, csrfCookie.Value)
+		assert.False(t, csrfCookie.HttpOnly, "the frontend has to read it")
+	}
+
+	recorder = httptest.NewRecorder()
+	ClearSessionCookies(recorder, cfg)
+
+	for _, c := range recorder.Result().Cookies() {
+		assert.Empty(t, c.Value)
+		assert.Equal(t, -1, c.MaxAge)
+	}
+}
+
+func TestValidCSRF(t *testing.T) {
+	cfg := cookieConfig()
+
+	testCases := []struct {
+		name   string
+		cookie string
+		header string
+		valid  bool
+	}{
+		{name: "matching", cookie: "token", header: "token", valid: true},
+		{name: "missing header", cookie: "token"},
+		{name: "different header", cookie: "token", header: "other"},
+		{name: "missing cookie", header: "token"},
+	}
+
+	for _, tc := range testCases {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		if tc.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "csrf_token", Value: tc.cookie})
+		}
+		if tc.header != "" {
+			req.Header.Set(CSRFHeader, tc.header)
+		}
+
+		assert.Equal(t, tc.valid, ValidCSRF(req, cfg), tc.name)
+	}
+}
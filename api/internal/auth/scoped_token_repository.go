@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	validator "interviews/pkg/vaildator"
+)
+
+// ScopedToken is a single-use token that is emailed to the user, e.g. for a
+// password reset. Only the SHA-256 hash of the plaintext is stored.
+type ScopedToken struct {
+	Plaintext string    `json:"token" bson:"-"`
+	Hash      []byte    `json:"-" bson:"hash"`
+	Email     string    `json:"-" bson:"email"`
+	Scope     string    `json:"-" bson:"scope"`
+	Expiry    time.Time `json:"expiry" bson:"expiry"`
+	CreatedAt time.Time `json:"-" bson:"createdAt"`
+}
+
+type ScopedTokenRepository struct {
+	client     *mongo.Client
+	collection *mongo.Collection
+}
+
+func generateScopedToken(email string, ttl time.Duration, scope string) (*ScopedToken, error) {
+	randomBytes := make([]byte, 16)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	token := &ScopedToken{
+		Plaintext: base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes),
+		Email:     email,
+		Scope:     scope,
+		Expiry:    now.Add(ttl),
+		CreatedAt: now,
+	}
+
+	hash := sha256.Sum256([]byte(token.Plaintext))
+	token.Hash = hash[:]
+
+	return token, nil
+}
+
+func (m *ScopedTokenRepository) New(ctx context.Context, email string, ttl time.Duration, scope string) (*ScopedToken, error) {
+	token, err := generateScopedToken(email, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = m.collection.InsertOne(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// Consume deletes the token matching the plaintext and returns the email it was
+// issued for. Deleting and reading in one operation makes the token single-use
+// even when two requests race.
+func (m *ScopedTokenRepository) Consume(ctx context.Context, scope, tokenPlaintext string) (string, error) {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+
+	filter := bson.M{
+		"hash":   hash[:],
+		"scope":  scope,
+		"expiry": bson.M{"$gt": time.Now()},
+	}
+
+	var token ScopedToken
+
+	err := m.collection.FindOneAndDelete(ctx, filter).Decode(&token)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return "", ErrInvalidToken
+		}
+
+		return "", err
+	}
+
+	return token.Email, nil
+}
+
+// Peek returns the email the token was issued for without using it up.
+func (m *ScopedTokenRepository) Peek(ctx context.Context, scope, tokenPlaintext string) (string, error) {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+
+	filter := bson.M{
+		"hash":   hash[:],
+		"scope":  scope,
+		"expiry": bson.M{"$gt": time.Now()},
+	}
+
+	var token ScopedToken
+
+	err := m.collection.FindOne(ctx, filter).Decode(&token)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return "", ErrInvalidToken
+		}
+
+		return "", err
+	}
+
+	return token.Email, nil
+}
+
+func (m *ScopedTokenRepository) DeleteAllForUser(ctx context.Context, scope, email string) error {
+	_, err := m.collection.DeleteMany(ctx, bson.M{"scope": scope, "email": email})
+
+	return err
+}
+
+// DeleteAllScopesForUser removes every outstanding token of the user, e.g. when
+// the account is erased.
+func (m *ScopedTokenRepository) DeleteAllScopesForUser(ctx context.Context, email string) error {
+	_, err := m.collection.DeleteMany(ctx, bson.M{"email": email})
+
+	return err
+}
+
+// ListForUser returns the outstanding tokens of the user. Only the metadata is
+// left since the plaintext is never stored.
+func (m *ScopedTokenRepository) ListForUser(ctx context.Context, email string) ([]ScopedToken, error) {
+	cursor, err := m.collection.Find(ctx, bson.M{"email": email}, options.Find().SetSort(bson.M{"createdAt": -1}))
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := []ScopedToken{}
+
+	err = cursor.All(ctx, &tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// LatestCreatedAt returns when the newest token of the scope was issued for the
+// email, or the zero time if there is none.
+func (m *ScopedTokenRepository) LatestCreatedAt(ctx context.Context, scope, email string) (time.Time, error) {
+	opts := options.FindOne().SetSort(bson.M{"createdAt": -1})
+
+	var token ScopedToken
+
+	err := m.collection.FindOne(ctx, bson.M{"scope": scope, "email": email}, opts).Decode(&token)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return time.Time{}, nil
+		}
+
+		return time.Time{}, err
+	}
+
+	return token.CreatedAt, nil
+}
+
+func ValidateScopedTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
+	v.Check(tokenPlaintext != "", "token", "must be provided")
+	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
+}
+
+// CreateIndexes lets Mongo delete the tokens that expired.
+func (m *ScopedTokenRepository) CreateIndexes(ctx context.Context) error {
+	_, err := m.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiry", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+
+	return err
+}
+
+func NewScopedTokenRepository(client *mongo.Client, collection *mongo.Collection) *ScopedTokenRepository {
+	return &ScopedTokenRepository{
+		client:     client,
+		collection: collection,
+	}
+}
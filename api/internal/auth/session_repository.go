@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tomasen/realip"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// lastSeenAt is written at most this often so active sessions don't cost
+	// a write per request
+	lastSeenInterval = time.Minute
+	maxUserAgentLen  = 512
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// Session is a login on one device. Every access token carries the ID of its
+// session in the sid claim and stops working once the session is deleted.
+type Session struct {
+	ID         string    `json:"id" bson:"_id"`
+	Email      string    `json:"-" bson:"email"`
+	UserAgent  string    `json:"user_agent" bson:"userAgent"`
+	IP         string    `json:"ip" bson:"ip"`
+	CreatedAt  time.Time `json:"created_at" bson:"createdAt"`
+	LastSeenAt time.Time `json:"last_seen_at" bson:"lastSeenAt"`
+	Expiry     time.Time `json:"expiry" bson:"expiry"`
+	// email of the admin who started the session to act as the user
+	ImpersonatedBy string `json:"impersonated_by,omitempty" bson:"impersonatedBy,omitempty"`
+	// set when listing the sessions of the user making the request
+	Current bool `json:"current" bson:"-"`
+}
+
+// Device describes where a request comes from.
+type Device struct {
+	UserAgent string
+	IP        string
+	// set when an admin acts as the user
+	ImpersonatedBy string
+}
+
+func DeviceFromRequest(r *http.Request) Device {
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLen {
+		userAgent = userAgent[:maxUserAgentLen]
+	}
+
+	return Device{
+		UserAgent: userAgent,
+		IP:        realip.FromRequest(r),
+	}
+}
+
+type SessionRepository struct {
+	client     *mongo.Client
+	collection *mongo.Collection
+}
+
+func (m *SessionRepository) New(ctx context.Context, email string, device Device, expiry time.Time) (*Session, error) {
+	now := time.Now()
+
+	session := &Session{
+		ID:         uuid.NewString(),
+		Email:      email,
+		UserAgent:  device.UserAgent,
+		IP:         device.IP,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		Expiry:     expiry,
+
+		ImpersonatedBy: device.ImpersonatedBy,
+	}
+
+	_, err := m.collection.InsertOne(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+func (m *SessionRepository) Get(ctx context.Context, id string) (*Session, error) {
+	filter := bson.M{
+		"_id":    id,
+		"expiry": bson.M{"$gt": time.Now()},
+	}
+
+	var session Session
+
+	err := m.collection.FindOne(ctx, filter).Decode(&session)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrSessionNotFound
+		}
+
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// Touch records that the session was used from the IP.
+func (m *SessionRepository) Touch(ctx context.Context, session *Session, ip string) error {
+	now := time.Now()
+
+	if now.Sub(session.LastSeenAt) < lastSeenInterval && session.IP == ip {
+		return nil
+	}
+
+	_, err := m.collection.UpdateOne(ctx, bson.M{"_id": session.ID},
+		bson.M{"$set": bson.M{"lastSeenAt": now, "ip": ip}})
+	if err != nil {
+		return err
+	}
+
+	session.LastSeenAt = now
+	session.IP = ip
+
+	return nil
+}
+
+// ListForUser returns the unexpired sessions of the user, most recently used
+// first.
+func (m *SessionRepository) ListForUser(ctx context.Context, email string) ([]Session, error) {
+	opts := options.Find().SetSort(bson.M{"lastSeenAt": -1})
+
+	cursor, err := m.collection.Find(ctx, bson.M{"email": email, "expiry": bson.M{"$gt": time.Now()}}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []Session{}
+
+	err = cursor.All(ctx, &sessions)
+	if err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+func (m *SessionRepository) Delete(ctx context.Context, email, id string) error {
+	res, err := m.collection.DeleteOne(ctx, bson.M{"_id": id, "email": email})
+	if err != nil {
+		return err
+	}
+
+	if res.DeletedCount == 0 {
+		return ErrSessionNotFound
+	}
+
+	return nil
+}
+
+// DeleteOthers signs the user out everywhere but the session with the ID and
+// returns how many sessions were ended.
+func (m *SessionRepository) DeleteOthers(ctx context.Context, email, id string) (int64, error) {
+	res, err := m.collection.DeleteMany(ctx, bson.M{"email": email, "_id": bson.M{"$ne": id}})
+	if err != nil {
+		return 0, err
+	}
+
+	return res.DeletedCount, nil
+}
+
+func (m *SessionRepository) DeleteAllForUser(ctx context.Context, email string) (int64, error) {
+	res, err := m.collection.DeleteMany(ctx, bson.M{"email": email})
+	if err != nil {
+		return 0, err
+	}
+
+	return res.DeletedCount, nil
+}
+
+// CreateIndexes lets Mongo delete the sessions that expired.
+func (m *SessionRepository) CreateIndexes(ctx context.Context) error {
+	_, err := m.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiry", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+
+	return err
+}
+
+func NewSessionRepository(client *mongo.Client, collection *mongo.Collection) *SessionRepository {
+	return &SessionRepository{
+		client:     client,
+		collection: collection,
+	}
+}
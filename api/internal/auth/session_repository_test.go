@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestSessionRepository_Touch(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	testCases := []struct {
+		name     string
+		lastSeen time.Duration
+		ip       string
+		written  bool
+	}{
+		{name: "recently seen from the same ip", lastSeen: 10 * time.Second, ip: "10.0.0.1"},
+		{name: "recently seen from another ip", lastSeen: 10 * time.Second, ip: "10.0.0.2", written: true},
+		{name: "not seen for a while", lastSeen: 2 * time.Minute, ip: "10.0.0.1", written: true},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		mt.Run(tc.name, func(mt *mtest.T) {
+			mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 1}, {Key: "nModified", Value: 1}})
+
+			session := &Session{ID: "id", IP: "10.0.0.1", LastSeenAt: time.Now().Add(-tc.lastSeen)}
+
+			repo := NewSessionRepository(mt.Client, mt.Coll)
+			err := repo.Touch(context.Background(), session, tc.ip)
+			require.NoError(mt, err)
+
+			assert.Equal(mt, tc.ip, session.IP)
+			if tc.written {
+				assert.Len(mt, mt.GetAllStartedEvents(), 1)
+				assert.WithinDuration(mt, time.Now(), session.LastSeenAt, time.Second)
+			} else {
+				assert.Empty(mt, mt.GetAllStartedEvents())
+			}
+		})
+	}
+}
+
+func TestSessionRepository_Delete(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	testCases := []struct {
+		name    string
+		deleted int
+		err     error
+	}{
+		{name: "session deleted", deleted: 1},
+		{name: "session of another user or unknown", deleted: 0, err: ErrSessionNotFound},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		mt.Run(tc.name, func(mt *mtest.T) {
+			mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: tc.deleted}})
+
+			repo := NewSessionRepository(mt.Client, mt.Coll)
+			err := repo.Delete(context.Background(), "test@test.com", "id")
+			assert.ErrorIs(mt, err, tc.err)
+		})
+	}
+}
+
+func TestDeviceFromRequest(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodPost, "/v1/login", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+
+	assert.Equal(t, Device{UserAgent: "Mozilla/5.0", IP: "203.0.113.7"}, DeviceFromRequest(req))
+}
@@ -0,0 +1,268 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"interviews/internal/config"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+var (
+	ErrUnknownKeyID       = errors.New("unknown signing key id")
+	ErrMissingSigningKey  = errors.New("no signing key configured")
+	ErrUnsupportedKey     = errors.New("unsupported key type")
+	ErrUnsupportedAlg     = errors.New("unsupported signing algorithm")
+	ErrInvalidKeyPEM      = errors.New("key file does not contain a PEM block")
+	ErrInvalidKeyListItem = errors.New("key list entries must have the form kid=value")
+)
+
+// SigningKey is a single key of the key set. Private is nil for keys that are
+// only kept around to verify tokens issued before a rotation.
+type SigningKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private interface{}
+	Public  interface{}
+}
+
+type KeySet struct {
+	signing *SigningKey
+	keys    map[string]*SigningKey
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+func (k *KeySet) Sign(claims jwt.Claims) (string, error) {
+	if k.signing == nil || k.signing.Private == nil {
+		return "", ErrMissingSigningKey
+	}
+
+	token := jwt.NewWithClaims(k.signing.Method, claims)
+	token.Header["kid"] = k.signing.ID
+
+	return token.SignedString(k.signing.Private)
+}
+
+// Keyfunc resolves the verification key from the kid header and refuses tokens
+// whose alg does not match the algorithm the key was configured for.
+func (k *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		return nil, ErrUnknownKeyID
+	}
+
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKeyID
+	}
+
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, ErrInvalidToken
+	}
+
+	return key.Public, nil
+}
+
+// JWKS returns the public keys of the set. Symmetric keys are never published.
+func (k *KeySet) JWKS() []JWK {
+	jwks := make([]JWK, 0, len(k.keys))
+
+	for _, key := range k.keys {
+		switch pub := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwks = append(jwks, JWK{
+				Kty: "RSA",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			jwks = append(jwks, JWK{
+				Kty: "OKP",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+
+	return jwks
+}
+
+func NewHMACKey(id string, secret []byte) *SigningKey {
+	return &SigningKey{
+		ID:      id,
+		Method:  jwt.SigningMethodHS256,
+		Private: secret,
+		Public:  secret,
+	}
+}
+
+// NewKeyFromPEM accepts PKCS#1, PKCS#8 and PKIX encoded RSA or Ed25519 keys and
+// infers the signing algorithm from the key type.
+func NewKeyFromPEM(id string, data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrInvalidKeyPEM
+	}
+
+	var parsed interface{}
+	var err error
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedKey, block.Type)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	key := &SigningKey{ID: id}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Method, key.Private, key.Public = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.Method, key.Public = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		key.Method, key.Private, key.Public = SigningMethodEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.Method, key.Public = SigningMethodEdDSA, k
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, parsed)
+	}
+
+	return key, nil
+}
+
+func NewKeySet(signing *SigningKey, verification ...*SigningKey) *KeySet {
+	keys := make(map[string]*SigningKey, len(verification)+1)
+
+	for _, key := range verification {
+		keys[key.ID] = key
+	}
+
+	keys[signing.ID] = signing
+
+	return &KeySet{
+		signing: signing,
+		keys:    keys,
+	}
+}
+
+// LoadKeySet builds the key set from the TokenConfig. The signing key is used
+// for new tokens while the verification keys keep tokens signed by rotated
+// keys valid until they expire.
+func LoadKeySet(cfg config.Config) (*KeySet, error) {
+	tc := cfg.TokenConfig
+
+	var signing *SigningKey
+
+	switch tc.SigningAlgorithm {
+	case AlgHS256:
+		if tc.SigningSecret == "" {
+			return nil, fmt.Errorf("%w: JWT_SIGNING_SECRET must be set for %s", ErrMissingSigningKey, AlgHS256)
+		}
+
+		signing = NewHMACKey(tc.SigningKeyID, []byte(tc.SigningSecret))
+	case AlgRS256, AlgEdDSA:
+		if tc.SigningKeyFile == "" {
+			return nil, fmt.Errorf("%w: JWT_SIGNING_KEY_FILE must be set for %s", ErrMissingSigningKey, tc.SigningAlgorithm)
+		}
+
+		key, err := readKeyFile(tc.SigningKeyID, tc.SigningKeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		if key.Private == nil || key.Method.Alg() != tc.SigningAlgorithm {
+			return nil, fmt.Errorf("%w: %s is not a %s private key", ErrUnsupportedKey, tc.SigningKeyFile, tc.SigningAlgorithm)
+		}
+
+		signing = key
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlg, tc.SigningAlgorithm)
+	}
+
+	var verification []*SigningKey
+
+	for _, item := range tc.VerificationKeys {
+		kid, path, err := splitKeyListItem(item)
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := readKeyFile(kid, path)
+		if err != nil {
+			return nil, err
+		}
+
+		verification = append(verification, key)
+	}
+
+	for _, item := range tc.VerificationSecrets {
+		kid, secret, err := splitKeyListItem(item)
+		if err != nil {
+			return nil, err
+		}
+
+		verification = append(verification, NewHMACKey(kid, []byte(secret)))
+	}
+
+	return NewKeySet(signing, verification...), nil
+}
+
+func readKeyFile(kid, path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewKeyFromPEM(kid, data)
+}
+
+func splitKeyListItem(item string) (string, string, error) {
+	kid, value, ok := strings.Cut(item, "=")
+	if !ok || kid == "" || value == "" {
+		return "", "", ErrInvalidKeyListItem
+	}
+
+	return kid, value, nil
+}
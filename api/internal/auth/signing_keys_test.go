@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func rsaKeyPEM(t *testing.T) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+}
+
+func ed25519KeyPEM(t *testing.T) []byte {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+// jwt-go v3 wraps keyfunc errors in a ValidationError without Unwrap support.
+func assertInnerError(t *testing.T, err, target error) {
+	var validationErr *jwt.ValidationError
+	require.True(t, errors.As(err, &validationErr))
+	assert.ErrorIs(t, validationErr.Inner, target)
+}
+
+func TestKeySet_SignAndVerify(t *testing.T) {
+	rsaKey, err := NewKeyFromPEM("rsa-1", rsaKeyPEM(t))
+	require.NoError(t, err)
+
+	edKey, err := NewKeyFromPEM("ed-1", ed25519KeyPEM(t))
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name string
+		key  *SigningKey
+		alg  string
+	}{
+		{name: "HS256", key: NewHMACKey("hmac-1", []byte("secret")), alg: AlgHS256},
+		{name: "RS256", key: rsaKey, alg: AlgRS256},
+		{name: "EdDSA", key: edKey, alg: AlgEdDSA},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			keys := NewKeySet(tc.key)
+
+			tokenString, err := keys.Sign(jwt.MapClaims{"exp": time.Now().Add(time.Hour).Unix()})
+			require.NoError(t, err)
+
+			token, err := jwt.Parse(tokenString, keys.Keyfunc)
+			require.NoError(t, err)
+			assert.True(t, token.Valid)
+			assert.Equal(t, tc.key.ID, token.Header["kid"])
+			assert.Equal(t, tc.alg, token.Header["alg"])
+		})
+	}
+}
+
+func TestKeySet_Rotation(t *testing.T) {
+	oldKey, err := NewKeyFromPEM("old", rsaKeyPEM(t))
+	require.NoError(t, err)
+
+	newKey, err := NewKeyFromPEM("new", ed25519KeyPEM(t))
+	require.NoError(t, err)
+
+	oldToken, err := NewKeySet(oldKey).Sign(jwt.MapClaims{})
+	require.NoError(t, err)
+
+	rotated := NewKeySet(newKey, oldKey)
+	_, err = jwt.Parse(oldToken, rotated.Keyfunc)
+	assert.NoError(t, err, "tokens signed by a rotated key must still verify")
+
+	retired := NewKeySet(newKey)
+	_, err = jwt.Parse(oldToken, retired.Keyfunc)
+	assertInnerError(t, err, ErrUnknownKeyID)
+
+	assert.Len(t, rotated.JWKS(), 2)
+}
+
+func TestKeySet_RejectsAlgorithmMismatch(t *testing.T) {
+	rsaKey, err := NewKeyFromPEM("shared", rsaKeyPEM(t))
+	require.NoError(t, err)
+
+	keys := NewKeySet(rsaKey)
+
+	// An attacker signing with HS256 using the public key as secret must not
+	// be accepted for a kid that is configured as RS256.
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{})
+	forged.Header["kid"] = "shared"
+	forgedString, err := forged.SignedString([]byte("anything"))
+	require.NoError(t, err)
+
+	_, err = jwt.Parse(forgedString, keys.Keyfunc)
+	assertInnerError(t, err, ErrInvalidToken)
+}
+
+func TestKeySet_JWKSSkipsSymmetricKeys(t *testing.T) {
+	edKey, err := NewKeyFromPEM("ed", ed25519KeyPEM(t))
+	require.NoError(t, err)
+
+	keys := NewKeySet(NewHMACKey("hmac", []byte("secret")), edKey)
+
+	jwks := keys.JWKS()
+	require.Len(t, jwks, 1)
+	assert.Equal(t, "ed", jwks[0].Kid)
+	assert.Equal(t, "OKP", jwks[0].Kty)
+	assert.Equal(t, "Ed25519", jwks[0].Crv)
+}
@@ -2,19 +2,16 @@ package auth
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
-	"fmt"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"interviews/internal/config"
 	data "interviews/internal/users"
 	log "interviews/pkg/logger"
 	validator "interviews/pkg/vaildator"
-	"io"
-	"net/http"
 	"time"
 )
 
@@ -22,9 +19,8 @@ const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
 	ScopePasswordReset  = "password-reset"
-	TokenSecret         = "NLp948i7pvQbgTYrGHtpVFaSUrkbxqpqnqf3hdR-ves="
-	// Not a valid GoogleClientID
-	GoogleClientID = "705206800363-b7kutdfkhfd8r8ge76it0t7ur6p4pmr25p.apps.googleusercontent.com"
+	ScopeMagicLink      = "magic-link"
+	ScopeEmailChange    = "email-change"
 )
 
 var (
@@ -32,24 +28,28 @@ var (
 	ErrInvalidToken = errors.New("invalid token")
 )
 
-type GoogleClaims struct {
-	Email         string `json:"email"`
-	Name          string `json:"name"`
-	EmailVerified bool   `json:"email_verified"`
-	FirstName     string `json:"given_name"`
-	LastName      string `json:"family_name"`
-	jwt.StandardClaims
-}
-
 type TokenRepository struct {
 	client     *mongo.Client
 	collection *mongo.Collection
+	sessions   SessionStore
+	keys       *KeySet
+	parser     *jwt.Parser
+	validator  *ClaimsValidator
+	expires    time.Duration
+	// lifetime of the challenge token between the password and the second factor
+	challengeExpires time.Duration
+	// impersonation tokens are deliberately short-lived
+	impersonationExpires time.Duration
 }
 
 type UserRepository interface {
 	GetByEmail(email string) (*data.User, error)
 }
 
+type SessionStore interface {
+	New(ctx context.Context, email string, device Device, expiry time.Time) (*Session, error)
+}
+
 type Token struct {
 	ID        uuid.UUID `json:"id"`
 	Username  string    `json:"username"`
@@ -156,174 +156,147 @@ func (m *TokenRepository) ValidateTokenPlaintext(v *validator.Validator, tokenPl
 	return true
 }
 
-func (m *TokenRepository) GenerateJWT(ctx context.Context, user *data.User) (string, error) {
-	clog := log.GetLoggerFromContext(ctx)
-
-	expirationTime := time.Now().Add(time.Hour * 24)
-	payload := make(map[string]interface{})
-
-	claims := jwt.MapClaims{}
-
-	claims["jti"] = uuid.NewString()
-	claims["exp"] = expirationTime.Unix()
-	claims["email"] = user.Email
-	claims["role"] = user.Role
-	claims["aud"] = "go-trakteer"
-
-	for i, v := range payload {
-		claims[i] = v
-	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	tokenString, err := token.SignedString([]byte(TokenSecret))
+// GenerateJWT starts a session on the device and issues an access token for it.
+func (m *TokenRepository) GenerateJWT(ctx context.Context, user *data.User, device Device, methods ...string) (string, error) {
+	session, err := m.sessions.New(ctx, user.Email, device, time.Now().Add(m.expires))
 	if err != nil {
-		clog.ErrorCtx(err, log.Ctx{"msg": "error occurred while generating jwt token"})
+		return "", err
 	}
 
-	return tokenString, nil
+	return m.generate(ctx, user, m.expires, "", session.ID, methods, nil)
 }
 
-func (m *TokenRepository) ValidateBearerToken(tokenString string) (*jwt.Token, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, ErrInvalidToken
-		}
-
-		return []byte(TokenSecret), nil
-	})
+// GenerateImpersonationJWT issues an access token for the user that carries
+// the admin acting as them in the act claim. Its session is marked as well so
+// the user can see it.
+func (m *TokenRepository) GenerateImpersonationJWT(ctx context.Context, user *data.User, actor string,
+	device Device) (string, time.Duration, error) {
+	device.ImpersonatedBy = actor
 
+	session, err := m.sessions.New(ctx, user.Email, device, time.Now().Add(m.impersonationExpires))
 	if err != nil {
-		return nil, err
-	}
-
-	ok := m.isExpired(token)
-	if !ok {
-		return nil, ErrExpiredToken
+		return "", 0, err
 	}
 
-	if !token.Valid {
-		return nil, ErrInvalidToken
+	token, err := m.generate(ctx, user, m.impersonationExpires, "", session.ID, nil, &Actor{Subject: actor})
+	if err != nil {
+		return "", 0, err
 	}
 
-	return token, nil
+	return token, m.impersonationExpires, nil
 }
 
-func (m *TokenRepository) isExpired(token *jwt.Token) bool {
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return true // Invalid claims format
-	}
-	expiryTimeFloat, ok := claims["exp"].(float64)
-	if !ok {
-		return true // Invalid expiry time format
-	}
-	expiryTime := int64(expiryTimeFloat)
-	return time.Now().Unix() > expiryTime
+// GenerateChallengeJWT issues the short-lived token that a user with two-factor
+// authentication enabled trades in for an access token together with a code.
+func (m *TokenRepository) GenerateChallengeJWT(ctx context.Context, user *data.User) (string, error) {
+	return m.generate(ctx, user, m.challengeExpires, ScopeTwoFactorChallenge, uuid.NewString(), []string{AuthMethodPassword}, nil)
 }
 
-func (m *TokenRepository) DecodeToken(accessToken *jwt.Token) Token {
-	var token Token
-	stringify, _ := json.Marshal(&accessToken)
-	json.Unmarshal(stringify, &token)
-
-	return token
-}
+func (m *TokenRepository) generate(ctx context.Context, user *data.User, expires time.Duration,
+	scope, sessionID string, methods []string, actor *Actor) (string, error) {
+	clog := log.GetLoggerFromContext(ctx)
 
-func (m *TokenRepository) GetEmailFromJWT(tokenString string) (string, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method")
-		}
-		return []byte(TokenSecret), nil
-	})
+	now := time.Now()
+
+	claims := Claims{
+		Email:       user.Email,
+		Role:        user.Role,
+		SessionID:   sessionID,
+		AuthMethods: methods,
+		Scope:       scope,
+		Actor:       actor,
+		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.NewString(),
+			Subject:   user.Email,
+			Issuer:    m.validator.Issuer,
+			Audience:  m.validator.Audience,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(expires).Unix(),
+		},
+	}
 
+	tokenString, err := m.keys.Sign(claims)
 	if err != nil {
-		return "", err
-	}
+		clog.ErrorCtx(err, log.Ctx{"msg": "error occurred while generating jwt token"})
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		email, emailOk := claims["email"].(string)
-		if !emailOk {
-			return "", fmt.Errorf("invalid token claims")
-		}
-		return email, nil
+		return "", err
 	}
 
-	return "", fmt.Errorf("invalid token")
+	return tokenString, nil
 }
 
-func getGooglePublicKey(keyID string) (string, error) {
-	resp, err := http.Get("https://www.googleapis.com/oauth2/v1/certs")
+// ValidateBearerToken verifies the signature of an access token and validates
+// its claims. Every path that accepts an access token must go through here.
+func (m *TokenRepository) ValidateBearerToken(tokenString string) (*Claims, error) {
+	claims, err := m.parse(tokenString)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	dat, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
+	// scoped tokens, e.g. two-factor challenges, don't grant access
+	if claims.Scope != "" {
+		return nil, ErrInvalidToken
 	}
 
-	myResp := map[string]string{}
-	err = json.Unmarshal(dat, &myResp)
+	return claims, nil
+}
+
+func (m *TokenRepository) ValidateChallengeToken(tokenString string) (*Claims, error) {
+	claims, err := m.parse(tokenString)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	key, ok := myResp[keyID]
-	if !ok {
-		return "", errors.New("key not found")
+	if claims.Scope != ScopeTwoFactorChallenge {
+		return nil, ErrInvalidToken
 	}
 
-	return key, nil
+	return claims, nil
 }
 
-func (m *TokenRepository) ValidateGoogleJWT(tokenString string) (GoogleClaims, error) {
-	claimsStruct := GoogleClaims{}
-
-	token, err := jwt.ParseWithClaims(
-		tokenString,
-		&claimsStruct,
-		func(token *jwt.Token) (interface{}, error) {
-			pem, err := getGooglePublicKey(fmt.Sprintf("%s", token.Header["kid"]))
-			if err != nil {
-				return nil, err
-			}
-			key, err := jwt.ParseRSAPublicKeyFromPEM([]byte(pem))
-			if err != nil {
-				return nil, err
-			}
-			return key, nil
-		},
-	)
-	if err != nil {
-		return GoogleClaims{}, err
-	}
+func (m *TokenRepository) parse(tokenString string) (*Claims, error) {
+	var claims Claims
 
-	claims, ok := token.Claims.(*GoogleClaims)
-	if !ok {
-		return GoogleClaims{}, errors.New("Invalid Google JWT")
+	_, err := m.parser.ParseWithClaims(tokenString, &claims, m.keys.Keyfunc)
+	if err != nil {
+		return nil, ErrInvalidToken
 	}
 
-	if claims.Issuer != "accounts.google.com" && claims.Issuer != "https://accounts.google.com" {
-		return GoogleClaims{}, errors.New("iss is invalid")
+	err = m.validator.Validate(&claims)
+	if err != nil {
+		return nil, err
 	}
 
-	if claims.Audience != GoogleClientID {
-		return GoogleClaims{}, errors.New("aud is invalid")
-	}
+	return &claims, nil
+}
 
-	if claims.ExpiresAt < time.Now().UTC().Unix() {
-		return GoogleClaims{}, errors.New("JWT is expired")
+func (m *TokenRepository) GetEmailFromJWT(tokenString string) (string, error) {
+	claims, err := m.ValidateBearerToken(tokenString)
+	if err != nil {
+		return "", err
 	}
 
-	return *claims, nil
+	return claims.Email, nil
 }
 
 func NewTokenRepository(client *mongo.Client,
-	collection *mongo.Collection) *TokenRepository {
+	collection *mongo.Collection, sessions SessionStore, keys *KeySet, cfg config.Config) *TokenRepository {
 	return &TokenRepository{
 		client:     client,
 		collection: collection,
+		sessions:   sessions,
+		keys:       keys,
+		// claims are validated by ClaimsValidator so the configured leeway applies
+		parser: &jwt.Parser{SkipClaimsValidation: true},
+		validator: &ClaimsValidator{
+			Issuer:   cfg.TokenConfig.Issuer,
+			Audience: cfg.TokenConfig.Audience,
+			Leeway:   cfg.TokenConfig.ClockSkew,
+		},
+		expires:          time.Duration(cfg.TokenConfig.Expires) * time.Hour,
+		challengeExpires: cfg.TokenConfig.TwoFactorChallengeTTL,
+
+		impersonationExpires: cfg.TokenConfig.ImpersonationTTL,
 	}
 }
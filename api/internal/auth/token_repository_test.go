@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"interviews/internal/config"
+	data "interviews/internal/users"
+)
+
+func newTestTokenRepository() *TokenRepository {
+	var cfg config.Config
+	cfg.TokenConfig.Expires = 1
+	cfg.TokenConfig.Issuer = "interviews-api"
+	cfg.TokenConfig.Audience = "go-trakteer"
+	cfg.TokenConfig.TwoFactorChallengeTTL = 5 * time.Minute
+	cfg.TokenConfig.ImpersonationTTL = 30 * time.Minute
+
+	return NewTokenRepository(nil, nil, &memorySessions{}, NewKeySet(NewHMACKey("primary", []byte("secret"))), cfg)
+}
+
+type memorySessions struct {
+	sessions []*Session
+}
+
+func (m *memorySessions) New(ctx context.Context, email string, device Device, expiry time.Time) (*Session, error) {
+	session := &Session{ID: "session-" + email, Email: email, UserAgent: device.UserAgent, IP: device.IP, Expiry: expiry,
+		ImpersonatedBy: device.ImpersonatedBy}
+	m.sessions = append(m.sessions, session)
+
+	return session, nil
+}
+
+func TestTokenRepository_ChallengeTokenScope(t *testing.T) {
+	repo := newTestTokenRepository()
+	user := &data.User{Email: "test@test.com"}
+
+	challenge, err := repo.GenerateChallengeJWT(context.Background(), user)
+	require.NoError(t, err)
+
+	// a challenge token must not be accepted as an access token
+	_, err = repo.ValidateBearerToken(challenge)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	claims, err := repo.ValidateChallengeToken(challenge)
+	require.NoError(t, err)
+	assert.Equal(t, "test@test.com", claims.Email)
+	assert.Equal(t, []string{AuthMethodPassword}, claims.AuthMethods)
+
+	access, err := repo.GenerateJWT(context.Background(), user, Device{}, AuthMethodPassword, AuthMethodOTP)
+	require.NoError(t, err)
+
+	_, err = repo.ValidateChallengeToken(access)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	claims, err = repo.ValidateBearerToken(access)
+	require.NoError(t, err)
+	assert.Equal(t, []string{AuthMethodPassword, AuthMethodOTP}, claims.AuthMethods)
+}
+
+func TestTokenRepository_GenerateJWTStartsSession(t *testing.T) {
+	repo := newTestTokenRepository()
+	sessions := repo.sessions.(*memorySessions)
+	user := &data.User{Email: "test@test.com"}
+
+	access, err := repo.GenerateJWT(context.Background(), user, Device{UserAgent: "Firefox", IP: "10.0.0.1"})
+	require.NoError(t, err)
+
+	require.Len(t, sessions.sessions, 1)
+	assert.Equal(t, Device{UserAgent: "Firefox", IP: "10.0.0.1"},
+		Device{UserAgent: sessions.sessions[0].UserAgent, IP: sessions.sessions[0].IP})
+	assert.WithinDuration(t, time.Now().Add(time.Hour), sessions.sessions[0].Expiry, time.Minute)
+
+	claims, err := repo.ValidateBearerToken(access)
+	require.NoError(t, err)
+	assert.Equal(t, sessions.sessions[0].ID, claims.SessionID)
+}
+
+func TestTokenRepository_GenerateImpersonationJWT(t *testing.T) {
+	repo := newTestTokenRepository()
+	sessions := repo.sessions.(*memorySessions)
+	user := &data.User{Email: "learner@test.com", Role: data.RoleUser}
+
+	token, ttl, err := repo.GenerateImpersonationJWT(context.Background(), user, "admin@test.com", Device{IP: "10.0.0.1"})
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Minute, ttl)
+
+	claims, err := repo.ValidateBearerToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, "learner@test.com", claims.Email)
+	assert.Equal(t, &Actor{Subject: "admin@test.com"}, claims.Actor)
+	assert.WithinDuration(t, time.Now().Add(30*time.Minute), time.Unix(claims.ExpiresAt, 0), time.Minute)
+
+	require.Len(t, sessions.sessions, 1)
+	assert.Equal(t, "admin@test.com", sessions.sessions[0].ImpersonatedBy)
+	assert.Equal(t, sessions.sessions[0].ID, claims.SessionID)
+
+	// regular tokens don't carry an actor
+	access, err := repo.GenerateJWT(context.Background(), user, Device{})
+	require.NoError(t, err)
+
+	claims, err = repo.ValidateBearerToken(access)
+	require.NoError(t, err)
+	assert.Nil(t, claims.Actor)
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// base32 of the ASCII secret "12345678901234567890" used by the RFC 6238 test vectors
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	testCases := []struct {
+		unix int64
+		code string
+	}{
+		{unix: 59, code: "287082"},
+		{unix: 1111111109, code: "081804"},
+		{unix: 1111111111, code: "050471"},
+		{unix: 1234567890, code: "005924"},
+		{unix: 2000000000, code: "279037"},
+	}
+
+	for _, tc := range testCases {
+		code, err := TOTPCode(rfcSecret, time.Unix(tc.unix, 0))
+		require.NoError(t, err)
+		assert.Equal(t, tc.code, code, "time: %d", tc.unix)
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+
+	step, err := ValidateTOTP(rfcSecret, "005924", now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1234567890/30), step)
+
+	// the code of the previous period is accepted for clock drift
+	previous, err := TOTPCode(rfcSecret, now.Add(-30*time.Second))
+	require.NoError(t, err)
+	step, err = ValidateTOTP(rfcSecret, previous, now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1234567890/30-1), step)
+
+	old, err := TOTPCode(rfcSecret, now.Add(-2*time.Minute))
+	require.NoError(t, err)
+	_, err = ValidateTOTP(rfcSecret, old, now)
+	assert.ErrorIs(t, err, ErrInvalidOTP)
+
+	_, err = ValidateTOTP("not base32!", "005924", now)
+	assert.ErrorIs(t, err, ErrInvalidTOTPSecret)
+}
+
+func TestTOTPURI(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	require.NoError(t, err)
+	assert.Len(t, secret, 32)
+
+	u, err := url.Parse(TOTPURI("Interviews", "test@test.com", secret))
+	require.NoError(t, err)
+
+	assert.Equal(t, "otpauth", u.Scheme)
+	assert.Equal(t, "totp", u.Host)
+	assert.Equal(t, "/Interviews:test@test.com", u.Path)
+	assert.Equal(t, secret, u.Query().Get("secret"))
+	assert.Equal(t, "Interviews", u.Query().Get("issuer"))
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, hashes, err := GenerateRecoveryCodes()
+	require.NoError(t, err)
+
+	assert.Len(t, codes, 10)
+	assert.Len(t, hashes, 10)
+
+	for i, code := range codes {
+		assert.Len(t, code, 9)
+		assert.Equal(t, hashes[i], HashRecoveryCode(code))
+		assert.Equal(t, hashes[i], HashRecoveryCode(" "+strings.ToUpper(code)))
+		assert.NotContains(t, hashes[i], code)
+	}
+}
@@ -0,0 +1,496 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"interviews/internal/config"
+	"interviews/internal/orgs"
+	data "interviews/internal/users"
+	"interviews/pkg"
+	validator "interviews/pkg/vaildator"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type PlanRepository interface {
+	New(ctx context.Context, plan *Plan) error
+	Get(ctx context.Context, code string) (*Plan, error)
+	List(ctx context.Context, withInactive bool) ([]Plan, error)
+	Update(ctx context.Context, plan *Plan) error
+}
+
+type SubscriptionRepository interface {
+	New(ctx context.Context, sub *Subscription) error
+	Get(ctx context.Context, id string) (*Subscription, error)
+	List(ctx context.Context, email string, orgIDs []primitive.ObjectID) ([]Subscription, error)
+	ListGranting(ctx context.Context, email string, orgIDs []primitive.ObjectID) ([]Subscription, error)
+	Cancel(ctx context.Context, id primitive.ObjectID, immediately bool) error
+	GetByProviderID(ctx context.Context, providerID string) (*Subscription, error)
+	SyncFromProvider(ctx context.Context, providerID, status string, periodEnd, eventAt time.Time) error
+	ListTrialsEndingBefore(ctx context.Context, before time.Time) ([]Subscription, error)
+	MarkReminded(ctx context.Context, id primitive.ObjectID) error
+	UnmarkReminded(ctx context.Context, id primitive.ObjectID) error
+	EndTrial(ctx context.Context, id primitive.ObjectID, status string) error
+}
+
+type MemberRepository interface {
+	ListForUser(ctx context.Context, email string) ([]orgs.Member, error)
+}
+
+type OrgRepository interface {
+	Get(ctx context.Context, id string) (*orgs.Org, error)
+	ListByIDs(ctx context.Context, ids []primitive.ObjectID) ([]orgs.Org, error)
+	Update(ctx context.Context, org *orgs.Org) error
+}
+
+type UserRepository interface {
+	GetByEmail(email string) (*data.User, error)
+}
+
+type Billing struct {
+	helper        pkg.Helper
+	e             pkg.CustomErrors
+	plans         PlanRepository
+	subscriptions SubscriptionRepository
+	members       MemberRepository
+	orgs          OrgRepository
+	users         UserRepository
+	coupons       CouponRepository
+	redemptions   RedemptionRepository
+	provider      Provider
+	events        WebhookEventRepository
+	mailer        Mailer
+	userCtx       data.UsersContext
+	cfg           config.Config
+}
+
+type envelope map[string]any
+
+// ListPlansHandler lists the plans that can be bought. Admins can ask for the
+// retired ones too.
+func (b *Billing) ListPlansHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	withInactive := r.URL.Query().Get("inactive") == "true" &&
+		b.userCtx.ContextGetUser(r).HasPermission(data.PermissionUsersAdmin)
+
+	plans, err := b.plans.List(ctx, withInactive)
+	if err != nil {
+		b.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = b.helper.WriteJSON(w, http.StatusOK, envelope{"plans": plans}, nil)
+	if err != nil {
+		b.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (b *Billing) CreatePlanHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	var input struct {
+		Code            string   `json:"code"`
+		Name            string   `json:"name"`
+		Kind            string   `json:"kind"`
+		Interval        string   `json:"interval"`
+		Price           int64    `json:"price"`
+		Currency        string   `json:"currency"`
+		Seats           int      `json:"seats"`
+		Courses         []string `json:"courses"`
+		ProviderPriceID string   `json:"provider_price_id"`
+		Active          *bool    `json:"active"`
+	}
+
+	err := b.helper.ReadJSON(w, r, &input)
+	if err != nil {
+		b.e.BadRequestResponse(w, r, err)
+
+		return
+	}
+
+	plan := &Plan{
+		Code:            input.Code,
+		Name:            strings.TrimSpace(input.Name),
+		Kind:            input.Kind,
+		Interval:        input.Interval,
+		Price:           input.Price,
+		Currency:        input.Currency,
+		Seats:           input.Seats,
+		Courses:         input.Courses,
+		ProviderPriceID: input.ProviderPriceID,
+		Active:          input.Active == nil || *input.Active,
+	}
+
+	v := validator.New()
+
+	ValidatePlan(v, plan)
+
+	if !v.Valid() {
+		b.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	err = b.plans.New(ctx, plan)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrDuplicatePlan):
+			v.AddError("code", "a plan with this code already exists")
+			b.e.FailedValidationResponse(w, r, v.Errors)
+		default:
+			b.e.ServerErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	err = b.helper.WriteJSON(w, http.StatusCreated, envelope{"plan": plan}, nil)
+	if err != nil {
+		b.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+// UpdatePlanHandler changes a plan for new subscriptions. Existing ones keep
+// what they were sold; retiring a plan only stops it from being offered. The
+// courses of a plan can't be changed since its subscriptions are given access
+// to them.
+func (b *Billing) UpdatePlanHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	plan, err := b.plans.Get(ctx, mux.Vars(r)["code"])
+	if err != nil {
+		b.billingErrorResponse(w, r, err)
+
+		return
+	}
+
+	var input struct {
+		Name            *string `json:"name"`
+		Price           *int64  `json:"price"`
+		Currency        *string `json:"currency"`
+		Seats           *int    `json:"seats"`
+		ProviderPriceID *string `json:"provider_price_id"`
+		Active          *bool   `json:"active"`
+	}
+
+	err = b.helper.ReadJSON(w, r, &input)
+	if err != nil {
+		b.e.BadRequestResponse(w, r, err)
+
+		return
+	}
+
+	if input.Name != nil {
+		plan.Name = strings.TrimSpace(*input.Name)
+	}
+
+	if input.Price != nil {
+		plan.Price = *input.Price
+	}
+
+	if input.Currency != nil {
+		plan.Currency = *input.Currency
+	}
+
+	if input.Seats != nil {
+		plan.Seats = *input.Seats
+	}
+
+	if input.ProviderPriceID != nil {
+		plan.ProviderPriceID = *input.ProviderPriceID
+	}
+
+	if input.Active != nil {
+		plan.Active = *input.Active
+	}
+
+	v := validator.New()
+
+	ValidatePlan(v, plan)
+
+	if !v.Valid() {
+		b.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	err = b.plans.Update(ctx, plan)
+	if err != nil {
+		b.billingErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = b.helper.WriteJSON(w, http.StatusOK, envelope{"plan": plan}, nil)
+	if err != nil {
+		b.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+// MySubscriptionsHandler lists the subscriptions of the user and the ones of
+// their orgs.
+func (b *Billing) MySubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	user := b.userCtx.ContextGetUser(r)
+
+	if user.IsService() {
+		b.e.NotPermittedResponse(w, r)
+
+		return
+	}
+
+	ids, err := b.orgIDs(ctx, user.Email)
+	if err != nil {
+		b.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	subs, err := b.subscriptions.List(ctx, user.Email, ids)
+	if err != nil {
+		b.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = b.helper.WriteJSON(w, http.StatusOK, envelope{"subscriptions": subs}, nil)
+	if err != nil {
+		b.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+// CreateSubscriptionHandler lets an admin subscribe a user to an individual
+// plan or an org to a team plan, e.g. for invoiced customers. The period
+// defaults to one interval of the plan.
+func (b *Billing) CreateSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	var input struct {
+		Plan             string     `json:"plan"`
+		Email            string     `json:"email"`
+		OrgID            string     `json:"org_id"`
+		CurrentPeriodEnd *time.Time `json:"current_period_end"`
+	}
+
+	err := b.helper.ReadJSON(w, r, &input)
+	if err != nil {
+		b.e.BadRequestResponse(w, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Plan != "", "plan", "must be provided")
+
+	if input.CurrentPeriodEnd != nil {
+		v.Check(input.CurrentPeriodEnd.After(time.Now()), "current_period_end", "must be in the future")
+	}
+
+	if !v.Valid() {
+		b.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	plan, err := b.plans.Get(ctx, input.Plan)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrPlanNotFound):
+			v.AddError("plan", "does not exist")
+			b.e.FailedValidationResponse(w, r, v.Errors)
+		default:
+			b.e.ServerErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	sub := &Subscription{
+		Plan:             plan.Code,
+		Status:           StatusActive,
+		CurrentPeriodEnd: plan.PeriodEnd(time.Now()),
+	}
+
+	if input.CurrentPeriodEnd != nil {
+		sub.CurrentPeriodEnd = *input.CurrentPeriodEnd
+	}
+
+	var org *orgs.Org
+
+	if plan.Kind == PlanTeam {
+		v.Check(input.Email == "", "email", "must not be set for a team plan")
+
+		org, err = b.orgs.Get(ctx, input.OrgID)
+		switch {
+		case err == nil:
+			sub.OrgID = &org.ID
+		case errors.Is(err, orgs.ErrOrgNotFound):
+			v.AddError("org_id", "must be an existing organization")
+		default:
+			b.e.ServerErrorResponse(w, r, err)
+
+			return
+		}
+	} else {
+		v.Check(input.OrgID == "", "org_id", "must only be set for a team plan")
+
+		user, err := b.users.GetByEmail(strings.ToLower(strings.TrimSpace(input.Email)))
+		switch {
+		case err == nil:
+			sub.Email = user.Email
+		case errors.Is(err, mongo.ErrNoDocuments), errors.Is(err, data.ErrInvalidEmail):
+			v.AddError("email", "must belong to an existing user")
+		default:
+			b.e.ServerErrorResponse(w, r, err)
+
+			return
+		}
+	}
+
+	if !v.Valid() {
+		b.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	active, err := b.subscriptions.ListGranting(ctx, sub.Email, orgIDs(sub.OrgID))
+	if err != nil {
+		b.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	// a trial ends once the user subscribes
+	if paid(active) {
+		b.e.BadRequestResponse(w, r, ErrAlreadySubscribed)
+
+		return
+	}
+
+	err = b.subscriptions.New(ctx, sub)
+	if err != nil {
+		b.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	// the org gets at least the seats that were bought
+	if org != nil && org.Seats < plan.Seats {
+		org.Seats = plan.Seats
+
+		err = b.orgs.Update(ctx, org)
+		if err != nil {
+			b.e.ServerErrorResponse(w, r, err)
+
+			return
+		}
+	}
+
+	err = b.helper.WriteJSON(w, http.StatusCreated, envelope{"subscription": sub}, nil)
+	if err != nil {
+		b.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+// CancelSubscriptionHandler lets an admin stop a subscription from renewing,
+// or end it right away.
+func (b *Billing) CancelSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	var input struct {
+		Immediately bool `json:"immediately"`
+	}
+
+	err := b.helper.ReadJSON(w, r, &input)
+	if err != nil {
+		b.e.BadRequestResponse(w, r, err)
+
+		return
+	}
+
+	sub, err := b.subscriptions.Get(ctx, mux.Vars(r)["id"])
+	if err != nil {
+		b.billingErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = b.subscriptions.Cancel(ctx, sub.ID, input.Immediately)
+	if err != nil {
+		b.billingErrorResponse(w, r, err)
+
+		return
+	}
+
+	sub, err = b.subscriptions.Get(ctx, sub.ID.Hex())
+	if err != nil {
+		b.billingErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = b.helper.WriteJSON(w, http.StatusOK, envelope{"subscription": sub}, nil)
+	if err != nil {
+		b.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (b *Billing) orgIDs(ctx context.Context, email string) ([]primitive.ObjectID, error) {
+	members, err := b.members.ListForUser(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(members))
+	for _, m := range members {
+		ids = append(ids, m.OrgID)
+	}
+
+	return ids, nil
+}
+
+func (b *Billing) billingErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, ErrPlanNotFound), errors.Is(err, ErrSubscriptionNotFound):
+		b.e.NotFoundResponse(w, r)
+	default:
+		b.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+func orgIDs(id *primitive.ObjectID) []primitive.ObjectID {
+	if id == nil {
+		return nil
+	}
+
+	return []primitive.ObjectID{*id}
+}
+
+func NewBillingService(plans PlanRepository, subscriptions SubscriptionRepository, members MemberRepository,
+	orgs OrgRepository, users UserRepository, coupons CouponRepository, redemptions RedemptionRepository,
+	provider Provider, events WebhookEventRepository, mailer Mailer, cfg config.Config) *Billing {
+	return &Billing{
+		helper:        pkg.Helper{},
+		e:             pkg.CustomErrors{},
+		plans:         plans,
+		subscriptions: subscriptions,
+		members:       members,
+		orgs:          orgs,
+		users:         users,
+		coupons:       coupons,
+		redemptions:   redemptions,
+		provider:      provider,
+		events:        events,
+		mailer:        mailer,
+		userCtx:       data.UsersContext{},
+		cfg:           cfg,
+	}
+}
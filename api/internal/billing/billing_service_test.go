@@ -0,0 +1,722 @@
+package billing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"interviews/internal/config"
+	"interviews/internal/orgs"
+	data "interviews/internal/users"
+	"interviews/internal/users/userstest"
+)
+
+type MockPlanRepository struct {
+	plans map[string]*Plan
+}
+
+func (m *MockPlanRepository) New(ctx context.Context, plan *Plan) error {
+	if _, ok := m.plans[plan.Code]; ok {
+		return ErrDuplicatePlan
+	}
+
+	m.plans[plan.Code] = plan
+
+	return nil
+}
+
+func (m *MockPlanRepository) Get(ctx context.Context, code string) (*Plan, error) {
+	plan, ok := m.plans[code]
+	if !ok {
+		return nil, ErrPlanNotFound
+	}
+
+	found := *plan
+
+	return &found, nil
+}
+
+func (m *MockPlanRepository) List(ctx context.Context, withInactive bool) ([]Plan, error) {
+	plans := []Plan{}
+
+	for _, plan := range m.plans {
+		if plan.Active || withInactive {
+			plans = append(plans, *plan)
+		}
+	}
+
+	return plans, nil
+}
+
+func (m *MockPlanRepository) Update(ctx context.Context, plan *Plan) error {
+	m.plans[plan.Code] = plan
+
+	return nil
+}
+
+type MockSubscriptionRepository struct {
+	subs []*Subscription
+}
+
+func (m *MockSubscriptionRepository) New(ctx context.Context, sub *Subscription) error {
+	sub.ID = primitive.NewObjectID()
+	m.subs = append(m.subs, sub)
+
+	return nil
+}
+
+func (m *MockSubscriptionRepository) Get(ctx context.Context, id string) (*Subscription, error) {
+	for _, sub := range m.subs {
+		if sub.ID.Hex() == id {
+			found := *sub
+
+			return &found, nil
+		}
+	}
+
+	return nil, ErrSubscriptionNotFound
+}
+
+func (m *MockSubscriptionRepository) List(ctx context.Context, email string, orgIDs []primitive.ObjectID) ([]Subscription, error) {
+	subs := []Subscription{}
+
+	for _, sub := range m.subs {
+		if (email != "" && sub.Email == email) || (sub.OrgID != nil && containsID(orgIDs, *sub.OrgID)) {
+			subs = append(subs, *sub)
+		}
+	}
+
+	return subs, nil
+}
+
+func (m *MockSubscriptionRepository) ListGranting(ctx context.Context, email string, orgIDs []primitive.ObjectID) ([]Subscription, error) {
+	subs, _ := m.List(ctx, email, orgIDs)
+
+	granting := []Subscription{}
+
+	for _, sub := range subs {
+		if sub.Grants(time.Now()) {
+			granting = append(granting, sub)
+		}
+	}
+
+	return granting, nil
+}
+
+func (m *MockSubscriptionRepository) Cancel(ctx context.Context, id primitive.ObjectID, immediately bool) error {
+	for _, sub := range m.subs {
+		if sub.ID == id {
+			sub.Status = StatusCanceled
+
+			if immediately {
+				sub.Status = StatusExpired
+				sub.CurrentPeriodEnd = time.Now()
+			}
+
+			return nil
+		}
+	}
+
+	return ErrSubscriptionNotFound
+}
+
+func (m *MockSubscriptionRepository) GetByProviderID(ctx context.Context, providerID string) (*Subscription, error) {
+	for _, sub := range m.subs {
+		if sub.ProviderID == providerID {
+			found := *sub
+
+			return &found, nil
+		}
+	}
+
+	return nil, ErrSubscriptionNotFound
+}
+
+func (m *MockSubscriptionRepository) SyncFromProvider(ctx context.Context, providerID, status string, periodEnd, eventAt time.Time) error {
+	for _, sub := range m.subs {
+		if sub.ProviderID != providerID {
+			continue
+		}
+
+		if eventAt.Before(sub.ProviderEventAt) {
+			return nil
+		}
+
+		sub.Status = status
+		sub.ProviderEventAt = eventAt
+
+		if !periodEnd.IsZero() {
+			sub.CurrentPeriodEnd = periodEnd
+		}
+
+		return nil
+	}
+
+	return ErrSubscriptionNotFound
+}
+
+func (m *MockSubscriptionRepository) ListTrialsEndingBefore(ctx context.Context, before time.Time) ([]Subscription, error) {
+	subs := []Subscription{}
+
+	for _, sub := range m.subs {
+		if sub.IsTrial() && sub.Status == StatusTrialing && !sub.CurrentPeriodEnd.After(before) {
+			subs = append(subs, *sub)
+		}
+	}
+
+	return subs, nil
+}
+
+func (m *MockSubscriptionRepository) MarkReminded(ctx context.Context, id primitive.ObjectID) error {
+	for _, sub := range m.subs {
+		if sub.ID == id && sub.Status == StatusTrialing && sub.ReminderSentAt.IsZero() {
+			sub.ReminderSentAt = time.Now()
+
+			return nil
+		}
+	}
+
+	return ErrSubscriptionNotFound
+}
+
+func (m *MockSubscriptionRepository) UnmarkReminded(ctx context.Context, id primitive.ObjectID) error {
+	for _, sub := range m.subs {
+		if sub.ID == id {
+			sub.ReminderSentAt = time.Time{}
+		}
+	}
+
+	return nil
+}
+
+func (m *MockSubscriptionRepository) EndTrial(ctx context.Context, id primitive.ObjectID, status string) error {
+	for _, sub := range m.subs {
+		if sub.ID == id && sub.Status == StatusTrialing {
+			sub.Status = status
+
+			if now := time.Now(); now.Before(sub.CurrentPeriodEnd) {
+				sub.CurrentPeriodEnd = now
+			}
+
+			return nil
+		}
+	}
+
+	return ErrSubscriptionNotFound
+}
+
+func containsID(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+type MockMemberRepository struct {
+	members []orgs.Member
+}
+
+func (m *MockMemberRepository) ListForUser(ctx context.Context, email string) ([]orgs.Member, error) {
+	members := []orgs.Member{}
+
+	for _, member := range m.members {
+		if member.Email == email {
+			members = append(members, member)
+		}
+	}
+
+	return members, nil
+}
+
+type MockOrgRepository struct {
+	orgs map[primitive.ObjectID]*orgs.Org
+}
+
+func (m *MockOrgRepository) Get(ctx context.Context, id string) (*orgs.Org, error) {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+
+	org, ok := m.orgs[objectID]
+	if !ok {
+		return nil, orgs.ErrOrgNotFound
+	}
+
+	found := *org
+
+	return &found, nil
+}
+
+func (m *MockOrgRepository) ListByIDs(ctx context.Context, ids []primitive.ObjectID) ([]orgs.Org, error) {
+	found := []orgs.Org{}
+
+	for _, id := range ids {
+		if org, ok := m.orgs[id]; ok {
+			found = append(found, *org)
+		}
+	}
+
+	return found, nil
+}
+
+func (m *MockOrgRepository) Update(ctx context.Context, org *orgs.Org) error {
+	m.orgs[org.ID] = org
+
+	return nil
+}
+
+type MockUserRepository struct {
+	users map[string]*data.User
+}
+
+func (m *MockUserRepository) GetByEmail(email string) (*data.User, error) {
+	user, ok := m.users[email]
+	if !ok {
+		return &data.User{}, mongo.ErrNoDocuments
+	}
+
+	return user, nil
+}
+
+type MockWebhookEventRepository struct {
+	processed map[string]bool
+}
+
+func (m *MockWebhookEventRepository) Claim(ctx context.Context, id, eventType string) error {
+	if _, ok := m.processed[id]; ok {
+		return ErrEventProcessed
+	}
+
+	m.processed[id] = false
+
+	return nil
+}
+
+func (m *MockWebhookEventRepository) Complete(ctx context.Context, id string) error {
+	m.processed[id] = true
+
+	return nil
+}
+
+func (m *MockWebhookEventRepository) Release(ctx context.Context, id string) error {
+	delete(m.processed, id)
+
+	return nil
+}
+
+type MockCouponRepository struct {
+	mu      sync.Mutex
+	coupons map[string]*Coupon
+}
+
+func (m *MockCouponRepository) New(ctx context.Context, coupon *Coupon) error {
+	if _, ok := m.coupons[coupon.Code]; ok {
+		return ErrDuplicateCoupon
+	}
+
+	coupon.ID = primitive.NewObjectID()
+	m.coupons[coupon.Code] = coupon
+
+	return nil
+}
+
+func (m *MockCouponRepository) Get(ctx context.Context, code string) (*Coupon, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	coupon, ok := m.coupons[code]
+	if !ok {
+		return nil, ErrCouponNotFound
+	}
+
+	found := *coupon
+
+	return &found, nil
+}
+
+func (m *MockCouponRepository) List(ctx context.Context) ([]Coupon, error) {
+	coupons := []Coupon{}
+
+	for _, coupon := range m.coupons {
+		coupons = append(coupons, *coupon)
+	}
+
+	return coupons, nil
+}
+
+func (m *MockCouponRepository) Update(ctx context.Context, coupon *Coupon) error {
+	if _, ok := m.coupons[coupon.Code]; !ok {
+		return ErrCouponNotFound
+	}
+
+	m.coupons[coupon.Code] = coupon
+
+	return nil
+}
+
+func (m *MockCouponRepository) Delete(ctx context.Context, code string) error {
+	coupon, ok := m.coupons[code]
+	if !ok {
+		return ErrCouponNotFound
+	}
+
+	if coupon.Uses > 0 {
+		return ErrCouponHasRedemption
+	}
+
+	delete(m.coupons, code)
+
+	return nil
+}
+
+func (m *MockCouponRepository) Reserve(ctx context.Context, code string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	coupon, ok := m.coupons[code]
+	if !ok || !coupon.Active || (coupon.MaxRedemptions > 0 && coupon.Uses >= coupon.MaxRedemptions) {
+		return ErrCouponUsedUp
+	}
+
+	coupon.Uses++
+
+	return nil
+}
+
+func (m *MockCouponRepository) Release(ctx context.Context, code string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if coupon, ok := m.coupons[code]; ok && coupon.Uses > 0 {
+		coupon.Uses--
+	}
+
+	return nil
+}
+
+type MockRedemptionRepository struct {
+	mu          sync.Mutex
+	redemptions []*Redemption
+}
+
+func (m *MockRedemptionRepository) New(ctx context.Context, redemption *Redemption, perUserLimit int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if perUserLimit > 0 {
+		used := 0
+
+		for _, r := range m.redemptions {
+			if r.Coupon == redemption.Coupon && r.Email == redemption.Email && r.Status != RedemptionReleased {
+				used++
+			}
+		}
+
+		if used >= perUserLimit {
+			return ErrCouponUserLimit
+		}
+	}
+
+	redemption.ID = primitive.NewObjectID()
+	redemption.Status = RedemptionPending
+	m.redemptions = append(m.redemptions, redemption)
+
+	return nil
+}
+
+func (m *MockRedemptionRepository) ListForCoupon(ctx context.Context, coupon string) ([]Redemption, error) {
+	redemptions := []Redemption{}
+
+	for _, r := range m.redemptions {
+		if r.Coupon == coupon {
+			redemptions = append(redemptions, *r)
+		}
+	}
+
+	return redemptions, nil
+}
+
+func (m *MockRedemptionRepository) Redeem(ctx context.Context, id string) error {
+	return m.setStatus(id, RedemptionRedeemed)
+}
+
+func (m *MockRedemptionRepository) Release(ctx context.Context, id string) error {
+	return m.setStatus(id, RedemptionReleased)
+}
+
+func (m *MockRedemptionRepository) setStatus(id, status string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, r := range m.redemptions {
+		if r.ID.Hex() == id && r.Status == RedemptionPending {
+			r.Status = status
+
+			return nil
+		}
+	}
+
+	return ErrRedemptionNotFound
+}
+
+type sentMail struct {
+	recipient string
+	template  string
+	data      map[string]any
+}
+
+type MockMailer struct {
+	sent []sentMail
+	err  error
+}
+
+func (m *MockMailer) Send(recipient, templateFile string, data any) error {
+	if m.err != nil {
+		return m.err
+	}
+
+	m.sent = append(m.sent, sentMail{recipient: recipient, template: templateFile, data: data.(map[string]any)})
+
+	return nil
+}
+
+type testBilling struct {
+	service       *Billing
+	plans         *MockPlanRepository
+	subscriptions *MockSubscriptionRepository
+	members       *MockMemberRepository
+	orgs          *MockOrgRepository
+	coupons       *MockCouponRepository
+	redemptions   *MockRedemptionRepository
+	events        *MockWebhookEventRepository
+	mailer        *MockMailer
+}
+
+func newTestBilling() *testBilling {
+	b := &testBilling{
+		plans: &MockPlanRepository{plans: map[string]*Plan{
+			"monthly": {Code: "monthly", Name: "Monthly", Kind: PlanIndividual, Interval: IntervalMonth, Price: 1900, Currency: "EUR", Active: true},
+			"annual":  {Code: "annual", Name: "Annual", Kind: PlanIndividual, Interval: IntervalYear, Price: 19000, Currency: "EUR", Active: true},
+			"team":    {Code: "team", Name: "Team", Kind: PlanTeam, Interval: IntervalYear, Price: 90000, Currency: "EUR", Seats: 10, Active: true},
+			"legacy":  {Code: "legacy", Name: "Legacy", Kind: PlanIndividual, Interval: IntervalMonth, Price: 900, Currency: "EUR"},
+		}},
+		subscriptions: &MockSubscriptionRepository{},
+		members:       &MockMemberRepository{},
+		orgs:          &MockOrgRepository{orgs: map[primitive.ObjectID]*orgs.Org{}},
+		coupons:       &MockCouponRepository{coupons: map[string]*Coupon{}},
+		redemptions:   &MockRedemptionRepository{},
+		events:        &MockWebhookEventRepository{processed: map[string]bool{}},
+		mailer:        &MockMailer{},
+	}
+
+	users := &MockUserRepository{users: map[string]*data.User{
+		"jane@test.com": {Email: "jane@test.com", Activated: true},
+	}}
+
+	var cfg config.Config
+	cfg.FrontendURL = "http://localhost:5173"
+	cfg.Payments.WebhookSecret = "whsec_test"
+	cfg.Payments.WebhookTolerance = 5 * time.Minute
+	cfg.Trials.Days = 14
+	cfg.Trials.ReminderBefore = 72 * time.Hour
+
+	b.service = NewBillingService(b.plans, b.subscriptions, b.members, b.orgs, users, b.coupons, b.redemptions,
+		NewStripeClient("http://127.0.0.1:0", "sk_test", http.DefaultClient), b.events, b.mailer, cfg)
+
+	return b
+}
+
+func TestBilling_ListPlansHandler(t *testing.T) {
+	b := newTestBilling()
+
+	list := func(user *data.User, target string) int {
+		recorder := httptest.NewRecorder()
+		b.service.ListPlansHandler(recorder, userstest.NewRequest(t, http.MethodGet, target, user, nil))
+
+		require.Equal(t, http.StatusOK, recorder.Code)
+
+		var res struct {
+			Plans []Plan `json:"plans"`
+		}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+
+		return len(res.Plans)
+	}
+
+	admin := &data.User{Email: "admin@test.com", Role: data.RoleAdmin, Activated: true}
+
+	assert.Equal(t, 3, list(data.AnonymousUser, "/v1/plans"))
+	assert.Equal(t, 3, list(data.AnonymousUser, "/v1/plans?inactive=true"))
+	assert.Equal(t, 4, list(admin, "/v1/plans?inactive=true"))
+}
+
+func TestBilling_CreatePlanHandler(t *testing.T) {
+	testCases := []struct {
+		name       string
+		body       map[string]any
+		statusCode int
+	}{
+		{
+			name:       "individual plan",
+			body:       map[string]any{"code": "quarterly", "name": "Quarterly", "kind": "individual", "interval": "month", "price": 4900, "currency": "EUR"},
+			statusCode: http.StatusCreated,
+		},
+		{
+			name:       "team plan",
+			body:       map[string]any{"code": "team-small", "name": "Small team", "kind": "team", "interval": "year", "price": 40000, "currency": "USD", "seats": 5},
+			statusCode: http.StatusCreated,
+		},
+		{
+			name:       "limited to a course",
+			body:       map[string]any{"code": "go", "name": "Go", "kind": "individual", "interval": "month", "price": 900, "currency": "EUR", "courses": []string{"go-course"}},
+			statusCode: http.StatusCreated,
+		},
+		{
+			name:       "duplicate courses",
+			body:       map[string]any{"code": "go", "name": "Go", "kind": "individual", "interval": "month", "price": 900, "currency": "EUR", "courses": []string{"go-course", "go-course"}},
+			statusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "team plan without seats",
+			body:       map[string]any{"code": "team-small", "name": "Small team", "kind": "team", "interval": "year", "price": 40000, "currency": "USD"},
+			statusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "seats on an individual plan",
+			body:       map[string]any{"code": "solo", "name": "Solo", "kind": "individual", "interval": "month", "price": 900, "currency": "EUR", "seats": 3},
+			statusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "code taken",
+			body:       map[string]any{"code": "monthly", "name": "Monthly", "kind": "individual", "interval": "month", "price": 900, "currency": "EUR"},
+			statusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "unknown interval",
+			body:       map[string]any{"code": "weekly", "name": "Weekly", "kind": "individual", "interval": "week", "price": 900, "currency": "EUR"},
+			statusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "bad currency",
+			body:       map[string]any{"code": "cheap", "name": "Cheap", "kind": "individual", "interval": "month", "price": -1, "currency": "euro"},
+			statusCode: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			b := newTestBilling()
+
+			recorder := httptest.NewRecorder()
+			b.service.CreatePlanHandler(recorder, userstest.NewRequest(t, http.MethodPost, "/v1/admin/plans", &data.User{}, tc.body))
+
+			assert.Equal(t, tc.statusCode, recorder.Code)
+
+			if tc.statusCode == http.StatusCreated {
+				plan, err := b.plans.Get(context.Background(), tc.body["code"].(string))
+				require.NoError(t, err)
+				assert.True(t, plan.Active)
+			}
+		})
+	}
+}
+
+func TestBilling_CreateSubscriptionHandler(t *testing.T) {
+	org := &orgs.Org{ID: primitive.NewObjectID(), Name: "Acme", Seats: 3}
+
+	testCases := []struct {
+		name       string
+		body       map[string]any
+		statusCode int
+	}{
+		{name: "individual", body: map[string]any{"plan": "monthly", "email": "Jane@test.com"}, statusCode: http.StatusCreated},
+		{name: "team", body: map[string]any{"plan": "team", "org_id": org.ID.Hex()}, statusCode: http.StatusCreated},
+		{name: "unknown plan", body: map[string]any{"plan": "lifetime", "email": "jane@test.com"}, statusCode: http.StatusUnprocessableEntity},
+		{name: "unknown user", body: map[string]any{"plan": "monthly", "email": "john@test.com"}, statusCode: http.StatusUnprocessableEntity},
+		{name: "team plan for a user", body: map[string]any{"plan": "team", "email": "jane@test.com"}, statusCode: http.StatusUnprocessableEntity},
+		{name: "individual plan for an org", body: map[string]any{"plan": "monthly", "email": "jane@test.com", "org_id": org.ID.Hex()}, statusCode: http.StatusUnprocessableEntity},
+		{name: "period in the past", body: map[string]any{"plan": "monthly", "email": "jane@test.com", "current_period_end": "2020-01-01T00:00:00Z"}, statusCode: http.StatusUnprocessableEntity},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			b := newTestBilling()
+			stored := *org
+			b.orgs.orgs[org.ID] = &stored
+
+			recorder := httptest.NewRecorder()
+			b.service.CreateSubscriptionHandler(recorder, userstest.NewRequest(t, http.MethodPost, "/v1/admin/subscriptions", &data.User{}, tc.body))
+
+			require.Equal(t, tc.statusCode, recorder.Code)
+
+			if tc.statusCode != http.StatusCreated {
+				assert.Empty(t, b.subscriptions.subs)
+
+				return
+			}
+
+			require.Len(t, b.subscriptions.subs, 1)
+
+			sub := b.subscriptions.subs[0]
+			assert.Equal(t, StatusActive, sub.Status)
+
+			if sub.OrgID != nil {
+				assert.Equal(t, org.ID, *sub.OrgID)
+				assert.Empty(t, sub.Email)
+				assert.WithinDuration(t, time.Now().AddDate(1, 0, 0), sub.CurrentPeriodEnd, time.Minute)
+				// the org gets the seats of the plan
+				assert.Equal(t, 10, b.orgs.orgs[org.ID].Seats)
+			} else {
+				assert.Equal(t, "jane@test.com", sub.Email)
+				assert.WithinDuration(t, time.Now().AddDate(0, 1, 0), sub.CurrentPeriodEnd, time.Minute)
+			}
+
+			// one active subscription at a time
+			recorder = httptest.NewRecorder()
+			b.service.CreateSubscriptionHandler(recorder, userstest.NewRequest(t, http.MethodPost, "/v1/admin/subscriptions", &data.User{}, tc.body))
+
+			assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		})
+	}
+}
+
+func TestBilling_CancelSubscriptionHandler(t *testing.T) {
+	b := newTestBilling()
+
+	sub := &Subscription{Plan: "monthly", Email: "jane@test.com", Status: StatusActive, CurrentPeriodEnd: time.Now().Add(24 * time.Hour)}
+	require.NoError(t, b.subscriptions.New(context.Background(), sub))
+
+	cancel := func(id string, immediately bool) *httptest.ResponseRecorder {
+		req := userstest.NewRequest(t, http.MethodPost, "/v1/admin/subscriptions/"+id+"/cancel", &data.User{},
+			map[string]bool{"immediately": immediately})
+		req = mux.SetURLVars(req, map[string]string{"id": id})
+
+		recorder := httptest.NewRecorder()
+		b.service.CancelSubscriptionHandler(recorder, req)
+
+		return recorder
+	}
+
+	assert.Equal(t, http.StatusNotFound, cancel(primitive.NewObjectID().Hex(), false).Code)
+
+	assert.Equal(t, http.StatusOK, cancel(sub.ID.Hex(), false).Code)
+	assert.Equal(t, StatusCanceled, sub.Status)
+	// paid for until the end of the period
+	assert.True(t, sub.Grants(time.Now()))
+
+	assert.Equal(t, http.StatusOK, cancel(sub.ID.Hex(), true).Code)
+	assert.Equal(t, StatusExpired, sub.Status)
+	assert.False(t, sub.Grants(time.Now()))
+}
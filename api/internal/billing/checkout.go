@@ -0,0 +1,467 @@
+package billing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"interviews/internal/orgs"
+	log "interviews/pkg/logger"
+	validator "interviews/pkg/vaildator"
+)
+
+type WebhookEventRepository interface {
+	Claim(ctx context.Context, id, eventType string) error
+	Complete(ctx context.Context, id string) error
+	Release(ctx context.Context, id string) error
+}
+
+// CheckoutHandler starts the purchase of a plan at the payment provider. The
+// subscription is created once the provider reports the checkout completed.
+// Team plans can only be bought by an owner of the org. A coupon discounts the
+// first payment.
+func (b *Billing) CheckoutHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	user := b.userCtx.ContextGetUser(r)
+
+	if user.IsService() {
+		b.e.NotPermittedResponse(w, r)
+
+		return
+	}
+
+	var input struct {
+		Plan   string `json:"plan"`
+		OrgID  string `json:"org_id"`
+		Coupon string `json:"coupon"`
+	}
+
+	err := b.helper.ReadJSON(w, r, &input)
+	if err != nil {
+		b.e.BadRequestResponse(w, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Plan != "", "plan", "must be provided")
+
+	if !v.Valid() {
+		b.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	plan, err := b.plans.Get(ctx, input.Plan)
+	switch {
+	case err == nil:
+		v.Check(plan.Active && plan.ProviderPriceID != "", "plan", "can not be bought")
+	case errors.Is(err, ErrPlanNotFound):
+		v.AddError("plan", "does not exist")
+	default:
+		b.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	if !v.Valid() {
+		b.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	params := CheckoutParams{
+		PriceID:    plan.ProviderPriceID,
+		SuccessURL: b.cfg.FrontendURL + "/billing/success?session_id={CHECKOUT_SESSION_ID}",
+		CancelURL:  b.cfg.FrontendURL + "/billing",
+		Metadata:   map[string]string{"plan": plan.Code},
+	}
+
+	var email string
+	var ids []primitive.ObjectID
+	var org *orgs.Org
+
+	if plan.Kind == PlanTeam {
+		v.Check(input.OrgID != "", "org_id", "must be provided for a team plan")
+
+		if !v.Valid() {
+			b.e.FailedValidationResponse(w, r, v.Errors)
+
+			return
+		}
+
+		owner, err := b.isOwner(ctx, user.Email, input.OrgID)
+		if err != nil {
+			b.e.ServerErrorResponse(w, r, err)
+
+			return
+		}
+
+		if !owner {
+			b.e.NotPermittedResponse(w, r)
+
+			return
+		}
+
+		org, err = b.orgs.Get(ctx, input.OrgID)
+		if err != nil {
+			switch {
+			case errors.Is(err, orgs.ErrOrgNotFound):
+				b.e.NotFoundResponse(w, r)
+			default:
+				b.e.ServerErrorResponse(w, r, err)
+			}
+
+			return
+		}
+
+		ids = orgIDs(&org.ID)
+		params.Reference = org.ID.Hex()
+		params.Metadata["org_id"] = org.ID.Hex()
+		params.Metadata["purchased_by"] = user.Email
+	} else {
+		v.Check(input.OrgID == "", "org_id", "must only be set for a team plan")
+
+		if !v.Valid() {
+			b.e.FailedValidationResponse(w, r, v.Errors)
+
+			return
+		}
+
+		email = user.Email
+		params.Email = user.Email
+		params.Reference = user.Email
+		params.Metadata["email"] = user.Email
+	}
+
+	active, err := b.subscriptions.ListGranting(ctx, email, ids)
+	if err != nil {
+		b.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	// a trial ends once the user subscribes
+	if paid(active) {
+		b.e.BadRequestResponse(w, r, ErrAlreadySubscribed)
+
+		return
+	}
+
+	var redemption *Redemption
+
+	if input.Coupon != "" {
+		coupon, err := b.applyCoupon(ctx, v, input.Coupon, plan)
+		if err != nil {
+			b.e.ServerErrorResponse(w, r, err)
+
+			return
+		}
+
+		if !v.Valid() {
+			b.e.FailedValidationResponse(w, r, v.Errors)
+
+			return
+		}
+
+		redemption = &Redemption{
+			Coupon:   coupon.Code,
+			Email:    user.Email,
+			Plan:     plan.Code,
+			Discount: coupon.Discount(plan),
+			Currency: plan.Currency,
+		}
+
+		if org != nil {
+			redemption.OrgID = &org.ID
+		}
+
+		err = b.redemptions.New(ctx, redemption, coupon.PerUserLimit)
+		if err != nil {
+			_ = b.coupons.Release(ctx, coupon.Code)
+
+			if errors.Is(err, ErrCouponUserLimit) {
+				v.AddError("coupon", couponErrorMessage(err))
+				b.e.FailedValidationResponse(w, r, v.Errors)
+
+				return
+			}
+
+			b.e.ServerErrorResponse(w, r, err)
+
+			return
+		}
+
+		params.Coupon = coupon.ProviderCouponID
+		params.Metadata["coupon"] = coupon.Code
+		params.Metadata["redemption"] = redemption.ID.Hex()
+	}
+
+	session, err := b.provider.CreateCheckoutSession(ctx, params)
+	if err != nil {
+		if redemption != nil {
+			if releaseErr := b.releaseCoupon(ctx, redemption.Coupon, redemption.ID.Hex()); releaseErr != nil {
+				log.GetLoggerFromContext(r.Context()).ErrorCtx(releaseErr, log.Ctx{
+					"msg":    "unable to release coupon",
+					"coupon": redemption.Coupon,
+				})
+			}
+		}
+
+		b.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	res := envelope{"checkout": session}
+	if redemption != nil {
+		res["discount"] = redemption.Discount
+	}
+
+	err = b.helper.WriteJSON(w, http.StatusCreated, res, nil)
+	if err != nil {
+		b.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+// WebhookHandler receives the events of the payment provider. The provider
+// delivers an event at least once and retries until it gets a 2xx response,
+// so events are recorded and redeliveries acknowledged without applying them
+// again.
+func (b *Billing) WebhookHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+	clog := log.GetLoggerFromContext(r.Context())
+
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookSize))
+	if err != nil {
+		b.e.BadRequestResponse(w, r, err)
+
+		return
+	}
+
+	err = VerifySignature(payload, r.Header.Get(SignatureHeader), b.cfg.Payments.WebhookSecret,
+		b.cfg.Payments.WebhookTolerance, time.Now())
+	if err != nil {
+		b.e.BadRequestResponse(w, r, err)
+
+		return
+	}
+
+	var event Event
+
+	err = json.Unmarshal(payload, &event)
+	if err != nil || event.ID == "" {
+		b.e.BadRequestResponse(w, r, errors.New("body must be a webhook event"))
+
+		return
+	}
+
+	err = b.events.Claim(ctx, event.ID, event.Type)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrEventProcessed):
+			err = b.helper.WriteJSON(w, http.StatusOK, envelope{"received": true}, nil)
+			if err != nil {
+				b.e.ServerErrorResponse(w, r, err)
+			}
+		default:
+			b.e.ServerErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	err = b.handleEvent(ctx, &event)
+	if err != nil {
+		// let the provider retry it
+		if releaseErr := b.events.Release(ctx, event.ID); releaseErr != nil {
+			clog.ErrorCtx(releaseErr, log.Ctx{
+				"msg":   "unable to release payment event",
+				"event": event.ID,
+			})
+		}
+
+		b.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = b.events.Complete(ctx, event.ID)
+	if err != nil {
+		b.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = b.helper.WriteJSON(w, http.StatusOK, envelope{"received": true}, nil)
+	if err != nil {
+		b.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (b *Billing) handleEvent(ctx context.Context, event *Event) error {
+	switch event.Type {
+	case EventCheckoutCompleted:
+		var obj checkoutObject
+
+		err := json.Unmarshal(event.Data.Object, &obj)
+		if err != nil {
+			return err
+		}
+
+		return b.checkoutCompleted(ctx, obj, event.Time())
+	case EventCheckoutExpired:
+		var obj checkoutObject
+
+		err := json.Unmarshal(event.Data.Object, &obj)
+		if err != nil {
+			return err
+		}
+
+		if obj.Metadata["redemption"] == "" {
+			return nil
+		}
+
+		return b.releaseCoupon(ctx, obj.Metadata["coupon"], obj.Metadata["redemption"])
+	case EventSubscriptionCreated, EventSubscriptionUpdated:
+		var obj subscriptionObject
+
+		err := json.Unmarshal(event.Data.Object, &obj)
+		if err != nil {
+			return err
+		}
+
+		status, ok := providerStatus(obj)
+		if !ok {
+			log.WarnCtx("ignoring unknown payment provider subscription status", log.Ctx{
+				"event":  event.ID,
+				"status": obj.Status,
+			})
+
+			return nil
+		}
+
+		return b.subscriptions.SyncFromProvider(ctx, obj.ID, status, time.Unix(obj.CurrentPeriodEnd, 0), event.Time())
+	case EventSubscriptionDeleted:
+		var obj subscriptionObject
+
+		err := json.Unmarshal(event.Data.Object, &obj)
+		if err != nil {
+			return err
+		}
+
+		return b.subscriptions.SyncFromProvider(ctx, obj.ID, StatusExpired, event.Time(), event.Time())
+	case EventInvoicePaymentFailed:
+		var obj invoiceObject
+
+		err := json.Unmarshal(event.Data.Object, &obj)
+		if err != nil {
+			return err
+		}
+
+		if obj.Subscription == "" {
+			return nil
+		}
+
+		return b.subscriptions.SyncFromProvider(ctx, obj.Subscription, StatusPastDue, time.Time{}, event.Time())
+	}
+
+	return nil
+}
+
+// checkoutCompleted creates the subscription that was bought and converts the
+// trial of the buyer. The events of the subscription itself keep its state in
+// sync afterwards.
+func (b *Billing) checkoutCompleted(ctx context.Context, obj checkoutObject, at time.Time) error {
+	if id := obj.Metadata["redemption"]; id != "" {
+		err := b.redemptions.Redeem(ctx, id)
+		if err != nil && !errors.Is(err, ErrRedemptionNotFound) {
+			return err
+		}
+	}
+
+	if obj.Subscription == "" {
+		return nil
+	}
+
+	buyer := obj.Metadata["email"]
+	if buyer == "" {
+		buyer = obj.Metadata["purchased_by"]
+	}
+
+	// a redelivered event still converts the trial in case that failed
+	_, err := b.subscriptions.GetByProviderID(ctx, obj.Subscription)
+	if err == nil {
+		return b.convertTrial(ctx, buyer)
+	}
+
+	if !errors.Is(err, ErrSubscriptionNotFound) {
+		return err
+	}
+
+	plan, err := b.plans.Get(ctx, obj.Metadata["plan"])
+	if err != nil {
+		return err
+	}
+
+	sub := &Subscription{
+		Plan:             plan.Code,
+		Status:           StatusActive,
+		CurrentPeriodEnd: plan.PeriodEnd(at),
+		ProviderID:       obj.Subscription,
+		CustomerID:       obj.Customer,
+	}
+
+	var org *orgs.Org
+
+	if id := obj.Metadata["org_id"]; id != "" {
+		org, err = b.orgs.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		sub.OrgID = &org.ID
+	} else {
+		sub.Email = obj.Metadata["email"]
+	}
+
+	err = b.subscriptions.New(ctx, sub)
+	if err != nil {
+		return err
+	}
+
+	err = b.convertTrial(ctx, buyer)
+	if err != nil {
+		return err
+	}
+
+	// the org gets at least the seats that were bought
+	if org != nil && org.Seats < plan.Seats {
+		org.Seats = plan.Seats
+
+		return b.orgs.Update(ctx, org)
+	}
+
+	return nil
+}
+
+func (b *Billing) isOwner(ctx context.Context, email, orgID string) (bool, error) {
+	members, err := b.members.ListForUser(ctx, email)
+	if err != nil {
+		return false, err
+	}
+
+	for _, m := range members {
+		if m.OrgID.Hex() == orgID {
+			return m.Role == orgs.RoleOwner, nil
+		}
+	}
+
+	return false, nil
+}
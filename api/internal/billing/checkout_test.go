@@ -0,0 +1,447 @@
+package billing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"interviews/internal/orgs"
+	data "interviews/internal/users"
+	"interviews/internal/users/userstest"
+)
+
+func TestVerifySignature(t *testing.T) {
+	payload := []byte(`{"id":"evt_1"}`)
+	now := time.Now()
+	ts := strconv.FormatInt(now.Unix(), 10)
+
+	testCases := []struct {
+		name   string
+		header string
+		valid  bool
+	}{
+		{name: "valid", header: fmt.Sprintf("t=%s,v1=%s", ts, sign(payload, ts, "whsec_test")), valid: true},
+		{name: "wrong secret", header: fmt.Sprintf("t=%s,v1=%s", ts, sign(payload, ts, "whsec_other"))},
+		{
+			name:   "rolled secret",
+			header: fmt.Sprintf("t=%s,v1=%s,v1=%s", ts, sign(payload, ts, "whsec_other"), sign(payload, ts, "whsec_test")),
+			valid:  true,
+		},
+		{
+			name:   "too old",
+			header: fmt.Sprintf("t=%d,v1=%s", now.Add(-time.Hour).Unix(), sign(payload, strconv.FormatInt(now.Add(-time.Hour).Unix(), 10), "whsec_test")),
+		},
+		{name: "timestamp not signed", header: fmt.Sprintf("t=%d,v1=%s", now.Unix()-1, sign(payload, ts, "whsec_test"))},
+		{name: "no signature", header: "t=" + ts},
+		{name: "malformed", header: "garbage"},
+		{name: "empty", header: ""},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			err := VerifySignature(payload, tc.header, "whsec_test", 5*time.Minute, now)
+			if tc.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrInvalidSignature)
+			}
+		})
+	}
+
+	header := fmt.Sprintf("t=%s,v1=%s", ts, sign(payload, ts, ""))
+	assert.ErrorIs(t, VerifySignature(payload, header, "", 5*time.Minute, now), ErrInvalidSignature)
+}
+
+// fakeProvider stands in for the payment provider.
+type fakeProvider struct {
+	mu       sync.Mutex
+	server   *httptest.Server
+	sessions []url.Values
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	p := &fakeProvider{}
+
+	p.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/checkout/sessions" || r.Header.Get("Authorization") != "Bearer sk_test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":{"message":"invalid api key"}}`))
+
+			return
+		}
+
+		require.NoError(t, r.ParseForm())
+
+		p.mu.Lock()
+		p.sessions = append(p.sessions, r.PostForm)
+		id := fmt.Sprintf("cs_test_%d", len(p.sessions))
+		p.mu.Unlock()
+
+		_ = json.NewEncoder(w).Encode(CheckoutSession{ID: id, URL: "https://checkout.test/" + id})
+	}))
+	t.Cleanup(p.server.Close)
+
+	return p
+}
+
+func newCheckoutBilling(t *testing.T) (*testBilling, *fakeProvider) {
+	b := newTestBilling()
+	b.plans.plans["monthly"].ProviderPriceID = "price_monthly"
+	b.plans.plans["team"].ProviderPriceID = "price_team"
+
+	p := newFakeProvider(t)
+	b.service.provider = NewStripeClient(p.server.URL, "sk_test", p.server.Client())
+
+	return b, p
+}
+
+// deliver sends a signed event to the webhook like the provider does.
+func deliver(t *testing.T, b *testBilling, event map[string]any) *httptest.ResponseRecorder {
+	payload, err := json.Marshal(event)
+	require.NoError(t, err)
+
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, _ := http.NewRequest(http.MethodPost, "/v1/billing/webhook", bytes.NewReader(payload))
+	req.Header.Set(SignatureHeader, fmt.Sprintf("t=%s,v1=%s", ts, sign(payload, ts, "whsec_test")))
+
+	rr := httptest.NewRecorder()
+	b.service.WebhookHandler(rr, req)
+
+	return rr
+}
+
+func event(id, eventType string, created time.Time, object map[string]any) map[string]any {
+	return map[string]any{
+		"id":      id,
+		"type":    eventType,
+		"created": created.Unix(),
+		"data":    map[string]any{"object": object},
+	}
+}
+
+func TestBilling_CheckoutHandler(t *testing.T) {
+	orgID := primitive.NewObjectID()
+	user := &data.User{Email: "jane@test.com", Activated: true}
+
+	testCases := []struct {
+		name           string
+		user           *data.User
+		input          map[string]any
+		subs           []*Subscription
+		expectedStatus int
+		expectedForm   map[string]string
+	}{
+		{
+			name:           "individual plan",
+			user:           user,
+			input:          map[string]any{"plan": "monthly"},
+			expectedStatus: http.StatusCreated,
+			expectedForm: map[string]string{
+				"line_items[0][price]": "price_monthly",
+				"customer_email":       "jane@test.com",
+				"metadata[email]":      "jane@test.com",
+				"metadata[plan]":       "monthly",
+				"success_url":          "http://localhost:5173/billing/success?session_id={CHECKOUT_SESSION_ID}",
+			},
+		},
+		{
+			name:           "team plan as owner",
+			user:           &data.User{Email: "owner@test.com", Activated: true},
+			input:          map[string]any{"plan": "team", "org_id": orgID.Hex()},
+			expectedStatus: http.StatusCreated,
+			expectedForm: map[string]string{
+				"line_items[0][price]":                      "price_team",
+				"metadata[org_id]":                          orgID.Hex(),
+				"metadata[purchased_by]":                    "owner@test.com",
+				"subscription_data[metadata][org_id]":       orgID.Hex(),
+				"subscription_data[metadata][plan]":         "team",
+				"client_reference_id":                       orgID.Hex(),
+				"subscription_data[metadata][purchased_by]": "owner@test.com",
+			},
+		},
+		{
+			name:           "team plan as member",
+			user:           &data.User{Email: "member@test.com", Activated: true},
+			input:          map[string]any{"plan": "team", "org_id": orgID.Hex()},
+			expectedStatus: http.StatusForbidden,
+		},
+		{name: "team plan without org", user: user, input: map[string]any{"plan": "team"}, expectedStatus: http.StatusUnprocessableEntity},
+		{name: "not for sale", user: user, input: map[string]any{"plan": "annual"}, expectedStatus: http.StatusUnprocessableEntity},
+		{name: "unknown plan", user: user, input: map[string]any{"plan": "nope"}, expectedStatus: http.StatusUnprocessableEntity},
+		{
+			name:           "already subscribed",
+			user:           user,
+			input:          map[string]any{"plan": "monthly"},
+			subs:           []*Subscription{{Email: "jane@test.com", Status: StatusActive, CurrentPeriodEnd: time.Now().Add(time.Hour)}},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "api key",
+			user:           &data.User{Service: &data.ServicePrincipal{Name: "reporting"}},
+			input:          map[string]any{"plan": "monthly"},
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			b, p := newCheckoutBilling(t)
+			b.subscriptions.subs = tc.subs
+			b.orgs.orgs[orgID] = &orgs.Org{ID: orgID, Name: "Acme"}
+			b.members.members = []orgs.Member{
+				{OrgID: orgID, Email: "owner@test.com", Role: orgs.RoleOwner},
+				{OrgID: orgID, Email: "member@test.com", Role: orgs.RoleMember},
+			}
+
+			rr := httptest.NewRecorder()
+			b.service.CheckoutHandler(rr, userstest.NewRequest(t, http.MethodPost, "/v1/billing/checkout", tc.user, tc.input))
+
+			require.Equal(t, tc.expectedStatus, rr.Code, rr.Body.String())
+
+			if tc.expectedStatus != http.StatusCreated {
+				assert.Empty(t, p.sessions)
+
+				return
+			}
+
+			var res struct {
+				Checkout CheckoutSession `json:"checkout"`
+			}
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+			assert.Equal(t, "https://checkout.test/cs_test_1", res.Checkout.URL)
+
+			require.Len(t, p.sessions, 1)
+			assert.Equal(t, "subscription", p.sessions[0].Get("mode"))
+
+			for k, v := range tc.expectedForm {
+				assert.Equal(t, v, p.sessions[0].Get(k), k)
+			}
+		})
+	}
+}
+
+func TestBilling_CheckoutHandler_ProviderError(t *testing.T) {
+	b, p := newCheckoutBilling(t)
+	b.service.provider = NewStripeClient(p.server.URL, "sk_wrong", p.server.Client())
+
+	rr := httptest.NewRecorder()
+	b.service.CheckoutHandler(rr, userstest.NewRequest(t, http.MethodPost, "/v1/billing/checkout",
+		&data.User{Email: "jane@test.com", Activated: true}, map[string]any{"plan": "monthly"}))
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+}
+
+// TestBilling_Webhook runs a purchase end to end: checkout at the fake
+// provider, then the events the provider sends for the subscription.
+func TestBilling_Webhook(t *testing.T) {
+	b, p := newCheckoutBilling(t)
+
+	rr := httptest.NewRecorder()
+	b.service.CheckoutHandler(rr, userstest.NewRequest(t, http.MethodPost, "/v1/billing/checkout",
+		&data.User{Email: "jane@test.com", Activated: true}, map[string]any{"plan": "monthly"}))
+	require.Equal(t, http.StatusCreated, rr.Code)
+	require.Len(t, p.sessions, 1)
+
+	start := time.Now().Add(-time.Minute).Truncate(time.Second)
+	metadata := map[string]any{}
+	for k := range p.sessions[0] {
+		if strings.HasPrefix(k, "metadata[") {
+			metadata[strings.TrimSuffix(strings.TrimPrefix(k, "metadata["), "]")] = p.sessions[0].Get(k)
+		}
+	}
+
+	completed := event("evt_1", EventCheckoutCompleted, start, map[string]any{
+		"id":           "cs_test_1",
+		"subscription": "sub_1",
+		"customer":     "cus_1",
+		"metadata":     metadata,
+	})
+
+	rr = deliver(t, b, completed)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.Len(t, b.subscriptions.subs, 1)
+
+	sub := b.subscriptions.subs[0]
+	assert.Equal(t, "jane@test.com", sub.Email)
+	assert.Equal(t, "monthly", sub.Plan)
+	assert.Equal(t, StatusActive, sub.Status)
+	assert.Equal(t, "sub_1", sub.ProviderID)
+	assert.Equal(t, "cus_1", sub.CustomerID)
+	assert.True(t, sub.Grants(time.Now()))
+
+	// a redelivery is acknowledged but not applied again
+	rr = deliver(t, b, completed)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Len(t, b.subscriptions.subs, 1)
+
+	periodEnd := start.AddDate(0, 1, 0)
+
+	rr = deliver(t, b, event("evt_2", EventSubscriptionUpdated, start.Add(10*time.Second), map[string]any{
+		"id":                   "sub_1",
+		"status":               "active",
+		"current_period_end":   periodEnd.Unix(),
+		"cancel_at_period_end": true,
+	}))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, StatusCanceled, sub.Status)
+	assert.Equal(t, periodEnd.Unix(), sub.CurrentPeriodEnd.Unix())
+
+	rr = deliver(t, b, event("evt_3", EventInvoicePaymentFailed, start.Add(20*time.Second), map[string]any{
+		"subscription": "sub_1",
+	}))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, StatusPastDue, sub.Status)
+
+	// delivered late, a newer state was applied already
+	rr = deliver(t, b, event("evt_4", EventSubscriptionUpdated, start.Add(5*time.Second), map[string]any{
+		"id":                 "sub_1",
+		"status":             "active",
+		"current_period_end": periodEnd.Unix(),
+	}))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, StatusPastDue, sub.Status)
+
+	// unknown statuses don't change anything
+	rr = deliver(t, b, event("evt_5", EventSubscriptionUpdated, start.Add(25*time.Second), map[string]any{
+		"id":     "sub_1",
+		"status": "paused_forever",
+	}))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, StatusPastDue, sub.Status)
+
+	rr = deliver(t, b, event("evt_6", EventSubscriptionDeleted, start.Add(30*time.Second), map[string]any{
+		"id":     "sub_1",
+		"status": "canceled",
+	}))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, StatusExpired, sub.Status)
+	assert.False(t, sub.Grants(time.Now()))
+
+	for _, id := range []string{"evt_1", "evt_2", "evt_3", "evt_4", "evt_5", "evt_6"} {
+		assert.True(t, b.events.processed[id], id)
+	}
+}
+
+func TestBilling_Webhook_Team(t *testing.T) {
+	b, _ := newCheckoutBilling(t)
+
+	orgID := primitive.NewObjectID()
+	b.orgs.orgs[orgID] = &orgs.Org{ID: orgID, Name: "Acme", Seats: 3}
+
+	rr := deliver(t, b, event("evt_1", EventCheckoutCompleted, time.Now(), map[string]any{
+		"id":           "cs_test_1",
+		"subscription": "sub_1",
+		"metadata":     map[string]any{"plan": "team", "org_id": orgID.Hex(), "purchased_by": "owner@test.com"},
+	}))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	require.Len(t, b.subscriptions.subs, 1)
+	assert.Equal(t, orgID, *b.subscriptions.subs[0].OrgID)
+	assert.Empty(t, b.subscriptions.subs[0].Email)
+	assert.Equal(t, 10, b.orgs.orgs[orgID].Seats)
+}
+
+func TestBilling_Webhook_ConvertsTrial(t *testing.T) {
+	b, _ := newCheckoutBilling(t)
+
+	trial := &Subscription{
+		ID:               primitive.NewObjectID(),
+		Plan:             TrialPlan,
+		Email:            "jane@test.com",
+		Status:           StatusTrialing,
+		CurrentPeriodEnd: time.Now().Add(10 * 24 * time.Hour),
+	}
+	b.subscriptions.subs = append(b.subscriptions.subs, trial)
+
+	completed := event("evt_1", EventCheckoutCompleted, time.Now(), map[string]any{
+		"id":           "cs_test_1",
+		"subscription": "sub_1",
+		"metadata":     map[string]any{"plan": "monthly", "email": "jane@test.com"},
+	})
+
+	rr := deliver(t, b, completed)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.Len(t, b.subscriptions.subs, 2)
+
+	// converted right away, not once the trial is about to end
+	assert.Equal(t, StatusConverted, trial.Status)
+	assert.False(t, trial.CurrentPeriodEnd.After(time.Now()))
+
+	// the team owner's trial is converted as well
+	orgID := primitive.NewObjectID()
+	b.orgs.orgs[orgID] = &orgs.Org{ID: orgID, Name: "Acme", Seats: 3}
+
+	ownerTrial := &Subscription{
+		ID:               primitive.NewObjectID(),
+		Plan:             TrialPlan,
+		Email:            "owner@test.com",
+		Status:           StatusTrialing,
+		CurrentPeriodEnd: time.Now().Add(10 * 24 * time.Hour),
+	}
+	b.subscriptions.subs = append(b.subscriptions.subs, ownerTrial)
+
+	rr = deliver(t, b, event("evt_2", EventCheckoutCompleted, time.Now(), map[string]any{
+		"id":           "cs_test_2",
+		"subscription": "sub_2",
+		"metadata":     map[string]any{"plan": "team", "org_id": orgID.Hex(), "purchased_by": "owner@test.com"},
+	}))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, StatusConverted, ownerTrial.Status)
+}
+
+func TestBilling_Webhook_Rejected(t *testing.T) {
+	b, _ := newCheckoutBilling(t)
+
+	payload := []byte(`{"id":"evt_1","type":"checkout.session.completed"}`)
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, _ := http.NewRequest(http.MethodPost, "/v1/billing/webhook", bytes.NewReader(payload))
+	req.Header.Set(SignatureHeader, fmt.Sprintf("t=%s,v1=%s", ts, sign(payload, ts, "whsec_forged")))
+
+	rr := httptest.NewRecorder()
+	b.service.WebhookHandler(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Empty(t, b.events.processed)
+}
+
+// An update for a subscription whose checkout wasn't processed yet fails, so
+// the provider retries it once the subscription exists.
+func TestBilling_Webhook_Retry(t *testing.T) {
+	b, _ := newCheckoutBilling(t)
+
+	updated := event("evt_2", EventSubscriptionUpdated, time.Now(), map[string]any{
+		"id":                 "sub_1",
+		"status":             "past_due",
+		"current_period_end": time.Now().AddDate(0, 1, 0).Unix(),
+	})
+
+	rr := deliver(t, b, updated)
+	require.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.NotContains(t, b.events.processed, "evt_2")
+
+	rr = deliver(t, b, event("evt_1", EventCheckoutCompleted, time.Now().Add(-time.Second), map[string]any{
+		"id":           "cs_test_1",
+		"subscription": "sub_1",
+		"metadata":     map[string]any{"plan": "monthly", "email": "jane@test.com"},
+	}))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	rr = deliver(t, b, updated)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, StatusPastDue, b.subscriptions.subs[0].Status)
+}
@@ -0,0 +1,258 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	validator "interviews/pkg/vaildator"
+)
+
+const (
+	DiscountPercent = "percent"
+	DiscountFixed   = "fixed"
+)
+
+var (
+	ErrCouponNotFound      = errors.New("coupon not found")
+	ErrDuplicateCoupon     = errors.New("a coupon with this code already exists")
+	ErrCouponInactive      = errors.New("coupon is not active")
+	ErrCouponExpired       = errors.New("coupon has expired")
+	ErrCouponUsedUp        = errors.New("coupon has been used up")
+	ErrCouponUserLimit     = errors.New("coupon was already used as often as allowed")
+	ErrCouponPlan          = errors.New("coupon is not valid for the plan")
+	ErrCouponCourse        = errors.New("coupon is only valid for specific courses")
+	ErrCouponCurrency      = errors.New("coupon is not valid for the currency of the plan")
+	ErrCouponHasRedemption = errors.New("coupon was already redeemed, deactivate it instead")
+
+	couponCodeRX = regexp.MustCompile("^[A-Z0-9]+([-_][A-Z0-9]+)*$")
+)
+
+// Coupon discounts the first payment of a plan bought at checkout. The
+// discount itself is applied by the payment provider.
+type Coupon struct {
+	ID   primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	Code string             `json:"code" bson:"code"`
+	Kind string             `json:"kind" bson:"kind"`
+	// for percentage discounts
+	Percent int `json:"percent,omitempty" bson:"percent,omitempty"`
+	// for fixed discounts, in the smallest unit of the currency
+	Amount   int64  `json:"amount,omitempty" bson:"amount,omitempty"`
+	Currency string `json:"currency,omitempty" bson:"currency,omitempty"`
+	// 0 means unlimited
+	MaxRedemptions int `json:"max_redemptions" bson:"maxRedemptions"`
+	PerUserLimit   int `json:"per_user_limit" bson:"perUserLimit"`
+	// checkouts that were started or completed with the coupon
+	Uses      int       `json:"uses" bson:"uses"`
+	ExpiresAt time.Time `json:"expires_at,omitempty" bson:"expiresAt,omitempty"`
+	// empty means any plan or course
+	Plans   []string `json:"plans" bson:"plans"`
+	Courses []string `json:"courses" bson:"courses"`
+	// the coupon at the payment provider
+	ProviderCouponID string    `json:"provider_coupon_id" bson:"providerCouponId"`
+	Active           bool      `json:"active" bson:"active"`
+	CreatedBy        string    `json:"created_by" bson:"createdBy"`
+	CreatedAt        time.Time `json:"created_at" bson:"createdAt"`
+}
+
+// Check reports why the coupon can't be used to buy the plan. A coupon that is
+// restricted to courses only discounts plans that grant nothing else. Usage
+// limits are checked when the coupon is reserved.
+func (c *Coupon) Check(plan *Plan, now time.Time) error {
+	switch {
+	case !c.Active:
+		return ErrCouponInactive
+	case !c.ExpiresAt.IsZero() && !now.Before(c.ExpiresAt):
+		return ErrCouponExpired
+	case len(c.Plans) > 0 && !validator.PermittedValue(plan.Code, c.Plans...):
+		return ErrCouponPlan
+	case len(c.Courses) > 0 && !plan.OnlyGrants(c.Courses):
+		return ErrCouponCourse
+	case c.Kind == DiscountFixed && c.Currency != plan.Currency:
+		return ErrCouponCurrency
+	}
+
+	return nil
+}
+
+// Discount returns how much less the first payment of the plan costs.
+func (c *Coupon) Discount(plan *Plan) int64 {
+	discount := c.Amount
+	if c.Kind == DiscountPercent {
+		discount = plan.Price * int64(c.Percent) / 100
+	}
+
+	if discount > plan.Price {
+		return plan.Price
+	}
+
+	return discount
+}
+
+type CouponRepo struct {
+	client     *mongo.Client
+	collection *mongo.Collection
+}
+
+func (m *CouponRepo) New(ctx context.Context, coupon *Coupon) error {
+	count, err := m.collection.CountDocuments(ctx, bson.M{"code": coupon.Code})
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return ErrDuplicateCoupon
+	}
+
+	coupon.CreatedAt = time.Now()
+
+	res, err := m.collection.InsertOne(ctx, coupon)
+	if err != nil {
+		return err
+	}
+
+	if id, ok := res.InsertedID.(primitive.ObjectID); ok {
+		coupon.ID = id
+	}
+
+	return nil
+}
+
+func (m *CouponRepo) Get(ctx context.Context, code string) (*Coupon, error) {
+	var coupon Coupon
+
+	err := m.collection.FindOne(ctx, bson.M{"code": code}).Decode(&coupon)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrCouponNotFound
+		}
+
+		return nil, err
+	}
+
+	return &coupon, nil
+}
+
+// List returns the coupons, newest first.
+func (m *CouponRepo) List(ctx context.Context) ([]Coupon, error) {
+	cursor, err := m.collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"createdAt": -1}))
+	if err != nil {
+		return nil, err
+	}
+
+	coupons := []Coupon{}
+
+	err = cursor.All(ctx, &coupons)
+	if err != nil {
+		return nil, err
+	}
+
+	return coupons, nil
+}
+
+// Update changes the limits and restrictions of a coupon; the discount is
+// fixed since it was promised to whoever got the code.
+func (m *CouponRepo) Update(ctx context.Context, coupon *Coupon) error {
+	res, err := m.collection.UpdateOne(ctx, bson.M{"code": coupon.Code}, bson.M{"$set": bson.M{
+		"maxRedemptions": coupon.MaxRedemptions,
+		"perUserLimit":   coupon.PerUserLimit,
+		"expiresAt":      coupon.ExpiresAt,
+		"plans":          coupon.Plans,
+		"courses":        coupon.Courses,
+		"active":         coupon.Active,
+	}})
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrCouponNotFound
+	}
+
+	return nil
+}
+
+// Delete removes a coupon that was never used.
+func (m *CouponRepo) Delete(ctx context.Context, code string) error {
+	res, err := m.collection.DeleteOne(ctx, bson.M{"code": code, "uses": 0})
+	if err != nil {
+		return err
+	}
+
+	if res.DeletedCount == 0 {
+		_, err = m.Get(ctx, code)
+		if err != nil {
+			return err
+		}
+
+		return ErrCouponHasRedemption
+	}
+
+	return nil
+}
+
+// Reserve takes one use of the coupon, unless it is used up. Concurrent
+// checkouts can't overshoot the limit.
+func (m *CouponRepo) Reserve(ctx context.Context, code string) error {
+	filter := bson.M{
+		"code":   code,
+		"active": true,
+		"$or": bson.A{
+			bson.M{"maxRedemptions": 0},
+			bson.M{"$expr": bson.M{"$lt": bson.A{"$uses", "$maxRedemptions"}}},
+		},
+	}
+
+	res, err := m.collection.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"uses": 1}})
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrCouponUsedUp
+	}
+
+	return nil
+}
+
+// Release gives back a use of a checkout that was abandoned.
+func (m *CouponRepo) Release(ctx context.Context, code string) error {
+	_, err := m.collection.UpdateOne(ctx, bson.M{"code": code, "uses": bson.M{"$gt": 0}}, bson.M{"$inc": bson.M{"uses": -1}})
+
+	return err
+}
+
+func ValidateCoupon(v *validator.Validator, coupon *Coupon) {
+	v.Check(validator.Matches(coupon.Code, couponCodeRX), "code", "must only contain letters, digits, dashes and underscores")
+	v.Check(len(coupon.Code) <= 50, "code", "must not be more than 50 bytes long")
+	v.Check(validator.PermittedValue(coupon.Kind, DiscountPercent, DiscountFixed), "kind", "must be percent or fixed")
+
+	if coupon.Kind == DiscountPercent {
+		v.Check(coupon.Percent > 0 && coupon.Percent <= 100, "percent", "must be between 1 and 100")
+		v.Check(coupon.Amount == 0, "amount", "must only be set for fixed discounts")
+		v.Check(coupon.Currency == "", "currency", "must only be set for fixed discounts")
+	} else {
+		v.Check(coupon.Amount > 0, "amount", "must be greater than zero")
+		v.Check(validator.Matches(coupon.Currency, currencyRX), "currency", "must be an ISO 4217 code, e.g. EUR")
+		v.Check(coupon.Percent == 0, "percent", "must only be set for percentage discounts")
+	}
+
+	v.Check(coupon.MaxRedemptions >= 0, "max_redemptions", "must not be negative")
+	v.Check(coupon.PerUserLimit >= 0, "per_user_limit", "must not be negative")
+	v.Check(validator.Unique(coupon.Plans), "plans", "must not contain duplicate values")
+	v.Check(validator.Unique(coupon.Courses), "courses", "must not contain duplicate values")
+	v.Check(coupon.ProviderCouponID != "", "provider_coupon_id", "must be provided")
+	v.Check(len(coupon.ProviderCouponID) <= 255, "provider_coupon_id", "must not be more than 255 bytes long")
+}
+
+func NewCouponRepository(client *mongo.Client, collection *mongo.Collection) *CouponRepo {
+	return &CouponRepo{
+		client:     client,
+		collection: collection,
+	}
+}
@@ -0,0 +1,396 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	validator "interviews/pkg/vaildator"
+)
+
+type CouponRepository interface {
+	New(ctx context.Context, coupon *Coupon) error
+	Get(ctx context.Context, code string) (*Coupon, error)
+	List(ctx context.Context) ([]Coupon, error)
+	Update(ctx context.Context, coupon *Coupon) error
+	Delete(ctx context.Context, code string) error
+	Reserve(ctx context.Context, code string) error
+	Release(ctx context.Context, code string) error
+}
+
+type RedemptionRepository interface {
+	New(ctx context.Context, redemption *Redemption, perUserLimit int) error
+	ListForCoupon(ctx context.Context, coupon string) ([]Redemption, error)
+	Redeem(ctx context.Context, id string) error
+	Release(ctx context.Context, id string) error
+}
+
+func (b *Billing) CreateCouponHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	admin := b.userCtx.ContextGetUser(r)
+
+	var input struct {
+		Code             string     `json:"code"`
+		Kind             string     `json:"kind"`
+		Percent          int        `json:"percent"`
+		Amount           int64      `json:"amount"`
+		Currency         string     `json:"currency"`
+		MaxRedemptions   int        `json:"max_redemptions"`
+		PerUserLimit     *int       `json:"per_user_limit"`
+		ExpiresAt        *time.Time `json:"expires_at"`
+		Plans            []string   `json:"plans"`
+		Courses          []string   `json:"courses"`
+		ProviderCouponID string     `json:"provider_coupon_id"`
+		Active           *bool      `json:"active"`
+	}
+
+	err := b.helper.ReadJSON(w, r, &input)
+	if err != nil {
+		b.e.BadRequestResponse(w, r, err)
+
+		return
+	}
+
+	coupon := &Coupon{
+		Code:             normalizeCode(input.Code),
+		Kind:             input.Kind,
+		Percent:          input.Percent,
+		Amount:           input.Amount,
+		Currency:         input.Currency,
+		MaxRedemptions:   input.MaxRedemptions,
+		PerUserLimit:     1,
+		Plans:            input.Plans,
+		Courses:          input.Courses,
+		ProviderCouponID: input.ProviderCouponID,
+		Active:           input.Active == nil || *input.Active,
+		CreatedBy:        admin.Email,
+	}
+
+	if input.PerUserLimit != nil {
+		coupon.PerUserLimit = *input.PerUserLimit
+	}
+
+	if input.ExpiresAt != nil {
+		coupon.ExpiresAt = *input.ExpiresAt
+	}
+
+	if coupon.Plans == nil {
+		coupon.Plans = []string{}
+	}
+
+	if coupon.Courses == nil {
+		coupon.Courses = []string{}
+	}
+
+	v := validator.New()
+
+	ValidateCoupon(v, coupon)
+
+	if input.ExpiresAt != nil {
+		v.Check(input.ExpiresAt.After(time.Now()), "expires_at", "must be in the future")
+	}
+
+	err = b.checkCouponPlans(ctx, v, coupon)
+	if err != nil {
+		b.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	if !v.Valid() {
+		b.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	err = b.coupons.New(ctx, coupon)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrDuplicateCoupon):
+			v.AddError("code", "a coupon with this code already exists")
+			b.e.FailedValidationResponse(w, r, v.Errors)
+		default:
+			b.e.ServerErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	err = b.helper.WriteJSON(w, http.StatusCreated, envelope{"coupon": coupon}, nil)
+	if err != nil {
+		b.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (b *Billing) ListCouponsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	coupons, err := b.coupons.List(ctx)
+	if err != nil {
+		b.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = b.helper.WriteJSON(w, http.StatusOK, envelope{"coupons": coupons}, nil)
+	if err != nil {
+		b.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (b *Billing) GetCouponHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	coupon, err := b.coupons.Get(ctx, normalizeCode(mux.Vars(r)["code"]))
+	if err != nil {
+		b.couponErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = b.helper.WriteJSON(w, http.StatusOK, envelope{"coupon": coupon}, nil)
+	if err != nil {
+		b.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+// UpdateCouponHandler changes the limits and restrictions of a coupon, or
+// deactivates it.
+func (b *Billing) UpdateCouponHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	coupon, err := b.coupons.Get(ctx, normalizeCode(mux.Vars(r)["code"]))
+	if err != nil {
+		b.couponErrorResponse(w, r, err)
+
+		return
+	}
+
+	var input struct {
+		MaxRedemptions *int       `json:"max_redemptions"`
+		PerUserLimit   *int       `json:"per_user_limit"`
+		ExpiresAt      *time.Time `json:"expires_at"`
+		Plans          []string   `json:"plans"`
+		Courses        []string   `json:"courses"`
+		Active         *bool      `json:"active"`
+	}
+
+	err = b.helper.ReadJSON(w, r, &input)
+	if err != nil {
+		b.e.BadRequestResponse(w, r, err)
+
+		return
+	}
+
+	if input.MaxRedemptions != nil {
+		coupon.MaxRedemptions = *input.MaxRedemptions
+	}
+
+	if input.PerUserLimit != nil {
+		coupon.PerUserLimit = *input.PerUserLimit
+	}
+
+	if input.ExpiresAt != nil {
+		coupon.ExpiresAt = *input.ExpiresAt
+	}
+
+	if input.Plans != nil {
+		coupon.Plans = input.Plans
+	}
+
+	if input.Courses != nil {
+		coupon.Courses = input.Courses
+	}
+
+	if input.Active != nil {
+		coupon.Active = *input.Active
+	}
+
+	v := validator.New()
+
+	ValidateCoupon(v, coupon)
+
+	err = b.checkCouponPlans(ctx, v, coupon)
+	if err != nil {
+		b.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	if !v.Valid() {
+		b.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	err = b.coupons.Update(ctx, coupon)
+	if err != nil {
+		b.couponErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = b.helper.WriteJSON(w, http.StatusOK, envelope{"coupon": coupon}, nil)
+	if err != nil {
+		b.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+// DeleteCouponHandler deletes a coupon that was never used. Used ones are
+// kept for the redemption report and can only be deactivated.
+func (b *Billing) DeleteCouponHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	err := b.coupons.Delete(ctx, normalizeCode(mux.Vars(r)["code"]))
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrCouponHasRedemption):
+			b.e.BadRequestResponse(w, r, err)
+		default:
+			b.couponErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	err = b.helper.WriteJSON(w, http.StatusOK, envelope{"message": "coupon successfully deleted"}, nil)
+	if err != nil {
+		b.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+// CouponRedemptionsHandler reports who used a coupon and the discount that
+// was given.
+func (b *Billing) CouponRedemptionsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	coupon, err := b.coupons.Get(ctx, normalizeCode(mux.Vars(r)["code"]))
+	if err != nil {
+		b.couponErrorResponse(w, r, err)
+
+		return
+	}
+
+	redemptions, err := b.redemptions.ListForCoupon(ctx, coupon.Code)
+	if err != nil {
+		b.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = b.helper.WriteJSON(w, http.StatusOK, envelope{
+		"coupon":      coupon,
+		"report":      Report(redemptions),
+		"redemptions": redemptions,
+	}, nil)
+	if err != nil {
+		b.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+// applyCoupon checks that the coupon can be used for the plan and reserves one
+// use of it. The reason it can't be used is added to the validator. The
+// per-user limit is enforced when the redemption is stored.
+func (b *Billing) applyCoupon(ctx context.Context, v *validator.Validator, code string, plan *Plan) (*Coupon, error) {
+	coupon, err := b.coupons.Get(ctx, normalizeCode(code))
+	if err != nil {
+		if errors.Is(err, ErrCouponNotFound) {
+			v.AddError("coupon", "does not exist")
+
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	err = coupon.Check(plan, time.Now())
+	if err != nil {
+		v.AddError("coupon", couponErrorMessage(err))
+
+		return nil, nil
+	}
+
+	err = b.coupons.Reserve(ctx, coupon.Code)
+	if err != nil {
+		if errors.Is(err, ErrCouponUsedUp) {
+			v.AddError("coupon", couponErrorMessage(err))
+
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return coupon, nil
+}
+
+// releaseCoupon gives back the use of a checkout that won't be completed.
+func (b *Billing) releaseCoupon(ctx context.Context, code, redemptionID string) error {
+	err := b.redemptions.Release(ctx, redemptionID)
+	if err != nil {
+		if errors.Is(err, ErrRedemptionNotFound) {
+			return nil
+		}
+
+		return err
+	}
+
+	return b.coupons.Release(ctx, code)
+}
+
+// checkCouponPlans makes sure a coupon is restricted to plans that exist.
+func (b *Billing) checkCouponPlans(ctx context.Context, v *validator.Validator, coupon *Coupon) error {
+	for _, code := range coupon.Plans {
+		_, err := b.plans.Get(ctx, code)
+		if err != nil {
+			if errors.Is(err, ErrPlanNotFound) {
+				v.AddError("plans", "must only contain existing plans")
+
+				return nil
+			}
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *Billing) couponErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, ErrCouponNotFound):
+		b.e.NotFoundResponse(w, r)
+	default:
+		b.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+func couponErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, ErrCouponInactive):
+		return "is no longer valid"
+	case errors.Is(err, ErrCouponExpired):
+		return "has expired"
+	case errors.Is(err, ErrCouponUsedUp):
+		return "has reached its usage limit"
+	case errors.Is(err, ErrCouponUserLimit):
+		return "was already used by you"
+	case errors.Is(err, ErrCouponPlan):
+		return "is not valid for this plan"
+	case errors.Is(err, ErrCouponCourse):
+		return "is only valid for specific courses"
+	case errors.Is(err, ErrCouponCurrency):
+		return "is not valid for the currency of this plan"
+	}
+
+	return "can not be used"
+}
+
+// normalizeCode makes codes case insensitive, they are often typed in by hand.
+func normalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
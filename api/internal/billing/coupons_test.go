@@ -0,0 +1,388 @@
+package billing
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	data "interviews/internal/users"
+	"interviews/internal/users/userstest"
+)
+
+func TestCoupon_Check(t *testing.T) {
+	monthly := &Plan{Code: "monthly", Price: 1900, Currency: "EUR"}
+	goOnly := &Plan{Code: "go", Price: 900, Currency: "EUR", Courses: []string{"go-course"}}
+	goAndRust := &Plan{Code: "go-rust", Price: 1500, Currency: "EUR", Courses: []string{"go-course", "rust-course"}}
+
+	testCases := []struct {
+		name     string
+		coupon   Coupon
+		plan     *Plan
+		expected error
+	}{
+		{name: "valid", coupon: Coupon{Kind: DiscountPercent, Percent: 20, Active: true}},
+		{name: "inactive", coupon: Coupon{Kind: DiscountPercent, Percent: 20}, expected: ErrCouponInactive},
+		{
+			name:     "expired",
+			coupon:   Coupon{Kind: DiscountPercent, Percent: 20, Active: true, ExpiresAt: time.Now().Add(-time.Minute)},
+			expected: ErrCouponExpired,
+		},
+		{name: "other plan", coupon: Coupon{Kind: DiscountPercent, Percent: 20, Active: true, Plans: []string{"annual"}}, expected: ErrCouponPlan},
+		{name: "listed plan", coupon: Coupon{Kind: DiscountPercent, Percent: 20, Active: true, Plans: []string{"annual", "monthly"}}},
+		{
+			name:     "plan with every course",
+			coupon:   Coupon{Kind: DiscountPercent, Percent: 20, Active: true, Courses: []string{"go-course"}},
+			expected: ErrCouponCourse,
+		},
+		{
+			name:     "plan with other courses too",
+			coupon:   Coupon{Kind: DiscountPercent, Percent: 20, Active: true, Courses: []string{"go-course"}},
+			plan:     goAndRust,
+			expected: ErrCouponCourse,
+		},
+		{
+			name:   "plan of a listed course",
+			coupon: Coupon{Kind: DiscountPercent, Percent: 20, Active: true, Courses: []string{"go-course", "java-course"}},
+			plan:   goOnly,
+		},
+		{name: "other currency", coupon: Coupon{Kind: DiscountFixed, Amount: 500, Currency: "USD", Active: true}, expected: ErrCouponCurrency},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			plan := tc.plan
+			if plan == nil {
+				plan = monthly
+			}
+
+			err := tc.coupon.Check(plan, time.Now())
+			if tc.expected == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tc.expected)
+			}
+		})
+	}
+}
+
+func TestCoupon_Discount(t *testing.T) {
+	monthly := &Plan{Code: "monthly", Price: 1900, Currency: "EUR"}
+
+	assert.Equal(t, int64(380), (&Coupon{Kind: DiscountPercent, Percent: 20}).Discount(monthly))
+	assert.Equal(t, int64(1900), (&Coupon{Kind: DiscountPercent, Percent: 100}).Discount(monthly))
+	assert.Equal(t, int64(500), (&Coupon{Kind: DiscountFixed, Amount: 500, Currency: "EUR"}).Discount(monthly))
+	assert.Equal(t, int64(1900), (&Coupon{Kind: DiscountFixed, Amount: 5000, Currency: "EUR"}).Discount(monthly))
+}
+
+func TestBilling_CreateCouponHandler(t *testing.T) {
+	admin := &data.User{Email: "admin@test.com", Role: data.RoleAdmin, Activated: true}
+
+	testCases := []struct {
+		name           string
+		input          map[string]any
+		expectedStatus int
+		expectedErrors []string
+	}{
+		{
+			name:           "percent",
+			input:          map[string]any{"code": "conf-2026", "kind": "percent", "percent": 25, "plans": []string{"monthly"}, "provider_coupon_id": "co_1"},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "fixed",
+			input:          map[string]any{"code": "TENOFF", "kind": "fixed", "amount": 1000, "currency": "EUR", "max_redemptions": 100, "provider_coupon_id": "co_2"},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "invalid",
+			input:          map[string]any{"code": "no spaces", "kind": "percent", "percent": 120, "plans": []string{"nope"}, "expires_at": time.Now().Add(-time.Hour)},
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedErrors: []string{"code", "percent", "plans", "expires_at", "provider_coupon_id"},
+		},
+		{
+			name:           "fixed without currency",
+			input:          map[string]any{"code": "TENOFF", "kind": "fixed", "amount": 1000, "provider_coupon_id": "co_2"},
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedErrors: []string{"currency"},
+		},
+		{
+			name:           "duplicate",
+			input:          map[string]any{"code": "existing", "kind": "percent", "percent": 10, "provider_coupon_id": "co_3"},
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedErrors: []string{"code"},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			b := newTestBilling()
+			b.coupons.coupons["EXISTING"] = &Coupon{Code: "EXISTING"}
+
+			rr := httptest.NewRecorder()
+			b.service.CreateCouponHandler(rr, userstest.NewRequest(t, http.MethodPost, "/v1/admin/coupons", admin, tc.input))
+
+			require.Equal(t, tc.expectedStatus, rr.Code, rr.Body.String())
+
+			var res struct {
+				Coupon Coupon            `json:"coupon"`
+				Error  map[string]string `json:"error"`
+			}
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+
+			for _, field := range tc.expectedErrors {
+				assert.Contains(t, res.Error, field)
+			}
+
+			if tc.expectedStatus == http.StatusCreated {
+				assert.Equal(t, normalizeCode(tc.input["code"].(string)), res.Coupon.Code)
+				assert.True(t, res.Coupon.Active)
+				assert.Equal(t, 1, res.Coupon.PerUserLimit)
+				assert.Equal(t, "admin@test.com", res.Coupon.CreatedBy)
+			}
+		})
+	}
+}
+
+func TestBilling_DeleteCouponHandler(t *testing.T) {
+	admin := &data.User{Email: "admin@test.com", Role: data.RoleAdmin, Activated: true}
+
+	b := newTestBilling()
+	b.coupons.coupons["UNUSED"] = &Coupon{Code: "UNUSED"}
+	b.coupons.coupons["USED"] = &Coupon{Code: "USED", Uses: 1}
+
+	for code, expected := range map[string]int{"unused": http.StatusOK, "used": http.StatusBadRequest, "nope": http.StatusNotFound} {
+		req := userstest.NewRequest(t, http.MethodDelete, "/v1/admin/coupons/"+code, admin, nil)
+		req = mux.SetURLVars(req, map[string]string{"code": code})
+
+		rr := httptest.NewRecorder()
+		b.service.DeleteCouponHandler(rr, req)
+
+		assert.Equal(t, expected, rr.Code, code)
+	}
+
+	assert.NotContains(t, b.coupons.coupons, "UNUSED")
+	assert.Contains(t, b.coupons.coupons, "USED")
+}
+
+func TestBilling_CheckoutHandler_Coupon(t *testing.T) {
+	user := &data.User{Email: "jane@test.com", Activated: true}
+
+	coupons := func() map[string]*Coupon {
+		return map[string]*Coupon{
+			"CONF": {Code: "CONF", Kind: DiscountPercent, Percent: 50, PerUserLimit: 1, ProviderCouponID: "co_conf", Active: true},
+			"LIMITED": {
+				Code: "LIMITED", Kind: DiscountFixed, Amount: 500, Currency: "EUR", MaxRedemptions: 1, Uses: 1,
+				ProviderCouponID: "co_limited", Active: true,
+			},
+			"OLD":    {Code: "OLD", Kind: DiscountPercent, Percent: 10, ExpiresAt: time.Now().Add(-time.Hour), Active: true},
+			"TEAM":   {Code: "TEAM", Kind: DiscountPercent, Percent: 10, Plans: []string{"team"}, Active: true},
+			"GO":     {Code: "GO", Kind: DiscountPercent, Percent: 10, Courses: []string{"go-course"}, ProviderCouponID: "co_go", Active: true},
+			"PAUSED": {Code: "PAUSED", Kind: DiscountPercent, Percent: 10},
+		}
+	}
+
+	testCases := []struct {
+		name             string
+		input            map[string]any
+		redeemed         bool
+		expectedStatus   int
+		expectedError    string
+		expectedCoupon   string
+		expectedDiscount int64
+	}{
+		{
+			name:             "valid",
+			input:            map[string]any{"plan": "monthly", "coupon": "conf"},
+			expectedStatus:   http.StatusCreated,
+			expectedCoupon:   "co_conf",
+			expectedDiscount: 950,
+		},
+		{
+			name:             "course",
+			input:            map[string]any{"plan": "go", "coupon": "GO"},
+			expectedStatus:   http.StatusCreated,
+			expectedCoupon:   "co_go",
+			expectedDiscount: 90,
+		},
+		{name: "unknown", input: map[string]any{"plan": "monthly", "coupon": "NOPE"}, expectedStatus: http.StatusUnprocessableEntity, expectedError: "does not exist"},
+		{name: "expired", input: map[string]any{"plan": "monthly", "coupon": "OLD"}, expectedStatus: http.StatusUnprocessableEntity, expectedError: "has expired"},
+		{name: "used up", input: map[string]any{"plan": "monthly", "coupon": "LIMITED"}, expectedStatus: http.StatusUnprocessableEntity, expectedError: "has reached its usage limit"},
+		{name: "other plan", input: map[string]any{"plan": "monthly", "coupon": "TEAM"}, expectedStatus: http.StatusUnprocessableEntity, expectedError: "is not valid for this plan"},
+		{name: "plan with every course", input: map[string]any{"plan": "monthly", "coupon": "GO"}, expectedStatus: http.StatusUnprocessableEntity, expectedError: "is only valid for specific courses"},
+		{name: "inactive", input: map[string]any{"plan": "monthly", "coupon": "PAUSED"}, expectedStatus: http.StatusUnprocessableEntity, expectedError: "is no longer valid"},
+		{
+			name:           "used by the user",
+			input:          map[string]any{"plan": "monthly", "coupon": "CONF"},
+			redeemed:       true,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedError:  "was already used by you",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			b, p := newCheckoutBilling(t)
+			b.coupons.coupons = coupons()
+			b.plans.plans["go"] = &Plan{
+				Code: "go", Name: "Go", Kind: PlanIndividual, Interval: IntervalMonth, Price: 900, Currency: "EUR",
+				Courses: []string{"go-course"}, ProviderPriceID: "price_go", Active: true,
+			}
+
+			if tc.redeemed {
+				b.redemptions.redemptions = []*Redemption{{Coupon: "CONF", Email: "jane@test.com", Status: RedemptionRedeemed}}
+			}
+
+			rr := httptest.NewRecorder()
+			b.service.CheckoutHandler(rr, userstest.NewRequest(t, http.MethodPost, "/v1/billing/checkout", user, tc.input))
+
+			require.Equal(t, tc.expectedStatus, rr.Code, rr.Body.String())
+
+			var res struct {
+				Discount int64             `json:"discount"`
+				Error    map[string]string `json:"error"`
+			}
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+
+			if tc.expectedStatus != http.StatusCreated {
+				assert.Equal(t, tc.expectedError, res.Error["coupon"])
+				assert.Empty(t, p.sessions)
+
+				return
+			}
+
+			assert.Equal(t, tc.expectedDiscount, res.Discount)
+
+			require.Len(t, p.sessions, 1)
+			assert.Equal(t, tc.expectedCoupon, p.sessions[0].Get("discounts[0][coupon]"))
+
+			require.Len(t, b.redemptions.redemptions, 1)
+			redemption := b.redemptions.redemptions[0]
+			assert.Equal(t, RedemptionPending, redemption.Status)
+			assert.Equal(t, redemption.ID.Hex(), p.sessions[0].Get("metadata[redemption]"))
+			assert.Equal(t, 1, b.coupons.coupons[redemption.Coupon].Uses)
+		})
+	}
+}
+
+func TestBilling_CheckoutHandler_CouponConcurrent(t *testing.T) {
+	user := &data.User{Email: "jane@test.com", Activated: true}
+
+	b, p := newCheckoutBilling(t)
+	b.coupons.coupons["CONF"] = &Coupon{Code: "CONF", Kind: DiscountPercent, Percent: 50, PerUserLimit: 1, ProviderCouponID: "co_conf", Active: true}
+	b.plans.plans["annual"].ProviderPriceID = "price_annual"
+
+	reqs := []*http.Request{
+		userstest.NewRequest(t, http.MethodPost, "/v1/billing/checkout", user, map[string]any{"plan": "monthly", "coupon": "CONF"}),
+		userstest.NewRequest(t, http.MethodPost, "/v1/billing/checkout", user, map[string]any{"plan": "annual", "coupon": "CONF"}),
+	}
+
+	codes := make([]int, len(reqs))
+
+	var wg sync.WaitGroup
+
+	for i := range reqs {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			rr := httptest.NewRecorder()
+			b.service.CheckoutHandler(rr, reqs[i])
+
+			codes[i] = rr.Code
+		}(i)
+	}
+
+	wg.Wait()
+
+	// only one of them gets the single use the user has
+	assert.ElementsMatch(t, []int{http.StatusCreated, http.StatusUnprocessableEntity}, codes)
+	assert.Len(t, p.sessions, 1)
+	assert.Len(t, b.redemptions.redemptions, 1)
+	assert.Equal(t, 1, b.coupons.coupons["CONF"].Uses)
+}
+
+// A coupon is used once the checkout is paid and given back when the
+// checkout is abandoned or can't be started.
+func TestBilling_CouponRedemption(t *testing.T) {
+	user := &data.User{Email: "jane@test.com", Activated: true}
+	admin := &data.User{Email: "admin@test.com", Role: data.RoleAdmin, Activated: true}
+
+	b, p := newCheckoutBilling(t)
+	b.coupons.coupons["CONF"] = &Coupon{Code: "CONF", Kind: DiscountPercent, Percent: 50, MaxRedemptions: 10, ProviderCouponID: "co_conf", Active: true}
+
+	checkout := func() {
+		rr := httptest.NewRecorder()
+		b.service.CheckoutHandler(rr, userstest.NewRequest(t, http.MethodPost, "/v1/billing/checkout", user, map[string]any{"plan": "monthly", "coupon": "CONF"}))
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	metadata := func(i int) map[string]any {
+		return map[string]any{
+			"plan":       p.sessions[i].Get("metadata[plan]"),
+			"email":      p.sessions[i].Get("metadata[email]"),
+			"coupon":     p.sessions[i].Get("metadata[coupon]"),
+			"redemption": p.sessions[i].Get("metadata[redemption]"),
+		}
+	}
+
+	// abandoned
+	checkout()
+
+	rr := deliver(t, b, event("evt_1", EventCheckoutExpired, time.Now(), map[string]any{"id": "cs_test_1", "metadata": metadata(0)}))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, RedemptionReleased, b.redemptions.redemptions[0].Status)
+	assert.Equal(t, 0, b.coupons.coupons["CONF"].Uses)
+
+	// paid
+	checkout()
+
+	rr = deliver(t, b, event("evt_2", EventCheckoutCompleted, time.Now(), map[string]any{
+		"id":           "cs_test_2",
+		"subscription": "sub_1",
+		"metadata":     metadata(1),
+	}))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, RedemptionRedeemed, b.redemptions.redemptions[1].Status)
+	assert.Equal(t, 1, b.coupons.coupons["CONF"].Uses)
+
+	// an expiry can't give back a paid one
+	rr = deliver(t, b, event("evt_3", EventCheckoutExpired, time.Now(), map[string]any{"id": "cs_test_2", "metadata": metadata(1)}))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, 1, b.coupons.coupons["CONF"].Uses)
+
+	// the provider is down
+	b.service.provider = NewStripeClient(p.server.URL, "sk_wrong", p.server.Client())
+	b.subscriptions.subs = nil
+
+	rr = httptest.NewRecorder()
+	b.service.CheckoutHandler(rr, userstest.NewRequest(t, http.MethodPost, "/v1/billing/checkout", user, map[string]any{"plan": "monthly", "coupon": "CONF"}))
+	require.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.Equal(t, RedemptionReleased, b.redemptions.redemptions[2].Status)
+	assert.Equal(t, 1, b.coupons.coupons["CONF"].Uses)
+
+	req := userstest.NewRequest(t, http.MethodGet, "/v1/admin/coupons/conf/redemptions", admin, nil)
+	req = mux.SetURLVars(req, map[string]string{"code": "conf"})
+
+	rr = httptest.NewRecorder()
+	b.service.CouponRedemptionsHandler(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var res struct {
+		Report      RedemptionReport `json:"report"`
+		Redemptions []Redemption     `json:"redemptions"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+
+	assert.Len(t, res.Redemptions, 3)
+	assert.Equal(t, RedemptionReport{Redeemed: 1, Released: 2, TotalDiscount: map[string]int64{"EUR": 950}}, res.Report)
+}
@@ -0,0 +1,139 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	data "interviews/internal/users"
+)
+
+// Access is the paid content a user can open.
+type Access struct {
+	// every subscription course and paid video
+	All bool
+	// the courses of limited plans and the ones an org was granted
+	Courses []string
+}
+
+// Includes reports whether the paid content of the course is covered.
+func (a Access) Includes(courseID string) bool {
+	if a.All {
+		return true
+	}
+
+	for _, id := range a.Courses {
+		if id == courseID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Entitlements answers what a user can access. A user gets everything with a
+// subscription of their own, through a team subscription of one of their
+// orgs, or as staff, unless the plan is limited to some courses; orgs can also
+// be granted single courses.
+type Entitlements struct {
+	subscriptions SubscriptionRepository
+	plans         PlanRepository
+	members       MemberRepository
+	orgs          OrgRepository
+}
+
+func (e *Entitlements) Access(ctx context.Context, user *data.User) (Access, error) {
+	if user.IsAnonymous() {
+		return Access{}, nil
+	}
+
+	if user.HasPermission(data.PermissionCoursesWrite) {
+		return Access{All: true}, nil
+	}
+
+	// api keys only get what their permissions grant
+	if user.IsService() {
+		return Access{}, nil
+	}
+
+	members, err := e.members.ListForUser(ctx, user.Email)
+	if err != nil {
+		return Access{}, err
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(members))
+	for _, m := range members {
+		ids = append(ids, m.OrgID)
+	}
+
+	subs, err := e.subscriptions.ListGranting(ctx, user.Email, ids)
+	if err != nil {
+		return Access{}, err
+	}
+
+	var access Access
+
+	now := time.Now()
+
+	for _, sub := range subs {
+		if !sub.Grants(now) {
+			continue
+		}
+
+		courses, err := e.planCourses(ctx, sub.Plan)
+		if err != nil {
+			return Access{}, err
+		}
+
+		if len(courses) == 0 {
+			return Access{All: true}, nil
+		}
+
+		access.Courses = append(access.Courses, courses...)
+	}
+
+	userOrgs, err := e.orgs.ListByIDs(ctx, ids)
+	if err != nil {
+		return Access{}, err
+	}
+
+	for _, org := range userOrgs {
+		if org.Entitlements.AllSubscriptionCourses {
+			return Access{All: true}, nil
+		}
+
+		access.Courses = append(access.Courses, org.Entitlements.Courses...)
+	}
+
+	return access, nil
+}
+
+// planCourses returns the courses a plan is limited to. Trials and plans that
+// aren't stored anymore grant every subscription course, as all plans did
+// before they could be limited.
+func (e *Entitlements) planCourses(ctx context.Context, code string) ([]string, error) {
+	if code == TrialPlan {
+		return nil, nil
+	}
+
+	plan, err := e.plans.Get(ctx, code)
+	if err != nil {
+		if errors.Is(err, ErrPlanNotFound) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return plan.Courses, nil
+}
+
+func NewEntitlements(subscriptions SubscriptionRepository, plans PlanRepository, members MemberRepository, orgs OrgRepository) *Entitlements {
+	return &Entitlements{
+		subscriptions: subscriptions,
+		plans:         plans,
+		members:       members,
+		orgs:          orgs,
+	}
+}
@@ -0,0 +1,121 @@
+package billing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"interviews/internal/orgs"
+	data "interviews/internal/users"
+)
+
+func TestEntitlements_Access(t *testing.T) {
+	teamOrg := primitive.NewObjectID()
+	grantOrg := primitive.NewObjectID()
+
+	user := func(email string) *data.User {
+		return &data.User{Email: email, Role: data.RoleUser, Activated: true}
+	}
+
+	testCases := []struct {
+		name     string
+		user     *data.User
+		subs     []*Subscription
+		expected Access
+	}{
+		{name: "anonymous", user: data.AnonymousUser},
+		{name: "no subscription", user: user("jane@test.com")},
+		{name: "instructor", user: &data.User{Email: "ann@test.com", Role: data.RoleInstructor}, expected: Access{All: true}},
+		{
+			name:     "api key",
+			user:     &data.User{Service: &data.ServicePrincipal{Name: "reporting"}},
+			expected: Access{},
+		},
+		{
+			name:     "own subscription",
+			user:     user("jane@test.com"),
+			subs:     []*Subscription{{Email: "jane@test.com", Status: StatusActive, CurrentPeriodEnd: time.Now().Add(time.Hour)}},
+			expected: Access{All: true},
+		},
+		{
+			name:     "canceled, period not over",
+			user:     user("jane@test.com"),
+			subs:     []*Subscription{{Email: "jane@test.com", Status: StatusCanceled, CurrentPeriodEnd: time.Now().Add(time.Hour)}},
+			expected: Access{All: true},
+		},
+		{
+			name: "period over",
+			user: user("jane@test.com"),
+			subs: []*Subscription{{Email: "jane@test.com", Status: StatusActive, CurrentPeriodEnd: time.Now().Add(-time.Hour)}},
+		},
+		{
+			name: "ended",
+			user: user("jane@test.com"),
+			subs: []*Subscription{{Email: "jane@test.com", Status: StatusExpired, CurrentPeriodEnd: time.Now().Add(time.Hour)}},
+		},
+		{
+			name:     "team subscription of their org",
+			user:     user("john@test.com"),
+			subs:     []*Subscription{{OrgID: &teamOrg, Status: StatusActive, CurrentPeriodEnd: time.Now().Add(time.Hour)}},
+			expected: Access{All: true},
+		},
+		{
+			name:     "trial",
+			user:     user("jane@test.com"),
+			subs:     []*Subscription{{Plan: TrialPlan, Email: "jane@test.com", Status: StatusTrialing, CurrentPeriodEnd: time.Now().Add(time.Hour)}},
+			expected: Access{All: true},
+		},
+		{
+			name:     "plan limited to a course",
+			user:     user("jane@test.com"),
+			subs:     []*Subscription{{Plan: "go", Email: "jane@test.com", Status: StatusActive, CurrentPeriodEnd: time.Now().Add(time.Hour)}},
+			expected: Access{Courses: []string{"go-course"}},
+		},
+		{
+			name:     "limited plan and courses granted to their org",
+			user:     user("max@test.com"),
+			subs:     []*Subscription{{Plan: "go", Email: "max@test.com", Status: StatusActive, CurrentPeriodEnd: time.Now().Add(time.Hour)}},
+			expected: Access{Courses: []string{"go-course", "course-1"}},
+		},
+		{
+			name:     "courses granted to their org",
+			user:     user("max@test.com"),
+			expected: Access{Courses: []string{"course-1"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			subscriptions := &MockSubscriptionRepository{subs: tc.subs}
+			members := &MockMemberRepository{members: []orgs.Member{
+				{OrgID: teamOrg, Email: "john@test.com", Role: orgs.RoleMember},
+				{OrgID: grantOrg, Email: "max@test.com", Role: orgs.RoleMember},
+			}}
+			orgRepo := &MockOrgRepository{orgs: map[primitive.ObjectID]*orgs.Org{
+				teamOrg:  {ID: teamOrg, Name: "Team"},
+				grantOrg: {ID: grantOrg, Name: "Grant", Entitlements: orgs.Entitlements{Courses: []string{"course-1"}}},
+			}}
+
+			plans := &MockPlanRepository{plans: map[string]*Plan{
+				"monthly": {Code: "monthly", Kind: PlanIndividual},
+				"go":      {Code: "go", Kind: PlanIndividual, Courses: []string{"go-course"}},
+			}}
+
+			access, err := NewEntitlements(subscriptions, plans, members, orgRepo).Access(context.Background(), tc.user)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.expected, access)
+		})
+	}
+}
+
+func TestAccess_Includes(t *testing.T) {
+	assert.True(t, Access{All: true}.Includes("course-1"))
+	assert.True(t, Access{Courses: []string{"course-1"}}.Includes("course-1"))
+	assert.False(t, Access{Courses: []string{"course-1"}}.Includes("course-2"))
+	assert.False(t, Access{}.Includes("course-1"))
+}
@@ -0,0 +1,192 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	validator "interviews/pkg/vaildator"
+)
+
+const (
+	PlanIndividual = "individual"
+	PlanTeam       = "team"
+
+	IntervalMonth = "month"
+	IntervalYear  = "year"
+)
+
+var (
+	ErrPlanNotFound  = errors.New("plan not found")
+	ErrDuplicatePlan = errors.New("a plan with this code already exists")
+
+	planCodeRX = regexp.MustCompile("^[a-z0-9]+(-[a-z0-9]+)*$")
+	currencyRX = regexp.MustCompile("^[A-Z]{3}$")
+)
+
+// Plan is what can be subscribed to. Individual plans are bought by a user,
+// team plans by an org for all of its members.
+type Plan struct {
+	ID       primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	Code     string             `json:"code" bson:"code"`
+	Name     string             `json:"name" bson:"name"`
+	Kind     string             `json:"kind" bson:"kind"`
+	Interval string             `json:"interval" bson:"interval"`
+	// in the smallest unit of the currency, e.g. cents
+	Price    int64  `json:"price" bson:"price"`
+	Currency string `json:"currency" bson:"currency"`
+	// seats a team plan includes
+	Seats int `json:"seats,omitempty" bson:"seats,omitempty"`
+	// the courses the plan is limited to, every subscription course if empty
+	Courses []string `json:"courses,omitempty" bson:"courses,omitempty"`
+	// the price of the plan at the payment provider, needed for checkout
+	ProviderPriceID string    `json:"provider_price_id,omitempty" bson:"providerPriceId,omitempty"`
+	Active          bool      `json:"active" bson:"active"`
+	CreatedAt       time.Time `json:"created_at" bson:"createdAt"`
+}
+
+// PeriodEnd returns when a period of the plan that starts at start ends.
+func (p *Plan) PeriodEnd(start time.Time) time.Time {
+	if p.Interval == IntervalYear {
+		return start.AddDate(1, 0, 0)
+	}
+
+	return start.AddDate(0, 1, 0)
+}
+
+// OnlyGrants reports whether the plan is limited to courses that are all among
+// the given ones.
+func (p *Plan) OnlyGrants(courses []string) bool {
+	if len(p.Courses) == 0 {
+		return false
+	}
+
+	for _, id := range p.Courses {
+		if !validator.PermittedValue(id, courses...) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type PlanRepo struct {
+	client     *mongo.Client
+	collection *mongo.Collection
+}
+
+func (m *PlanRepo) New(ctx context.Context, plan *Plan) error {
+	count, err := m.collection.CountDocuments(ctx, bson.M{"code": plan.Code})
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return ErrDuplicatePlan
+	}
+
+	plan.CreatedAt = time.Now()
+
+	res, err := m.collection.InsertOne(ctx, plan)
+	if err != nil {
+		return err
+	}
+
+	if id, ok := res.InsertedID.(primitive.ObjectID); ok {
+		plan.ID = id
+	}
+
+	return nil
+}
+
+func (m *PlanRepo) Get(ctx context.Context, code string) (*Plan, error) {
+	var plan Plan
+
+	err := m.collection.FindOne(ctx, bson.M{"code": code}).Decode(&plan)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrPlanNotFound
+		}
+
+		return nil, err
+	}
+
+	return &plan, nil
+}
+
+// List returns the plans by price, only the ones that can be bought unless
+// inactive ones are asked for.
+func (m *PlanRepo) List(ctx context.Context, withInactive bool) ([]Plan, error) {
+	filter := bson.M{"active": true}
+	if withInactive {
+		filter = bson.M{}
+	}
+
+	cursor, err := m.collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "price", Value: 1}, {Key: "code", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	plans := []Plan{}
+
+	err = cursor.All(ctx, &plans)
+	if err != nil {
+		return nil, err
+	}
+
+	return plans, nil
+}
+
+// Update changes what can be changed without affecting existing
+// subscriptions; the kind and interval of a plan are fixed.
+func (m *PlanRepo) Update(ctx context.Context, plan *Plan) error {
+	res, err := m.collection.UpdateOne(ctx, bson.M{"code": plan.Code}, bson.M{"$set": bson.M{
+		"name":            plan.Name,
+		"price":           plan.Price,
+		"currency":        plan.Currency,
+		"seats":           plan.Seats,
+		"providerPriceId": plan.ProviderPriceID,
+		"active":          plan.Active,
+	}})
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrPlanNotFound
+	}
+
+	return nil
+}
+
+func ValidatePlan(v *validator.Validator, plan *Plan) {
+	v.Check(validator.Matches(plan.Code, planCodeRX), "code", "must only contain lowercase letters, digits and dashes")
+	v.Check(len(plan.Code) <= 50, "code", "must not be more than 50 bytes long")
+	v.Check(plan.Name != "", "name", "must be provided")
+	v.Check(len(plan.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(validator.PermittedValue(plan.Kind, PlanIndividual, PlanTeam), "kind", "must be individual or team")
+	v.Check(validator.PermittedValue(plan.Interval, IntervalMonth, IntervalYear), "interval", "must be month or year")
+	v.Check(plan.Price >= 0, "price", "must not be negative")
+	v.Check(validator.Matches(plan.Currency, currencyRX), "currency", "must be an ISO 4217 code, e.g. EUR")
+	v.Check(len(plan.ProviderPriceID) <= 255, "provider_price_id", "must not be more than 255 bytes long")
+	v.Check(validator.Unique(plan.Courses), "courses", "must not contain duplicate values")
+
+	if plan.Kind == PlanTeam {
+		v.Check(plan.Seats > 0, "seats", "must be greater than zero")
+		v.Check(plan.Seats <= 10000, "seats", "must not be more than 10000")
+	} else {
+		v.Check(plan.Seats == 0, "seats", "must only be set for team plans")
+	}
+}
+
+func NewPlanRepository(client *mongo.Client, collection *mongo.Collection) *PlanRepo {
+	return &PlanRepo{
+		client:     client,
+		collection: collection,
+	}
+}
@@ -0,0 +1,126 @@
+package billing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var ErrProvider = errors.New("payment provider request failed")
+
+// maxResponseSize limits what is read from the provider.
+const maxResponseSize = 1 << 20
+
+// Provider is the payment provider subscriptions are bought through.
+type Provider interface {
+	CreateCheckoutSession(ctx context.Context, params CheckoutParams) (*CheckoutSession, error)
+}
+
+// CheckoutParams describe what is bought. The metadata comes back with the
+// webhook events of the checkout and of the subscription it creates.
+type CheckoutParams struct {
+	PriceID    string
+	Email      string
+	Reference  string
+	SuccessURL string
+	CancelURL  string
+	// coupon of the provider applied to the first payment
+	Coupon   string
+	Metadata map[string]string
+}
+
+// CheckoutSession is a hosted payment page the user is sent to.
+type CheckoutSession struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+// StripeClient talks to a provider with the API of Stripe.
+type StripeClient struct {
+	baseURL   string
+	secretKey string
+	client    *http.Client
+}
+
+func (s *StripeClient) CreateCheckoutSession(ctx context.Context, params CheckoutParams) (*CheckoutSession, error) {
+	form := url.Values{}
+	form.Set("mode", "subscription")
+	form.Set("line_items[0][price]", params.PriceID)
+	form.Set("line_items[0][quantity]", "1")
+	form.Set("success_url", params.SuccessURL)
+	form.Set("cancel_url", params.CancelURL)
+	form.Set("client_reference_id", params.Reference)
+
+	if params.Email != "" {
+		form.Set("customer_email", params.Email)
+	}
+
+	if params.Coupon != "" {
+		form.Set("discounts[0][coupon]", params.Coupon)
+	}
+
+	for k, v := range params.Metadata {
+		form.Set(fmt.Sprintf("metadata[%s]", k), v)
+		form.Set(fmt.Sprintf("subscription_data[metadata][%s]", k), v)
+	}
+
+	var session CheckoutSession
+
+	err := s.post(ctx, "/v1/checkout/sessions", form, &session)
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+func (s *StripeClient) post(ctx context.Context, path string, form url.Values, dst any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.baseURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+s.secretKey)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrProvider, err)
+	}
+	defer res.Body.Close()
+
+	body := io.LimitReader(res.Body, maxResponseSize)
+
+	if res.StatusCode != http.StatusOK {
+		var providerErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+
+		_ = json.NewDecoder(body).Decode(&providerErr)
+
+		return fmt.Errorf("%w: responded with %d: %s", ErrProvider, res.StatusCode, providerErr.Error.Message)
+	}
+
+	err = json.NewDecoder(body).Decode(dst)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrProvider, err)
+	}
+
+	return nil
+}
+
+func NewStripeClient(baseURL, secretKey string, client *http.Client) *StripeClient {
+	return &StripeClient{
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+		secretKey: secretKey,
+		client:    client,
+	}
+}
@@ -0,0 +1,203 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// the checkout was started but not paid yet
+	RedemptionPending  = "pending"
+	RedemptionRedeemed = "redeemed"
+	// the checkout was abandoned, the use was given back
+	RedemptionReleased = "released"
+)
+
+var ErrRedemptionNotFound = errors.New("redemption not found")
+
+// Redemption is the use of a coupon in a checkout.
+type Redemption struct {
+	ID     primitive.ObjectID  `json:"id" bson:"_id,omitempty"`
+	Coupon string              `json:"coupon" bson:"coupon"`
+	Email  string              `json:"email" bson:"email"`
+	OrgID  *primitive.ObjectID `json:"org_id,omitempty" bson:"orgId,omitempty"`
+	Plan   string              `json:"plan" bson:"plan"`
+	// discount on the first payment
+	Discount   int64     `json:"discount" bson:"discount"`
+	Currency   string    `json:"currency" bson:"currency"`
+	Status     string    `json:"status" bson:"status"`
+	CreatedAt  time.Time `json:"created_at" bson:"createdAt"`
+	RedeemedAt time.Time `json:"redeemed_at,omitempty" bson:"redeemedAt,omitempty"`
+	// which of the uses the user has of a coupon with a per-user limit it
+	// takes, given back when the redemption is released
+	Slot *int `json:"-" bson:"slot,omitempty"`
+}
+
+// RedemptionReport sums up the redemptions of a coupon.
+type RedemptionReport struct {
+	Redeemed int `json:"redeemed"`
+	Pending  int `json:"pending"`
+	Released int `json:"released"`
+	// discount given with the completed checkouts, by currency
+	TotalDiscount map[string]int64 `json:"total_discount"`
+}
+
+type RedemptionRepo struct {
+	client     *mongo.Client
+	collection *mongo.Collection
+}
+
+// CreateIndexes makes a slot of a user for a coupon unique, which is what
+// enforces the per-user limits. Released redemptions don't have a slot.
+func (m *RedemptionRepo) CreateIndexes(ctx context.Context) error {
+	_, err := m.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "coupon", Value: 1}, {Key: "email", Value: 1}, {Key: "slot", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"slot": bson.M{"$exists": true}}),
+	})
+
+	return err
+}
+
+// New stores a pending redemption. With a per-user limit it takes the first
+// free slot of the user for the coupon and fails with ErrCouponUserLimit when
+// there is none, so checkouts running at the same time can't go over it.
+func (m *RedemptionRepo) New(ctx context.Context, redemption *Redemption, perUserLimit int) error {
+	redemption.Status = RedemptionPending
+	redemption.CreatedAt = time.Now()
+
+	if perUserLimit == 0 {
+		return m.insert(ctx, redemption)
+	}
+
+	for slot := 0; slot < perUserLimit; slot++ {
+		taken := slot
+		redemption.Slot = &taken
+
+		err := m.insert(ctx, redemption)
+		if !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+	}
+
+	redemption.Slot = nil
+
+	return ErrCouponUserLimit
+}
+
+func (m *RedemptionRepo) insert(ctx context.Context, redemption *Redemption) error {
+	res, err := m.collection.InsertOne(ctx, redemption)
+	if err != nil {
+		return err
+	}
+
+	if id, ok := res.InsertedID.(primitive.ObjectID); ok {
+		redemption.ID = id
+	}
+
+	return nil
+}
+
+// ListForCoupon returns the redemptions of a coupon, newest first.
+func (m *RedemptionRepo) ListForCoupon(ctx context.Context, coupon string) ([]Redemption, error) {
+	return m.list(ctx, bson.M{"coupon": coupon})
+}
+
+// ListForUser returns the coupons the user used, newest first.
+func (m *RedemptionRepo) ListForUser(ctx context.Context, email string) ([]Redemption, error) {
+	return m.list(ctx, bson.M{"email": email})
+}
+
+func (m *RedemptionRepo) list(ctx context.Context, filter bson.M) ([]Redemption, error) {
+	cursor, err := m.collection.Find(ctx, filter, options.Find().SetSort(bson.M{"createdAt": -1}))
+	if err != nil {
+		return nil, err
+	}
+
+	redemptions := []Redemption{}
+
+	err = cursor.All(ctx, &redemptions)
+	if err != nil {
+		return nil, err
+	}
+
+	return redemptions, nil
+}
+
+// Redeem completes a pending redemption once the checkout was paid.
+func (m *RedemptionRepo) Redeem(ctx context.Context, id string) error {
+	return m.setStatus(ctx, id, bson.M{"$set": bson.M{"status": RedemptionRedeemed, "redeemedAt": time.Now()}})
+}
+
+// Release marks a pending redemption as abandoned and frees its slot. It fails
+// with ErrRedemptionNotFound when it isn't pending, so the use of the coupon is
+// only given back once.
+func (m *RedemptionRepo) Release(ctx context.Context, id string) error {
+	return m.setStatus(ctx, id, bson.M{"$set": bson.M{"status": RedemptionReleased}, "$unset": bson.M{"slot": ""}})
+}
+
+func (m *RedemptionRepo) setStatus(ctx context.Context, id string, update bson.M) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrRedemptionNotFound
+	}
+
+	res, err := m.collection.UpdateOne(ctx, bson.M{"_id": objectID, "status": RedemptionPending}, update)
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrRedemptionNotFound
+	}
+
+	return nil
+}
+
+// Report sums up the redemptions.
+func Report(redemptions []Redemption) RedemptionReport {
+	report := RedemptionReport{TotalDiscount: map[string]int64{}}
+
+	for _, r := range redemptions {
+		switch r.Status {
+		case RedemptionRedeemed:
+			report.Redeemed++
+			report.TotalDiscount[r.Currency] += r.Discount
+		case RedemptionPending:
+			report.Pending++
+		case RedemptionReleased:
+			report.Released++
+		}
+	}
+
+	return report
+}
+
+// ChangeEmail moves the redemptions of the user to their new address, so they
+// still count towards the per-user limits.
+func (m *RedemptionRepo) ChangeEmail(ctx context.Context, email, newEmail string) error {
+	_, err := m.collection.UpdateMany(ctx, bson.M{"email": email}, bson.M{"$set": bson.M{"email": newEmail}})
+
+	return err
+}
+
+// Anonymize replaces the email of an erased user with a pseudonym. The
+// redemptions are kept for the coupon reports.
+func (m *RedemptionRepo) Anonymize(ctx context.Context, email, pseudonym string) error {
+	_, err := m.collection.UpdateMany(ctx, bson.M{"email": email}, bson.M{"$set": bson.M{"email": pseudonym}})
+
+	return err
+}
+
+func NewRedemptionRepository(client *mongo.Client, collection *mongo.Collection) *RedemptionRepo {
+	return &RedemptionRepo{
+		client:     client,
+		collection: collection,
+	}
+}
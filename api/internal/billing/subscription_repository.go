@@ -0,0 +1,353 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// free access for new users, see Billing.StartTrial
+	StatusTrialing = "trialing"
+	StatusActive   = "active"
+	// the last payment failed and the provider is retrying it
+	StatusPastDue = "past_due"
+	// runs until the end of the period that was paid for
+	StatusCanceled = "canceled"
+	StatusExpired  = "expired"
+	// the trial ended because the user subscribed
+	StatusConverted = "converted"
+
+	// the plan of trials, it isn't sold
+	TrialPlan = "trial"
+)
+
+var (
+	ErrSubscriptionNotFound = errors.New("subscription not found")
+	ErrAlreadySubscribed    = errors.New("there already is an active subscription")
+)
+
+// grantingStatuses are the statuses that give access until the period ends.
+var grantingStatuses = []string{StatusTrialing, StatusActive, StatusPastDue, StatusCanceled}
+
+// Subscription belongs to either a user or an org, never both.
+type Subscription struct {
+	ID               primitive.ObjectID  `json:"id" bson:"_id,omitempty"`
+	Plan             string              `json:"plan" bson:"plan"`
+	Email            string              `json:"email,omitempty" bson:"email,omitempty"`
+	OrgID            *primitive.ObjectID `json:"org_id,omitempty" bson:"orgId,omitempty"`
+	Status           string              `json:"status" bson:"status"`
+	CurrentPeriodEnd time.Time           `json:"current_period_end" bson:"currentPeriodEnd"`
+	CanceledAt       time.Time           `json:"canceled_at,omitempty" bson:"canceledAt,omitempty"`
+	CreatedAt        time.Time           `json:"created_at" bson:"createdAt"`
+	UpdatedAt        time.Time           `json:"updated_at" bson:"updatedAt"`
+
+	// set for subscriptions bought through the payment provider
+	ProviderID string `json:"-" bson:"providerId,omitempty"`
+	CustomerID string `json:"-" bson:"customerId,omitempty"`
+	// when the provider sent the state the subscription has, older events are
+	// ignored since the provider doesn't deliver them in order
+	ProviderEventAt time.Time `json:"-" bson:"providerEventAt,omitempty"`
+
+	// when the user was told that the trial ends soon
+	ReminderSentAt time.Time `json:"-" bson:"reminderSentAt,omitempty"`
+}
+
+// Grants reports whether the subscription gives access at the time.
+func (s *Subscription) Grants(now time.Time) bool {
+	for _, status := range grantingStatuses {
+		if s.Status == status {
+			return now.Before(s.CurrentPeriodEnd)
+		}
+	}
+
+	return false
+}
+
+func (s *Subscription) IsTrial() bool {
+	return s.Plan == TrialPlan
+}
+
+// paid reports whether one of the subscriptions was paid for.
+func paid(subs []Subscription) bool {
+	for _, sub := range subs {
+		if !sub.IsTrial() {
+			return true
+		}
+	}
+
+	return false
+}
+
+type SubscriptionRepo struct {
+	client     *mongo.Client
+	collection *mongo.Collection
+}
+
+func (m *SubscriptionRepo) New(ctx context.Context, sub *Subscription) error {
+	now := time.Now()
+
+	sub.CreatedAt = now
+	sub.UpdatedAt = now
+
+	res, err := m.collection.InsertOne(ctx, sub)
+	if err != nil {
+		return err
+	}
+
+	if id, ok := res.InsertedID.(primitive.ObjectID); ok {
+		sub.ID = id
+	}
+
+	return nil
+}
+
+func (m *SubscriptionRepo) Get(ctx context.Context, id string) (*Subscription, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrSubscriptionNotFound
+	}
+
+	var sub Subscription
+
+	err = m.collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&sub)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrSubscriptionNotFound
+		}
+
+		return nil, err
+	}
+
+	return &sub, nil
+}
+
+func (m *SubscriptionRepo) GetByProviderID(ctx context.Context, providerID string) (*Subscription, error) {
+	var sub Subscription
+
+	err := m.collection.FindOne(ctx, bson.M{"providerId": providerID}).Decode(&sub)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrSubscriptionNotFound
+		}
+
+		return nil, err
+	}
+
+	return &sub, nil
+}
+
+// List returns the subscriptions of the user and of the orgs, newest first.
+func (m *SubscriptionRepo) List(ctx context.Context, email string, orgIDs []primitive.ObjectID) ([]Subscription, error) {
+	return m.find(ctx, owners(email, orgIDs))
+}
+
+// ListGranting returns the subscriptions of the user and of the orgs that
+// give access right now.
+func (m *SubscriptionRepo) ListGranting(ctx context.Context, email string, orgIDs []primitive.ObjectID) ([]Subscription, error) {
+	filter := owners(email, orgIDs)
+	if filter == nil {
+		return []Subscription{}, nil
+	}
+
+	filter["status"] = bson.M{"$in": grantingStatuses}
+	filter["currentPeriodEnd"] = bson.M{"$gt": time.Now()}
+
+	return m.find(ctx, filter)
+}
+
+// Cancel stops the renewal, so the subscription ends with its period. Ending
+// it immediately takes the access away now.
+func (m *SubscriptionRepo) Cancel(ctx context.Context, id primitive.ObjectID, immediately bool) error {
+	now := time.Now()
+
+	set := bson.M{
+		"status":     StatusCanceled,
+		"canceledAt": now,
+		"updatedAt":  now,
+	}
+
+	if immediately {
+		set["status"] = StatusExpired
+		set["currentPeriodEnd"] = now
+	}
+
+	filter := bson.M{"_id": id, "status": bson.M{"$ne": StatusExpired}}
+
+	res, err := m.collection.UpdateOne(ctx, filter, bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrSubscriptionNotFound
+	}
+
+	return nil
+}
+
+// SyncFromProvider applies the state the provider sent at eventAt, unless a
+// newer one was applied already. A zero period end keeps the current one.
+func (m *SubscriptionRepo) SyncFromProvider(ctx context.Context, providerID, status string, periodEnd, eventAt time.Time) error {
+	set := bson.M{
+		"status":          status,
+		"providerEventAt": eventAt,
+		"updatedAt":       time.Now(),
+	}
+
+	if !periodEnd.IsZero() {
+		set["currentPeriodEnd"] = periodEnd
+	}
+
+	if status == StatusCanceled {
+		set["canceledAt"] = eventAt
+	}
+
+	filter := bson.M{
+		"providerId": providerID,
+		"$or": bson.A{
+			bson.M{"providerEventAt": bson.M{"$exists": false}},
+			bson.M{"providerEventAt": bson.M{"$lte": eventAt}},
+		},
+	}
+
+	res, err := m.collection.UpdateOne(ctx, filter, bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount > 0 {
+		return nil
+	}
+
+	// either a newer state was applied or the subscription isn't known yet
+	_, err = m.GetByProviderID(ctx, providerID)
+
+	return err
+}
+
+// ListTrialsEndingBefore returns the running trials that end before the time.
+func (m *SubscriptionRepo) ListTrialsEndingBefore(ctx context.Context, before time.Time) ([]Subscription, error) {
+	return m.find(ctx, bson.M{
+		"plan":             TrialPlan,
+		"status":           StatusTrialing,
+		"currentPeriodEnd": bson.M{"$lte": before},
+	})
+}
+
+// MarkReminded records that the reminder of a running trial is being sent.
+// It fails with ErrSubscriptionNotFound when it was already, so only one
+// instance sends it.
+func (m *SubscriptionRepo) MarkReminded(ctx context.Context, id primitive.ObjectID) error {
+	filter := bson.M{"_id": id, "status": StatusTrialing, "reminderSentAt": bson.M{"$exists": false}}
+
+	res, err := m.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"reminderSentAt": time.Now()}})
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrSubscriptionNotFound
+	}
+
+	return nil
+}
+
+// UnmarkReminded lets a reminder that couldn't be sent be retried.
+func (m *SubscriptionRepo) UnmarkReminded(ctx context.Context, id primitive.ObjectID) error {
+	_, err := m.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$unset": bson.M{"reminderSentAt": ""}})
+
+	return err
+}
+
+// EndTrial ends a running trial with the status. It fails with
+// ErrSubscriptionNotFound when it isn't running anymore, so only one instance
+// ends it.
+func (m *SubscriptionRepo) EndTrial(ctx context.Context, id primitive.ObjectID, status string) error {
+	now := time.Now()
+
+	filter := bson.M{"_id": id, "status": StatusTrialing}
+
+	res, err := m.collection.UpdateOne(ctx, filter, bson.M{
+		"$set": bson.M{"status": status, "updatedAt": now},
+		"$min": bson.M{"currentPeriodEnd": now},
+	})
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrSubscriptionNotFound
+	}
+
+	return nil
+}
+
+func (m *SubscriptionRepo) find(ctx context.Context, filter bson.M) ([]Subscription, error) {
+	subs := []Subscription{}
+
+	if filter == nil {
+		return subs, nil
+	}
+
+	cursor, err := m.collection.Find(ctx, filter, options.Find().SetSort(bson.M{"createdAt": -1}))
+	if err != nil {
+		return nil, err
+	}
+
+	err = cursor.All(ctx, &subs)
+	if err != nil {
+		return nil, err
+	}
+
+	return subs, nil
+}
+
+// owners matches the subscriptions of the user or the orgs, nil when there
+// is neither.
+func owners(email string, orgIDs []primitive.ObjectID) bson.M {
+	or := bson.A{}
+
+	if email != "" {
+		or = append(or, bson.M{"email": email})
+	}
+
+	if len(orgIDs) > 0 {
+		or = append(or, bson.M{"orgId": bson.M{"$in": orgIDs}})
+	}
+
+	if len(or) == 0 {
+		return nil
+	}
+
+	return bson.M{"$or": or}
+}
+
+// ChangeEmail moves the subscriptions and trials of the user to their new
+// address.
+func (m *SubscriptionRepo) ChangeEmail(ctx context.Context, email, newEmail string) error {
+	_, err := m.collection.UpdateMany(ctx, bson.M{"email": email}, bson.M{"$set": bson.M{"email": newEmail}})
+
+	return err
+}
+
+// Anonymize replaces the email of an erased user with a pseudonym. The
+// subscriptions are kept as a record of what was paid for, and the provider
+// still refers to them by their provider id.
+func (m *SubscriptionRepo) Anonymize(ctx context.Context, email, pseudonym string) error {
+	_, err := m.collection.UpdateMany(ctx, bson.M{"email": email}, bson.M{"$set": bson.M{"email": pseudonym}})
+
+	return err
+}
+
+func NewSubscriptionRepository(client *mongo.Client, collection *mongo.Collection) *SubscriptionRepo {
+	return &SubscriptionRepo{
+		client:     client,
+		collection: collection,
+	}
+}
@@ -0,0 +1,162 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	log "interviews/pkg/logger"
+)
+
+type Mailer interface {
+	Send(recipient, templateFile string, data any) error
+}
+
+// StartTrial gives a new user access to the subscription courses for the
+// configured number of days. Users that had a subscription or a trial before
+// don't get one.
+func (b *Billing) StartTrial(ctx context.Context, email string) error {
+	if b.cfg.Trials.Days <= 0 {
+		return nil
+	}
+
+	subs, err := b.subscriptions.List(ctx, email, nil)
+	if err != nil {
+		return err
+	}
+
+	if len(subs) > 0 {
+		return nil
+	}
+
+	return b.subscriptions.New(ctx, &Subscription{
+		Plan:             TrialPlan,
+		Email:            email,
+		Status:           StatusTrialing,
+		CurrentPeriodEnd: time.Now().AddDate(0, 0, b.cfg.Trials.Days),
+	})
+}
+
+// convertTrial ends the running trial of a user who subscribed. Trials of org
+// members who didn't buy the subscription are converted by ProcessTrials.
+func (b *Billing) convertTrial(ctx context.Context, email string) error {
+	if email == "" {
+		return nil
+	}
+
+	subs, err := b.subscriptions.List(ctx, email, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range subs {
+		if !sub.IsTrial() || sub.Status != StatusTrialing {
+			continue
+		}
+
+		err = b.subscriptions.EndTrial(ctx, sub.ID, StatusConverted)
+		if err != nil && !errors.Is(err, ErrSubscriptionNotFound) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ProcessTrials converts the trials of users who subscribed, reminds users
+// whose trial ends soon and expires the trials that are over. Each trial is
+// marked before an email is sent, so every email is sent once even when
+// several instances process the trials.
+func (b *Billing) ProcessTrials(ctx context.Context) error {
+	now := time.Now()
+
+	trials, err := b.subscriptions.ListTrialsEndingBefore(ctx, now.Add(b.cfg.Trials.ReminderBefore))
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+
+	for _, trial := range trials {
+		if ctx.Err() != nil {
+			break
+		}
+
+		err = b.processTrial(ctx, trial, now)
+		if err != nil {
+			log.ErrorCtx(err, log.Ctx{
+				"msg":   "unable to process trial",
+				"trial": trial.ID.Hex(),
+			})
+
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (b *Billing) processTrial(ctx context.Context, trial Subscription, now time.Time) error {
+	ids, err := b.orgIDs(ctx, trial.Email)
+	if err != nil {
+		return err
+	}
+
+	active, err := b.subscriptions.ListGranting(ctx, trial.Email, ids)
+	if err != nil {
+		return err
+	}
+
+	if paid(active) {
+		err = b.subscriptions.EndTrial(ctx, trial.ID, StatusConverted)
+		if errors.Is(err, ErrSubscriptionNotFound) {
+			return nil
+		}
+
+		return err
+	}
+
+	if !trial.CurrentPeriodEnd.After(now) {
+		err = b.subscriptions.EndTrial(ctx, trial.ID, StatusExpired)
+		if err != nil {
+			// another instance ended it
+			if errors.Is(err, ErrSubscriptionNotFound) {
+				return nil
+			}
+
+			return err
+		}
+
+		return b.mailer.Send(trial.Email, "trial_ended.tmpl", map[string]any{
+			"plansURL": b.cfg.FrontendURL + "/billing",
+		})
+	}
+
+	if !trial.ReminderSentAt.IsZero() {
+		return nil
+	}
+
+	err = b.subscriptions.MarkReminded(ctx, trial.ID)
+	if err != nil {
+		if errors.Is(err, ErrSubscriptionNotFound) {
+			return nil
+		}
+
+		return err
+	}
+
+	err = b.mailer.Send(trial.Email, "trial_ending.tmpl", map[string]any{
+		"endDate":  trial.CurrentPeriodEnd.Format("January 2, 2006"),
+		"plansURL": b.cfg.FrontendURL + "/billing",
+	})
+	if err != nil {
+		// retried on the next run
+		if unmarkErr := b.subscriptions.UnmarkReminded(ctx, trial.ID); unmarkErr != nil {
+			return errors.Join(err, unmarkErr)
+		}
+
+		return err
+	}
+
+	return nil
+}
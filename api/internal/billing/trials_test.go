@@ -0,0 +1,107 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"interviews/internal/orgs"
+)
+
+func TestBilling_StartTrial(t *testing.T) {
+	b := newTestBilling()
+
+	require.NoError(t, b.service.StartTrial(context.Background(), "jane@test.com"))
+	require.Len(t, b.subscriptions.subs, 1)
+
+	trial := b.subscriptions.subs[0]
+	assert.Equal(t, TrialPlan, trial.Plan)
+	assert.Equal(t, StatusTrialing, trial.Status)
+	assert.WithinDuration(t, time.Now().AddDate(0, 0, 14), trial.CurrentPeriodEnd, time.Minute)
+	assert.True(t, trial.Grants(time.Now()))
+
+	// only once
+	require.NoError(t, b.service.StartTrial(context.Background(), "jane@test.com"))
+	assert.Len(t, b.subscriptions.subs, 1)
+
+	// disabled
+	b = newTestBilling()
+	b.service.cfg.Trials.Days = 0
+
+	require.NoError(t, b.service.StartTrial(context.Background(), "jane@test.com"))
+	assert.Empty(t, b.subscriptions.subs)
+}
+
+func TestBilling_ProcessTrials(t *testing.T) {
+	orgID := primitive.NewObjectID()
+
+	trial := func(email string, ends time.Duration) *Subscription {
+		return &Subscription{
+			ID:               primitive.NewObjectID(),
+			Plan:             TrialPlan,
+			Email:            email,
+			Status:           StatusTrialing,
+			CurrentPeriodEnd: time.Now().Add(ends),
+		}
+	}
+
+	b := newTestBilling()
+	b.members.members = []orgs.Member{{OrgID: orgID, Email: "team@test.com", Role: orgs.RoleMember}}
+	b.subscriptions.subs = []*Subscription{
+		trial("new@test.com", 10*24*time.Hour),
+		trial("soon@test.com", 48*time.Hour),
+		trial("over@test.com", -time.Minute),
+		trial("paid@test.com", 48*time.Hour),
+		{ID: primitive.NewObjectID(), Plan: "monthly", Email: "paid@test.com", Status: StatusActive, CurrentPeriodEnd: time.Now().AddDate(0, 1, 0)},
+		trial("team@test.com", -time.Minute),
+		{ID: primitive.NewObjectID(), Plan: "team", OrgID: &orgID, Status: StatusActive, CurrentPeriodEnd: time.Now().AddDate(1, 0, 0)},
+	}
+
+	require.NoError(t, b.service.ProcessTrials(context.Background()))
+
+	subs := b.subscriptions.subs
+	assert.Equal(t, StatusTrialing, subs[0].Status)
+	assert.True(t, subs[0].ReminderSentAt.IsZero())
+	assert.Equal(t, StatusTrialing, subs[1].Status)
+	assert.False(t, subs[1].ReminderSentAt.IsZero())
+	assert.Equal(t, StatusExpired, subs[2].Status)
+	assert.Equal(t, StatusConverted, subs[3].Status)
+	assert.Equal(t, StatusConverted, subs[5].Status)
+
+	require.Len(t, b.mailer.sent, 2)
+	assert.Equal(t, "soon@test.com", b.mailer.sent[0].recipient)
+	assert.Equal(t, "trial_ending.tmpl", b.mailer.sent[0].template)
+	assert.Equal(t, subs[1].CurrentPeriodEnd.Format("January 2, 2006"), b.mailer.sent[0].data["endDate"])
+	assert.Equal(t, "over@test.com", b.mailer.sent[1].recipient)
+	assert.Equal(t, "trial_ended.tmpl", b.mailer.sent[1].template)
+
+	// another run, e.g. on the other instance, sends nothing again
+	require.NoError(t, b.service.ProcessTrials(context.Background()))
+	assert.Len(t, b.mailer.sent, 2)
+}
+
+func TestBilling_ProcessTrials_MailFails(t *testing.T) {
+	b := newTestBilling()
+	b.mailer.err = errors.New("smtp down")
+	b.subscriptions.subs = []*Subscription{{
+		ID:               primitive.NewObjectID(),
+		Plan:             TrialPlan,
+		Email:            "soon@test.com",
+		Status:           StatusTrialing,
+		CurrentPeriodEnd: time.Now().Add(48 * time.Hour),
+	}}
+
+	assert.Error(t, b.service.ProcessTrials(context.Background()))
+	assert.True(t, b.subscriptions.subs[0].ReminderSentAt.IsZero())
+
+	// retried on the next run
+	b.mailer.err = nil
+
+	require.NoError(t, b.service.ProcessTrials(context.Background()))
+	assert.Len(t, b.mailer.sent, 1)
+	assert.False(t, b.subscriptions.subs[0].ReminderSentAt.IsZero())
+}
@@ -0,0 +1,142 @@
+package billing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SignatureHeader = "Stripe-Signature"
+
+	EventCheckoutCompleted    = "checkout.session.completed"
+	EventCheckoutExpired      = "checkout.session.expired"
+	EventSubscriptionCreated  = "customer.subscription.created"
+	EventSubscriptionUpdated  = "customer.subscription.updated"
+	EventSubscriptionDeleted  = "customer.subscription.deleted"
+	EventInvoicePaymentFailed = "invoice.payment_failed"
+)
+
+// maxWebhookSize limits the events that are read.
+const maxWebhookSize = 1 << 20
+
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// Event is a webhook event of the payment provider.
+type Event struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Created int64  `json:"created"`
+	Data    struct {
+		Object json.RawMessage `json:"object"`
+	} `json:"data"`
+}
+
+func (e *Event) Time() time.Time {
+	return time.Unix(e.Created, 0)
+}
+
+type checkoutObject struct {
+	ID           string            `json:"id"`
+	Subscription string            `json:"subscription"`
+	Customer     string            `json:"customer"`
+	Metadata     map[string]string `json:"metadata"`
+}
+
+type subscriptionObject struct {
+	ID                string `json:"id"`
+	Status            string `json:"status"`
+	CurrentPeriodEnd  int64  `json:"current_period_end"`
+	CancelAtPeriodEnd bool   `json:"cancel_at_period_end"`
+}
+
+type invoiceObject struct {
+	Subscription string `json:"subscription"`
+}
+
+// VerifySignature checks the signature header the provider sends with a
+// webhook, t=<unix time>,v1=<hex hmac>. Several v1 signatures are sent while
+// the secret is rolled. Old timestamps are rejected so a captured request
+// can't be replayed.
+func VerifySignature(payload []byte, header, secret string, tolerance time.Duration, now time.Time) error {
+	if secret == "" {
+		return ErrInvalidSignature
+	}
+
+	var timestamp string
+	var signatures []string
+
+	for _, part := range strings.Split(header, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+
+		switch k {
+		case "t":
+			timestamp = v
+		case "v1":
+			signatures = append(signatures, v)
+		}
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return ErrInvalidSignature
+	}
+
+	age := now.Sub(time.Unix(unix, 0))
+	if age > tolerance || age < -tolerance {
+		return ErrInvalidSignature
+	}
+
+	expected, _ := hex.DecodeString(sign(payload, timestamp, secret))
+
+	for _, s := range signatures {
+		actual, err := hex.DecodeString(s)
+		if err == nil && hmac.Equal(actual, expected) {
+			return nil
+		}
+	}
+
+	return ErrInvalidSignature
+}
+
+func sign(payload []byte, timestamp, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = fmt.Fprintf(mac, "%s.", timestamp)
+	mac.Write(payload)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// providerStatus maps the status of a subscription at the provider to ours.
+// The provider keeps a canceled subscription active until the period ends.
+func providerStatus(obj subscriptionObject) (string, bool) {
+	var status string
+
+	switch obj.Status {
+	case "trialing":
+		status = StatusTrialing
+	case "active":
+		status = StatusActive
+	case "past_due", "unpaid", "incomplete":
+		status = StatusPastDue
+	case "canceled", "incomplete_expired":
+		return StatusExpired, true
+	default:
+		return "", false
+	}
+
+	if obj.CancelAtPeriodEnd {
+		status = StatusCanceled
+	}
+
+	return status, true
+}
@@ -0,0 +1,86 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ErrEventProcessed = errors.New("event was already processed")
+
+// eventLease is how long an instance has to process an event before a
+// redelivery may process it again.
+const eventLease = 5 * time.Minute
+
+// WebhookEvent records a provider event so a redelivery isn't applied twice.
+type WebhookEvent struct {
+	ID          string    `bson:"_id"`
+	Type        string    `bson:"type"`
+	Processed   bool      `bson:"processed"`
+	ClaimedAt   time.Time `bson:"claimedAt"`
+	ProcessedAt time.Time `bson:"processedAt,omitempty"`
+}
+
+type WebhookEventRepo struct {
+	client     *mongo.Client
+	collection *mongo.Collection
+}
+
+// Claim marks the event as being processed. It fails with ErrEventProcessed
+// when the event was processed already or another instance is processing it.
+func (m *WebhookEventRepo) Claim(ctx context.Context, id, eventType string) error {
+	now := time.Now()
+
+	_, err := m.collection.InsertOne(ctx, WebhookEvent{ID: id, Type: eventType, ClaimedAt: now})
+	if err == nil {
+		return nil
+	}
+
+	if !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+
+	// take over from an instance that died while processing it
+	filter := bson.M{
+		"_id":       id,
+		"processed": false,
+		"claimedAt": bson.M{"$lt": now.Add(-eventLease)},
+	}
+
+	res, err := m.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"claimedAt": now}})
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrEventProcessed
+	}
+
+	return nil
+}
+
+func (m *WebhookEventRepo) Complete(ctx context.Context, id string) error {
+	_, err := m.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+		"processed":   true,
+		"processedAt": time.Now(),
+	}})
+
+	return err
+}
+
+// Release gives up the claim so the redelivery of a failed event is processed.
+func (m *WebhookEventRepo) Release(ctx context.Context, id string) error {
+	_, err := m.collection.DeleteOne(ctx, bson.M{"_id": id, "processed": false})
+
+	return err
+}
+
+func NewWebhookEventRepository(client *mongo.Client, collection *mongo.Collection) *WebhookEventRepo {
+	return &WebhookEventRepo{
+		client:     client,
+		collection: collection,
+	}
+}
@@ -10,18 +10,115 @@ type Config struct {
 	Env     string `envconfig:"ENV" default:"development"`
 	Version string `envconfig:"VERSION" default:"development"`
 
+	// the metrics are served on their own port, which must not be exposed
+	MetricsPort int `envconfig:"METRICS_PORT" default:"9090"`
+
+	FrontendURL string `envconfig:"FRONTEND_URL" default:"http://localhost:5173"`
+
 	Limiter struct {
 		Enabled bool
 		Rps     float64
 		Burst   int
 	}
 
+	Lockout struct {
+		Threshold   int           `envconfig:"LOGIN_LOCKOUT_THRESHOLD" default:"5"`
+		IPThreshold int           `envconfig:"LOGIN_LOCKOUT_IP_THRESHOLD" default:"50"`
+		Duration    time.Duration `envconfig:"LOGIN_LOCKOUT_DURATION" default:"15m"`
+		Window      time.Duration `envconfig:"LOGIN_FAILURE_WINDOW" default:"1h"`
+		BaseDelay   time.Duration `envconfig:"LOGIN_BACKOFF_BASE" default:"1s"`
+		MaxDelay    time.Duration `envconfig:"LOGIN_BACKOFF_MAX" default:"1m"`
+	}
+
+	TwoFactor struct {
+		Issuer string `envconfig:"TWO_FACTOR_ISSUER" default:"Interviews"`
+	}
+
+	// every provider is configured with OIDC_<NAME>_* variables, see oidc.LoadProviderConfigs
+	OIDC struct {
+		// google backs the /v1/google login, so it is always configured unless
+		// the list is set explicitly
+		Providers   []string      `envconfig:"OIDC_PROVIDERS" default:"google"`
+		StateTTL    time.Duration `envconfig:"OIDC_STATE_TTL" default:"10m"`
+		HTTPTimeout time.Duration `envconfig:"OIDC_HTTP_TIMEOUT" default:"10s"`
+	}
+
+	// applies to new passwords, see password.Policy
+	Password struct {
+		MinLength        int    `envconfig:"PASSWORD_MIN_LENGTH" default:"10"`
+		MinClasses       int    `envconfig:"PASSWORD_MIN_CHARACTER_CLASSES" default:"2"`
+		BreachedListFile string `envconfig:"PASSWORD_BREACHED_LIST_FILE"`
+
+		// Argon2id cost, hashes made with other values are upgraded on login
+		Argon2Memory      uint32 `envconfig:"PASSWORD_ARGON2_MEMORY_KIB" default:"19456"`
+		Argon2Iterations  uint32 `envconfig:"PASSWORD_ARGON2_ITERATIONS" default:"2"`
+		Argon2Parallelism uint8  `envconfig:"PASSWORD_ARGON2_PARALLELISM" default:"1"`
+	}
+
+	// requests are limited per email and per client IP
+	MagicLink struct {
+		TTL        time.Duration `envconfig:"MAGIC_LINK_TTL" default:"15m"`
+		EmailLimit int           `envconfig:"MAGIC_LINK_EMAIL_LIMIT" default:"5"`
+		IPLimit    int           `envconfig:"MAGIC_LINK_IP_LIMIT" default:"20"`
+		Window     time.Duration `envconfig:"MAGIC_LINK_LIMIT_WINDOW" default:"1h"`
+	}
+
+	Orgs struct {
+		InviteTTL time.Duration `envconfig:"ORG_INVITE_TTL" default:"168h"`
+	}
+
+	// data exports and account deletion requested by the user
+	Privacy struct {
+		ExportTTL           time.Duration `envconfig:"DATA_EXPORT_TTL" default:"168h"`
+		ExportInterval      time.Duration `envconfig:"DATA_EXPORT_INTERVAL" default:"24h"`
+		DeletionGracePeriod time.Duration `envconfig:"ACCOUNT_DELETION_GRACE_PERIOD" default:"720h"`
+		DeletionInterval    time.Duration `envconfig:"ACCOUNT_DELETION_INTERVAL" default:"1h"`
+	}
+
+	// Stripe-style payment provider subscriptions are bought through
+	Payments struct {
+		ProviderURL      string        `envconfig:"PAYMENT_PROVIDER_URL" default:"https://api.stripe.com"`
+		SecretKey        string        `envconfig:"PAYMENT_SECRET_KEY"`
+		WebhookSecret    string        `envconfig:"PAYMENT_WEBHOOK_SECRET"`
+		WebhookTolerance time.Duration `envconfig:"PAYMENT_WEBHOOK_TOLERANCE" default:"5m"`
+		HTTPTimeout      time.Duration `envconfig:"PAYMENT_HTTP_TIMEOUT" default:"10s"`
+	}
+
+	// new users get a trial of the subscription courses, 0 days disables it
+	Trials struct {
+		Days           int           `envconfig:"TRIAL_DAYS" default:"14"`
+		ReminderBefore time.Duration `envconfig:"TRIAL_REMINDER_BEFORE" default:"72h"`
+		JobInterval    time.Duration `envconfig:"TRIAL_JOB_INTERVAL" default:"15m"`
+	}
+
+	// scheduled jobs run on one instance at a time, see jobs.Scheduler
+	Jobs struct {
+		PollInterval time.Duration `envconfig:"JOB_POLL_INTERVAL" default:"1m"`
+		Lease        time.Duration `envconfig:"JOB_LEASE" default:"10m"`
+	}
+
+	APIKeys struct {
+		DefaultTTL time.Duration `envconfig:"API_KEY_DEFAULT_TTL" default:"2160h"`
+		MaxTTL     time.Duration `envconfig:"API_KEY_MAX_TTL" default:"8760h"`
+	}
+
 	Smtp struct {
-		Host     string
-		Port     int
-		Username string
-		Password string
-		Sender   string
+		Host     string `envconfig:"SMTP_HOST" default:"localhost"`
+		Port     int    `envconfig:"SMTP_PORT" default:"1025"`
+		Username string `envconfig:"SMTP_USERNAME"`
+		Password string `envconfig:"SMTP_PASSWORD"`
+		Sender   string `envconfig:"SMTP_SENDER" default:"Interviews <no-reply@interviews.local>"`
+	}
+
+	// browsers get the access token in an HttpOnly cookie instead of the
+	// response body, see auth.SetSessionCookies
+	Cookies struct {
+		Enabled  bool   `envconfig:"AUTH_COOKIE_ENABLED" default:"false"`
+		Name     string `envconfig:"AUTH_COOKIE_NAME" default:"access_token"`
+		CSRFName string `envconfig:"CSRF_COOKIE_NAME" default:"csrf_token"`
+		Domain   string `envconfig:"AUTH_COOKIE_DOMAIN"`
+		Secure   bool   `envconfig:"AUTH_COOKIE_SECURE" default:"true"`
+		SameSite string `envconfig:"AUTH_COOKIE_SAMESITE" default:"lax"` // strict, lax or none
 	}
 
 	Cors struct {
@@ -34,6 +131,23 @@ type Config struct {
 		CourseCollection string `envconfig:"COURSE_COLLECTION" default:"courses"`
 		LoginCollection  string `envconfig:"LOGIN_COLLECTION" default:"users"`
 		TokenCollection  string `envconfig:"TOKEN_COLLECTION" default:"tokens"`
+
+		ScopedTokenCollection  string `envconfig:"SCOPED_TOKEN_COLLECTION" default:"scoped_tokens"`
+		LoginAttemptCollection string `envconfig:"LOGIN_ATTEMPT_COLLECTION" default:"login_attempts"`
+		OIDCStateCollection    string `envconfig:"OIDC_STATE_COLLECTION" default:"oidc_states"`
+		APIKeyCollection       string `envconfig:"API_KEY_COLLECTION" default:"api_keys"`
+		SessionCollection      string `envconfig:"SESSION_COLLECTION" default:"sessions"`
+		AuditCollection        string `envconfig:"AUDIT_COLLECTION" default:"audit_log"`
+		OrgCollection          string `envconfig:"ORG_COLLECTION" default:"orgs"`
+		OrgMemberCollection    string `envconfig:"ORG_MEMBER_COLLECTION" default:"org_members"`
+		OrgInviteCollection    string `envconfig:"ORG_INVITE_COLLECTION" default:"org_invites"`
+		DataExportCollection   string `envconfig:"DATA_EXPORT_COLLECTION" default:"data_exports"`
+		PlanCollection         string `envconfig:"PLAN_COLLECTION" default:"plans"`
+		SubscriptionCollection string `envconfig:"SUBSCRIPTION_COLLECTION" default:"subscriptions"`
+		PaymentEventCollection string `envconfig:"PAYMENT_EVENT_COLLECTION" default:"payment_events"`
+		CouponCollection       string `envconfig:"COUPON_COLLECTION" default:"coupons"`
+		RedemptionCollection   string `envconfig:"REDEMPTION_COLLECTION" default:"coupon_redemptions"`
+		JobCollection          string `envconfig:"JOB_COLLECTION" default:"jobs"`
 	}
 
 	UserConfig struct {
@@ -42,10 +156,28 @@ type Config struct {
 
 	TokenConfig struct {
 		Expires           int           `envconfig:"TOKEN_EXPIRES" default:"24"`
+		PasswordResetTTL  time.Duration `envconfig:"PASSWORD_RESET_TOKEN_TTL" default:"45m"`
+		ActivationTTL     time.Duration `envconfig:"ACTIVATION_TOKEN_TTL" default:"72h"`
+		ActivationResend  time.Duration `envconfig:"ACTIVATION_RESEND_INTERVAL" default:"2m"`
+		EmailChangeTTL    time.Duration `envconfig:"EMAIL_CHANGE_TOKEN_TTL" default:"24h"`
 		MaxRetries        int           `envconfig:"TOKEN_CACHE_INIT_MAX_RETRIES" default:"10"`
 		RetryPeriod       time.Duration `envconfig:"TOKEN_CACHE_INIT_RETRY_PERIOD" default:"300ms"`
 		ValidityThreshold time.Duration `envconfig:"TOKEN_CACHE_VALIDITY_THRESHOLD" default:"3h30m"`
 		RefreshPeriod     time.Duration `envconfig:"TOKEN_CACHE_REFRESH_PERIOD" default:"15m"`
+
+		Issuer    string        `envconfig:"JWT_ISSUER" default:"interviews-api"`
+		Audience  string        `envconfig:"JWT_AUDIENCE" default:"go-trakteer"`
+		ClockSkew time.Duration `envconfig:"JWT_CLOCK_SKEW" default:"30s"`
+
+		TwoFactorChallengeTTL time.Duration `envconfig:"TWO_FACTOR_CHALLENGE_TTL" default:"5m"`
+		ImpersonationTTL      time.Duration `envconfig:"IMPERSONATION_TOKEN_TTL" default:"30m"`
+
+		SigningAlgorithm    string   `envconfig:"JWT_SIGNING_ALG" default:"HS256"`
+		SigningKeyID        string   `envconfig:"JWT_SIGNING_KID" default:"primary"`
+		SigningSecret       string   `envconfig:"JWT_SIGNING_SECRET"`
+		SigningKeyFile      string   `envconfig:"JWT_SIGNING_KEY_FILE"`
+		VerificationKeys    []string `envconfig:"JWT_VERIFICATION_KEYS"`    // kid=path to a PEM key kept for rotation
+		VerificationSecrets []string `envconfig:"JWT_VERIFICATION_SECRETS"` // kid=secret of a rotated HS256 key
 	}
 
 	UsersConfig struct {
@@ -0,0 +1,92 @@
+package courses
+
+import (
+	"context"
+	"errors"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/mongo"
+	"interviews/internal/billing"
+	data "interviews/internal/users"
+	"net/http"
+)
+
+type Entitlements interface {
+	Access(ctx context.Context, user *data.User) (billing.Access, error)
+}
+
+// CanAccessCourse reports whether the user can open, and enroll in, the
+// course. Only subscription courses need an entitlement.
+func CanAccessCourse(access billing.Access, course *Course) bool {
+	return !course.Subscription || access.Includes(course.ObjectId.Hex())
+}
+
+// CanAccessVideo reports whether the user can watch the video of the course.
+// Free videos of a subscription course are previews anyone can watch.
+func CanAccessVideo(access billing.Access, course *Course, video *Video) bool {
+	return !video.Paid || access.Includes(course.ObjectId.Hex())
+}
+
+// CourseAccessHandler tells the user what they can access of the course, e.g.
+// before they enroll.
+func (c *Courses) CourseAccessHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	course, err := c.repo.GetCourseById(ctx, mux.Vars(r)["id"])
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrConvertingKeyToHex), errors.Is(err, mongo.ErrNoDocuments):
+			c.e.NotFoundResponse(w, r)
+		default:
+			c.e.ServerErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	access, err := c.entitlements.Access(ctx, c.userCtx.ContextGetUser(r))
+	if err != nil {
+		c.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	env := envelope{
+		"course":      CanAccessCourse(access, course),
+		"paid_videos": access.Includes(course.ObjectId.Hex()),
+	}
+
+	err = c.helper.WriteJSON(w, http.StatusOK, envelope{"access": env}, nil)
+	if err != nil {
+		c.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+// lockPaidContent marks what the user of the request can access and removes
+// the urls of the paid videos they can't watch.
+func (c *Courses) lockPaidContent(r *http.Request, courses ...*Course) error {
+	access, err := c.entitlements.Access(context.Background(), c.userCtx.ContextGetUser(r))
+	if err != nil {
+		return err
+	}
+
+	for _, course := range courses {
+		if course == nil {
+			continue
+		}
+
+		course.Entitled = CanAccessCourse(access, course)
+
+		for i := range course.Contents {
+			for j := range course.Contents[i].Videos {
+				video := &course.Contents[i].Videos[j]
+
+				if !CanAccessVideo(access, course, video) {
+					video.Url = ""
+					video.Locked = true
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package courses
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"interviews/internal/billing"
+	data "interviews/internal/users"
+)
+
+func testCourse(subscription bool) *Course {
+	course := &Course{ObjectId: primitive.NewObjectID(), Title: "Go", Subscription: subscription}
+	course.Contents = append(course.Contents, struct {
+		SectionTitle string  `bson:"section_title" json:"sectionTitle"`
+		Videos       []Video `bson:"videos" json:"videos"`
+	}{
+		SectionTitle: "Basics",
+		Videos: []Video{
+			{Title: "Intro", Url: "https://videos.test/intro"},
+			{Title: "Goroutines", Url: "https://videos.test/goroutines", Paid: true},
+		},
+	})
+
+	return course
+}
+
+func TestCourses_CoursesIdHandler_LocksPaidVideos(t *testing.T) {
+	user := &data.User{Email: "jane@test.com", Activated: true}
+
+	testCases := []struct {
+		name         string
+		user         *data.User
+		subscription bool
+		access       billing.Access
+		entitled     bool
+		paidURL      string
+	}{
+		{name: "anonymous, free course", user: data.AnonymousUser, entitled: true},
+		{name: "anonymous, subscription course", user: data.AnonymousUser, subscription: true},
+		{name: "no subscription", user: user, subscription: true},
+		{
+			name:         "subscribed",
+			user:         user,
+			subscription: true,
+			access:       billing.Access{All: true},
+			entitled:     true,
+			paidURL:      "https://videos.test/goroutines",
+		},
+		{
+			name:         "org was granted another course",
+			user:         user,
+			subscription: true,
+			access:       billing.Access{Courses: []string{"other"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			course := testCourse(tc.subscription)
+			service := NewCoursesService(&MockRepository{Course: course}, &MockEntitlements{access: tc.access})
+
+			req, _ := http.NewRequest(http.MethodGet, "/v1/course/"+course.ObjectId.Hex(), nil)
+			req = mux.SetURLVars(req, map[string]string{"id": course.ObjectId.Hex()})
+			userCtx := data.UsersContext{}
+			req = userCtx.ContextSetUser(req, tc.user)
+
+			recorder := httptest.NewRecorder()
+			service.CoursesIdHandler(recorder, req)
+
+			require.Equal(t, http.StatusOK, recorder.Code)
+
+			var res struct {
+				Data Course `json:"data"`
+			}
+			require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+
+			videos := res.Data.Contents[0].Videos
+
+			assert.Equal(t, tc.entitled, res.Data.Entitled)
+			assert.Equal(t, "https://videos.test/intro", videos[0].Url)
+			assert.False(t, videos[0].Locked)
+			assert.Equal(t, tc.paidURL, videos[1].Url)
+			assert.Equal(t, tc.paidURL == "", videos[1].Locked)
+		})
+	}
+}
+
+func TestCourses_CourseAccessHandler(t *testing.T) {
+	course := testCourse(true)
+	user := &data.User{Email: "jane@test.com", Activated: true}
+
+	access := func(access billing.Access) map[string]bool {
+		service := NewCoursesService(&MockRepository{Course: course}, &MockEntitlements{access: access})
+
+		req, _ := http.NewRequest(http.MethodGet, "/v1/course/"+course.ObjectId.Hex()+"/access", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": course.ObjectId.Hex()})
+		userCtx := data.UsersContext{}
+		req = userCtx.ContextSetUser(req, user)
+
+		recorder := httptest.NewRecorder()
+		service.CourseAccessHandler(recorder, req)
+
+		require.Equal(t, http.StatusOK, recorder.Code)
+
+		var res struct {
+			Access map[string]bool `json:"access"`
+		}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+
+		return res.Access
+	}
+
+	assert.Equal(t, map[string]bool{"course": false, "paid_videos": false}, access(billing.Access{}))
+	assert.Equal(t, map[string]bool{"course": true, "paid_videos": true}, access(billing.Access{Courses: []string{course.ObjectId.Hex()}}))
+}
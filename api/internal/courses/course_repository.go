@@ -44,14 +44,20 @@ type Course struct {
 		HighLevelOverview string   `bson:"highLevelOverview" json:"highLevelOverview"`
 	} `bson:"course_details" json:"course_details"`
 	Contents []struct {
-		SectionTitle string `bson:"section_title" json:"sectionTitle"`
-		Videos       []struct {
-			Title  string `bson:"title" json:"title"`
-			Url    string `bson:"url" json:"url"`
-			Paid   bool   `bson:"paid" json:"paid"`
-			Length string `bson:"length" json:"length"`
-		} `bson:"videos" json:"videos"`
+		SectionTitle string  `bson:"section_title" json:"sectionTitle"`
+		Videos       []Video `bson:"videos" json:"videos"`
 	} `bson:"contents" json:"contents"`
+	// whether the user of the request can access the course, see lockPaidContent
+	Entitled bool `bson:"-" json:"entitled"`
+}
+
+type Video struct {
+	Title  string `bson:"title" json:"title"`
+	Url    string `bson:"url" json:"url"`
+	Paid   bool   `bson:"paid" json:"paid"`
+	Length string `bson:"length" json:"length"`
+	// the url of a paid video is only sent to users entitled to it
+	Locked bool `bson:"-" json:"locked"`
 }
 
 type CourseRepo struct {
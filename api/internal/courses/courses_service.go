@@ -6,6 +6,7 @@ import (
 	"errors"
 	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/bson"
+	data "interviews/internal/users"
 	"interviews/pkg"
 	log "interviews/pkg/logger"
 	validator "interviews/pkg/vaildator"
@@ -36,9 +37,12 @@ type Repository interface {
 }
 
 type Courses struct {
-	helper    pkg.Helper
-	repo      Repository
-	validator validator.Validator
+	helper       pkg.Helper
+	e            pkg.CustomErrors
+	repo         Repository
+	entitlements Entitlements
+	userCtx      data.UsersContext
+	validator    validator.Validator
 }
 
 type Filter struct {
@@ -126,6 +130,18 @@ func (c *Courses) CoursesAllHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	courses := make([]*Course, 0, len(res))
+	for i := range res {
+		courses = append(courses, &res[i])
+	}
+
+	err = c.lockPaidContent(r, courses...)
+	if err != nil {
+		c.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
 	err = c.helper.WriteJSON(w, http.StatusOK, envelope{"data": res, "metadata": "none"}, nil)
 	if err != nil {
 		clog.ErrorCtx(err, log.Ctx{
@@ -246,6 +262,13 @@ func (c *Courses) CoursesIdHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = c.lockPaidContent(r, res)
+	if err != nil {
+		c.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
 	err = c.helper.WriteJSON(w, http.StatusOK, envelope{"data": res, "metadata": "none"}, nil)
 	if err != nil {
 		log.ErrorCtx(err, log.Ctx{
@@ -382,9 +405,12 @@ func validateCourse(v *validator.Validator, course *Course) {
 	v.Check(course.Title != "", "title", "title must be provided")
 }
 
-func NewCoursesService(repo Repository) *Courses {
+func NewCoursesService(repo Repository, entitlements Entitlements) *Courses {
 	return &Courses{
-		repo:      repo,
-		validator: *validator.New(),
+		e:            pkg.CustomErrors{},
+		repo:         repo,
+		entitlements: entitlements,
+		userCtx:      data.UsersContext{},
+		validator:    *validator.New(),
 	}
 }
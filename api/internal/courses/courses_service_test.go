@@ -6,6 +6,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"go.mongodb.org/mongo-driver/bson"
+	"interviews/internal/billing"
+	data "interviews/internal/users"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -43,6 +45,24 @@ func (m *MockRepository) GetCourseById(ctx context.Context, id string) (*Course,
 	return m.Course, m.err
 }
 
+type MockEntitlements struct {
+	access billing.Access
+}
+
+func (m *MockEntitlements) Access(ctx context.Context, user *data.User) (billing.Access, error) {
+	if user.IsAnonymous() {
+		return billing.Access{}, nil
+	}
+
+	return m.access, nil
+}
+
+func anonymous(req *http.Request) *http.Request {
+	userCtx := data.UsersContext{}
+
+	return userCtx.ContextSetUser(req, data.AnonymousUser)
+}
+
 func Test_CoursesAllHandler(t *testing.T) {
 
 	testCases := []struct {
@@ -115,9 +135,10 @@ func Test_CoursesAllHandler(t *testing.T) {
 		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
 
-			coursesService := NewCoursesService(tc.repo)
+			coursesService := NewCoursesService(tc.repo, &MockEntitlements{})
 
 			req, err := http.NewRequest("POST", "/courses", bytes.NewBuffer(tc.reqBody))
+			req = anonymous(req)
 			assert.NoError(t, err)
 
 			rr := httptest.NewRecorder()
@@ -177,10 +198,11 @@ func Test_CoursesIdHandler(t *testing.T) {
 		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
 
-			coursesService := NewCoursesService(tc.repo)
+			coursesService := NewCoursesService(tc.repo, &MockEntitlements{})
 
 			url := fmt.Sprintf("/v1/course/%s", tc.id)
 			req, err := http.NewRequest("GET", url, nil)
+			req = anonymous(req)
 			assert.ErrorIs(t, err, tc.err)
 
 			rr := httptest.NewRecorder()
@@ -231,7 +253,7 @@ func Test_CourseRepo_DeleteCourse(t *testing.T) {
 		tc := tc
 		t.Run(tc.Name, func(t *testing.T) {
 
-			coursesService := NewCoursesService(tc.repo)
+			coursesService := NewCoursesService(tc.repo, &MockEntitlements{})
 
 			url := fmt.Sprintf("/v1/delete-course/%s", tc.id)
 			req, err := http.NewRequest("DELETE", url, nil)
@@ -290,22 +312,12 @@ func Test_CreateCourseHandler(t *testing.T) {
 					HighLevelOverview: "Overview of the course",
 				},
 				Contents: []struct {
-					SectionTitle string `bson:"section_title" json:"sectionTitle"`
-					Videos       []struct {
-						Title  string `bson:"title" json:"title"`
-						Url    string `bson:"url" json:"url"`
-						Paid   bool   `bson:"paid" json:"paid"`
-						Length string `bson:"length" json:"length"`
-					} `bson:"videos" json:"videos"`
+					SectionTitle string  `bson:"section_title" json:"sectionTitle"`
+					Videos       []Video `bson:"videos" json:"videos"`
 				}{
 					{
 						SectionTitle: "Introduction",
-						Videos: []struct {
-							Title  string `bson:"title" json:"title"`
-							Url    string `bson:"url" json:"url"`
-							Paid   bool   `bson:"paid" json:"paid"`
-							Length string `bson:"length" json:"length"`
-						}{
+						Videos: []Video{
 							{
 								Title:  "Welcome Video",
 								Url:    "https://example.com/intro-video",
@@ -352,22 +364,12 @@ func Test_CreateCourseHandler(t *testing.T) {
 					HighLevelOverview: "Overview of the course",
 				},
 				Contents: []struct {
-					SectionTitle string `bson:"section_title" json:"sectionTitle"`
-					Videos       []struct {
-						Title  string `bson:"title" json:"title"`
-						Url    string `bson:"url" json:"url"`
-						Paid   bool   `bson:"paid" json:"paid"`
-						Length string `bson:"length" json:"length"`
-					} `bson:"videos" json:"videos"`
+					SectionTitle string  `bson:"section_title" json:"sectionTitle"`
+					Videos       []Video `bson:"videos" json:"videos"`
 				}{
 					{
 						SectionTitle: "Introduction",
-						Videos: []struct {
-							Title  string `bson:"title" json:"title"`
-							Url    string `bson:"url" json:"url"`
-							Paid   bool   `bson:"paid" json:"paid"`
-							Length string `bson:"length" json:"length"`
-						}{
+						Videos: []Video{
 							{
 								Title:  "Welcome Video",
 								Url:    "https://example.com/intro-video",
@@ -385,7 +387,7 @@ func Test_CreateCourseHandler(t *testing.T) {
 	for _, tc := range testCases {
 		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
-			coursesService := NewCoursesService(tc.repo)
+			coursesService := NewCoursesService(tc.repo, &MockEntitlements{})
 			mockCourseBytes, err := json.Marshal(tc.reqBody)
 			assert.NoError(t, err)
 			mockCourseJSON := []byte(fmt.Sprintf("%s", mockCourseBytes))
@@ -0,0 +1,82 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Job is the schedule of a job, shared by all instances.
+type Job struct {
+	Name string `bson:"_id"`
+	// the instance running the job until the lock runs out
+	Owner       string    `bson:"owner"`
+	LockedUntil time.Time `bson:"lockedUntil"`
+	NextRunAt   time.Time `bson:"nextRunAt"`
+	LastRunAt   time.Time `bson:"lastRunAt,omitempty"`
+	LastError   string    `bson:"lastError,omitempty"`
+}
+
+type JobRepo struct {
+	client     *mongo.Client
+	collection *mongo.Collection
+}
+
+// Acquire locks the job for the owner if it is due and no other instance is
+// running it. A lock that ran out, e.g. because the instance holding it
+// crashed, can be taken over.
+func (m *JobRepo) Acquire(ctx context.Context, name, owner string, lease time.Duration) (bool, error) {
+	now := time.Now()
+
+	filter := bson.M{
+		"_id":         name,
+		"nextRunAt":   bson.M{"$lte": now},
+		"lockedUntil": bson.M{"$lte": now},
+	}
+
+	update := bson.M{
+		"$set":         bson.M{"owner": owner, "lockedUntil": now.Add(lease)},
+		"$setOnInsert": bson.M{"nextRunAt": now},
+	}
+
+	_, err := m.collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		// the job exists but isn't due or is locked
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Finish releases the lock of the owner and schedules the next run.
+func (m *JobRepo) Finish(ctx context.Context, name, owner string, next time.Time, runErr error) error {
+	now := time.Now()
+
+	lastError := ""
+	if runErr != nil {
+		lastError = runErr.Error()
+	}
+
+	_, err := m.collection.UpdateOne(ctx, bson.M{"_id": name, "owner": owner}, bson.M{"$set": bson.M{
+		"lockedUntil": now,
+		"nextRunAt":   next,
+		"lastRunAt":   now,
+		"lastError":   lastError,
+	}})
+
+	return err
+}
+
+func NewJobRepository(client *mongo.Client, collection *mongo.Collection) *JobRepo {
+	return &JobRepo{
+		client:     client,
+		collection: collection,
+	}
+}
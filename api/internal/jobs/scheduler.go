@@ -0,0 +1,113 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"time"
+
+	log "interviews/pkg/logger"
+)
+
+type LockRepository interface {
+	Acquire(ctx context.Context, name, owner string, lease time.Duration) (bool, error)
+	Finish(ctx context.Context, name, owner string, next time.Time, runErr error) error
+}
+
+type job struct {
+	name     string
+	interval time.Duration
+	run      func(ctx context.Context) error
+}
+
+// Scheduler runs jobs at an interval on one instance at a time. The schedule
+// is kept in the database, so a restart neither skips nor repeats a run, and
+// a run that died with its instance is picked up by another one once the
+// lease ran out.
+type Scheduler struct {
+	locks LockRepository
+	owner string
+	poll  time.Duration
+	lease time.Duration
+	jobs  []job
+}
+
+// Register adds a job. It should finish well within the lease, or another
+// instance may start it as well.
+func (s *Scheduler) Register(name string, interval time.Duration, run func(ctx context.Context) error) {
+	s.jobs = append(s.jobs, job{name: name, interval: interval, run: run})
+}
+
+// Start runs the jobs when they are due until the context is cancelled.
+func (s *Scheduler) Start(ctx context.Context) {
+	for {
+		s.RunDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.poll):
+		}
+	}
+}
+
+// RunDue runs the jobs that are due and not running on another instance.
+func (s *Scheduler) RunDue(ctx context.Context) {
+	for _, j := range s.jobs {
+		if ctx.Err() != nil {
+			return
+		}
+
+		acquired, err := s.locks.Acquire(ctx, j.name, s.owner, s.lease)
+		if err != nil {
+			log.ErrorCtx(err, log.Ctx{
+				"msg": "unable to lock job",
+				"job": j.name,
+			})
+
+			continue
+		}
+
+		if !acquired {
+			continue
+		}
+
+		start := time.Now()
+
+		runErr := j.run(ctx)
+		if runErr != nil {
+			log.ErrorCtx(runErr, log.Ctx{
+				"msg": "job failed",
+				"job": j.name,
+			})
+		}
+
+		err = s.locks.Finish(ctx, j.name, s.owner, start.Add(j.interval), runErr)
+		if err != nil {
+			log.ErrorCtx(err, log.Ctx{
+				"msg": "unable to unlock job",
+				"job": j.name,
+			})
+		}
+	}
+}
+
+// instanceID tells the instances apart in the locks.
+func instanceID() string {
+	host, _ := os.Hostname()
+
+	b := make([]byte, 6)
+	_, _ = rand.Read(b)
+
+	return host + "-" + hex.EncodeToString(b)
+}
+
+func NewScheduler(locks LockRepository, poll, lease time.Duration) *Scheduler {
+	return &Scheduler{
+		locks: locks,
+		owner: instanceID(),
+		poll:  poll,
+		lease: lease,
+	}
+}
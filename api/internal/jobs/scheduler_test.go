@@ -0,0 +1,154 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// MockLockRepository keeps the schedule like the jobs collection does.
+type MockLockRepository struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+func (m *MockLockRepository) Acquire(ctx context.Context, name, owner string, lease time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+
+	j, ok := m.jobs[name]
+	if !ok {
+		j = &Job{Name: name, NextRunAt: now}
+		m.jobs[name] = j
+	}
+
+	if j.NextRunAt.After(now) || j.LockedUntil.After(now) {
+		return false, nil
+	}
+
+	j.Owner = owner
+	j.LockedUntil = now.Add(lease)
+
+	return true, nil
+}
+
+func (m *MockLockRepository) Finish(ctx context.Context, name, owner string, next time.Time, runErr error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j := m.jobs[name]
+	if j.Owner != owner {
+		return nil
+	}
+
+	j.LockedUntil = time.Now()
+	j.NextRunAt = next
+	j.LastRunAt = time.Now()
+	j.LastError = ""
+
+	if runErr != nil {
+		j.LastError = runErr.Error()
+	}
+
+	return nil
+}
+
+func TestScheduler_RunDue(t *testing.T) {
+	locks := &MockLockRepository{jobs: map[string]*Job{}}
+
+	runs := 0
+	run := func(ctx context.Context) error {
+		runs++
+
+		return nil
+	}
+
+	// two instances share the schedule
+	a := NewScheduler(locks, time.Minute, time.Minute)
+	a.Register("trials", time.Hour, run)
+	b := NewScheduler(locks, time.Minute, time.Minute)
+	b.Register("trials", time.Hour, run)
+
+	require.NotEqual(t, a.owner, b.owner)
+
+	a.RunDue(context.Background())
+	b.RunDue(context.Background())
+	assert.Equal(t, 1, runs)
+
+	// a restarted instance doesn't run it before it is due
+	c := NewScheduler(locks, time.Minute, time.Minute)
+	c.Register("trials", time.Hour, run)
+	c.RunDue(context.Background())
+	assert.Equal(t, 1, runs)
+
+	locks.jobs["trials"].NextRunAt = time.Now().Add(-time.Second)
+
+	b.RunDue(context.Background())
+	a.RunDue(context.Background())
+	assert.Equal(t, 2, runs)
+	assert.Equal(t, b.owner, locks.jobs["trials"].Owner)
+}
+
+func TestScheduler_RunDue_Locked(t *testing.T) {
+	locks := &MockLockRepository{jobs: map[string]*Job{}}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	runs := 0
+
+	a := NewScheduler(locks, time.Minute, time.Minute)
+	a.Register("trials", time.Hour, func(ctx context.Context) error {
+		runs++
+		close(started)
+		<-release
+
+		return nil
+	})
+
+	done := make(chan struct{})
+	go func() {
+		a.RunDue(context.Background())
+		close(done)
+	}()
+	<-started
+
+	// another instance doesn't start it while it runs
+	b := NewScheduler(locks, time.Minute, time.Minute)
+	b.Register("trials", time.Hour, func(ctx context.Context) error {
+		runs++
+
+		return nil
+	})
+	b.RunDue(context.Background())
+
+	close(release)
+	<-done
+
+	assert.Equal(t, 1, runs)
+}
+
+func TestScheduler_RunDue_LeaseRunOut(t *testing.T) {
+	// an instance died while running the job
+	locks := &MockLockRepository{jobs: map[string]*Job{
+		"trials": {Name: "trials", Owner: "crashed", LockedUntil: time.Now().Add(-time.Second), NextRunAt: time.Now().Add(-time.Hour)},
+	}}
+
+	s := NewScheduler(locks, time.Minute, time.Minute)
+	s.Register("trials", time.Hour, func(ctx context.Context) error {
+		return errors.New("smtp down")
+	})
+	s.RunDue(context.Background())
+
+	j := locks.jobs["trials"]
+	assert.Equal(t, s.owner, j.Owner)
+	assert.Equal(t, "smtp down", j.LastError)
+	assert.True(t, j.NextRunAt.After(time.Now().Add(59*time.Minute)))
+	assert.False(t, j.LockedUntil.After(time.Now()))
+}
@@ -0,0 +1,403 @@
+package login
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"interviews/internal/audit"
+	"interviews/internal/auth"
+	data "interviews/internal/users"
+	log "interviews/pkg/logger"
+	validator "interviews/pkg/vaildator"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	BulkSuspend            = "suspend"
+	BulkUnsuspend          = "unsuspend"
+	BulkForcePasswordReset = "force_password_reset"
+	BulkSetRole            = "set_role"
+
+	maxBulkUsers = 100
+)
+
+var ErrOwnAccount = errors.New("you can't do this to your own account")
+
+// ListUsersHandler lets admins search users by email or name and filter them
+// by role, status and sign-up date.
+func (l *Login) ListUsersHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	qs := r.URL.Query()
+
+	v := validator.New()
+
+	filter := data.UserFilter{
+		Search:        strings.TrimSpace(l.helper.ReadString(qs, "search", "")),
+		Role:          l.helper.ReadString(qs, "role", ""),
+		Activated:     readBool(qs, "activated", v),
+		Suspended:     readBool(qs, "suspended", v),
+		CreatedAfter:  readTime(qs, "created_after", v),
+		CreatedBefore: readTime(qs, "created_before", v),
+		Page:          l.helper.ReadInt(qs, "page", 1, v),
+		PageSize:      l.helper.ReadInt(qs, "page_size", 20, v),
+	}
+
+	data.ValidateUserFilter(v, filter)
+
+	if !v.Valid() {
+		l.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	users, metadata, err := l.userRepo.List(ctx, filter)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = l.helper.WriteJSON(w, http.StatusOK, envelope{"users": users, "metadata": metadata}, nil)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (l *Login) SuspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	var input struct {
+		Email  string `json:"email"`
+		Reason string `json:"reason"`
+	}
+
+	err := l.helper.ReadJSON(w, r, &input)
+	if err != nil {
+		l.e.BadRequestResponse(w, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	l.validateEmail(v, input.Email)
+	validateSuspendReason(v, input.Reason)
+
+	if !v.Valid() {
+		l.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	err = l.suspendUser(ctx, r, input.Email, input.Reason)
+	if err != nil {
+		l.adminActionErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = l.helper.WriteJSON(w, http.StatusOK, envelope{"message": "user suspended"}, nil)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (l *Login) UnsuspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := l.helper.ReadJSON(w, r, &input)
+	if err != nil {
+		l.e.BadRequestResponse(w, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	l.validateEmail(v, input.Email)
+
+	if !v.Valid() {
+		l.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	err = l.unsuspendUser(ctx, r, input.Email)
+	if err != nil {
+		l.adminActionErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = l.helper.WriteJSON(w, http.StatusOK, envelope{"message": "user reinstated"}, nil)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+// ForcePasswordResetHandler is for accounts that may be compromised. The
+// user is logged out everywhere and has to choose a new password through the
+// emailed link.
+func (l *Login) ForcePasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := l.helper.ReadJSON(w, r, &input)
+	if err != nil {
+		l.e.BadRequestResponse(w, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	l.validateEmail(v, input.Email)
+
+	if !v.Valid() {
+		l.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	err = l.forcePasswordReset(ctx, r, input.Email)
+	if err != nil {
+		l.adminActionErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = l.helper.WriteJSON(w, http.StatusOK, envelope{"message": "the user has to reset their password"}, nil)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+// BulkUserActionHandler applies one action to many users. A user that fails
+// doesn't stop the others; the response lists the outcome per user.
+func (l *Login) BulkUserActionHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+	clog := log.GetLoggerFromContext(ctx)
+
+	var input struct {
+		Action string   `json:"action"`
+		Emails []string `json:"emails"`
+		Reason string   `json:"reason"`
+		Role   string   `json:"role"`
+	}
+
+	err := l.helper.ReadJSON(w, r, &input)
+	if err != nil {
+		l.e.BadRequestResponse(w, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(validator.PermittedValue(input.Action, BulkSuspend, BulkUnsuspend, BulkForcePasswordReset, BulkSetRole),
+		"action", "must be one of suspend, unsuspend, force_password_reset or set_role")
+	v.Check(len(input.Emails) > 0, "emails", "must contain at least one email")
+	v.Check(len(input.Emails) <= maxBulkUsers, "emails", fmt.Sprintf("must not contain more than %d emails", maxBulkUsers))
+	v.Check(validator.Unique(input.Emails), "emails", "must not contain duplicate emails")
+
+	for _, email := range input.Emails {
+		v.Check(validator.Matches(email, validator.EmailRX), "emails", "must only contain valid email addresses")
+	}
+
+	switch input.Action {
+	case BulkSuspend:
+		validateSuspendReason(v, input.Reason)
+	case BulkSetRole:
+		v.Check(data.ValidRole(input.Role), "role", "must be one of user, instructor or admin")
+	}
+
+	if !v.Valid() {
+		l.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	type result struct {
+		Email string `json:"email"`
+		OK    bool   `json:"ok"`
+		Error string `json:"error,omitempty"`
+	}
+
+	results := make([]result, 0, len(input.Emails))
+	failed := 0
+
+	for _, email := range input.Emails {
+		switch input.Action {
+		case BulkSuspend:
+			err = l.suspendUser(ctx, r, email, input.Reason)
+		case BulkUnsuspend:
+			err = l.unsuspendUser(ctx, r, email)
+		case BulkForcePasswordReset:
+			err = l.forcePasswordReset(ctx, r, email)
+		case BulkSetRole:
+			_, err = l.changeRole(ctx, r, email, input.Role)
+		}
+
+		if err == nil {
+			results = append(results, result{Email: email, OK: true})
+
+			continue
+		}
+
+		failed++
+
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			results = append(results, result{Email: email, Error: "user not found"})
+		case errors.Is(err, ErrOwnAccount), errors.Is(err, ErrOwnRole):
+			results = append(results, result{Email: email, Error: err.Error()})
+		default:
+			clog.ErrorCtx(err, log.Ctx{
+				"msg":    "bulk user action failed",
+				"action": input.Action,
+			})
+			results = append(results, result{Email: email, Error: "the action failed, please try again"})
+		}
+	}
+
+	env := envelope{
+		"results":   results,
+		"succeeded": len(results) - failed,
+		"failed":    failed,
+	}
+
+	err = l.helper.WriteJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (l *Login) suspendUser(ctx context.Context, r *http.Request, email, reason string) error {
+	if strings.EqualFold(email, l.userCtx.ContextGetUser(r).Email) {
+		return ErrOwnAccount
+	}
+
+	err := l.userRepo.SetSuspended(ctx, email, true, reason)
+	if err != nil {
+		return err
+	}
+
+	l.recordAdminAction(ctx, r, audit.ActionUserSuspended, email, map[string]string{"reason": reason})
+
+	return nil
+}
+
+func (l *Login) unsuspendUser(ctx context.Context, r *http.Request, email string) error {
+	err := l.userRepo.SetSuspended(ctx, email, false, "")
+	if err != nil {
+		return err
+	}
+
+	l.recordAdminAction(ctx, r, audit.ActionUserUnsuspended, email, nil)
+
+	return nil
+}
+
+func (l *Login) forcePasswordReset(ctx context.Context, r *http.Request, email string) error {
+	if strings.EqualFold(email, l.userCtx.ContextGetUser(r).Email) {
+		return ErrOwnAccount
+	}
+
+	err := l.repo.ForcePasswordReset(ctx, email)
+	if err != nil {
+		return err
+	}
+
+	_, err = l.sessions.DeleteAllForUser(ctx, email)
+	if err != nil {
+		return err
+	}
+
+	err = l.sendPasswordResetToken(ctx, email)
+	if err != nil {
+		return err
+	}
+
+	l.recordAdminAction(ctx, r, audit.ActionUserPasswordResetForced, email, nil)
+
+	return nil
+}
+
+func (l *Login) recordAdminAction(ctx context.Context, r *http.Request, action, subject string, details map[string]string) {
+	device := auth.DeviceFromRequest(r)
+
+	l.recordAudit(ctx, audit.Event{
+		Action:    action,
+		Actor:     l.userCtx.ContextGetUser(r).Email,
+		Subject:   subject,
+		IP:        device.IP,
+		UserAgent: device.UserAgent,
+		Details:   details,
+	})
+}
+
+func (l *Login) adminActionErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		l.e.NotFoundResponse(w, r)
+	case errors.Is(err, ErrOwnAccount), errors.Is(err, ErrOwnRole):
+		l.e.BadRequestResponse(w, r, err)
+	default:
+		l.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+func validateSuspendReason(v *validator.Validator, reason string) {
+	v.Check(strings.TrimSpace(reason) != "", "reason", "must be provided")
+	v.Check(len(reason) <= 500, "reason", "must not be more than 500 bytes long")
+}
+
+func readBool(qs url.Values, key string, v *validator.Validator) *bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return nil
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be true or false")
+		return nil
+	}
+
+	return &b
+}
+
+// readTime accepts a date or an RFC 3339 timestamp.
+func readTime(qs url.Values, key string, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+
+	if s == "" {
+		return time.Time{}
+	}
+
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return t
+		}
+	}
+
+	v.AddError(key, "must be a date (2006-01-02) or an RFC 3339 timestamp")
+
+	return time.Time{}
+}
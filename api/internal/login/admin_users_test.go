@@ -0,0 +1,288 @@
+package login
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/mongo"
+	"interviews/internal/audit"
+	"interviews/internal/auth"
+	data "interviews/internal/users"
+)
+
+// MockUserDirectory holds several users for the admin handlers.
+type MockUserDirectory struct {
+	users  map[string]*data.User
+	filter data.UserFilter
+}
+
+func newMockUserDirectory(users ...*data.User) *MockUserDirectory {
+	m := &MockUserDirectory{users: map[string]*data.User{}}
+
+	for _, user := range users {
+		m.users[user.Email] = user
+	}
+
+	return m
+}
+
+func (m *MockUserDirectory) GetByEmail(email string) (*data.User, error) {
+	user, ok := m.users[email]
+	if !ok {
+		return &data.User{}, mongo.ErrNoDocuments
+	}
+
+	return user, nil
+}
+
+func (m *MockUserDirectory) UpdateRole(ctx context.Context, user *data.User) error {
+	m.users[user.Email].Role = user.Role
+
+	return nil
+}
+
+func (m *MockUserDirectory) List(ctx context.Context, filter data.UserFilter) ([]data.User, data.Metadata, error) {
+	m.filter = filter
+
+	users := []data.User{}
+	for _, user := range m.users {
+		users = append(users, *user)
+	}
+
+	return users, data.Metadata{TotalRecords: int64(len(users))}, nil
+}
+
+func (m *MockUserDirectory) SetSuspended(ctx context.Context, email string, suspended bool, reason string) error {
+	user, ok := m.users[email]
+	if !ok {
+		return mongo.ErrNoDocuments
+	}
+
+	user.Suspended = suspended
+	user.SuspendedReason = reason
+
+	return nil
+}
+
+func newAdminLogin(users *MockUserDirectory) (*Login, *MockAuditLog) {
+	auditLog := &MockAuditLog{}
+
+	l := &Login{
+		repo:     &MockLoginRepository{},
+		userRepo: users,
+		tokens:   &MockScopedTokenRepository{},
+		sessions: &MockSessionRepository{},
+		audit:    auditLog,
+		mailer:   &MockMailer{sent: make(chan sentMail, 10)},
+	}
+	l.cfg.TokenConfig.PasswordResetTTL = 45 * time.Minute
+
+	return l, auditLog
+}
+
+func adminRequest(l *Login, method, target string, body any) *http.Request {
+	requestBodyBytes, _ := json.Marshal(body)
+	req, _ := http.NewRequest(method, target, bytes.NewReader(requestBodyBytes))
+
+	return l.userCtx.ContextSetUser(req, &data.User{Email: "admin@test.com", Role: data.RoleAdmin})
+}
+
+func TestLogin_ListUsersHandler(t *testing.T) {
+	testCases := []struct {
+		Name       string
+		query      string
+		statusCode int
+		filter     data.UserFilter
+	}{
+		{
+			Name:       "Defaults",
+			query:      "",
+			statusCode: http.StatusOK,
+			filter:     data.UserFilter{Page: 1, PageSize: 20},
+		},
+		{
+			Name:       "All Filters",
+			query:      "?search=doe&role=instructor&activated=true&suspended=false&created_after=2024-01-01&page=2&page_size=50",
+			statusCode: http.StatusOK,
+			filter: data.UserFilter{
+				Search:       "doe",
+				Role:         data.RoleInstructor,
+				Activated:    boolPtr(true),
+				Suspended:    boolPtr(false),
+				CreatedAfter: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+				Page:         2,
+				PageSize:     50,
+			},
+		},
+		{Name: "Unknown Role", query: "?role=root", statusCode: http.StatusUnprocessableEntity},
+		{Name: "Page Too Big", query: "?page_size=1000", statusCode: http.StatusUnprocessableEntity},
+		{Name: "Invalid Bool", query: "?activated=maybe", statusCode: http.StatusUnprocessableEntity},
+		{Name: "Invalid Date", query: "?created_before=yesterday", statusCode: http.StatusUnprocessableEntity},
+		{Name: "Empty Date Range", query: "?created_after=2024-02-01&created_before=2024-01-01", statusCode: http.StatusUnprocessableEntity},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			users := newMockUserDirectory(&data.User{Email: "jane@test.com", PassHash: "secret-hash"})
+			l, _ := newAdminLogin(users)
+
+			recorder := httptest.NewRecorder()
+			l.ListUsersHandler(recorder, adminRequest(l, http.MethodGet, "/v1/admin/users"+tc.query, nil))
+
+			assert.Equal(t, tc.statusCode, recorder.Code)
+
+			if tc.statusCode == http.StatusOK {
+				assert.Equal(t, tc.filter, users.filter)
+				assert.NotContains(t, recorder.Body.String(), "secret-hash")
+			}
+		})
+	}
+}
+
+func TestLogin_SuspendUser(t *testing.T) {
+	jane := &data.User{Email: "jane@test.com", Activated: true}
+	users := newMockUserDirectory(jane)
+	l, auditLog := newAdminLogin(users)
+
+	suspend := func(body map[string]string) int {
+		recorder := httptest.NewRecorder()
+		l.SuspendUserHandler(recorder, adminRequest(l, http.MethodPost, "/v1/admin/users/suspend", body))
+
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusUnprocessableEntity, suspend(map[string]string{"email": "jane@test.com"}))
+	assert.Equal(t, http.StatusNotFound, suspend(map[string]string{"email": "john@test.com", "reason": "spam"}))
+	assert.Equal(t, http.StatusBadRequest, suspend(map[string]string{"email": "admin@test.com", "reason": "oops"}))
+	assert.False(t, jane.Suspended)
+
+	assert.Equal(t, http.StatusOK, suspend(map[string]string{"email": "jane@test.com", "reason": "chargeback"}))
+	assert.True(t, jane.Suspended)
+	assert.Equal(t, "chargeback", jane.SuspendedReason)
+
+	// a suspended user can't log in any other way either
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/v1/login", nil)
+	l.writeLoginResponse(context.Background(), recorder, req, jane, auth.AuthMethodPassword)
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	l.UnsuspendUserHandler(recorder, adminRequest(l, http.MethodPost, "/v1/admin/users/unsuspend",
+		map[string]string{"email": "jane@test.com"}))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.False(t, jane.Suspended)
+	assert.Equal(t, []string{audit.ActionUserSuspended, audit.ActionUserUnsuspended}, auditLog.actions())
+	assert.Equal(t, "admin@test.com", auditLog.events[0].Actor)
+	assert.Equal(t, "chargeback", auditLog.events[0].Details["reason"])
+}
+
+func TestLogin_ForcePasswordResetHandler(t *testing.T) {
+	jane := &data.User{Email: "jane@test.com", Activated: true, PassHash: "hash"}
+	l, auditLog := newAdminLogin(newMockUserDirectory(jane))
+	l.repo = &MockLoginRepository{user: jane}
+
+	sessions := &MockSessionRepository{sessions: []auth.Session{
+		{ID: "1", Email: "jane@test.com"},
+		{ID: "2", Email: "john@test.com"},
+	}}
+	l.sessions = sessions
+
+	recorder := httptest.NewRecorder()
+	l.ForcePasswordResetHandler(recorder, adminRequest(l, http.MethodPost, "/v1/admin/users/password-reset",
+		map[string]string{"email": "jane@test.com"}))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Empty(t, jane.PassHash)
+	require.Len(t, sessions.sessions, 1)
+	assert.Equal(t, "john@test.com", sessions.sessions[0].Email)
+
+	select {
+	case mail := <-l.mailer.(*MockMailer).sent:
+		assert.Equal(t, "jane@test.com", mail.recipient)
+		assert.Equal(t, "password_reset.tmpl", mail.template)
+	case <-time.After(time.Second):
+		t.Fatal("password reset email was not sent")
+	}
+
+	assert.Equal(t, []string{audit.ActionUserPasswordResetForced}, auditLog.actions())
+}
+
+func TestLogin_BulkUserActionHandler(t *testing.T) {
+	testCases := []struct {
+		Name       string
+		body       map[string]any
+		statusCode int
+		results    map[string]string
+	}{
+		{
+			Name:       "Suspend Some",
+			body:       map[string]any{"action": "suspend", "reason": "spam wave", "emails": []string{"jane@test.com", "missing@test.com", "admin@test.com"}},
+			statusCode: http.StatusOK,
+			results:    map[string]string{"jane@test.com": "", "missing@test.com": "user not found", "admin@test.com": ErrOwnAccount.Error()},
+		},
+		{
+			Name:       "Set Role",
+			body:       map[string]any{"action": "set_role", "role": "instructor", "emails": []string{"jane@test.com", "john@test.com"}},
+			statusCode: http.StatusOK,
+			results:    map[string]string{"jane@test.com": "", "john@test.com": ""},
+		},
+		{Name: "Unknown Action", body: map[string]any{"action": "delete", "emails": []string{"jane@test.com"}}, statusCode: http.StatusUnprocessableEntity},
+		{Name: "Missing Reason", body: map[string]any{"action": "suspend", "emails": []string{"jane@test.com"}}, statusCode: http.StatusUnprocessableEntity},
+		{Name: "Missing Role", body: map[string]any{"action": "set_role", "emails": []string{"jane@test.com"}}, statusCode: http.StatusUnprocessableEntity},
+		{Name: "No Emails", body: map[string]any{"action": "unsuspend", "emails": []string{}}, statusCode: http.StatusUnprocessableEntity},
+		{Name: "Duplicate Emails", body: map[string]any{"action": "unsuspend", "emails": []string{"jane@test.com", "jane@test.com"}}, statusCode: http.StatusUnprocessableEntity},
+		{Name: "Invalid Email", body: map[string]any{"action": "unsuspend", "emails": []string{"jane"}}, statusCode: http.StatusUnprocessableEntity},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			jane := &data.User{Email: "jane@test.com", Role: data.RoleUser}
+			john := &data.User{Email: "john@test.com", Role: data.RoleUser}
+			admin := &data.User{Email: "admin@test.com", Role: data.RoleAdmin}
+			l, auditLog := newAdminLogin(newMockUserDirectory(jane, john, admin))
+
+			recorder := httptest.NewRecorder()
+			l.BulkUserActionHandler(recorder, adminRequest(l, http.MethodPost, "/v1/admin/users/bulk", tc.body))
+
+			assert.Equal(t, tc.statusCode, recorder.Code)
+
+			if tc.statusCode != http.StatusOK {
+				assert.Empty(t, auditLog.events)
+
+				return
+			}
+
+			var response struct {
+				Results []struct {
+					Email string `json:"email"`
+					OK    bool   `json:"ok"`
+					Error string `json:"error"`
+				} `json:"results"`
+			}
+			require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+			require.Len(t, response.Results, len(tc.results))
+
+			for _, result := range response.Results {
+				assert.Equal(t, tc.results[result.Email], result.Error, result.Email)
+				assert.Equal(t, tc.results[result.Email] == "", result.OK, result.Email)
+			}
+
+			assert.False(t, admin.Suspended)
+			assert.Equal(t, data.RoleAdmin, admin.Role)
+		})
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
@@ -0,0 +1,110 @@
+package login
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// Attempt tracks the failed logins for a key, which is either an account or a
+// client IP. The counter starts over once no failure happened for a window.
+type Attempt struct {
+	Key         string    `bson:"_id"`
+	Failures    int       `bson:"failures"`
+	LastFailure time.Time `bson:"lastFailure"`
+	LockedUntil time.Time `bson:"lockedUntil"`
+	// when neither the window nor the lock count anymore
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+type AttemptsRepo struct {
+	db         *mongo.Client
+	collection *mongo.Collection
+}
+
+// DeleteForEmail removes the failure counters kept under the email.
+func (r *AttemptsRepo) DeleteForEmail(ctx context.Context, email string) error {
+	keys := bson.A{accountKey(email), magicLinkEmailKey(email)}
+
+	_, err := r.collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": keys}})
+
+	return err
+}
+
+func (r *AttemptsRepo) Get(ctx context.Context, key string) (*Attempt, error) {
+	var attempt Attempt
+
+	err := r.collection.FindOne(ctx, bson.M{"_id": key}).Decode(&attempt)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return &Attempt{Key: key}, nil
+		}
+
+		return nil, err
+	}
+
+	return &attempt, nil
+}
+
+// RecordFailure increments the counter of the key in a single update so that
+// concurrent attempts against several instances are all counted, and locks the
+// key once the threshold is reached.
+func (r *AttemptsRepo) RecordFailure(ctx context.Context, key string, threshold int,
+	lockout, window time.Duration) (*Attempt, error) {
+	now := time.Now()
+
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"failures": bson.M{"$cond": bson.A{
+				bson.M{"$lt": bson.A{"$lastFailure", now.Add(-window)}},
+				1,
+				bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$failures", 0}}, 1}},
+			}},
+			"lastFailure": now,
+		}}},
+		{{Key: "$set", Value: bson.M{
+			"lockedUntil": bson.M{"$cond": bson.A{
+				bson.M{"$gte": bson.A{"$failures", threshold}},
+				now.Add(lockout),
+				bson.M{"$ifNull": bson.A{"$lockedUntil", time.Time{}}},
+			}},
+		}}},
+		{{Key: "$set", Value: bson.M{
+			"expiresAt": bson.M{"$max": bson.A{"$lockedUntil", now.Add(window)}},
+		}}},
+	}
+
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var attempt Attempt
+
+	err := r.collection.FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(&attempt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &attempt, nil
+}
+
+func (r *AttemptsRepo) Reset(ctx context.Context, key string) error {
+	_, err := r.collection.DeleteOne(ctx, bson.M{"_id": key})
+
+	return err
+}
+
+// CreateIndexes lets Mongo delete the counters that expired.
+func (r *AttemptsRepo) CreateIndexes(ctx context.Context) error {
+	_, err := r.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+
+	return err
+}
+
+func NewAttemptsRepository(client *mongo.Client, collection *mongo.Collection) *AttemptsRepo {
+	return &AttemptsRepo{db: client, collection: collection}
+}
@@ -0,0 +1,111 @@
+package login
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"interviews/internal/audit"
+	"interviews/internal/auth"
+	data "interviews/internal/users"
+	validator "interviews/pkg/vaildator"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrImpersonateSelf  = errors.New("you can't impersonate yourself")
+	ErrImpersonateAdmin = errors.New("administrators can't be impersonated")
+)
+
+// ImpersonateHandler issues a short-lived token that lets support staff see
+// what the user sees. The token names the admin in its act claim, can't reach
+// admin endpoints or change the account, and every use of it is audited.
+func (l *Login) ImpersonateHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	var input struct {
+		Email  string `json:"email"`
+		Reason string `json:"reason"`
+	}
+
+	err := l.helper.ReadJSON(w, r, &input)
+	if err != nil {
+		l.e.BadRequestResponse(w, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	l.validateEmail(v, input.Email)
+	v.Check(strings.TrimSpace(input.Reason) != "", "reason", "must be provided")
+	v.Check(len(input.Reason) <= 500, "reason", "must not be more than 500 bytes long")
+
+	if !v.Valid() {
+		l.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	actor := l.userCtx.ContextGetUser(r)
+
+	// an API key isn't a person who could be held accountable
+	if actor.IsService() {
+		l.e.NotPermittedResponse(w, r)
+
+		return
+	}
+
+	if strings.EqualFold(actor.Email, input.Email) {
+		l.e.BadRequestResponse(w, r, ErrImpersonateSelf)
+
+		return
+	}
+
+	user, err := l.userRepo.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			l.e.NotFoundResponse(w, r)
+		default:
+			l.e.ServerErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	if user.HasPermission(data.PermissionUsersAdmin) {
+		l.e.BadRequestResponse(w, r, ErrImpersonateAdmin)
+
+		return
+	}
+
+	device := auth.DeviceFromRequest(r)
+
+	token, ttl, err := l.tokenRepo.GenerateImpersonationJWT(ctx, user, actor.Email, device)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	l.recordAudit(ctx, audit.Event{
+		Action:    audit.ActionImpersonationStarted,
+		Actor:     actor.Email,
+		Subject:   user.Email,
+		IP:        device.IP,
+		UserAgent: device.UserAgent,
+		Details:   map[string]string{"reason": input.Reason},
+	})
+
+	env := envelope{
+		"token":         token,
+		"impersonating": user.Email,
+		"expires_in":    ttl.String(),
+	}
+
+	err = l.helper.WriteJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+	}
+}
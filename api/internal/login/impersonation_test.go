@@ -0,0 +1,112 @@
+package login
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"interviews/internal/audit"
+	data "interviews/internal/users"
+)
+
+func TestLogin_ImpersonateHandler(t *testing.T) {
+	admin := &data.User{Email: "admin@test.com", Role: data.RoleAdmin, Activated: true}
+
+	testCases := []struct {
+		Name       string
+		actor      *data.User
+		target     *data.User
+		body       map[string]string
+		statusCode int
+	}{
+		{
+			Name:       "Impersonate Learner",
+			actor:      admin,
+			target:     &data.User{Email: "learner@test.com", Role: data.RoleUser},
+			body:       map[string]string{"email": "learner@test.com", "reason": "ticket 1234, course page is empty"},
+			statusCode: http.StatusCreated,
+		},
+		{
+			Name:       "Missing Reason",
+			actor:      admin,
+			target:     &data.User{Email: "learner@test.com", Role: data.RoleUser},
+			body:       map[string]string{"email": "learner@test.com"},
+			statusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:       "Yourself",
+			actor:      admin,
+			target:     admin,
+			body:       map[string]string{"email": "admin@test.com", "reason": "testing"},
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			Name:       "Another Admin",
+			actor:      admin,
+			target:     &data.User{Email: "other@test.com", Role: data.RoleAdmin},
+			body:       map[string]string{"email": "other@test.com", "reason": "testing"},
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			Name:       "Unknown User",
+			actor:      admin,
+			body:       map[string]string{"email": "missing@test.com", "reason": "testing"},
+			statusCode: http.StatusNotFound,
+		},
+		{
+			Name: "Service",
+			actor: &data.User{Activated: true, Service: &data.ServicePrincipal{
+				Permissions: []string{data.PermissionUsersImpersonate},
+			}},
+			target:     &data.User{Email: "learner@test.com", Role: data.RoleUser},
+			body:       map[string]string{"email": "learner@test.com", "reason": "testing"},
+			statusCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			auditLog := &MockAuditLog{}
+			tokenRepo := &MockTokenRepository{token: "impersonation-token"}
+
+			l := &Login{
+				userRepo:  &MockUserRepository{user: tc.target},
+				tokenRepo: tokenRepo,
+				audit:     auditLog,
+			}
+
+			body, _ := json.Marshal(tc.body)
+			req, _ := http.NewRequest(http.MethodPost, "/v1/admin/users/impersonate", bytes.NewReader(body))
+			req = l.userCtx.ContextSetUser(req, tc.actor)
+
+			recorder := httptest.NewRecorder()
+			l.ImpersonateHandler(recorder, req)
+
+			assert.Equal(t, tc.statusCode, recorder.Code)
+
+			if tc.statusCode != http.StatusCreated {
+				assert.Empty(t, auditLog.events)
+
+				return
+			}
+
+			var response map[string]string
+			assert.NoError(t, json.NewDecoder(recorder.Body).Decode(&response))
+			assert.Equal(t, "impersonation-token", response["token"])
+			assert.Equal(t, "learner@test.com", response["impersonating"])
+			assert.Equal(t, "admin@test.com", tokenRepo.actor)
+
+			if assert.Len(t, auditLog.events, 1) {
+				event := auditLog.events[0]
+				assert.Equal(t, audit.ActionImpersonationStarted, event.Action)
+				assert.Equal(t, "admin@test.com", event.Actor)
+				assert.Equal(t, "learner@test.com", event.Subject)
+				assert.Equal(t, tc.body["reason"], event.Details["reason"])
+			}
+		})
+	}
+}
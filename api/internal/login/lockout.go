@@ -0,0 +1,134 @@
+package login
+
+import (
+	"context"
+	log "interviews/pkg/logger"
+	"strings"
+	"time"
+)
+
+func accountKey(email string) string {
+	return "email:" + strings.ToLower(email)
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// backoff returns the delay required after the given number of consecutive
+// failures. The first failure is free, after that the delay doubles up to max.
+func backoff(failures int, base, max time.Duration) time.Duration {
+	if failures <= 1 {
+		return 0
+	}
+
+	delay := base
+	for i := 2; i < failures; i++ {
+		delay *= 2
+		if delay >= max {
+			return max
+		}
+	}
+
+	return delay
+}
+
+// ipFreeFailures is how many failures an IP has before it is slowed down.
+// Many users can share an IP, e.g. behind a NAT, so its backoff only starts
+// half way to the lockout.
+func (l *Login) ipFreeFailures() int {
+	if free := l.cfg.Lockout.IPThreshold / 2; free > 1 {
+		return free
+	}
+
+	return 1
+}
+
+// retryAfter returns how long the key has to wait before it may try again.
+// The first free failures don't delay the next attempt.
+func (l *Login) retryAfter(a *Attempt, free int, now time.Time) time.Duration {
+	if now.Before(a.LockedUntil) {
+		return a.LockedUntil.Sub(now)
+	}
+
+	delay := backoff(a.Failures-free+1, l.cfg.Lockout.BaseDelay, l.cfg.Lockout.MaxDelay)
+
+	if wait := a.LastFailure.Add(delay).Sub(now); wait > 0 {
+		return wait
+	}
+
+	return 0
+}
+
+// throttled returns the longest wait of the account and the client IP.
+func (l *Login) throttled(ctx context.Context, email, ip string) (time.Duration, error) {
+	var wait time.Duration
+
+	now := time.Now()
+
+	keys := []struct {
+		key  string
+		free int
+	}{
+		{key: accountKey(email), free: 1},
+		{key: ipKey(ip), free: l.ipFreeFailures()},
+	}
+
+	for _, k := range keys {
+		attempt, err := l.attempts.Get(ctx, k.key)
+		if err != nil {
+			return 0, err
+		}
+
+		if d := l.retryAfter(attempt, k.free, now); d > wait {
+			wait = d
+		}
+	}
+
+	return wait, nil
+}
+
+// recordFailure counts a failed login for the account and the client IP and
+// notifies the owner when the account just got locked.
+func (l *Login) recordFailure(ctx context.Context, email, ip string, accountExists bool) {
+	clog := log.GetLoggerFromContext(ctx)
+
+	lockout := l.cfg.Lockout
+
+	attempt, err := l.attempts.RecordFailure(ctx, accountKey(email), lockout.Threshold, lockout.Duration, lockout.Window)
+	if err != nil {
+		clog.ErrorCtx(err, log.Ctx{"msg": "unable to record failed login for account"})
+	}
+
+	_, err = l.attempts.RecordFailure(ctx, ipKey(ip), lockout.IPThreshold, lockout.Duration, lockout.Window)
+	if err != nil {
+		clog.ErrorCtx(err, log.Ctx{"msg": "unable to record failed login for ip"})
+	}
+
+	if attempt == nil || attempt.Failures != lockout.Threshold {
+		return
+	}
+
+	clog.WarnCtx("account locked after failed logins", log.Ctx{
+		"email": email,
+		"ip":    ip,
+	})
+
+	if !accountExists {
+		return
+	}
+
+	l.helper.Background(func() {
+		data := map[string]any{
+			"lockedFor":          lockout.Duration.String(),
+			"ip":                 ip,
+			"passwordResetURL":   l.cfg.FrontendURL + "/forgot-password",
+			"failedLoginAttempt": attempt.Failures,
+		}
+
+		err := l.mailer.Send(email, "account_locked.tmpl", data)
+		if err != nil {
+			clog.ErrorCtx(err, log.Ctx{"msg": "unable to send account locked email"})
+		}
+	})
+}
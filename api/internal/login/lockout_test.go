@@ -0,0 +1,195 @@
+package login
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+	data "interviews/internal/users"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type MockAttemptsRepository struct {
+	attempts map[string]*Attempt
+}
+
+func newMockAttemptsRepository() *MockAttemptsRepository {
+	return &MockAttemptsRepository{attempts: make(map[string]*Attempt)}
+}
+
+func (m *MockAttemptsRepository) Get(ctx context.Context, key string) (*Attempt, error) {
+	if a, ok := m.attempts[key]; ok {
+		return a, nil
+	}
+
+	return &Attempt{Key: key}, nil
+}
+
+func (m *MockAttemptsRepository) RecordFailure(ctx context.Context, key string, threshold int,
+	lockout, window time.Duration) (*Attempt, error) {
+	a, ok := m.attempts[key]
+	if !ok {
+		a = &Attempt{Key: key}
+		m.attempts[key] = a
+	}
+
+	a.Failures++
+	a.LastFailure = time.Now()
+
+	if a.Failures >= threshold {
+		a.LockedUntil = time.Now().Add(lockout)
+	}
+
+	return a, nil
+}
+
+func (m *MockAttemptsRepository) Reset(ctx context.Context, key string) error {
+	delete(m.attempts, key)
+
+	return nil
+}
+
+func TestBackoff(t *testing.T) {
+	testCases := []struct {
+		failures int
+		delay    time.Duration
+	}{
+		{failures: 0, delay: 0},
+		{failures: 1, delay: 0},
+		{failures: 2, delay: time.Second},
+		{failures: 3, delay: 2 * time.Second},
+		{failures: 4, delay: 4 * time.Second},
+		{failures: 10, delay: 30 * time.Second},
+		{failures: 1000, delay: 30 * time.Second},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.delay, backoff(tc.failures, time.Second, 30*time.Second), "failures: %d", tc.failures)
+	}
+}
+
+func newLockoutLogin(repo *MockLoginRepository, attempts *MockAttemptsRepository, mailer *MockMailer) *Login {
+	l := &Login{
+		repo:      repo,
+		tokenRepo: &MockTokenRepository{token: "testtoken"},
+		attempts:  attempts,
+		mailer:    mailer,
+	}
+	l.cfg.Lockout.Threshold = 3
+	l.cfg.Lockout.IPThreshold = 100
+	l.cfg.Lockout.Duration = 15 * time.Minute
+	l.cfg.Lockout.Window = time.Hour
+	l.cfg.Lockout.BaseDelay = time.Second
+	l.cfg.Lockout.MaxDelay = time.Minute
+
+	return l
+}
+
+func login(l *Login, email, password string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	body, _ := json.Marshal(map[string]string{"email": email, "password": password})
+	req, _ := http.NewRequest(http.MethodPost, "/v1/login", bytes.NewReader(body))
+	req.RemoteAddr = "203.0.113.7:4711"
+	l.LoginHandler(recorder, req)
+
+	return recorder
+}
+
+func TestLogin_LoginHandlerLockout(t *testing.T) {
+	attempts := newMockAttemptsRepository()
+	mailer := &MockMailer{sent: make(chan sentMail, 1)}
+	repo := &MockLoginRepository{user: &data.User{}, error: ErrInvalidPassword}
+	l := newLockoutLogin(repo, attempts, mailer)
+
+	res := login(l, "test@test.com", "wrong-password")
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+	assert.Equal(t, 1, attempts.attempts[accountKey("test@test.com")].Failures)
+	assert.Equal(t, 1, attempts.attempts[ipKey("203.0.113.7")].Failures)
+
+	// the second attempt right away is rejected by the backoff without
+	// checking the password
+	attempts.attempts[accountKey("test@test.com")].Failures = 2
+	res = login(l, "test@test.com", "wrong-password")
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+	assert.Equal(t, "1", res.Header().Get("Retry-After"))
+
+	// once the delay passed the attempt counts and locks the account
+	attempts.attempts[accountKey("test@test.com")].LastFailure = time.Now().Add(-time.Minute)
+	res = login(l, "test@test.com", "wrong-password")
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+
+	select {
+	case mail := <-mailer.sent:
+		assert.Equal(t, "test@test.com", mail.recipient)
+		assert.Equal(t, "account_locked.tmpl", mail.template)
+	case <-time.After(time.Second):
+		t.Fatal("account locked email was not sent")
+	}
+
+	// even the right password is refused while locked
+	repo.error = nil
+	attempts.attempts[accountKey("test@test.com")].LastFailure = time.Now().Add(-time.Minute)
+	res = login(l, "test@test.com", "right-password")
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+}
+
+func TestLogin_LoginHandlerSharedIP(t *testing.T) {
+	attempts := newMockAttemptsRepository()
+	repo := &MockLoginRepository{user: &data.User{}, error: ErrInvalidPassword}
+	l := newLockoutLogin(repo, attempts, &MockMailer{sent: make(chan sentMail, 1)})
+
+	// users behind the same IP aren't slowed down by each other's typos
+	attempts.attempts[ipKey("203.0.113.7")] = &Attempt{Failures: 40, LastFailure: time.Now()}
+
+	res := login(l, "test@test.com", "wrong-password")
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+
+	// half way to the lockout the IP backs off
+	attempts.attempts[ipKey("203.0.113.7")] = &Attempt{Failures: 51, LastFailure: time.Now()}
+
+	res = login(l, "other@test.com", "wrong-password")
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+	assert.Equal(t, "1", res.Header().Get("Retry-After"))
+}
+
+func TestLogin_LoginHandlerUnknownAccount(t *testing.T) {
+	attempts := newMockAttemptsRepository()
+	mailer := &MockMailer{sent: make(chan sentMail, 1)}
+	repo := &MockLoginRepository{user: &data.User{}, error: mongo.ErrNoDocuments}
+	l := newLockoutLogin(repo, attempts, mailer)
+	l.cfg.Lockout.Threshold = 1
+
+	res := login(l, "nobody@test.com", "password")
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+	assert.Equal(t, 1, attempts.attempts[accountKey("nobody@test.com")].Failures)
+	assert.Empty(t, mailer.sent, "no email is sent for accounts that don't exist")
+}
+
+func TestLogin_LoginHandlerResetsOnSuccess(t *testing.T) {
+	attempts := newMockAttemptsRepository()
+	attempts.attempts[accountKey("test@test.com")] = &Attempt{Failures: 1, LastFailure: time.Now().Add(-time.Hour)}
+	repo := &MockLoginRepository{user: &data.User{Email: "test@test.com"}}
+	l := newLockoutLogin(repo, attempts, &MockMailer{})
+
+	res := login(l, "test@test.com", "right-password")
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.NotContains(t, attempts.attempts, accountKey("test@test.com"))
+}
+
+func TestLogin_UnlockAccountHandler(t *testing.T) {
+	attempts := newMockAttemptsRepository()
+	attempts.attempts[accountKey("test@test.com")] = &Attempt{Failures: 5, LockedUntil: time.Now().Add(time.Hour)}
+	l := &Login{attempts: attempts}
+
+	recorder := httptest.NewRecorder()
+	body, _ := json.Marshal(map[string]string{"email": "test@test.com"})
+	req, _ := http.NewRequest(http.MethodPost, "/v1/admin/users/unlock", bytes.NewReader(body))
+	l.UnlockAccountHandler(recorder, req)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.NotContains(t, attempts.attempts, accountKey("test@test.com"))
+}
@@ -5,20 +5,23 @@ import (
 	"errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
-	"golang.org/x/crypto/bcrypt"
-	"interviews/internal/auth"
+	"interviews/internal/password"
 	data "interviews/internal/users"
+	log "interviews/pkg/logger"
 	"time"
 )
 
 var (
 	ErrUserExists      = errors.New("user already exists")
 	ErrInvalidPassword = errors.New("invalid password")
+	ErrCodeUsed        = errors.New("code was already used")
+	ErrIdentityLinked  = errors.New("an account of this provider is already linked")
 )
 
 type Repo struct {
 	db         *mongo.Client
 	collection *mongo.Collection
+	hasher     password.Hasher
 }
 
 func (r *Repo) Login(email string, password string) (data.User, error) {
@@ -30,17 +33,45 @@ func (r *Repo) Login(email string, password string) (data.User, error) {
 		return data.User{}, err
 	}
 
-	ok := validatePassword(password, user.PassHash)
-	if !ok {
-		//TODO: might need to add a counter here is the login to many times...Then lock.
-
+	// accounts created with a provider have no password
+	match, rehash, err := r.hasher.Verify(password, user.PassHash)
+	if err != nil || !match {
 		return data.User{}, ErrInvalidPassword
 	}
 
+	// upgrade old bcrypt or weaker hashes now that we have the password
+	if rehash {
+		err = r.rehash(ctx, email, user.PassHash, password)
+		if err != nil {
+			log.ErrorCtx(err, log.Ctx{
+				"msg": "unable to upgrade password hash",
+			})
+		}
+	}
+
 	return data.User{
-		ID:    user.ID,
-		Email: email,
-	}, err
+		ID:          user.ID,
+		Name:        user.Name,
+		Email:       email,
+		Role:        user.Role,
+		Activated:   user.Activated,
+		Suspended:   user.Suspended,
+		TOTPEnabled: user.TOTPEnabled,
+	}, nil
+}
+
+// rehash replaces the hash unless the password was changed in the meantime.
+func (r *Repo) rehash(ctx context.Context, email, oldHash, password string) error {
+	hash, err := r.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.collection.UpdateOne(ctx,
+		bson.M{"email": email, "passHash": oldHash},
+		bson.M{"$set": bson.M{"passHash": hash}})
+
+	return err
 }
 
 func (r *Repo) Register(email string, password string) (*data.User, error) {
@@ -48,7 +79,7 @@ func (r *Repo) Register(email string, password string) (*data.User, error) {
 
 	var user data.User
 	err := r.collection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
-	if err != nil {
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
 		return nil, err
 	}
 
@@ -56,7 +87,7 @@ func (r *Repo) Register(email string, password string) (*data.User, error) {
 		return nil, ErrUserExists
 	}
 
-	hash, err := hashPassword(password)
+	hash, err := r.hasher.Hash(password)
 	if err != nil {
 		return nil, err
 	}
@@ -66,7 +97,7 @@ func (r *Repo) Register(email string, password string) (*data.User, error) {
 		Email:     email,
 		PassHash:  hash,
 		CreatedAt: time.Now(),
-		Role:      "",
+		Role:      data.RoleUser,
 		Activated: false,
 	}
 
@@ -78,27 +109,27 @@ func (r *Repo) Register(email string, password string) (*data.User, error) {
 	return &newUser, nil
 }
 
-func (r *Repo) RegisterGoogle(claims auth.GoogleClaims, password string) (*data.User, error) {
-	ctx := context.Background()
-
+// RegisterExternal creates a user that signed up through an identity provider.
+// It has no password, so it can only log in through a provider until the user
+// resets it.
+func (r *Repo) RegisterExternal(ctx context.Context, name, email string, activated bool, identity data.Identity) (*data.User, error) {
 	var user data.User
-	err := r.collection.FindOne(ctx, bson.M{"email": claims.Email}).Decode(&user)
+	err := r.collection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
 	if err == nil {
 		return nil, ErrUserExists
 	}
 
-	hash, err := hashPassword(password)
-	if err != nil {
+	if !errors.Is(err, mongo.ErrNoDocuments) {
 		return nil, err
 	}
 
 	newUser := data.User{
-		Name:      claims.Name,
-		Email:     claims.Email,
-		PassHash:  hash,
-		CreatedAt: time.Now(),
-		Role:      "",
-		Activated: false,
+		Name:       name,
+		Email:      email,
+		CreatedAt:  time.Now(),
+		Role:       data.RoleUser,
+		Activated:  activated,
+		Identities: []data.Identity{identity},
 	}
 
 	_, err = r.collection.InsertOne(ctx, newUser)
@@ -106,24 +137,203 @@ func (r *Repo) RegisterGoogle(claims auth.GoogleClaims, password string) (*data.
 		return nil, err
 	}
 
+	return &newUser, nil
+}
+
+func (r *Repo) GetByIdentity(ctx context.Context, provider, subject string) (*data.User, error) {
+	filter := bson.M{
+		"identities": bson.M{"$elemMatch": bson.M{"provider": provider, "subject": subject}},
+	}
+
+	var user data.User
+
+	err := r.collection.FindOne(ctx, filter).Decode(&user)
+	if err != nil {
+		return nil, err
+	}
+
 	return &user, nil
 }
 
-func hashPassword(password string) (string, error) {
-	// Generate a salt for the bcrypt hash
-	salt, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+// LinkIdentity adds the identity to the user unless the user already has an
+// identity of that provider.
+func (r *Repo) LinkIdentity(ctx context.Context, email string, identity data.Identity) error {
+	filter := bson.M{
+		"email":               email,
+		"identities.provider": bson.M{"$ne": identity.Provider},
+	}
+
+	res, err := r.collection.UpdateOne(ctx, filter, bson.M{"$push": bson.M{"identities": identity}})
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrIdentityLinked
+	}
+
+	return nil
+}
+
+// UpdatePassword stores the hash of the new password and revokes every access
+// token issued before now.
+func (r *Repo) UpdatePassword(ctx context.Context, email, password string) error {
+	hash, err := r.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"passHash":          hash,
+			"sessionsRevokedAt": time.Now(),
+		},
+	}
+
+	res, err := r.collection.UpdateOne(ctx, bson.M{"email": email}, update)
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// ForcePasswordReset removes the password and revokes every access token, so
+// the user has to set a new password before they can log in with one again.
+func (r *Repo) ForcePasswordReset(ctx context.Context, email string) error {
+	update := bson.M{
+		"$set": bson.M{
+			"passHash":          "",
+			"sessionsRevokedAt": time.Now(),
+		},
+	}
+
+	res, err := r.collection.UpdateOne(ctx, bson.M{"email": email}, update)
 	if err != nil {
-		return "", err
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+func (r *Repo) Activate(ctx context.Context, email string) error {
+	update := bson.M{
+		"$set": bson.M{
+			"activated": true,
+		},
+	}
+
+	res, err := r.collection.UpdateOne(ctx, bson.M{"email": email}, update)
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
 	}
 
-	return string(salt), nil
+	return nil
 }
 
-func validatePassword(password, hashedPassword string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
-	return err == nil
+// SetPendingTOTP stores a secret that only becomes active once the user
+// confirmed it with a code.
+func (r *Repo) SetPendingTOTP(ctx context.Context, email, secret string) error {
+	update := bson.M{
+		"$set": bson.M{
+			"totpPendingSecret": secret,
+		},
+	}
+
+	res, err := r.collection.UpdateOne(ctx, bson.M{"email": email}, update)
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// EnableTOTP activates the pending secret and replaces the recovery codes.
+func (r *Repo) EnableTOTP(ctx context.Context, email, secret string, step int64, recoveryCodes []string) error {
+	filter := bson.M{
+		"email":             email,
+		"totpPendingSecret": secret,
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"totpEnabled":   true,
+			"totpSecret":    secret,
+			"totpLastStep":  step,
+			"recoveryCodes": recoveryCodes,
+		},
+		"$unset": bson.M{
+			"totpPendingSecret": "",
+		},
+	}
+
+	res, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// UseTOTPStep records the time step of an accepted code. The filter makes it
+// fail with ErrCodeUsed when the step or a later one was already used.
+func (r *Repo) UseTOTPStep(ctx context.Context, email string, step int64) error {
+	filter := bson.M{
+		"email":        email,
+		"totpLastStep": bson.M{"$lt": step},
+	}
+
+	res, err := r.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"totpLastStep": step}})
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrCodeUsed
+	}
+
+	return nil
+}
+
+// UseRecoveryCode removes the hashed recovery code so it can only be used once.
+func (r *Repo) UseRecoveryCode(ctx context.Context, email, hash string) error {
+	filter := bson.M{
+		"email":         email,
+		"recoveryCodes": hash,
+	}
+
+	res, err := r.collection.UpdateOne(ctx, filter, bson.M{"$pull": bson.M{"recoveryCodes": hash}})
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrCodeUsed
+	}
+
+	return nil
 }
 
-func NewLoginRepository(client *mongo.Client, collection *mongo.Collection) *Repo {
-	return &Repo{db: client, collection: collection}
+func NewLoginRepository(client *mongo.Client, collection *mongo.Collection, hasher password.Hasher) *Repo {
+	return &Repo{db: client, collection: collection, hasher: hasher}
 }
@@ -1,16 +1,24 @@
 package login
 
 import (
-	"github.com/dgrijalva/jwt-go"
+	"context"
 	"github.com/stretchr/testify/assert"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
-	"interviews/internal/auth"
+	"golang.org/x/crypto/bcrypt"
+	"interviews/internal/password"
 	data "interviews/internal/users"
+	"strings"
 	"testing"
 )
 
+// cheap parameters so tests don't spend their time hashing
+var testHasher = password.NewChain(
+	password.Argon2id{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+	password.Bcrypt{Cost: bcrypt.MinCost},
+)
+
 type MockRepository struct {
 	client     *mongo.Client
 	collection *mongo.Collection
@@ -21,75 +29,34 @@ func (m *MockRepository) Login(email string, password string) (data.User, error)
 	return data.User{}, nil
 }
 
-func TestLoginRepo_RegisterGoogle(t *testing.T) {
+func TestLoginRepo_RegisterExternal(t *testing.T) {
 	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
 	defer mt.Close()
 
+	identity := data.Identity{
+		Provider: "google",
+		Subject:  "1234567890",
+		Email:    "test@test.com",
+	}
+
 	testCases := []struct {
 		name           string
 		email          string
-		claims         auth.GoogleClaims
-		password       string
-		mockRepo       *MockRepository
 		mockedResponse bson.D
 		err            error
 	}{
 		{
 			name:  "user already registered with email",
 			email: "test@test.com",
-			mockRepo: &MockRepository{
-				user: data.User{
-					ID: int64(34),
-				},
-			},
-			password: "test_test123",
 			mockedResponse: bson.D{
 				{"email", "test@test.com"},
 			},
-			claims: auth.GoogleClaims{
-				Email:         "test@test.com",
-				Name:          "test user",
-				EmailVerified: false,
-				FirstName:     "Test",
-				LastName:      "Test1",
-				StandardClaims: jwt.StandardClaims{
-					Audience:  "",
-					ExpiresAt: 0,
-					Id:        "",
-					IssuedAt:  0,
-					Issuer:    "",
-					NotBefore: 0,
-					Subject:   "",
-				},
-			},
 			err: ErrUserExists,
 		},
 		{
-			name:  "New User Registers with Google Successfully",
-			email: "test@test.com",
-			mockRepo: &MockRepository{
-				user: data.User{
-					ID: int64(34),
-				},
-			},
-			password:       "test_test123",
+			name:           "New User Registers with a provider Successfully",
+			email:          "test@test.com",
 			mockedResponse: nil, // user not found
-			claims: auth.GoogleClaims{
-				Email:         "test@test.com",
-				Name:          "test user",
-				EmailVerified: false,
-				FirstName:     "Test",
-				LastName:      "Test1",
-				StandardClaims: jwt.StandardClaims{
-					Audience:  "",
-					ExpiresAt: 0,
-					Id:        "",
-					IssuedAt:  0,
-					Issuer:    "",
-					NotBefore: 0,
-					Subject:   "",
-				},
-			},
 		},
 	}
 
@@ -97,18 +64,57 @@ func TestLoginRepo_RegisterGoogle(t *testing.T) {
 		tc := tc
 		mt.Run(tc.name, func(mt *mtest.T) {
 			mt.Run(tc.name, func(mt *mtest.T) {
+				var docs []bson.D
+				if tc.mockedResponse != nil {
+					docs = append(docs, tc.mockedResponse)
+				}
+
 				// findOne mock
-				mt.AddMockResponses(mtest.CreateCursorResponse(0, "mock.users", mtest.FirstBatch, tc.mockedResponse))
+				mt.AddMockResponses(mtest.CreateCursorResponse(0, "mock.users", mtest.FirstBatch, docs...))
 				mt.AddMockResponses(mtest.CreateSuccessResponse())
-				loginRepo := NewLoginRepository(mt.Client, mt.Coll)
-				_, err := loginRepo.RegisterGoogle(tc.claims, tc.password)
-				assert.ErrorIs(t, err, tc.err)
+				loginRepo := NewLoginRepository(mt.Client, mt.Coll, testHasher)
+				user, err := loginRepo.RegisterExternal(context.Background(), "test user", tc.email, true, identity)
+				assert.ErrorIs(mt, err, tc.err)
+
+				if tc.err == nil {
+					assert.Equal(mt, []data.Identity{identity}, user.Identities)
+					assert.Empty(mt, user.PassHash)
+				}
 			})
 		})
 
 	}
 }
 
+func TestLoginRepo_LinkIdentity(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	testCases := []struct {
+		name    string
+		matched int
+		err     error
+	}{
+		{name: "identity linked", matched: 1},
+		{name: "provider already linked", matched: 0, err: ErrIdentityLinked},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		mt.Run(tc.name, func(mt *mtest.T) {
+			mt.AddMockResponses(bson.D{
+				{Key: "ok", Value: 1},
+				{Key: "n", Value: tc.matched},
+				{Key: "nModified", Value: tc.matched},
+			})
+
+			loginRepo := NewLoginRepository(mt.Client, mt.Coll, testHasher)
+			err := loginRepo.LinkIdentity(context.Background(), "test@test.com", data.Identity{Provider: "google"})
+			assert.ErrorIs(mt, err, tc.err)
+		})
+	}
+}
+
 func TestLoginRepo_Login(t *testing.T) {
 	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
 	defer mt.Close()
@@ -146,7 +152,7 @@ func TestLoginRepo_Login(t *testing.T) {
 				{"passHash", tc.PassHash},
 			}))
 
-			loginRepo := NewLoginRepository(mt.Client, mt.Coll)
+			loginRepo := NewLoginRepository(mt.Client, mt.Coll, testHasher)
 			_, err := loginRepo.Login(tc.Email, tc.PassPlain)
 			assert.ErrorIs(mt, err, tc.err)
 		})
@@ -194,10 +200,65 @@ func TestLoginRepo_Register(t *testing.T) {
 				killCursors := mtest.CreateCursorResponse(0, "mock.users", mtest.NextBatch)
 				mt.AddMockResponses(first, second, killCursors)
 
-				loginRepo := NewLoginRepository(mt.Client, mt.Coll)
+				loginRepo := NewLoginRepository(mt.Client, mt.Coll, testHasher)
 				_, err := loginRepo.Register(tc.email, tc.password)
 				assert.ErrorIs(t, err, tc.err)
 			})
 		})
 	}
 }
+
+func TestLoginRepo_LoginUpgradesHash(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	current, err := testHasher.Hash("test_test123")
+	assert.NoError(t, err)
+
+	weaker, err := password.Argon2id{Memory: 32, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}.Hash("test_test123")
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		name     string
+		passHash string
+		upgraded bool
+		err      error
+	}{
+		{name: "bcrypt hash", passHash: "$2a$10$3LAcNwf/7rXdU1K2gRGKU.nOAeqM6B.CSKE7Nejd3VKCgoYZR/tYa", upgraded: true},
+		{name: "argon2id with other parameters", passHash: weaker, upgraded: true},
+		{name: "current argon2id hash", passHash: current},
+		{name: "account without a password", passHash: "", err: ErrInvalidPassword},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		mt.Run(tc.name, func(mt *mtest.T) {
+			mt.AddMockResponses(mtest.CreateCursorResponse(1, "mock.users", mtest.FirstBatch, bson.D{
+				{Key: "email", Value: "test@test.com"},
+				{Key: "passHash", Value: tc.passHash},
+			}))
+			mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 1}, {Key: "nModified", Value: 1}})
+
+			loginRepo := NewLoginRepository(mt.Client, mt.Coll, testHasher)
+			_, err := loginRepo.Login("test@test.com", "test_test123")
+			assert.ErrorIs(mt, err, tc.err)
+
+			var update bson.Raw
+			for _, e := range mt.GetAllStartedEvents() {
+				if e.CommandName == "update" {
+					update = e.Command
+				}
+			}
+
+			if !tc.upgraded {
+				assert.Nil(mt, update)
+				return
+			}
+
+			if assert.NotNil(mt, update) {
+				hash := update.Lookup("updates", "0", "u", "$set", "passHash").StringValue()
+				assert.True(mt, strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$"), hash)
+			}
+		})
+	}
+}
@@ -3,28 +3,47 @@ package login
 import (
 	"context"
 	"encoding/json"
-	"github.com/dgrijalva/jwt-go"
+	"errors"
+	"fmt"
+	"github.com/tomasen/realip"
+	"go.mongodb.org/mongo-driver/mongo"
+	"interviews/internal/audit"
 	"interviews/internal/auth"
 	"interviews/internal/config"
+	"interviews/internal/oidc"
+	"interviews/internal/password"
 	data "interviews/internal/users"
 	"interviews/pkg"
 	log "interviews/pkg/logger"
 	validator "interviews/pkg/vaildator"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Login struct {
 	helper    pkg.Helper
+	e         pkg.CustomErrors
 	user      data.User
 	res       Response
 	repo      Repository
 	userRepo  UserRepository
 	tokenRepo TokenRepository
+	tokens    ScopedTokenRepository
+	attempts  AttemptsRepository
+	sessions  SessionRepository
+	audit     AuditLog
+	oidc      OIDC
+	trials    Trials
+	mailer    Mailer
+	passwords password.Policy
+	userCtx   data.UsersContext
 	cfg       config.Config
-	validator *validator.Validator
 }
 
+type envelope map[string]any
+
 var (
 	FailedLoginResponse = Response{
 		Success: false,
@@ -41,27 +60,86 @@ type Response struct {
 	User    string `json:"user"`
 	Token   string `json:"token"`
 	Error   string `json:"error"`
+
+	TwoFactorRequired bool   `json:"two_factor_required,omitempty"`
+	ChallengeToken    string `json:"challenge_token,omitempty"`
+
+	// replaces the token when it is set as a cookie instead
+	CSRFToken string `json:"csrf_token,omitempty"`
 }
 
 type Repository interface {
 	Login(email string, password string) (data.User, error)
 	Register(email, password string) (*data.User, error)
-	RegisterGoogle(claims auth.GoogleClaims, password string) (*data.User, error)
+	RegisterExternal(ctx context.Context, name, email string, activated bool, identity data.Identity) (*data.User, error)
+	GetByIdentity(ctx context.Context, provider, subject string) (*data.User, error)
+	LinkIdentity(ctx context.Context, email string, identity data.Identity) error
+	UpdatePassword(ctx context.Context, email, password string) error
+	ForcePasswordReset(ctx context.Context, email string) error
+	Activate(ctx context.Context, email string) error
+	SetPendingTOTP(ctx context.Context, email, secret string) error
+	EnableTOTP(ctx context.Context, email, secret string, step int64, recoveryCodes []string) error
+	UseTOTPStep(ctx context.Context, email string, step int64) error
+	UseRecoveryCode(ctx context.Context, email, hash string) error
 }
 
 type UserRepository interface {
 	GetByEmail(email string) (*data.User, error)
+	UpdateRole(ctx context.Context, user *data.User) error
+	List(ctx context.Context, filter data.UserFilter) ([]data.User, data.Metadata, error)
+	SetSuspended(ctx context.Context, email string, suspended bool, reason string) error
 }
 
 type TokenRepository interface {
-	GenerateJWT(ctx context.Context, user *data.User) (string, error)
-	ValidateBearerToken(bearerToken string) (*jwt.Token, error)
+	GenerateJWT(ctx context.Context, user *data.User, device auth.Device, methods ...string) (string, error)
+	GenerateChallengeJWT(ctx context.Context, user *data.User) (string, error)
+	GenerateImpersonationJWT(ctx context.Context, user *data.User, actor string, device auth.Device) (string, time.Duration, error)
+	ValidateBearerToken(bearerToken string) (*auth.Claims, error)
+	ValidateChallengeToken(tokenString string) (*auth.Claims, error)
 	SaveToken(ctx context.Context, token string, email string) error
-	ValidateGoogleJWT(tokenString string) (auth.GoogleClaims, error)
 	DeleteToken(ctx context.Context, email string) error
 	GetEmailFromJWT(tokenString string) (string, error)
 }
 
+type ScopedTokenRepository interface {
+	New(ctx context.Context, email string, ttl time.Duration, scope string) (*auth.ScopedToken, error)
+	Consume(ctx context.Context, scope, tokenPlaintext string) (string, error)
+	Peek(ctx context.Context, scope, tokenPlaintext string) (string, error)
+	DeleteAllForUser(ctx context.Context, scope, email string) error
+	LatestCreatedAt(ctx context.Context, scope, email string) (time.Time, error)
+}
+
+type AttemptsRepository interface {
+	Get(ctx context.Context, key string) (*Attempt, error)
+	RecordFailure(ctx context.Context, key string, threshold int, lockout, window time.Duration) (*Attempt, error)
+	Reset(ctx context.Context, key string) error
+}
+
+type SessionRepository interface {
+	ListForUser(ctx context.Context, email string) ([]auth.Session, error)
+	Delete(ctx context.Context, email, id string) error
+	DeleteOthers(ctx context.Context, email, id string) (int64, error)
+	DeleteAllForUser(ctx context.Context, email string) (int64, error)
+}
+
+type AuditLog interface {
+	Record(ctx context.Context, event audit.Event) error
+}
+
+type OIDC interface {
+	AuthCodeURL(ctx context.Context, provider string) (string, error)
+	Exchange(ctx context.Context, provider, code, state string) (*oidc.Identity, error)
+	VerifyIDToken(ctx context.Context, provider, rawIDToken string) (*oidc.Identity, error)
+}
+
+type Mailer interface {
+	Send(recipient, templateFile string, data any) error
+}
+
+type Trials interface {
+	StartTrial(ctx context.Context, email string) error
+}
+
 type TokenCache interface {
 	AddToken(ctx context.Context, email, token string) error
 	RemoveToken(ctx context.Context, email string) error
@@ -70,82 +148,72 @@ type TokenCache interface {
 func (l *Login) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := context.Background()
 
-	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusBadRequest)
-
-		json.NewEncoder(w).Encode(FailedLoginResponse)
-
-		return
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
 	}
 
-	decoder := json.NewDecoder(r.Body)
-	var requestBody map[string]string
-	err := decoder.Decode(&requestBody)
+	err := l.helper.ReadJSON(w, r, &input)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-
-		json.NewEncoder(w).Encode(FailedLoginResponse)
+		l.e.BadRequestResponse(w, r, err)
 
 		return
 	}
 
-	email, ok := requestBody["email"]
-	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
+	v := validator.New()
 
-		json.NewEncoder(w).Encode(FailedLoginResponse)
+	l.ValidateUser(v, input.Email, input.Password)
+
+	if !v.Valid() {
+		l.e.FailedValidationResponse(w, r, v.Errors)
 
 		return
 	}
 
-	password, ok := requestBody["password"]
-	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
+	ip := realip.FromRequest(r)
 
-		json.NewEncoder(w).Encode(FailedLoginResponse)
+	wait, err := l.throttled(ctx, input.Email, ip)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
 
 		return
 	}
 
-	l.ValidateUser(l.validator, email, password)
-	l.validateEmail(l.validator, email)
-	//TODO: have a look at why the password length fails sometimes when it is still valid
-	l.validatePasswordPlaintext(l.validator, password)
-
-	if l.validator.Valid() {
-		user, err := l.repo.Login(email, password)
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-
-			json.NewEncoder(w).Encode(FailedLoginResponse)
-
-			return
-		}
-
-		token, err := l.tokenRepo.GenerateJWT(ctx, &user)
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
+	if wait > 0 {
+		l.e.LoginThrottledResponse(w, r, wait)
 
-			json.NewEncoder(w).Encode(FailedLoginResponse)
+		return
+	}
 
-			return
+	user, err := l.repo.Login(input.Email, input.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidPassword):
+			l.recordFailure(ctx, input.Email, ip, true)
+			l.e.InvalidCredentialsResponse(w, r)
+		case errors.Is(err, mongo.ErrNoDocuments):
+			// unknown accounts are counted as well so lockouts don't reveal who is registered
+			l.recordFailure(ctx, input.Email, ip, false)
+			l.e.InvalidCredentialsResponse(w, r)
+		default:
+			l.e.ServerErrorResponse(w, r, err)
 		}
 
-		response := Response{
-			Success: true,
-			Message: "Login successful",
-			User:    email,
-			Token:   token,
-		}
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(response)
+		return
+	}
 
-	} else {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(FailedLoginResponse)
+	err = l.attempts.Reset(ctx, accountKey(input.Email))
+	if err != nil {
+		log.ErrorCtx(err, log.Ctx{
+			"msg": "unable to reset failed login attempts",
+		})
 	}
 
+	l.writeLoginResponse(ctx, w, r, &user, auth.AuthMethodPassword)
 }
+
+// LoginGoogleHandler is kept for existing clients, new ones use the ID-token
+// login of the google provider.
 func (l *Login) LoginGoogleHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := context.Background()
 
@@ -177,7 +245,7 @@ func (l *Login) LoginGoogleHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	claims, err := l.tokenRepo.ValidateGoogleJWT(token)
+	identity, err := l.oidc.VerifyIDToken(ctx, "google", token)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 
@@ -186,26 +254,7 @@ func (l *Login) LoginGoogleHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := l.userRepo.GetByEmail(claims.Email)
-
-	l.validateEmail(l.validator, claims.Email)
-
-	if err != nil && l.validator.Valid() {
-
-		var err error
-		// no password needed if login with Google
-		user, err = l.repo.RegisterGoogle(claims, "password")
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-
-			json.NewEncoder(w).Encode(FailedLoginResponse)
-
-			return
-		}
-
-	}
-
-	validToken, err := l.tokenRepo.GenerateJWT(ctx, user)
+	user, err := l.externalLogin(ctx, identity)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 
@@ -214,14 +263,7 @@ func (l *Login) LoginGoogleHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	response := Response{
-		Success: true,
-		Message: "Login successful",
-		Token:   validToken,
-	}
-
-	json.NewEncoder(w).Encode(response)
-
+	l.writeLoginResponse(ctx, w, r, user)
 }
 func (l *Login) CreateAccountHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := context.Background()
@@ -263,7 +305,16 @@ func (l *Login) CreateAccountHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	//TODO: add validation for email and password
+	v := validator.New()
+
+	l.validateEmail(v, email)
+	l.validateNewPassword(v, password, email)
+
+	if !v.Valid() {
+		l.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
 
 	user, err := l.repo.Register(email, password)
 	if err != nil {
@@ -274,7 +325,15 @@ func (l *Login) CreateAccountHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := l.tokenRepo.GenerateJWT(ctx, user)
+	// a failed email shouldn't fail the sign-up, the user can request a new one
+	err = l.sendActivationToken(ctx, user.Email)
+	if err != nil {
+		log.ErrorCtx(err, log.Ctx{
+			"msg": "unable to issue activation token",
+		})
+	}
+
+	token, err := l.tokenRepo.GenerateJWT(ctx, user, auth.DeviceFromRequest(r))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 
@@ -288,45 +347,341 @@ func (l *Login) CreateAccountHandler(w http.ResponseWriter, r *http.Request) {
 		"token":   token,
 	}
 
+	if l.cfg.Cookies.Enabled {
+		csrf, err := auth.SetSessionCookies(w, l.cfg, token)
+		if err != nil {
+			l.e.ServerErrorResponse(w, r, err)
+
+			return
+		}
+
+		delete(response, "token")
+		response["csrf_token"] = csrf
+	}
+
 	json.NewEncoder(w).Encode(response)
 }
+func (l *Login) ActivateUserHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := l.helper.ReadJSON(w, r, &input)
+	if err != nil {
+		l.e.BadRequestResponse(w, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	auth.ValidateScopedTokenPlaintext(v, input.TokenPlaintext)
+
+	if !v.Valid() {
+		l.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	email, err := l.tokens.Consume(ctx, auth.ScopeActivation, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidToken):
+			v.AddError("token", "invalid or expired activation token")
+			l.e.FailedValidationResponse(w, r, v.Errors)
+		default:
+			l.e.ServerErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	err = l.repo.Activate(ctx, email)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	l.startTrial(ctx, email)
+
+	err = l.helper.WriteJSON(w, http.StatusOK, envelope{"message": "your account has been activated"}, nil)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+// startTrial gives a newly activated user their trial. Activation doesn't
+// fail without it, an admin can still grant access.
+func (l *Login) startTrial(ctx context.Context, email string) {
+	err := l.trials.StartTrial(ctx, email)
+	if err != nil {
+		log.ErrorCtx(err, log.Ctx{
+			"msg": "unable to start trial",
+		})
+	}
+}
+func (l *Login) ResendActivationHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := l.helper.ReadJSON(w, r, &input)
+	if err != nil {
+		l.e.BadRequestResponse(w, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	l.validateEmail(v, input.Email)
+
+	if !v.Valid() {
+		l.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	env := envelope{"message": "an email will be sent to you containing activation instructions"}
+
+	user, err := l.userRepo.GetByEmail(input.Email)
+	if err != nil || user.Activated {
+		err = l.helper.WriteJSON(w, http.StatusAccepted, env, nil)
+		if err != nil {
+			l.e.ServerErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	last, err := l.tokens.LatestCreatedAt(ctx, auth.ScopeActivation, user.Email)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	if wait := l.cfg.TokenConfig.ActivationResend - time.Since(last); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		l.e.RateLimitExceededResponse(w, r)
+
+		return
+	}
+
+	err = l.sendActivationToken(ctx, user.Email)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = l.helper.WriteJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+// UnlockAccountHandler lets an admin lift a lockout before it expires.
+func (l *Login) UnlockAccountHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := l.helper.ReadJSON(w, r, &input)
+	if err != nil {
+		l.e.BadRequestResponse(w, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	l.validateEmail(v, input.Email)
+
+	if !v.Valid() {
+		l.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	err = l.attempts.Reset(ctx, accountKey(input.Email))
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = l.helper.WriteJSON(w, http.StatusOK, envelope{"message": "account unlocked"}, nil)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+	}
+}
 func (l *Login) PasswordResetHandler(w http.ResponseWriter, r *http.Request) {
-	panic("implement me")
+	ctx := context.Background()
+	clog := log.GetLoggerFromContext(ctx)
+
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := l.helper.ReadJSON(w, r, &input)
+	if err != nil {
+		l.e.BadRequestResponse(w, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	auth.ValidateScopedTokenPlaintext(v, input.TokenPlaintext)
+
+	if !v.Valid() {
+		l.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	// the password is checked against the email before the token is used up
+	// so a rejected password doesn't cost the user their reset link
+	email, err := l.tokens.Peek(ctx, auth.ScopePasswordReset, input.TokenPlaintext)
+	if err == nil {
+		l.validateNewPassword(v, input.Password, email)
+
+		if !v.Valid() {
+			l.e.FailedValidationResponse(w, r, v.Errors)
+
+			return
+		}
+
+		email, err = l.tokens.Consume(ctx, auth.ScopePasswordReset, input.TokenPlaintext)
+	}
+
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidToken):
+			v.AddError("token", "invalid or expired password reset token")
+			l.e.FailedValidationResponse(w, r, v.Errors)
+		default:
+			l.e.ServerErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	// also revokes every session that was started with the old password
+	err = l.repo.UpdatePassword(ctx, email, input.Password)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = l.tokenRepo.DeleteToken(ctx, email)
+	if err != nil {
+		clog.ErrorCtx(err, log.Ctx{
+			"msg": "unable to delete stored tokens after password reset",
+		})
+	}
+
+	err = l.helper.WriteJSON(w, http.StatusOK, envelope{"message": "your password was successfully reset"}, nil)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+	}
 }
 func (l *Login) ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
-	panic("implement me")
-}
-func (l *Login) LogoutHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := context.Background()
 	clog := log.GetLoggerFromContext(ctx)
 
-	w.Header().Add("Vary", "Authorization")
+	var input struct {
+		Email string `json:"email"`
+	}
 
-	authorizationHeader := r.Header.Get("Authorization")
+	err := l.helper.ReadJSON(w, r, &input)
+	if err != nil {
+		l.e.BadRequestResponse(w, r, err)
 
-	if authorizationHeader == "" {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
 
-		json.NewEncoder(w).Encode(FailedLoginResponse)
+	v := validator.New()
+
+	l.validateEmail(v, input.Email)
+
+	if !v.Valid() {
+		l.e.FailedValidationResponse(w, r, v.Errors)
 
 		return
 	}
 
-	headerParts := strings.Split(authorizationHeader, " ")
-	if len(headerParts) != 2 || headerParts[0] != "Bearer" {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	// the response is the same whether or not the account exists so the
+	// endpoint can't be used to find out who is registered
+	env := envelope{"message": "an email will be sent to you containing password reset instructions"}
 
-		json.NewEncoder(w).Encode(FailedLoginResponse)
+	user, err := l.userRepo.GetByEmail(input.Email)
+	if err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			clog.ErrorCtx(err, log.Ctx{
+				"msg": "unable to look up user for password reset",
+			})
+		}
+
+		err = l.helper.WriteJSON(w, http.StatusAccepted, env, nil)
+		if err != nil {
+			l.e.ServerErrorResponse(w, r, err)
+		}
 
 		return
 	}
 
-	// TODO: put token in a blacklist and clear the list every 24 hours
-	// or use token versioning
-	bearerToken := headerParts[1]
-	clog.InfoCtx(bearerToken, log.Ctx{
-		"msg": "Put this is a blacklist",
-	})
+	err = l.sendPasswordResetToken(ctx, user.Email)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = l.helper.WriteJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+	}
+}
+func (l *Login) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+	clog := log.GetLoggerFromContext(ctx)
+
+	w.Header().Add("Vary", "Authorization")
+
+	authorizationHeader := r.Header.Get("Authorization")
+
+	// browsers in cookie mode are authenticated without the header
+	cookieSession := l.cfg.Cookies.Enabled && authorizationHeader == "" && l.userCtx.ContextGetSessionID(r) != ""
+
+	if !cookieSession {
+		if authorizationHeader == "" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+
+			json.NewEncoder(w).Encode(FailedLoginResponse)
+
+			return
+		}
+
+		headerParts := strings.Split(authorizationHeader, " ")
+		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+
+			json.NewEncoder(w).Encode(FailedLoginResponse)
+
+			return
+		}
+	}
 
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -336,7 +691,21 @@ func (l *Login) LogoutHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// If there is no logout-related operation to perform, you can simply respond with success.
+	// the access token stops working once its session is gone
+	err := l.endSession(ctx, r)
+	if err != nil {
+		clog.ErrorCtx(err, log.Ctx{
+			"msg": "unable to end session",
+		})
+		l.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	if l.cfg.Cookies.Enabled {
+		auth.ClearSessionCookies(w, l.cfg)
+	}
+
 	successResponse := Response{
 		Success: true,
 		Message: "Logout successful",
@@ -353,19 +722,114 @@ func (l *Login) validateEmail(v *validator.Validator, email string) {
 	v.Check(email != "", "email", "must be provided")
 	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
 }
-func (l *Login) validatePasswordPlaintext(v *validator.Validator, password string) {
-	v.Check(password != "", "password", "must be provided")
-	//v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
-	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+
+// validatePasswordPlaintext only checks what every stored password satisfies,
+// new passwords go through validateNewPassword.
+func (l *Login) validatePasswordPlaintext(v *validator.Validator, plaintext string) {
+	v.Check(plaintext != "", "password", "must be provided")
+	v.Check(len(plaintext) <= password.MaxLength, "password", fmt.Sprintf("must not be more than %d bytes long", password.MaxLength))
+}
+
+// sendActivationToken replaces any outstanding activation token of the user and
+// emails the new one in the background.
+func (l *Login) sendActivationToken(ctx context.Context, email string) error {
+	clog := log.GetLoggerFromContext(ctx)
+
+	err := l.tokens.DeleteAllForUser(ctx, auth.ScopeActivation, email)
+	if err != nil {
+		return err
+	}
+
+	ttl := l.cfg.TokenConfig.ActivationTTL
+
+	token, err := l.tokens.New(ctx, email, ttl, auth.ScopeActivation)
+	if err != nil {
+		return err
+	}
+
+	l.helper.Background(func() {
+		data := map[string]any{
+			"activationToken": token.Plaintext,
+			"activationURL":   fmt.Sprintf("%s/activate?token=%s", l.cfg.FrontendURL, token.Plaintext),
+			"expiresIn":       ttl.String(),
+		}
+
+		err := l.mailer.Send(email, "user_welcome.tmpl", data)
+		if err != nil {
+			clog.ErrorCtx(err, log.Ctx{
+				"msg": "unable to send activation email",
+			})
+		}
+	})
+
+	return nil
+}
+
+// sendPasswordResetToken replaces any outstanding password reset token of the
+// user and emails the new one in the background. Only the most recently
+// requested token stays valid.
+func (l *Login) sendPasswordResetToken(ctx context.Context, email string) error {
+	clog := log.GetLoggerFromContext(ctx)
+
+	err := l.tokens.DeleteAllForUser(ctx, auth.ScopePasswordReset, email)
+	if err != nil {
+		return err
+	}
+
+	ttl := l.cfg.TokenConfig.PasswordResetTTL
+
+	token, err := l.tokens.New(ctx, email, ttl, auth.ScopePasswordReset)
+	if err != nil {
+		return err
+	}
+
+	l.helper.Background(func() {
+		data := map[string]any{
+			"passwordResetToken": token.Plaintext,
+			"passwordResetURL":   fmt.Sprintf("%s/password-reset?token=%s", l.cfg.FrontendURL, token.Plaintext),
+			"expiresIn":          ttl.String(),
+		}
+
+		err := l.mailer.Send(email, "password_reset.tmpl", data)
+		if err != nil {
+			clog.ErrorCtx(err, log.Ctx{
+				"msg": "unable to send password reset email",
+			})
+		}
+	})
+
+	return nil
+}
+func (l *Login) validateNewPassword(v *validator.Validator, password, email string) {
+	l.passwords.Validate(v, password, email)
 }
 func NewLoginService(repository Repository,
 	tokenRepo TokenRepository,
-	userRepo UserRepository) *Login {
+	userRepo UserRepository,
+	tokens ScopedTokenRepository,
+	attempts AttemptsRepository,
+	sessions SessionRepository,
+	audit AuditLog,
+	oidc OIDC,
+	trials Trials,
+	mailer Mailer,
+	passwords password.Policy,
+	cfg config.Config) *Login {
 	return &Login{
 		helper:    pkg.Helper{},
+		e:         pkg.CustomErrors{},
 		repo:      repository,
 		userRepo:  userRepo,
 		tokenRepo: tokenRepo,
-		validator: validator.New(),
+		tokens:    tokens,
+		attempts:  attempts,
+		sessions:  sessions,
+		audit:     audit,
+		oidc:      oidc,
+		trials:    trials,
+		mailer:    mailer,
+		passwords: passwords,
+		userCtx:   data.UsersContext{},
+		cfg:       cfg,
 	}
 }
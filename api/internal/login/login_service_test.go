@@ -4,13 +4,12 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
-	"github.com/dgrijalva/jwt-go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/mongo"
 	"interviews/internal/auth"
+	"interviews/internal/oidc"
 	data "interviews/internal/users"
-	validator "interviews/pkg/vaildator"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -31,81 +30,308 @@ func (m *MockLoginRepository) Register(email, password string) (*data.User, erro
 	return m.user, m.error
 }
 
-func (m *MockLoginRepository) RegisterGoogle(claims auth.GoogleClaims, password string) (*data.User, error) {
+func (m *MockLoginRepository) RegisterExternal(ctx context.Context, name, email string, activated bool, identity data.Identity) (*data.User, error) {
+	if m.error != nil {
+		return nil, m.error
+	}
 
-	// Simulate password hashing
-	hash, err := hashPassword(password)
-	if err != nil {
-		return nil, err
+	m.user = &data.User{
+		Name:       name,
+		Email:      email,
+		CreatedAt:  time.Now(),
+		Activated:  activated,
+		Identities: []data.Identity{identity},
 	}
 
-	// Create a new user in the mock database
-	newUser := &data.User{
-		Name:      claims.Name,
-		Email:     claims.Email,
-		PassHash:  hash,
-		CreatedAt: time.Now(),
-		Role:      "",
-		Activated: false,
+	return m.user, nil
+}
+
+func (m *MockLoginRepository) GetByIdentity(ctx context.Context, provider, subject string) (*data.User, error) {
+	if m.user != nil {
+		for _, identity := range m.user.Identities {
+			if identity.Provider == provider && identity.Subject == subject {
+				return m.user, nil
+			}
+		}
 	}
 
-	return newUser, nil
+	return nil, mongo.ErrNoDocuments
+}
+
+func (m *MockLoginRepository) LinkIdentity(ctx context.Context, email string, identity data.Identity) error {
+	for _, linked := range m.user.Identities {
+		if linked.Provider == identity.Provider {
+			return ErrIdentityLinked
+		}
+	}
+
+	m.user.Identities = append(m.user.Identities, identity)
+
+	return m.error
 }
 
 func (m *MockLoginRepository) Login(email string, password string) (data.User, error) {
 	return *m.user, m.error
 }
 
-type MockTokenRepository struct {
-	token string
+func (m *MockLoginRepository) Activate(ctx context.Context, email string) error {
+	if m.user != nil {
+		m.user.Email = email
+		m.user.Activated = true
+	}
+
+	return m.error
 }
 
-type MockUserRepository struct {
-	user *data.User
+func (m *MockLoginRepository) UpdatePassword(ctx context.Context, email, password string) error {
+	if m.user != nil {
+		m.user.Email = email
+		m.user.PassHash, _ = testHasher.Hash(password)
+	}
+
+	return m.error
 }
 
-func (m *MockUserRepository) GetByEmail(email string) (*data.User, error) {
-	return m.user, nil
+func (m *MockLoginRepository) ForcePasswordReset(ctx context.Context, email string) error {
+	if m.user != nil {
+		m.user.PassHash = ""
+	}
+
+	return m.error
 }
 
-func (m *MockTokenRepository) ValidateBearerToken(bearerToken string) (*jwt.Token, error) {
-	//TODO implement me
-	panic("implement me")
+func (m *MockLoginRepository) SetPendingTOTP(ctx context.Context, email, secret string) error {
+	m.user.TOTPPendingSecret = secret
+
+	return m.error
 }
 
-func (m *MockTokenRepository) SaveToken(ctx context.Context, token string, email string) error {
-	//TODO implement me
-	panic("implement me")
+func (m *MockLoginRepository) EnableTOTP(ctx context.Context, email, secret string, step int64, recoveryCodes []string) error {
+	m.user.TOTPEnabled = true
+	m.user.TOTPSecret = secret
+	m.user.TOTPPendingSecret = ""
+	m.user.TOTPLastStep = step
+	m.user.RecoveryCodes = recoveryCodes
+
+	return m.error
+}
+
+func (m *MockLoginRepository) UseTOTPStep(ctx context.Context, email string, step int64) error {
+	if step <= m.user.TOTPLastStep {
+		return ErrCodeUsed
+	}
+	m.user.TOTPLastStep = step
+
+	return m.error
+}
+
+func (m *MockLoginRepository) UseRecoveryCode(ctx context.Context, email, hash string) error {
+	for i, code := range m.user.RecoveryCodes {
+		if code == hash {
+			m.user.RecoveryCodes = append(m.user.RecoveryCodes[:i], m.user.RecoveryCodes[i+1:]...)
+
+			return m.error
+		}
+	}
+
+	return ErrCodeUsed
 }
 
-func (m *MockTokenRepository) ValidateGoogleJWT(tokenString string) (auth.GoogleClaims, error) {
-	sampleClaims := auth.GoogleClaims{
+type MockScopedTokenRepository struct {
+	email  string
+	latest time.Time
+	issued []*auth.ScopedToken
+}
+
+func (m *MockScopedTokenRepository) New(ctx context.Context, email string, ttl time.Duration, scope string) (*auth.ScopedToken, error) {
+	token := &auth.ScopedToken{
+		Plaintext: "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+		Email:     email,
+		Scope:     scope,
+		Expiry:    time.Now().Add(ttl),
+	}
+	m.issued = append(m.issued, token)
+
+	return token, nil
+}
+
+func (m *MockScopedTokenRepository) Consume(ctx context.Context, scope, tokenPlaintext string) (string, error) {
+	if m.email == "" {
+		return "", auth.ErrInvalidToken
+	}
+
+	return m.email, nil
+}
+
+func (m *MockScopedTokenRepository) Peek(ctx context.Context, scope, tokenPlaintext string) (string, error) {
+	return m.Consume(ctx, scope, tokenPlaintext)
+}
+
+func (m *MockScopedTokenRepository) DeleteAllForUser(ctx context.Context, scope, email string) error {
+	return nil
+}
+
+func (m *MockScopedTokenRepository) LatestCreatedAt(ctx context.Context, scope, email string) (time.Time, error) {
+	return m.latest, nil
+}
+
+type sentMail struct {
+	recipient string
+	template  string
+	data      any
+}
+
+type MockMailer struct {
+	sent chan sentMail
+}
+
+func (m *MockMailer) Send(recipient, templateFile string, data any) error {
+	if m.sent != nil {
+		m.sent <- sentMail{recipient: recipient, template: templateFile, data: data}
+	}
+
+	return nil
+}
+
+type MockTrials struct {
+	started []string
+}
+
+func (m *MockTrials) StartTrial(ctx context.Context, email string) error {
+	m.started = append(m.started, email)
+
+	return nil
+}
+
+type MockTokenRepository struct {
+	token     string
+	methods   []string
+	actor     string
+	challenge *auth.Claims
+}
+
+type MockOIDC struct{}
+
+func (m *MockOIDC) identity(provider string) *oidc.Identity {
+	return &oidc.Identity{
+		Provider:      provider,
+		Subject:       "1234567890",
 		Name:          "John Doe",
 		Email:         "johndoe@example.com",
 		EmailVerified: true,
 	}
+}
 
-	if tokenString == "valid_mock_token" {
-		return sampleClaims, nil
+func (m *MockOIDC) AuthCodeURL(ctx context.Context, provider string) (string, error) {
+	if provider != "google" {
+		return "", oidc.ErrUnknownProvider
 	}
 
-	// Simulate an invalid token scenario for demonstration purposes
-	return auth.GoogleClaims{}, errors.New("invalid token")
+	return "https://accounts.google.com/o/oauth2/v2/auth?state=state", nil
 }
 
-func (m *MockTokenRepository) DeleteToken(ctx context.Context, email string) error {
+func (m *MockOIDC) Exchange(ctx context.Context, provider, code, state string) (*oidc.Identity, error) {
+	if code != "valid_code" || state != "state" {
+		return nil, oidc.ErrInvalidState
+	}
+
+	return m.identity(provider), nil
+}
+
+func (m *MockOIDC) VerifyIDToken(ctx context.Context, provider, rawIDToken string) (*oidc.Identity, error) {
+	if provider != "google" {
+		return nil, oidc.ErrUnknownProvider
+	}
+
+	if rawIDToken != "valid_mock_token" {
+		return nil, oidc.ErrInvalidIDToken
+	}
+
+	return m.identity(provider), nil
+}
+
+type MockUserRepository struct {
+	user *data.User
+	err  error
+}
+
+func (m *MockUserRepository) GetByEmail(email string) (*data.User, error) {
+	if m.user == nil && m.err == nil {
+		return &data.User{}, mongo.ErrNoDocuments
+	}
+
+	return m.user, m.err
+}
+
+func (m *MockUserRepository) UpdateRole(ctx context.Context, user *data.User) error {
+	m.user.Role = user.Role
+
+	return nil
+}
+
+func (m *MockUserRepository) List(ctx context.Context, filter data.UserFilter) ([]data.User, data.Metadata, error) {
+	if m.user == nil {
+		return []data.User{}, data.Metadata{}, nil
+	}
+
+	return []data.User{*m.user}, data.Metadata{CurrentPage: 1, PageSize: filter.PageSize, LastPage: 1, TotalRecords: 1}, nil
+}
+
+func (m *MockUserRepository) SetSuspended(ctx context.Context, email string, suspended bool, reason string) error {
+	if m.user == nil || m.user.Email != email {
+		return mongo.ErrNoDocuments
+	}
+
+	m.user.Suspended = suspended
+	m.user.SuspendedReason = reason
+
+	return nil
+}
+
+func (m *MockTokenRepository) ValidateBearerToken(bearerToken string) (*auth.Claims, error) {
+	//TODO implement me
+	panic("implement me")
+}
+
+func (m *MockTokenRepository) SaveToken(ctx context.Context, token string, email string) error {
 	//TODO implement me
 	panic("implement me")
 }
 
+func (m *MockTokenRepository) DeleteToken(ctx context.Context, email string) error {
+	return nil
+}
+
 func (m *MockTokenRepository) GetEmailFromJWT(tokenString string) (string, error) {
 	//TODO implement me
 	panic("implement me")
 }
 
-func (m *MockTokenRepository) GenerateJWT(ctx context.Context, user *data.User) (string, error) {
+func (m *MockTokenRepository) GenerateJWT(ctx context.Context, user *data.User, device auth.Device, methods ...string) (string, error) {
+	m.methods = methods
+
 	return m.token, nil
 }
+
+func (m *MockTokenRepository) GenerateChallengeJWT(ctx context.Context, user *data.User) (string, error) {
+	return "challenge-token", nil
+}
+
+func (m *MockTokenRepository) GenerateImpersonationJWT(ctx context.Context, user *data.User, actor string,
+	device auth.Device) (string, time.Duration, error) {
+	m.actor = actor
+
+	return m.token, 30 * time.Minute, nil
+}
+
+func (m *MockTokenRepository) ValidateChallengeToken(tokenString string) (*auth.Claims, error) {
+	if m.challenge == nil || tokenString != "challenge-token" {
+		return nil, auth.ErrInvalidToken
+	}
+
+	return m.challenge, nil
+}
+
 func Test_LoginHandler(t *testing.T) {
 
 	testCases := []struct {
@@ -126,7 +352,6 @@ func Test_LoginHandler(t *testing.T) {
 						CreatedAt: time.Time{},
 						Name:      "Test",
 						Email:     "",
-						PassPlain: "asdfasdfadsf",
 						PassHash:  "",
 						Role:      "",
 						Activated: false,
@@ -134,14 +359,12 @@ func Test_LoginHandler(t *testing.T) {
 					},
 				},
 				tokenRepo: &MockTokenRepository{},
-				validator: validator.New(),
 			},
 			requestBody: map[string]string{
 				"email":    "test@example.com",
 				"password": "",
 			},
-			statusCode:       http.StatusBadRequest,
-			expectedResponse: FailedLoginResponse,
+			statusCode: http.StatusUnprocessableEntity,
 		},
 		{
 			Name: "Missing Email",
@@ -152,7 +375,6 @@ func Test_LoginHandler(t *testing.T) {
 						CreatedAt: time.Time{},
 						Name:      "Test",
 						Email:     "",
-						PassPlain: "yxcy<ycx<",
 						PassHash:  "",
 						Role:      "",
 						Activated: false,
@@ -160,14 +382,12 @@ func Test_LoginHandler(t *testing.T) {
 					},
 				},
 				tokenRepo: &MockTokenRepository{},
-				validator: validator.New(),
 			},
 			requestBody: map[string]string{
 				"email":    "",
 				"password": "testpassword",
 			},
-			statusCode:       http.StatusBadRequest,
-			expectedResponse: FailedLoginResponse,
+			statusCode: http.StatusUnprocessableEntity,
 		},
 		{
 			Name: "Invalid Request Body",
@@ -178,7 +398,6 @@ func Test_LoginHandler(t *testing.T) {
 						CreatedAt: time.Time{},
 						Name:      "Test",
 						Email:     "test@test.com",
-						PassPlain: "asdfasdfadsf",
 						PassHash:  "",
 						Role:      "",
 						Activated: false,
@@ -186,14 +405,13 @@ func Test_LoginHandler(t *testing.T) {
 					},
 				},
 				tokenRepo: &MockTokenRepository{},
-				validator: validator.New(),
 			},
 			requestBody: map[string]string{
-				"email":    "",
+				"email":    "test@test.com",
 				"password": "testpassword",
+				"username": "test",
 			},
-			expectedResponse: FailedLoginResponse,
-			statusCode:       http.StatusBadRequest,
+			statusCode: http.StatusBadRequest,
 		},
 		{
 			Name: "Successful Login",
@@ -204,7 +422,6 @@ func Test_LoginHandler(t *testing.T) {
 						CreatedAt: time.Time{},
 						Name:      "Test",
 						Email:     "test@test.com",
-						PassPlain: "asdfasdfadsf",
 						PassHash:  "",
 						Role:      "",
 						Activated: false,
@@ -212,7 +429,7 @@ func Test_LoginHandler(t *testing.T) {
 					},
 				},
 				tokenRepo: &MockTokenRepository{},
-				validator: validator.New(),
+				attempts:  newMockAttemptsRepository(),
 			},
 			requestBody: map[string]string{
 				"email":    "test@test.com",
@@ -239,6 +456,15 @@ func Test_LoginHandler(t *testing.T) {
 			// Check the status code
 			assert.Equal(t, tc.statusCode, recorder.Result().StatusCode)
 
+			if tc.statusCode != http.StatusOK {
+				var response map[string]any
+				err := json.Unmarshal(recorder.Body.Bytes(), &response)
+				assert.NoError(t, err)
+				assert.Contains(t, response, "error")
+
+				return
+			}
+
 			var response Response
 			err := json.Unmarshal(recorder.Body.Bytes(), &response)
 			assert.NoError(t, err)
@@ -269,7 +495,6 @@ func TestLogin_LoginGoogleHandler(t *testing.T) {
 						CreatedAt: time.Time{},
 						Name:      "Test",
 						Email:     "",
-						PassPlain: "asdfasdfadsf",
 						PassHash:  "",
 						Role:      "",
 						Activated: false,
@@ -278,7 +503,8 @@ func TestLogin_LoginGoogleHandler(t *testing.T) {
 				},
 				tokenRepo: &MockTokenRepository{},
 				userRepo:  &MockUserRepository{},
-				validator: validator.New(),
+				oidc:      &MockOIDC{},
+				trials:    &MockTrials{},
 			},
 			requestBody: map[string]string{
 				"token": "",
@@ -295,7 +521,6 @@ func TestLogin_LoginGoogleHandler(t *testing.T) {
 						CreatedAt: time.Time{},
 						Name:      "Test",
 						Email:     "",
-						PassPlain: "",
 						PassHash:  "",
 						Role:      "",
 						Activated: false,
@@ -304,7 +529,8 @@ func TestLogin_LoginGoogleHandler(t *testing.T) {
 				},
 				tokenRepo: &MockTokenRepository{},
 				userRepo:  &MockUserRepository{},
-				validator: validator.New(),
+				oidc:      &MockOIDC{},
+				trials:    &MockTrials{},
 			},
 			requestBody: map[string]string{
 				"token": "valid_mock_token",
@@ -313,6 +539,7 @@ func TestLogin_LoginGoogleHandler(t *testing.T) {
 			expectedResponse: Response{
 				Success: true,
 				Message: "Login successful",
+				User:    "johndoe@example.com",
 				Token:   VALID_TOKEN,
 			},
 		},
@@ -357,7 +584,6 @@ func TestLogin_CreateAccountHandler(t *testing.T) {
 				},
 				tokenRepo: &MockTokenRepository{},
 				userRepo:  &MockUserRepository{},
-				validator: validator.New(),
 			},
 			requestBody: map[string]string{
 				"email":    "",
@@ -374,7 +600,6 @@ func TestLogin_CreateAccountHandler(t *testing.T) {
 				},
 				tokenRepo: &MockTokenRepository{},
 				userRepo:  &MockUserRepository{},
-				validator: validator.New(),
 			},
 			requestBody: map[string]string{
 				"email":    "test@test.com",
@@ -384,19 +609,50 @@ func TestLogin_CreateAccountHandler(t *testing.T) {
 			expectedResponse: FailedLoginResponse,
 		},
 		{
-			Name: "Successful Account Creation",
+			Name: "Password Too Short",
+			login: &Login{
+				repo: &MockLoginRepository{
+					user: &data.User{},
+				},
+				tokenRepo: &MockTokenRepository{},
+				userRepo:  &MockUserRepository{},
+			},
+			requestBody: map[string]string{
+				"email":    "test@test.com",
+				"password": "a",
+			},
+			statusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			Name: "Password Contains Email",
 			login: &Login{
 				repo: &MockLoginRepository{
 					user: &data.User{},
 				},
 				tokenRepo: &MockTokenRepository{},
 				userRepo:  &MockUserRepository{},
-				validator: validator.New(),
 			},
 			requestBody: map[string]string{
 				"email":    "test@test.com",
 				"password": "Test/67$PassWord",
 			},
+			statusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			Name: "Successful Account Creation",
+			login: &Login{
+				repo: &MockLoginRepository{
+					user: &data.User{},
+				},
+				tokenRepo: &MockTokenRepository{},
+				userRepo:  &MockUserRepository{},
+				tokens:    &MockScopedTokenRepository{},
+				mailer:    &MockMailer{},
+			},
+			requestBody: map[string]string{
+				"email":    "test@test.com",
+				"password": "Correct/67$Horse",
+			},
 			statusCode: http.StatusOK,
 			expectedResponse: Response{
 				Success: true,
@@ -417,6 +673,11 @@ func TestLogin_CreateAccountHandler(t *testing.T) {
 			// Check the status code
 			assert.Equal(t, tc.statusCode, recorder.Result().StatusCode)
 
+			if tc.statusCode == http.StatusUnprocessableEntity {
+				assert.Contains(t, recorder.Body.String(), `"password":`)
+				return
+			}
+
 			var response Response
 			err := json.Unmarshal(recorder.Body.Bytes(), &response)
 			assert.NoError(t, err)
@@ -425,3 +686,316 @@ func TestLogin_CreateAccountHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestLogin_ForgotPasswordHandler(t *testing.T) {
+
+	testCases := []struct {
+		Name        string
+		userRepo    *MockUserRepository
+		requestBody map[string]string
+		statusCode  int
+		mailSent    bool
+	}{
+		{
+			Name:        "Invalid Email",
+			userRepo:    &MockUserRepository{},
+			requestBody: map[string]string{"email": "not-an-email"},
+			statusCode:  http.StatusUnprocessableEntity,
+		},
+		{
+			Name:        "Unknown Email",
+			userRepo:    &MockUserRepository{err: mongo.ErrNoDocuments},
+			requestBody: map[string]string{"email": "unknown@test.com"},
+			statusCode:  http.StatusAccepted,
+		},
+		{
+			Name:        "Reset Email Sent",
+			userRepo:    &MockUserRepository{user: &data.User{Email: "test@test.com"}},
+			requestBody: map[string]string{"email": "test@test.com"},
+			statusCode:  http.StatusAccepted,
+			mailSent:    true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			mailer := &MockMailer{sent: make(chan sentMail, 1)}
+			tokens := &MockScopedTokenRepository{}
+
+			login := &Login{
+				userRepo: tc.userRepo,
+				tokens:   tokens,
+				mailer:   mailer,
+			}
+			login.cfg.TokenConfig.PasswordResetTTL = 45 * time.Minute
+
+			recorder := httptest.NewRecorder()
+			requestBodyBytes, _ := json.Marshal(tc.requestBody)
+			req, _ := http.NewRequest(http.MethodPost, "/v1/forgot-password", bytes.NewReader(requestBodyBytes))
+			login.ForgotPasswordHandler(recorder, req)
+
+			assert.Equal(t, tc.statusCode, recorder.Result().StatusCode)
+
+			if !tc.mailSent {
+				assert.Empty(t, tokens.issued)
+
+				return
+			}
+
+			select {
+			case mail := <-mailer.sent:
+				assert.Equal(t, "test@test.com", mail.recipient)
+				assert.Equal(t, "password_reset.tmpl", mail.template)
+			case <-time.After(time.Second):
+				t.Fatal("password reset email was not sent")
+			}
+
+			if assert.Len(t, tokens.issued, 1) {
+				assert.Equal(t, auth.ScopePasswordReset, tokens.issued[0].Scope)
+			}
+		})
+	}
+}
+
+func TestLogin_PasswordResetHandler(t *testing.T) {
+
+	testCases := []struct {
+		Name        string
+		tokens      *MockScopedTokenRepository
+		requestBody map[string]string
+		statusCode  int
+		updated     bool
+	}{
+		{
+			Name:   "Password Too Short",
+			tokens: &MockScopedTokenRepository{email: "test@test.com"},
+			requestBody: map[string]string{
+				"token":    "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+				"password": "short",
+			},
+			statusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:   "Password Contains Email",
+			tokens: &MockScopedTokenRepository{email: "test@test.com"},
+			requestBody: map[string]string{
+				"token":    "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+				"password": "my-test-password",
+			},
+			statusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:   "Invalid Or Expired Token",
+			tokens: &MockScopedTokenRepository{},
+			requestBody: map[string]string{
+				"token":    "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+				"password": "a-new-password",
+			},
+			statusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:   "Password Reset",
+			tokens: &MockScopedTokenRepository{email: "test@test.com"},
+			requestBody: map[string]string{
+				"token":    "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+				"password": "a-new-password",
+			},
+			statusCode: http.StatusOK,
+			updated:    true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			repo := &MockLoginRepository{user: &data.User{}}
+
+			login := &Login{
+				repo:      repo,
+				tokenRepo: &MockTokenRepository{},
+				tokens:    tc.tokens,
+			}
+
+			recorder := httptest.NewRecorder()
+			requestBodyBytes, _ := json.Marshal(tc.requestBody)
+			req, _ := http.NewRequest(http.MethodPost, "/v1/password-reset", bytes.NewReader(requestBodyBytes))
+			login.PasswordResetHandler(recorder, req)
+
+			assert.Equal(t, tc.statusCode, recorder.Result().StatusCode)
+
+			if tc.updated {
+				assert.Equal(t, "test@test.com", repo.user.Email)
+				match, _, err := testHasher.Verify("a-new-password", repo.user.PassHash)
+				assert.NoError(t, err)
+				assert.True(t, match)
+			} else {
+				assert.Empty(t, repo.user.PassHash)
+			}
+		})
+	}
+}
+
+func TestLogin_ActivateUserHandler(t *testing.T) {
+
+	testCases := []struct {
+		Name       string
+		tokens     *MockScopedTokenRepository
+		token      string
+		statusCode int
+		activated  bool
+	}{
+		{
+			Name:       "Malformed Token",
+			tokens:     &MockScopedTokenRepository{email: "test@test.com"},
+			token:      "short",
+			statusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:       "Invalid Or Expired Token",
+			tokens:     &MockScopedTokenRepository{},
+			token:      "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+			statusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:       "Account Activated",
+			tokens:     &MockScopedTokenRepository{email: "test@test.com"},
+			token:      "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+			statusCode: http.StatusOK,
+			activated:  true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			repo := &MockLoginRepository{user: &data.User{}}
+			trials := &MockTrials{}
+
+			login := &Login{
+				repo:   repo,
+				tokens: tc.tokens,
+				trials: trials,
+			}
+
+			recorder := httptest.NewRecorder()
+			requestBodyBytes, _ := json.Marshal(map[string]string{"token": tc.token})
+			req, _ := http.NewRequest(http.MethodPut, "/v1/users/activated", bytes.NewReader(requestBodyBytes))
+			login.ActivateUserHandler(recorder, req)
+
+			assert.Equal(t, tc.statusCode, recorder.Result().StatusCode)
+			assert.Equal(t, tc.activated, repo.user.Activated)
+
+			if tc.activated {
+				assert.Equal(t, []string{"test@test.com"}, trials.started)
+			} else {
+				assert.Empty(t, trials.started)
+			}
+		})
+	}
+}
+
+func TestLogin_ResendActivationHandler(t *testing.T) {
+
+	testCases := []struct {
+		Name       string
+		user       *data.User
+		latest     time.Time
+		statusCode int
+		issued     int
+	}{
+		{
+			Name:       "Already Activated",
+			user:       &data.User{Email: "test@test.com", Activated: true},
+			statusCode: http.StatusAccepted,
+		},
+		{
+			Name:       "Requested Too Recently",
+			user:       &data.User{Email: "test@test.com"},
+			latest:     time.Now().Add(-10 * time.Second),
+			statusCode: http.StatusTooManyRequests,
+		},
+		{
+			Name:       "Activation Email Resent",
+			user:       &data.User{Email: "test@test.com"},
+			latest:     time.Now().Add(-time.Hour),
+			statusCode: http.StatusAccepted,
+			issued:     1,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			tokens := &MockScopedTokenRepository{latest: tc.latest}
+
+			login := &Login{
+				userRepo: &MockUserRepository{user: tc.user},
+				tokens:   tokens,
+				mailer:   &MockMailer{},
+			}
+			login.cfg.TokenConfig.ActivationResend = 2 * time.Minute
+
+			recorder := httptest.NewRecorder()
+			requestBodyBytes, _ := json.Marshal(map[string]string{"email": "test@test.com"})
+			req, _ := http.NewRequest(http.MethodPost, "/v1/users/activation", bytes.NewReader(requestBodyBytes))
+			login.ResendActivationHandler(recorder, req)
+
+			assert.Equal(t, tc.statusCode, recorder.Result().StatusCode)
+			assert.Len(t, tokens.issued, tc.issued)
+		})
+	}
+}
+
+func TestLogin_CookieMode(t *testing.T) {
+	login := &Login{
+		repo:      &MockLoginRepository{user: &data.User{Email: "test@test.com", Activated: true}},
+		tokenRepo: &MockTokenRepository{token: "access-token"},
+		attempts:  newMockAttemptsRepository(),
+		sessions:  &MockSessionRepository{},
+	}
+	login.cfg.TokenConfig.Expires = 24
+	login.cfg.Cookies.Enabled = true
+	login.cfg.Cookies.Name = "access_token"
+	login.cfg.Cookies.CSRFName = "csrf_token"
+	login.cfg.Cookies.Secure = true
+
+	recorder := httptest.NewRecorder()
+	body, _ := json.Marshal(map[string]string{"email": "test@test.com", "password": "testpassword"})
+	req, _ := http.NewRequest(http.MethodPost, "/v1/login", bytes.NewReader(body))
+	login.LoginHandler(recorder, req)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	var response Response
+	assert.NoError(t, json.NewDecoder(recorder.Body).Decode(&response))
+	assert.Empty(t, response.Token, "scripts mustn't see the access token")
+	assert.NotEmpty(t, response.CSRFToken)
+
+	cookies := map[string]*http.Cookie{}
+	for _, c := range recorder.Result().Cookies() {
+		cookies[c.Name] = c
+	}
+
+	if assert.Contains(t, cookies, "access_token") {
+		assert.Equal(t, "access-token", cookies["access_token"].Value)
+		assert.True(t, cookies["access_token"].HttpOnly)
+	}
+
+	if assert.Contains(t, cookies, "csrf_token") {
+		assert.Equal(t, response.CSRFToken, cookies["csrf_token"].Value)
+	}
+
+	// logging out with the cookie alone clears it
+	recorder = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodPost, "/v1/logout", nil)
+	req = login.userCtx.ContextSetUser(req, &data.User{Email: "test@test.com"})
+	req = login.userCtx.ContextSetSessionID(req, "session-id")
+	login.LogoutHandler(recorder, req)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	for _, c := range recorder.Result().Cookies() {
+		assert.Equal(t, -1, c.MaxAge, c.Name)
+	}
+}
@@ -0,0 +1,255 @@
+package login
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/mongo"
+	"interviews/internal/audit"
+	"interviews/internal/auth"
+	log "interviews/pkg/logger"
+	validator "interviews/pkg/vaildator"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func magicLinkEmailKey(email string) string {
+	return "magic-link:email:" + strings.ToLower(email)
+}
+
+func magicLinkIPKey(ip string) string {
+	return "magic-link:ip:" + ip
+}
+
+// MagicLinkHandler emails a link that logs the user in without a password.
+func (l *Login) MagicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+	clog := log.GetLoggerFromContext(ctx)
+
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := l.helper.ReadJSON(w, r, &input)
+	if err != nil {
+		l.e.BadRequestResponse(w, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	l.validateEmail(v, input.Email)
+
+	if !v.Valid() {
+		l.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	device := auth.DeviceFromRequest(r)
+
+	wait, err := l.magicLinkThrottled(ctx, input.Email, device.IP)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		l.e.RateLimitExceededResponse(w, r)
+
+		return
+	}
+
+	// the response is the same whether or not the account exists so the
+	// endpoint can't be used to find out who is registered
+	env := envelope{"message": "an email will be sent to you containing a login link"}
+
+	user, err := l.userRepo.GetByEmail(input.Email)
+	if err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			clog.ErrorCtx(err, log.Ctx{
+				"msg": "unable to look up user for magic link",
+			})
+		}
+
+		err = l.helper.WriteJSON(w, http.StatusAccepted, env, nil)
+		if err != nil {
+			l.e.ServerErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	// only the most recently requested link stays valid
+	err = l.tokens.DeleteAllForUser(ctx, auth.ScopeMagicLink, user.Email)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	ttl := l.cfg.MagicLink.TTL
+
+	token, err := l.tokens.New(ctx, user.Email, ttl, auth.ScopeMagicLink)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	l.recordAudit(ctx, audit.Event{
+		Action:    audit.ActionMagicLinkRequested,
+		Subject:   user.Email,
+		IP:        device.IP,
+		UserAgent: device.UserAgent,
+	})
+
+	l.helper.Background(func() {
+		data := map[string]any{
+			"magicLinkURL": fmt.Sprintf("%s/login/magic-link?token=%s", l.cfg.FrontendURL, token.Plaintext),
+			"expiresIn":    ttl.String(),
+			"ip":           device.IP,
+		}
+
+		err := l.mailer.Send(user.Email, "magic_link.tmpl", data)
+		if err != nil {
+			clog.ErrorCtx(err, log.Ctx{
+				"msg": "unable to send magic link email",
+			})
+		}
+	})
+
+	err = l.helper.WriteJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+// MagicLinkExchangeHandler trades the token of a magic link for an access
+// token. Users with two-factor authentication still get a challenge.
+func (l *Login) MagicLinkExchangeHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := l.helper.ReadJSON(w, r, &input)
+	if err != nil {
+		l.e.BadRequestResponse(w, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	auth.ValidateScopedTokenPlaintext(v, input.TokenPlaintext)
+
+	if !v.Valid() {
+		l.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	device := auth.DeviceFromRequest(r)
+
+	email, err := l.tokens.Consume(ctx, auth.ScopeMagicLink, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidToken):
+			l.recordAudit(ctx, audit.Event{
+				Action:    audit.ActionMagicLinkRejected,
+				IP:        device.IP,
+				UserAgent: device.UserAgent,
+			})
+
+			v.AddError("token", "invalid or expired login link")
+			l.e.FailedValidationResponse(w, r, v.Errors)
+		default:
+			l.e.ServerErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	user, err := l.userRepo.GetByEmail(email)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	// opening the link proves the user owns the address
+	if !user.Activated {
+		err = l.repo.Activate(ctx, user.Email)
+		if err != nil {
+			l.e.ServerErrorResponse(w, r, err)
+
+			return
+		}
+
+		user.Activated = true
+
+		l.startTrial(ctx, user.Email)
+	}
+
+	l.recordAudit(ctx, audit.Event{
+		Action:    audit.ActionMagicLinkLogin,
+		Actor:     user.Email,
+		Subject:   user.Email,
+		IP:        device.IP,
+		UserAgent: device.UserAgent,
+	})
+
+	l.writeLoginResponse(ctx, w, r, user, auth.AuthMethodMagicLink)
+}
+
+// magicLinkThrottled counts the request against the email and the client IP
+// and returns how long to wait once either went over its limit. Requests for
+// unknown accounts are counted as well.
+func (l *Login) magicLinkThrottled(ctx context.Context, email, ip string) (time.Duration, error) {
+	limits := l.cfg.MagicLink
+
+	var wait time.Duration
+
+	now := time.Now()
+
+	for key, limit := range map[string]int{magicLinkEmailKey(email): limits.EmailLimit, magicLinkIPKey(ip): limits.IPLimit} {
+		attempt, err := l.attempts.Get(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+
+		if now.Before(attempt.LockedUntil) {
+			if d := attempt.LockedUntil.Sub(now); d > wait {
+				wait = d
+			}
+
+			continue
+		}
+
+		// the key is blocked for a window once it reached the limit
+		_, err = l.attempts.RecordFailure(ctx, key, limit, limits.Window, limits.Window)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return wait, nil
+}
+
+// recordAudit doesn't fail the request when the audit log can't be written.
+func (l *Login) recordAudit(ctx context.Context, event audit.Event) {
+	err := l.audit.Record(ctx, event)
+	if err != nil {
+		log.ErrorCtx(err, log.Ctx{
+			"msg":    "unable to write audit log",
+			"action": event.Action,
+		})
+	}
+}
@@ -0,0 +1,261 @@
+package login
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+	"interviews/internal/audit"
+	"interviews/internal/auth"
+	data "interviews/internal/users"
+)
+
+type MockAuditLog struct {
+	events []audit.Event
+}
+
+func (m *MockAuditLog) Record(ctx context.Context, event audit.Event) error {
+	m.events = append(m.events, event)
+
+	return nil
+}
+
+func (m *MockAuditLog) actions() []string {
+	var actions []string
+	for _, e := range m.events {
+		actions = append(actions, e.Action)
+	}
+
+	return actions
+}
+
+func newMagicLinkLogin(userRepo *MockUserRepository, tokens *MockScopedTokenRepository,
+	attempts *MockAttemptsRepository, mailer *MockMailer, auditLog *MockAuditLog) *Login {
+	l := &Login{
+		repo:      &MockLoginRepository{user: &data.User{}},
+		userRepo:  userRepo,
+		tokenRepo: &MockTokenRepository{token: "access-token"},
+		tokens:    tokens,
+		attempts:  attempts,
+		mailer:    mailer,
+		audit:     auditLog,
+		trials:    &MockTrials{},
+	}
+	l.cfg.MagicLink.TTL = 15 * time.Minute
+	l.cfg.MagicLink.EmailLimit = 2
+	l.cfg.MagicLink.IPLimit = 20
+	l.cfg.MagicLink.Window = time.Hour
+
+	return l
+}
+
+func TestLogin_MagicLinkHandler(t *testing.T) {
+	testCases := []struct {
+		Name       string
+		userRepo   *MockUserRepository
+		email      string
+		requests   int
+		statusCode int
+		mailSent   bool
+	}{
+		{
+			Name:       "Invalid Email",
+			userRepo:   &MockUserRepository{},
+			email:      "not-an-email",
+			requests:   1,
+			statusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:       "Unknown Email",
+			userRepo:   &MockUserRepository{err: mongo.ErrNoDocuments},
+			email:      "unknown@test.com",
+			requests:   1,
+			statusCode: http.StatusAccepted,
+		},
+		{
+			Name:       "Link Sent",
+			userRepo:   &MockUserRepository{user: &data.User{Email: "test@test.com"}},
+			email:      "test@test.com",
+			requests:   1,
+			statusCode: http.StatusAccepted,
+			mailSent:   true,
+		},
+		{
+			Name:       "Too Many Requests For Email",
+			userRepo:   &MockUserRepository{err: mongo.ErrNoDocuments},
+			email:      "unknown@test.com",
+			requests:   3,
+			statusCode: http.StatusTooManyRequests,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			mailer := &MockMailer{sent: make(chan sentMail, 1)}
+			tokens := &MockScopedTokenRepository{}
+			auditLog := &MockAuditLog{}
+
+			login := newMagicLinkLogin(tc.userRepo, tokens, newMockAttemptsRepository(), mailer, auditLog)
+
+			var recorder *httptest.ResponseRecorder
+			for i := 0; i < tc.requests; i++ {
+				recorder = httptest.NewRecorder()
+				body, _ := json.Marshal(map[string]string{"email": tc.email})
+				req, _ := http.NewRequest(http.MethodPost, "/v1/login/magic-link", bytes.NewReader(body))
+				login.MagicLinkHandler(recorder, req)
+			}
+
+			assert.Equal(t, tc.statusCode, recorder.Code)
+
+			if tc.statusCode == http.StatusTooManyRequests {
+				assert.NotEmpty(t, recorder.Header().Get("Retry-After"))
+			}
+
+			if !tc.mailSent {
+				assert.Empty(t, tokens.issued)
+				assert.Empty(t, auditLog.events)
+
+				return
+			}
+
+			select {
+			case mail := <-mailer.sent:
+				assert.Equal(t, "test@test.com", mail.recipient)
+				assert.Equal(t, "magic_link.tmpl", mail.template)
+			case <-time.After(time.Second):
+				t.Fatal("magic link email was not sent")
+			}
+
+			if assert.Len(t, tokens.issued, 1) {
+				assert.Equal(t, auth.ScopeMagicLink, tokens.issued[0].Scope)
+				assert.WithinDuration(t, time.Now().Add(15*time.Minute), tokens.issued[0].Expiry, time.Second)
+			}
+
+			assert.Equal(t, []string{audit.ActionMagicLinkRequested}, auditLog.actions())
+		})
+	}
+}
+
+func TestLogin_MagicLinkHandlerLimitsIP(t *testing.T) {
+	login := newMagicLinkLogin(&MockUserRepository{err: mongo.ErrNoDocuments}, &MockScopedTokenRepository{},
+		newMockAttemptsRepository(), &MockMailer{}, &MockAuditLog{})
+	login.cfg.MagicLink.IPLimit = 2
+
+	codes := make([]int, 0, 3)
+	for _, email := range []string{"a@test.com", "b@test.com", "c@test.com"} {
+		recorder := httptest.NewRecorder()
+		body, _ := json.Marshal(map[string]string{"email": email})
+		req, _ := http.NewRequest(http.MethodPost, "/v1/login/magic-link", bytes.NewReader(body))
+		req.RemoteAddr = "203.0.113.7:1234"
+		login.MagicLinkHandler(recorder, req)
+
+		codes = append(codes, recorder.Code)
+	}
+
+	assert.Equal(t, []int{http.StatusAccepted, http.StatusAccepted, http.StatusTooManyRequests}, codes)
+}
+
+func TestLogin_MagicLinkExchangeHandler(t *testing.T) {
+	testCases := []struct {
+		Name       string
+		tokens     *MockScopedTokenRepository
+		user       *data.User
+		token      string
+		statusCode int
+		activated  bool
+		challenge  bool
+		audit      string
+	}{
+		{
+			Name:       "Malformed Token",
+			tokens:     &MockScopedTokenRepository{email: "test@test.com"},
+			user:       &data.User{Email: "test@test.com", Activated: true},
+			token:      "short",
+			statusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:       "Used Or Expired Token",
+			tokens:     &MockScopedTokenRepository{},
+			user:       &data.User{Email: "test@test.com", Activated: true},
+			token:      "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+			statusCode: http.StatusUnprocessableEntity,
+			audit:      audit.ActionMagicLinkRejected,
+		},
+		{
+			Name:       "Logged In",
+			tokens:     &MockScopedTokenRepository{email: "test@test.com"},
+			user:       &data.User{Email: "test@test.com", Activated: true},
+			token:      "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+			statusCode: http.StatusOK,
+			audit:      audit.ActionMagicLinkLogin,
+		},
+		{
+			Name:       "Activates Account",
+			tokens:     &MockScopedTokenRepository{email: "new@test.com"},
+			user:       &data.User{Email: "new@test.com"},
+			token:      "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+			statusCode: http.StatusOK,
+			activated:  true,
+			audit:      audit.ActionMagicLinkLogin,
+		},
+		{
+			Name:       "Two-Factor Still Required",
+			tokens:     &MockScopedTokenRepository{email: "test@test.com"},
+			user:       &data.User{Email: "test@test.com", Activated: true, TOTPEnabled: true},
+			token:      "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+			statusCode: http.StatusOK,
+			challenge:  true,
+			audit:      audit.ActionMagicLinkLogin,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			auditLog := &MockAuditLog{}
+			login := newMagicLinkLogin(&MockUserRepository{user: tc.user}, tc.tokens, newMockAttemptsRepository(),
+				&MockMailer{}, auditLog)
+			loginRepo := login.repo.(*MockLoginRepository)
+			tokenRepo := login.tokenRepo.(*MockTokenRepository)
+
+			recorder := httptest.NewRecorder()
+			body, _ := json.Marshal(map[string]string{"token": tc.token})
+			req, _ := http.NewRequest(http.MethodPost, "/v1/login/magic-link/exchange", bytes.NewReader(body))
+			login.MagicLinkExchangeHandler(recorder, req)
+
+			assert.Equal(t, tc.statusCode, recorder.Code)
+			assert.Equal(t, tc.activated, loginRepo.user.Activated)
+			assert.Equal(t, tc.activated, len(login.trials.(*MockTrials).started) == 1)
+
+			if tc.audit == "" {
+				assert.Empty(t, auditLog.events)
+			} else {
+				assert.Equal(t, []string{tc.audit}, auditLog.actions())
+			}
+
+			if tc.statusCode != http.StatusOK {
+				return
+			}
+
+			var response Response
+			assert.NoError(t, json.NewDecoder(recorder.Body).Decode(&response))
+
+			if tc.challenge {
+				assert.True(t, response.TwoFactorRequired)
+				assert.Empty(t, response.Token)
+
+				return
+			}
+
+			assert.Equal(t, "access-token", response.Token)
+			assert.Equal(t, []string{auth.AuthMethodMagicLink}, tokenRepo.methods)
+		})
+	}
+}
@@ -0,0 +1,329 @@
+package login
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/mongo"
+	"interviews/internal/auth"
+	"interviews/internal/oidc"
+	data "interviews/internal/users"
+	validator "interviews/pkg/vaildator"
+	"net/http"
+	"time"
+)
+
+var (
+	ErrUnverifiedEmail = errors.New("email address is not verified by the identity provider")
+	ErrIdentityInUse   = errors.New("this account is already linked to another user")
+)
+
+// writeLoginResponse issues the access token, or the two-factor challenge when
+// the user has it enabled.
+func (l *Login) writeLoginResponse(ctx context.Context, w http.ResponseWriter, r *http.Request,
+	user *data.User, methods ...string) {
+	if user.Suspended {
+		l.e.AccountSuspendedResponse(w, r)
+
+		return
+	}
+
+	if user.TOTPEnabled {
+		l.writeTwoFactorChallenge(ctx, w, r, user)
+
+		return
+	}
+
+	token, err := l.tokenRepo.GenerateJWT(ctx, user, auth.DeviceFromRequest(r), methods...)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	response := Response{
+		Success: true,
+		Message: "Login successful",
+		User:    user.Email,
+		Token:   token,
+	}
+
+	err = l.deliverToken(w, &response)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// deliverToken moves the access token of the response into an HttpOnly cookie
+// when cookie mode is on, so scripts never see it. The response carries the
+// CSRF token instead.
+func (l *Login) deliverToken(w http.ResponseWriter, response *Response) error {
+	if !l.cfg.Cookies.Enabled {
+		return nil
+	}
+
+	csrf, err := auth.SetSessionCookies(w, l.cfg, response.Token)
+	if err != nil {
+		return err
+	}
+
+	response.Token = ""
+	response.CSRFToken = csrf
+
+	return nil
+}
+
+// externalLogin finds the user of a provider identity. Unknown identities are
+// linked to the account with the same email, or get a new account, but only if
+// the provider verified the email.
+func (l *Login) externalLogin(ctx context.Context, identity *oidc.Identity) (*data.User, error) {
+	user, err := l.repo.GetByIdentity(ctx, identity.Provider, identity.Subject)
+	if err == nil {
+		return user, nil
+	}
+
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, ErrUnverifiedEmail
+	}
+
+	linked := data.Identity{
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+		LinkedAt: time.Now(),
+	}
+
+	user, err = l.userRepo.GetByEmail(identity.Email)
+	switch {
+	case err == nil:
+		err = l.repo.LinkIdentity(ctx, user.Email, linked)
+		if err != nil {
+			return nil, err
+		}
+
+		return user, nil
+	case errors.Is(err, mongo.ErrNoDocuments):
+		// the provider already confirmed the user owns the address
+		user, err = l.repo.RegisterExternal(ctx, identity.Name, identity.Email, true, linked)
+		if err != nil {
+			return nil, err
+		}
+
+		l.startTrial(ctx, user.Email)
+
+		return user, nil
+	default:
+		return nil, err
+	}
+}
+
+func (l *Login) oidcErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, oidc.ErrUnknownProvider):
+		l.e.NotFoundResponse(w, r)
+	case errors.Is(err, ErrUnverifiedEmail), errors.Is(err, ErrIdentityLinked), errors.Is(err, ErrIdentityInUse):
+		l.e.BadRequestResponse(w, r, err)
+	case errors.Is(err, oidc.ErrInvalidState),
+		errors.Is(err, oidc.ErrInvalidIDToken),
+		errors.Is(err, oidc.ErrInvalidIssuer),
+		errors.Is(err, oidc.ErrInvalidAud),
+		errors.Is(err, oidc.ErrExpiredIDToken),
+		errors.Is(err, oidc.ErrInvalidNonce),
+		errors.Is(err, oidc.ErrUnknownKey),
+		errors.Is(err, oidc.ErrTokenExchange),
+		errors.Is(err, oidc.ErrMissingIDToken):
+		l.e.InvalidCredentialsResponse(w, r)
+	default:
+		l.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+// OIDCAuthorizeHandler returns the provider URL the frontend sends the user to.
+func (l *Login) OIDCAuthorizeHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	provider := mux.Vars(r)["provider"]
+
+	authURL, err := l.oidc.AuthCodeURL(ctx, provider)
+	if err != nil {
+		l.oidcErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = l.helper.WriteJSON(w, http.StatusOK, envelope{"authorization_url": authURL}, nil)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+// OIDCCallbackHandler completes the authorization code flow with the code and
+// state the provider redirected the user back with.
+func (l *Login) OIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	provider := mux.Vars(r)["provider"]
+
+	var input struct {
+		Code  string `json:"code"`
+		State string `json:"state"`
+	}
+
+	err := l.helper.ReadJSON(w, r, &input)
+	if err != nil {
+		l.e.BadRequestResponse(w, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Code != "", "code", "must be provided")
+	v.Check(input.State != "", "state", "must be provided")
+
+	if !v.Valid() {
+		l.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	identity, err := l.oidc.Exchange(ctx, provider, input.Code, input.State)
+	if err != nil {
+		l.oidcErrorResponse(w, r, err)
+
+		return
+	}
+
+	user, err := l.externalLogin(ctx, identity)
+	if err != nil {
+		l.oidcErrorResponse(w, r, err)
+
+		return
+	}
+
+	l.writeLoginResponse(ctx, w, r, user)
+}
+
+// OIDCTokenLoginHandler logs in with an ID token the client got from the
+// provider itself.
+func (l *Login) OIDCTokenLoginHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	provider := mux.Vars(r)["provider"]
+
+	var input struct {
+		IDToken string `json:"id_token"`
+	}
+
+	err := l.helper.ReadJSON(w, r, &input)
+	if err != nil {
+		l.e.BadRequestResponse(w, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.IDToken != "", "id_token", "must be provided")
+
+	if !v.Valid() {
+		l.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	identity, err := l.oidc.VerifyIDToken(ctx, provider, input.IDToken)
+	if err != nil {
+		l.oidcErrorResponse(w, r, err)
+
+		return
+	}
+
+	user, err := l.externalLogin(ctx, identity)
+	if err != nil {
+		l.oidcErrorResponse(w, r, err)
+
+		return
+	}
+
+	l.writeLoginResponse(ctx, w, r, user)
+}
+
+// LinkIdentityHandler links a provider account to the current user, e.g. when
+// it uses a different email address.
+func (l *Login) LinkIdentityHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	user := l.userCtx.ContextGetUser(r)
+	provider := mux.Vars(r)["provider"]
+
+	var input struct {
+		IDToken string `json:"id_token"`
+	}
+
+	err := l.helper.ReadJSON(w, r, &input)
+	if err != nil {
+		l.e.BadRequestResponse(w, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.IDToken != "", "id_token", "must be provided")
+
+	if !v.Valid() {
+		l.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	identity, err := l.oidc.VerifyIDToken(ctx, provider, input.IDToken)
+	if err != nil {
+		l.oidcErrorResponse(w, r, err)
+
+		return
+	}
+
+	owner, err := l.repo.GetByIdentity(ctx, identity.Provider, identity.Subject)
+	if err == nil && owner.Email != user.Email {
+		l.oidcErrorResponse(w, r, ErrIdentityInUse)
+
+		return
+	}
+
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		l.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	linked := data.Identity{
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+		LinkedAt: time.Now(),
+	}
+
+	err = l.repo.LinkIdentity(ctx, user.Email, linked)
+	if err != nil {
+		l.oidcErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = l.helper.WriteJSON(w, http.StatusOK, envelope{"identity": linked}, nil)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,187 @@
+package login
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	data "interviews/internal/users"
+)
+
+func oidcRequest(method, target, provider string, body any) *http.Request {
+	requestBodyBytes, _ := json.Marshal(body)
+	req, _ := http.NewRequest(method, target, bytes.NewReader(requestBodyBytes))
+
+	return mux.SetURLVars(req, map[string]string{"provider": provider})
+}
+
+func TestLogin_OIDCTokenLoginHandler(t *testing.T) {
+	testCases := []struct {
+		Name       string
+		provider   string
+		idToken    string
+		user       *data.User
+		statusCode int
+		identities int
+	}{
+		{
+			Name:       "Unknown Provider",
+			provider:   "unknown",
+			idToken:    "valid_mock_token",
+			statusCode: http.StatusNotFound,
+		},
+		{
+			Name:       "Invalid ID Token",
+			provider:   "google",
+			idToken:    "forged",
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			Name:       "New User Is Registered",
+			provider:   "google",
+			idToken:    "valid_mock_token",
+			statusCode: http.StatusOK,
+			identities: 1,
+		},
+		{
+			Name:       "Existing User Is Linked By Email",
+			provider:   "google",
+			idToken:    "valid_mock_token",
+			user:       &data.User{Email: "johndoe@example.com"},
+			statusCode: http.StatusOK,
+			identities: 1,
+		},
+		{
+			Name:     "Linked User Logs In",
+			provider: "google",
+			idToken:  "valid_mock_token",
+			user: &data.User{
+				Email:      "john.doe@work.example.com",
+				Identities: []data.Identity{{Provider: "google", Subject: "1234567890"}},
+			},
+			statusCode: http.StatusOK,
+			identities: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			repo := &MockLoginRepository{user: tc.user}
+			userRepo := &MockUserRepository{}
+			if tc.user != nil && tc.user.Email == "johndoe@example.com" {
+				userRepo.user = tc.user
+			}
+
+			trials := &MockTrials{}
+
+			l := &Login{
+				repo:      repo,
+				userRepo:  userRepo,
+				tokenRepo: &MockTokenRepository{token: "testtoken"},
+				oidc:      &MockOIDC{},
+				trials:    trials,
+			}
+
+			recorder := httptest.NewRecorder()
+			l.OIDCTokenLoginHandler(recorder, oidcRequest(http.MethodPost, "/v1/oidc/token", tc.provider,
+				map[string]string{"id_token": tc.idToken}))
+
+			assert.Equal(t, tc.statusCode, recorder.Code)
+
+			if tc.statusCode == http.StatusOK {
+				var response Response
+				require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+				assert.Equal(t, "testtoken", response.Token)
+				assert.Len(t, repo.user.Identities, tc.identities)
+			}
+
+			// only new users get a trial
+			assert.Equal(t, tc.user == nil && tc.statusCode == http.StatusOK, len(trials.started) == 1)
+		})
+	}
+}
+
+func TestLogin_OIDCAuthorizationCodeFlow(t *testing.T) {
+	l := &Login{
+		repo:      &MockLoginRepository{},
+		userRepo:  &MockUserRepository{},
+		tokenRepo: &MockTokenRepository{token: "testtoken"},
+		oidc:      &MockOIDC{},
+		trials:    &MockTrials{},
+	}
+
+	recorder := httptest.NewRecorder()
+	l.OIDCAuthorizeHandler(recorder, oidcRequest(http.MethodGet, "/v1/oidc/authorize", "unknown", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	l.OIDCAuthorizeHandler(recorder, oidcRequest(http.MethodGet, "/v1/oidc/authorize", "google", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "authorization_url")
+
+	recorder = httptest.NewRecorder()
+	l.OIDCCallbackHandler(recorder, oidcRequest(http.MethodPost, "/v1/oidc/callback", "google",
+		map[string]string{"code": "valid_code", "state": "tampered"}))
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	l.OIDCCallbackHandler(recorder, oidcRequest(http.MethodPost, "/v1/oidc/callback", "google",
+		map[string]string{"code": "valid_code", "state": "state"}))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
+
+func TestLogin_LinkIdentityHandler(t *testing.T) {
+	testCases := []struct {
+		Name       string
+		owner      *data.User
+		statusCode int
+	}{
+		{
+			Name:       "Identity Linked",
+			statusCode: http.StatusOK,
+		},
+		{
+			Name: "Identity Belongs To Another User",
+			owner: &data.User{
+				Email:      "someone@else.com",
+				Identities: []data.Identity{{Provider: "google", Subject: "1234567890"}},
+			},
+			statusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			current := &data.User{Email: "test@test.com", Activated: true}
+
+			repo := &MockLoginRepository{user: current}
+			if tc.owner != nil {
+				repo.user = tc.owner
+			}
+
+			l := &Login{
+				repo: repo,
+				oidc: &MockOIDC{},
+			}
+
+			req := oidcRequest(http.MethodPost, "/v1/me/identities", "google", map[string]string{"id_token": "valid_mock_token"})
+			req = l.userCtx.ContextSetUser(req, current)
+
+			recorder := httptest.NewRecorder()
+			l.LinkIdentityHandler(recorder, req)
+
+			assert.Equal(t, tc.statusCode, recorder.Code)
+
+			if tc.owner == nil {
+				assert.Len(t, current.Identities, 1)
+			}
+		})
+	}
+}
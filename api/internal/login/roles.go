@@ -0,0 +1,90 @@
+package login
+
+import (
+	"context"
+	"errors"
+	"interviews/internal/audit"
+	data "interviews/internal/users"
+	validator "interviews/pkg/vaildator"
+	"net/http"
+	"strings"
+)
+
+var ErrOwnRole = errors.New("you can't change your own role")
+
+// roleResponse is what an admin gets back about the user whose role changed,
+// never the stored user with its credentials.
+type roleResponse struct {
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+	Activated bool   `json:"activated"`
+}
+
+// UpdateRoleHandler assigns a role to a user. It takes effect on the user's
+// next request since the role is loaded with the user on every request.
+func (l *Login) UpdateRoleHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	var input struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+
+	err := l.helper.ReadJSON(w, r, &input)
+	if err != nil {
+		l.e.BadRequestResponse(w, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	l.validateEmail(v, input.Email)
+	v.Check(data.ValidRole(input.Role), "role", "must be one of user, instructor or admin")
+
+	if !v.Valid() {
+		l.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	user, err := l.changeRole(ctx, r, input.Email, input.Role)
+	if err != nil {
+		l.adminActionErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = l.helper.WriteJSON(w, http.StatusOK, envelope{"user": roleResponse{
+		Email:     user.Email,
+		Role:      user.Role,
+		Activated: user.Activated,
+	}}, nil)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (l *Login) changeRole(ctx context.Context, r *http.Request, email, role string) (*data.User, error) {
+	// an admin demoting themselves could leave nobody to manage users
+	if strings.EqualFold(email, l.userCtx.ContextGetUser(r).Email) {
+		return nil, ErrOwnRole
+	}
+
+	user, err := l.userRepo.GetByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := user.Role
+	user.Role = role
+
+	err = l.userRepo.UpdateRole(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	l.recordAdminAction(ctx, r, audit.ActionUserRoleChanged, email, map[string]string{"from": previous, "to": role})
+
+	return user, nil
+}
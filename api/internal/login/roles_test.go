@@ -0,0 +1,109 @@
+package login
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	data "interviews/internal/users"
+)
+
+func TestLogin_UpdateRoleHandler(t *testing.T) {
+	testCases := []struct {
+		Name       string
+		email      string
+		role       string
+		target     *data.User
+		statusCode int
+		wantRole   string
+	}{
+		{
+			Name:       "Promote To Instructor",
+			email:      "test@test.com",
+			role:       data.RoleInstructor,
+			target:     &data.User{Email: "test@test.com", Role: data.RoleUser, PassHash: "$argon2id$secret"},
+			statusCode: http.StatusOK,
+			wantRole:   data.RoleInstructor,
+		},
+		{
+			Name:       "Unknown Role",
+			email:      "test@test.com",
+			role:       "superuser",
+			target:     &data.User{Email: "test@test.com", Role: data.RoleUser},
+			statusCode: http.StatusUnprocessableEntity,
+			wantRole:   data.RoleUser,
+		},
+		{
+			Name:       "Own Role",
+			email:      "admin@test.com",
+			role:       data.RoleUser,
+			target:     &data.User{Email: "admin@test.com", Role: data.RoleAdmin},
+			statusCode: http.StatusBadRequest,
+			wantRole:   data.RoleAdmin,
+		},
+		{
+			Name:       "User Not Found",
+			email:      "missing@test.com",
+			role:       data.RoleAdmin,
+			statusCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			l := &Login{
+				userRepo: &MockUserRepository{user: tc.target},
+				audit:    &MockAuditLog{},
+			}
+
+			body, _ := json.Marshal(map[string]string{"email": tc.email, "role": tc.role})
+			req, _ := http.NewRequest(http.MethodPut, "/v1/admin/users/role", bytes.NewReader(body))
+			req = l.userCtx.ContextSetUser(req, &data.User{Email: "admin@test.com", Role: data.RoleAdmin})
+
+			recorder := httptest.NewRecorder()
+			l.UpdateRoleHandler(recorder, req)
+
+			assert.Equal(t, tc.statusCode, recorder.Code)
+			assert.NotContains(t, recorder.Body.String(), "secret")
+
+			if tc.target != nil {
+				assert.Equal(t, tc.wantRole, tc.target.Role)
+			}
+		})
+	}
+}
+
+func TestUser_HasPermission(t *testing.T) {
+	testCases := []struct {
+		role       string
+		permission string
+		want       bool
+	}{
+		{role: data.RoleAdmin, permission: data.PermissionUsersAdmin, want: true},
+		{role: data.RoleAdmin, permission: data.PermissionCoursesWrite, want: true},
+		{role: data.RoleInstructor, permission: data.PermissionCoursesWrite, want: true},
+		{role: data.RoleInstructor, permission: data.PermissionUsersAdmin, want: false},
+		{role: data.RoleAdmin, permission: data.PermissionUsersImpersonate, want: true},
+		{role: data.RoleInstructor, permission: data.PermissionUsersImpersonate, want: false},
+		{role: data.RoleUser, permission: data.PermissionCoursesWrite, want: false},
+		{role: "", permission: data.PermissionCoursesWrite, want: false},
+	}
+
+	for _, tc := range testCases {
+		user := &data.User{Role: tc.role}
+		assert.Equal(t, tc.want, user.HasPermission(tc.permission), "%q %s", tc.role, tc.permission)
+	}
+
+	assert.False(t, data.AnonymousUser.HasPermission(data.PermissionCoursesWrite))
+
+	// a service only has the permissions of its API key, whatever its role
+	service := &data.User{Role: data.RoleAdmin, Service: &data.ServicePrincipal{
+		Permissions: []string{data.PermissionCoursesWrite},
+	}}
+	assert.True(t, service.HasPermission(data.PermissionCoursesWrite))
+	assert.False(t, service.HasPermission(data.PermissionUsersAdmin))
+}
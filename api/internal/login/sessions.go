@@ -0,0 +1,152 @@
+package login
+
+import (
+	"context"
+	"errors"
+	"github.com/gorilla/mux"
+	"interviews/internal/auth"
+	validator "interviews/pkg/vaildator"
+	"net/http"
+)
+
+// ListSessionsHandler lists the devices the user is logged in on.
+func (l *Login) ListSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	user := l.userCtx.ContextGetUser(r)
+
+	sessions, err := l.sessions.ListForUser(ctx, user.Email)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	current := l.userCtx.ContextGetSessionID(r)
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == current
+	}
+
+	err = l.helper.WriteJSON(w, http.StatusOK, envelope{"sessions": sessions}, nil)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+// DeleteSessionHandler signs the user out on one device.
+func (l *Login) DeleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	user := l.userCtx.ContextGetUser(r)
+
+	err := l.sessions.Delete(ctx, user.Email, mux.Vars(r)["id"])
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrSessionNotFound):
+			l.e.NotFoundResponse(w, r)
+		default:
+			l.e.ServerErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	err = l.helper.WriteJSON(w, http.StatusOK, envelope{"message": "session ended"}, nil)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+// DeleteOtherSessionsHandler signs the user out everywhere but the device the
+// request comes from.
+func (l *Login) DeleteOtherSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	user := l.userCtx.ContextGetUser(r)
+
+	ended, err := l.sessions.DeleteOthers(ctx, user.Email, l.userCtx.ContextGetSessionID(r))
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = l.helper.WriteJSON(w, http.StatusOK, envelope{"sessions_ended": ended}, nil)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+// UserSessionsHandler lets admins see where a user is logged in, e.g. to spot
+// a shared account.
+func (l *Login) UserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	email := r.URL.Query().Get("email")
+
+	v := validator.New()
+
+	l.validateEmail(v, email)
+
+	if !v.Valid() {
+		l.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	sessions, err := l.sessions.ListForUser(ctx, email)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = l.helper.WriteJSON(w, http.StatusOK, envelope{"sessions": sessions}, nil)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+// DeleteUserSessionsHandler signs a user out on every device.
+func (l *Login) DeleteUserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	email := r.URL.Query().Get("email")
+
+	v := validator.New()
+
+	l.validateEmail(v, email)
+
+	if !v.Valid() {
+		l.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	ended, err := l.sessions.DeleteAllForUser(ctx, email)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = l.helper.WriteJSON(w, http.StatusOK, envelope{"sessions_ended": ended}, nil)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+// endSession deletes the session of the request's access token, if any.
+func (l *Login) endSession(ctx context.Context, r *http.Request) error {
+	id := l.userCtx.ContextGetSessionID(r)
+	if id == "" {
+		return nil
+	}
+
+	err := l.sessions.Delete(ctx, l.userCtx.ContextGetUser(r).Email, id)
+	if err != nil && !errors.Is(err, auth.ErrSessionNotFound) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package login
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"interviews/internal/auth"
+	data "interviews/internal/users"
+)
+
+type MockSessionRepository struct {
+	sessions []auth.Session
+}
+
+func (m *MockSessionRepository) ListForUser(ctx context.Context, email string) ([]auth.Session, error) {
+	var sessions []auth.Session
+	for _, s := range m.sessions {
+		if s.Email == email {
+			sessions = append(sessions, s)
+		}
+	}
+
+	return sessions, nil
+}
+
+func (m *MockSessionRepository) Delete(ctx context.Context, email, id string) error {
+	for i, s := range m.sessions {
+		if s.ID == id && s.Email == email {
+			m.sessions = append(m.sessions[:i], m.sessions[i+1:]...)
+
+			return nil
+		}
+	}
+
+	return auth.ErrSessionNotFound
+}
+
+func (m *MockSessionRepository) DeleteOthers(ctx context.Context, email, id string) (int64, error) {
+	var kept []auth.Session
+	for _, s := range m.sessions {
+		if s.Email != email || s.ID == id {
+			kept = append(kept, s)
+		}
+	}
+
+	ended := int64(len(m.sessions) - len(kept))
+	m.sessions = kept
+
+	return ended, nil
+}
+
+func (m *MockSessionRepository) DeleteAllForUser(ctx context.Context, email string) (int64, error) {
+	return m.DeleteOthers(ctx, email, "")
+}
+
+func newMockSessionRepository() *MockSessionRepository {
+	return &MockSessionRepository{sessions: []auth.Session{
+		{ID: "laptop", Email: "test@test.com", UserAgent: "Firefox"},
+		{ID: "phone", Email: "test@test.com", UserAgent: "Safari"},
+		{ID: "tablet", Email: "test@test.com", UserAgent: "Chrome"},
+		{ID: "other", Email: "other@test.com", UserAgent: "Edge"},
+	}}
+}
+
+func sessionRequest(l *Login, method, target, sessionID string, user *data.User) *http.Request {
+	req, _ := http.NewRequest(method, target, nil)
+	req = l.userCtx.ContextSetUser(req, user)
+
+	return l.userCtx.ContextSetSessionID(req, sessionID)
+}
+
+func TestLogin_SessionHandlers(t *testing.T) {
+	user := &data.User{Email: "test@test.com", Activated: true}
+	sessions := newMockSessionRepository()
+	l := &Login{sessions: sessions}
+
+	// list marks the session of the request
+	recorder := httptest.NewRecorder()
+	l.ListSessionsHandler(recorder, sessionRequest(l, http.MethodGet, "/v1/me/sessions", "laptop", user))
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var res struct {
+		Sessions []struct {
+			ID      string `json:"id"`
+			Current bool   `json:"current"`
+		} `json:"sessions"`
+	}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+	require.Len(t, res.Sessions, 3)
+	assert.True(t, res.Sessions[0].Current)
+	assert.False(t, res.Sessions[1].Current)
+
+	// another user's session can't be ended
+	req := sessionRequest(l, http.MethodDelete, "/v1/me/sessions/other", "laptop", user)
+	recorder = httptest.NewRecorder()
+	l.DeleteSessionHandler(recorder, mux.SetURLVars(req, map[string]string{"id": "other"}))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+
+	req = sessionRequest(l, http.MethodDelete, "/v1/me/sessions/phone", "laptop", user)
+	recorder = httptest.NewRecorder()
+	l.DeleteSessionHandler(recorder, mux.SetURLVars(req, map[string]string{"id": "phone"}))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Len(t, sessions.sessions, 3)
+
+	// sign out everywhere else
+	recorder = httptest.NewRecorder()
+	l.DeleteOtherSessionsHandler(recorder, sessionRequest(l, http.MethodDelete, "/v1/me/sessions", "laptop", user))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"sessions_ended": 1}`, recorder.Body.String())
+	assert.Equal(t, []auth.Session{
+		{ID: "laptop", Email: "test@test.com", UserAgent: "Firefox"},
+		{ID: "other", Email: "other@test.com", UserAgent: "Edge"},
+	}, sessions.sessions)
+}
+
+func TestLogin_AdminSessionHandlers(t *testing.T) {
+	admin := &data.User{Email: "admin@test.com", Role: data.RoleAdmin, Activated: true}
+	sessions := newMockSessionRepository()
+	l := &Login{sessions: sessions}
+
+	recorder := httptest.NewRecorder()
+	l.UserSessionsHandler(recorder, sessionRequest(l, http.MethodGet, "/v1/admin/users/sessions?email=test@test.com", "", admin))
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var res struct {
+		Sessions []auth.Session `json:"sessions"`
+	}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+	assert.Len(t, res.Sessions, 3)
+
+	recorder = httptest.NewRecorder()
+	l.UserSessionsHandler(recorder, sessionRequest(l, http.MethodGet, "/v1/admin/users/sessions", "", admin))
+	assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	l.DeleteUserSessionsHandler(recorder, sessionRequest(l, http.MethodDelete, "/v1/admin/users/sessions?email=test@test.com", "", admin))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"sessions_ended": 3}`, recorder.Body.String())
+	assert.Len(t, sessions.sessions, 1)
+}
+
+func TestLogin_LogoutEndsSession(t *testing.T) {
+	user := &data.User{Email: "test@test.com", Activated: true}
+	sessions := newMockSessionRepository()
+	l := &Login{sessions: sessions}
+
+	req := sessionRequest(l, http.MethodPost, "/v1/logout", "phone", user)
+	req.Header.Set("Authorization", "Bearer token")
+
+	recorder := httptest.NewRecorder()
+	l.LogoutHandler(recorder, req)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Len(t, sessions.sessions, 3)
+
+	for _, s := range sessions.sessions {
+		assert.NotEqual(t, "phone", s.ID)
+	}
+}
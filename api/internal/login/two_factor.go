@@ -0,0 +1,276 @@
+package login
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"interviews/internal/auth"
+	data "interviews/internal/users"
+	log "interviews/pkg/logger"
+	validator "interviews/pkg/vaildator"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	ErrTwoFactorEnabled = errors.New("two-factor authentication is already enabled")
+)
+
+func twoFactorKey(email string) string {
+	return "2fa:" + strings.ToLower(email)
+}
+
+// writeTwoFactorChallenge answers a login with a correct password but without
+// the second factor yet. The challenge token can only be used on /v1/login/2fa.
+func (l *Login) writeTwoFactorChallenge(ctx context.Context, w http.ResponseWriter, r *http.Request, user *data.User) {
+	challenge, err := l.tokenRepo.GenerateChallengeJWT(ctx, user)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	response := Response{
+		Success:           true,
+		Message:           "two-factor authentication required",
+		User:              user.Email,
+		TwoFactorRequired: true,
+		ChallengeToken:    challenge,
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// EnrollTwoFactorHandler creates a new secret for the current user. It only
+// becomes active once confirmed with a code from the authenticator app.
+func (l *Login) EnrollTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	user := l.userCtx.ContextGetUser(r)
+
+	if user.TOTPEnabled {
+		l.e.BadRequestResponse(w, r, ErrTwoFactorEnabled)
+
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = l.repo.SetPendingTOTP(ctx, user.Email, secret)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	env := envelope{
+		"secret":      secret,
+		"otpauth_uri": auth.TOTPURI(l.cfg.TwoFactor.Issuer, user.Email, secret),
+	}
+
+	err = l.helper.WriteJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+// ConfirmTwoFactorHandler enables two-factor authentication and returns the
+// recovery codes. They are only shown this once.
+func (l *Login) ConfirmTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	user := l.userCtx.ContextGetUser(r)
+
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := l.helper.ReadJSON(w, r, &input)
+	if err != nil {
+		l.e.BadRequestResponse(w, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	auth.ValidateOTP(v, input.Code)
+
+	if !v.Valid() {
+		l.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	if user.TOTPEnabled {
+		l.e.BadRequestResponse(w, r, ErrTwoFactorEnabled)
+
+		return
+	}
+
+	if user.TOTPPendingSecret == "" {
+		v.AddError("code", "two-factor enrollment has not been started")
+		l.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	step, err := auth.ValidateTOTP(user.TOTPPendingSecret, input.Code, time.Now())
+	if err != nil {
+		v.AddError("code", "invalid code")
+		l.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	codes, hashes, err := auth.GenerateRecoveryCodes()
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = l.repo.EnableTOTP(ctx, user.Email, user.TOTPPendingSecret, step, hashes)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	env := envelope{
+		"message":        "two-factor authentication enabled",
+		"recovery_codes": codes,
+	}
+
+	err = l.helper.WriteJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+// LoginTwoFactorHandler trades a challenge token and a code, or one of the
+// recovery codes, in for an access token.
+func (l *Login) LoginTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+	clog := log.GetLoggerFromContext(ctx)
+
+	var input struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+
+	err := l.helper.ReadJSON(w, r, &input)
+	if err != nil {
+		l.e.BadRequestResponse(w, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.ChallengeToken != "", "challenge_token", "must be provided")
+
+	if input.RecoveryCode == "" {
+		auth.ValidateOTP(v, input.Code)
+	}
+
+	if !v.Valid() {
+		l.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	claims, err := l.tokenRepo.ValidateChallengeToken(input.ChallengeToken)
+	if err != nil {
+		l.e.InvalidAuthenticationTokenResponse(w, r)
+
+		return
+	}
+
+	// the challenge token stays valid for a few minutes, so guessing codes
+	// is throttled like guessing passwords
+	attempt, err := l.attempts.Get(ctx, twoFactorKey(claims.Email))
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	if wait := l.retryAfter(attempt, 1, time.Now()); wait > 0 {
+		l.e.LoginThrottledResponse(w, r, wait)
+
+		return
+	}
+
+	user, err := l.userRepo.GetByEmail(claims.Email)
+	if err != nil || !user.TOTPEnabled {
+		l.e.InvalidAuthenticationTokenResponse(w, r)
+
+		return
+	}
+
+	if input.RecoveryCode != "" {
+		err = l.repo.UseRecoveryCode(ctx, user.Email, auth.HashRecoveryCode(input.RecoveryCode))
+	} else {
+		var step int64
+
+		step, err = auth.ValidateTOTP(user.TOTPSecret, input.Code, time.Now())
+		if err == nil {
+			err = l.repo.UseTOTPStep(ctx, user.Email, step)
+		}
+	}
+
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidOTP), errors.Is(err, ErrCodeUsed):
+			lockout := l.cfg.Lockout
+
+			_, err = l.attempts.RecordFailure(ctx, twoFactorKey(user.Email), lockout.Threshold, lockout.Duration, lockout.Window)
+			if err != nil {
+				clog.ErrorCtx(err, log.Ctx{"msg": "unable to record failed two-factor login"})
+			}
+
+			l.e.InvalidCredentialsResponse(w, r)
+		default:
+			l.e.ServerErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	err = l.attempts.Reset(ctx, twoFactorKey(user.Email))
+	if err != nil {
+		clog.ErrorCtx(err, log.Ctx{"msg": "unable to reset failed two-factor logins"})
+	}
+
+	token, err := l.tokenRepo.GenerateJWT(ctx, user, auth.DeviceFromRequest(r), auth.AuthMethodPassword, auth.AuthMethodOTP)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	response := Response{
+		Success: true,
+		Message: "Login successful",
+		User:    user.Email,
+		Token:   token,
+	}
+
+	err = l.deliverToken(w, &response)
+	if err != nil {
+		l.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
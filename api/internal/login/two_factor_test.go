@@ -0,0 +1,179 @@
+package login
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"interviews/internal/auth"
+	data "interviews/internal/users"
+	"interviews/internal/users/userstest"
+)
+
+func TestLogin_TwoFactorFlow(t *testing.T) {
+	user := &data.User{Email: "test@test.com", Activated: true}
+	tokenRepo := &MockTokenRepository{token: "testtoken"}
+
+	l := &Login{
+		repo:      &MockLoginRepository{user: user},
+		userRepo:  &MockUserRepository{user: user},
+		tokenRepo: tokenRepo,
+		attempts:  newMockAttemptsRepository(),
+	}
+	l.cfg.TwoFactor.Issuer = "Interviews"
+	l.cfg.Lockout.Threshold = 5
+	l.cfg.Lockout.Duration = time.Minute
+	l.cfg.Lockout.Window = time.Hour
+
+	// enroll
+	recorder := httptest.NewRecorder()
+	l.EnrollTwoFactorHandler(recorder, userstest.NewRequest(t, http.MethodPost, "/v1/me/2fa/enroll", user, nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var enrollment struct {
+		Secret string `json:"secret"`
+		URI    string `json:"otpauth_uri"`
+	}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &enrollment))
+	assert.Equal(t, user.TOTPPendingSecret, enrollment.Secret)
+	assert.Contains(t, enrollment.URI, "otpauth://totp/Interviews:test@test.com")
+	assert.False(t, user.TOTPEnabled)
+
+	// confirm with a wrong code
+	recorder = httptest.NewRecorder()
+	l.ConfirmTwoFactorHandler(recorder, userstest.NewRequest(t, http.MethodPost, "/v1/me/2fa/confirm", user, map[string]string{"code": "000000"}))
+	assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+	assert.False(t, user.TOTPEnabled)
+
+	// confirm with the current code
+	code, err := auth.TOTPCode(enrollment.Secret, time.Now().Add(-30*time.Second))
+	require.NoError(t, err)
+
+	recorder = httptest.NewRecorder()
+	l.ConfirmTwoFactorHandler(recorder, userstest.NewRequest(t, http.MethodPost, "/v1/me/2fa/confirm", user, map[string]string{"code": code}))
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var confirmation struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &confirmation))
+	assert.Len(t, confirmation.RecoveryCodes, 10)
+	assert.True(t, user.TOTPEnabled)
+	assert.Equal(t, enrollment.Secret, user.TOTPSecret)
+
+	// the password alone only yields a challenge
+	recorder = httptest.NewRecorder()
+	l.LoginHandler(recorder, userstest.NewRequest(t, http.MethodPost, "/v1/login", nil, map[string]string{
+		"email":    "test@test.com",
+		"password": "password",
+	}))
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var challenge Response
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &challenge))
+	assert.True(t, challenge.TwoFactorRequired)
+	assert.Equal(t, "challenge-token", challenge.ChallengeToken)
+	assert.Empty(t, challenge.Token)
+
+	tokenRepo.challenge = &auth.Claims{Email: "test@test.com"}
+
+	// the code used for the confirmation can't be replayed
+	recorder = httptest.NewRecorder()
+	l.LoginTwoFactorHandler(recorder, userstest.NewRequest(t, http.MethodPost, "/v1/login/2fa", nil, map[string]string{
+		"challenge_token": "challenge-token",
+		"code":            code,
+	}))
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+
+	code, err = auth.TOTPCode(user.TOTPSecret, time.Now().Add(30*time.Second))
+	require.NoError(t, err)
+
+	recorder = httptest.NewRecorder()
+	l.LoginTwoFactorHandler(recorder, userstest.NewRequest(t, http.MethodPost, "/v1/login/2fa", nil, map[string]string{
+		"challenge_token": "challenge-token",
+		"code":            code,
+	}))
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var response Response
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.Equal(t, "testtoken", response.Token)
+	assert.Equal(t, []string{auth.AuthMethodPassword, auth.AuthMethodOTP}, tokenRepo.methods)
+
+	// a recovery code works exactly once
+	for _, status := range []int{http.StatusOK, http.StatusUnauthorized} {
+		recorder = httptest.NewRecorder()
+		l.LoginTwoFactorHandler(recorder, userstest.NewRequest(t, http.MethodPost, "/v1/login/2fa", nil, map[string]string{
+			"challenge_token": "challenge-token",
+			"recovery_code":   confirmation.RecoveryCodes[0],
+		}))
+		assert.Equal(t, status, recorder.Code)
+	}
+	assert.Len(t, user.RecoveryCodes, 9)
+}
+
+func TestLogin_LoginTwoFactorHandler(t *testing.T) {
+	secret, err := auth.GenerateTOTPSecret()
+	require.NoError(t, err)
+
+	testCases := []struct {
+		Name       string
+		challenge  *auth.Claims
+		attempt    *Attempt
+		body       map[string]string
+		statusCode int
+	}{
+		{
+			Name:       "Missing Code",
+			challenge:  &auth.Claims{Email: "test@test.com"},
+			body:       map[string]string{"challenge_token": "challenge-token"},
+			statusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:       "Invalid Challenge Token",
+			body:       map[string]string{"challenge_token": "access-token", "code": "123456"},
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			Name:       "Wrong Code",
+			challenge:  &auth.Claims{Email: "test@test.com"},
+			body:       map[string]string{"challenge_token": "challenge-token", "code": "abcdef"},
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			Name:       "Too Many Wrong Codes",
+			challenge:  &auth.Claims{Email: "test@test.com"},
+			attempt:    &Attempt{Failures: 5, LockedUntil: time.Now().Add(time.Minute)},
+			body:       map[string]string{"challenge_token": "challenge-token", "code": "123456"},
+			statusCode: http.StatusTooManyRequests,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			user := &data.User{Email: "test@test.com", TOTPEnabled: true, TOTPSecret: secret}
+			attempts := newMockAttemptsRepository()
+			if tc.attempt != nil {
+				attempts.attempts[twoFactorKey(user.Email)] = tc.attempt
+			}
+
+			l := &Login{
+				repo:      &MockLoginRepository{user: user},
+				userRepo:  &MockUserRepository{user: user},
+				tokenRepo: &MockTokenRepository{token: "testtoken", challenge: tc.challenge},
+				attempts:  attempts,
+			}
+			l.cfg.Lockout.Threshold = 5
+
+			recorder := httptest.NewRecorder()
+			l.LoginTwoFactorHandler(recorder, userstest.NewRequest(t, http.MethodPost, "/v1/login/2fa", nil, tc.body))
+
+			assert.Equal(t, tc.statusCode, recorder.Code)
+		})
+	}
+}
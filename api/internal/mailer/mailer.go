@@ -0,0 +1,137 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"mime"
+	"mime/multipart"
+	"net/smtp"
+	"net/textproto"
+	"time"
+)
+
+//go:embed "templates"
+var templateFS embed.FS
+
+type Mailer struct {
+	addr   string
+	auth   smtp.Auth
+	sender string
+	send   func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+	sleep  func(d time.Duration)
+}
+
+const (
+	sendAttempts  = 3
+	retryDelay    = 500 * time.Millisecond
+	maxRetryDelay = 2 * time.Second
+)
+
+// Send renders the subject, plainBody and htmlBody templates of templateFile
+// and delivers them as a multipart/alternative message.
+func (m Mailer) Send(recipient, templateFile string, data any) error {
+	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return err
+	}
+
+	subject := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return err
+	}
+
+	plainBody := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
+	if err != nil {
+		return err
+	}
+
+	htmlBody := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	if err != nil {
+		return err
+	}
+
+	msg, err := m.message(recipient, subject.String(), plainBody.Bytes(), htmlBody.Bytes())
+	if err != nil {
+		return err
+	}
+
+	// retry a couple of times as SMTP relays tend to drop connections
+	delay := retryDelay
+
+	for i := 1; i <= sendAttempts; i++ {
+		err = m.send(m.addr, m.auth, m.sender, []string{recipient}, msg)
+		if err == nil {
+			return nil
+		}
+
+		if i < sendAttempts {
+			m.sleep(delay)
+
+			delay *= 2
+			if delay > maxRetryDelay {
+				delay = maxRetryDelay
+			}
+		}
+	}
+
+	return err
+}
+
+func (m Mailer) message(recipient, subject string, plainBody, htmlBody []byte) ([]byte, error) {
+	msg := new(bytes.Buffer)
+	body := multipart.NewWriter(msg)
+
+	fmt.Fprintf(msg, "From: %s\r\n", m.sender)
+	fmt.Fprintf(msg, "To: %s\r\n", recipient)
+	fmt.Fprintf(msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(msg, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", body.Boundary())
+
+	parts := []struct {
+		contentType string
+		content     []byte
+	}{
+		{contentType: "text/plain; charset=utf-8", content: plainBody},
+		{contentType: "text/html; charset=utf-8", content: htmlBody},
+	}
+
+	for _, p := range parts {
+		w, err := body.CreatePart(textproto.MIMEHeader{"Content-Type": {p.contentType}})
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = w.Write(p.content)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return msg.Bytes(), nil
+}
+
+func New(host string, port int, username, password, sender string) Mailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return Mailer{
+		addr:   fmt.Sprintf("%s:%d", host, port),
+		auth:   auth,
+		sender: sender,
+		send:   smtp.SendMail,
+		sleep:  time.Sleep,
+	}
+}
@@ -0,0 +1,129 @@
+package mailer
+
+import (
+	"errors"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// smtpSink is a minimal local SMTP server that hands every received message
+// to the test.
+type smtpSink struct {
+	listener net.Listener
+	messages chan string
+}
+
+func newSMTPSink(t *testing.T) *smtpSink {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	sink := &smtpSink{listener: ln, messages: make(chan string, 1)}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go sink.serve(conn)
+		}
+	}()
+
+	return sink
+}
+
+func (s *smtpSink) serve(conn net.Conn) {
+	defer conn.Close()
+
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 localhost ESMTP sink")
+
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		switch cmd := strings.ToUpper(strings.Fields(line)[0]); cmd {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250-localhost")
+			tp.PrintfLine("250 8BITMIME")
+		case "DATA":
+			tp.PrintfLine("354 end data with <CR><LF>.<CR><LF>")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.messages <- string(data)
+			tp.PrintfLine("250 OK")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("250 OK")
+		}
+	}
+}
+
+func (s *smtpSink) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestMailer_Send(t *testing.T) {
+	sink := newSMTPSink(t)
+
+	m := New("127.0.0.1", sink.port(), "", "", "Interviews <no-reply@interviews.test>")
+
+	err := m.Send("learner@test.com", "password_reset.tmpl", map[string]any{
+		"passwordResetToken": "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+		"passwordResetURL":   "http://localhost:5173/password-reset?token=ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+		"expiresIn":          "45 minutes",
+	})
+	require.NoError(t, err)
+
+	select {
+	case msg := <-sink.messages:
+		assert.Contains(t, msg, "To: learner@test.com")
+		assert.Contains(t, msg, "Subject: Reset your password")
+		assert.Contains(t, msg, "multipart/alternative")
+		assert.Contains(t, msg, "text/html")
+		assert.Contains(t, msg, "ABCDEFGHIJKLMNOPQRSTUVWXYZ")
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message received by the smtp sink")
+	}
+}
+
+func TestMailer_SendUnknownTemplate(t *testing.T) {
+	m := New("127.0.0.1", 0, "", "", "no-reply@interviews.test")
+
+	err := m.Send("learner@test.com", "does_not_exist.tmpl", nil)
+	assert.Error(t, err)
+}
+
+func TestMailer_SendRetries(t *testing.T) {
+	m := New("127.0.0.1", 0, "", "", "no-reply@interviews.test")
+
+	var attempts int
+	var delays []time.Duration
+
+	m.send = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		attempts++
+
+		return errors.New("connection reset")
+	}
+	m.sleep = func(d time.Duration) { delays = append(delays, d) }
+
+	err := m.Send("learner@test.com", "password_reset.tmpl", nil)
+	assert.Error(t, err)
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, []time.Duration{500 * time.Millisecond, time.Second}, delays)
+}
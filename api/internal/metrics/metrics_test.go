@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+func scrape(t *testing.T, m *Metrics) string {
+	t.Helper()
+
+	rr := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	return rr.Body.String()
+}
+
+func TestMetrics(t *testing.T) {
+	m := New()
+
+	m.ObserveRequest("/v1/course/{id}", http.MethodGet, http.StatusOK, 20*time.Millisecond)
+	m.ObserveRequest("/v1/course/{id}", http.MethodGet, http.StatusOK, 30*time.Millisecond)
+	m.RateLimited()
+
+	monitor := m.CommandMonitor()
+	monitor.Succeeded(context.Background(), &event.CommandSucceededEvent{
+		CommandFinishedEvent: event.CommandFinishedEvent{CommandName: "find", Duration: 2 * time.Millisecond},
+	})
+	monitor.Failed(context.Background(), &event.CommandFailedEvent{
+		CommandFinishedEvent: event.CommandFinishedEvent{CommandName: "insert", Duration: time.Millisecond},
+	})
+
+	body := scrape(t, m)
+
+	assert.Contains(t, body, `interviews_http_request_duration_seconds_count{method="GET",route="/v1/course/{id}",status="200"} 2`)
+	assert.Contains(t, body, `interviews_rate_limited_requests_total 1`)
+	assert.Contains(t, body, `interviews_mongo_command_duration_seconds_count{command="find",outcome="success"} 1`)
+	assert.Contains(t, body, `interviews_mongo_command_duration_seconds_count{command="insert",outcome="failure"} 1`)
+	assert.Contains(t, body, `go_goroutines`)
+}
+
+func TestMetrics_NewTwice(t *testing.T) {
+	first := New()
+	second := New()
+
+	first.RateLimited()
+
+	assert.Contains(t, scrape(t, first), `interviews_rate_limited_requests_total 1`)
+	assert.Contains(t, scrape(t, second), `interviews_rate_limited_requests_total 0`)
+}
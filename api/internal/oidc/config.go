@@ -0,0 +1,103 @@
+package oidc
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	ErrIncompleteProvider = errors.New("oidc provider needs an issuer and at least one client id")
+)
+
+// ProviderConfig describes one identity provider. Every provider listed in
+// OIDC_PROVIDERS is configured through OIDC_<NAME>_* environment variables,
+// e.g. OIDC_GOOGLE_CLIENT_IDS.
+type ProviderConfig struct {
+	Name         string
+	Issuer       string
+	AltIssuers   []string // other spellings of the issuer the provider puts into tokens
+	ClientIDs    []string // the first one is used for the code flow, all are accepted as audience
+	ClientSecret string
+	RedirectURL  string
+	JWKSURL      string // only needed when the provider doesn't support discovery
+	Scopes       []string
+}
+
+func (p ProviderConfig) ClientID() string {
+	return p.ClientIDs[0]
+}
+
+var providerDefaults = map[string]ProviderConfig{
+	"google": {
+		Issuer:     "https://accounts.google.com",
+		AltIssuers: []string{"accounts.google.com"},
+	},
+}
+
+// LoadProviderConfigs reads the configuration of the named providers from the
+// environment. The redirect URL defaults to the frontend callback page.
+func LoadProviderConfigs(names []string, frontendURL string) ([]ProviderConfig, error) {
+	configs := make([]ProviderConfig, 0, len(names))
+
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		cfg := providerDefaults[name]
+		cfg.Name = name
+
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+
+		lookup := func(key string, dst *string) {
+			if v, ok := os.LookupEnv(prefix + key); ok {
+				*dst = v
+			}
+		}
+
+		lookupList := func(key string, dst *[]string) {
+			if v, ok := os.LookupEnv(prefix + key); ok {
+				*dst = splitList(v)
+			}
+		}
+
+		lookup("ISSUER", &cfg.Issuer)
+		lookupList("ALT_ISSUERS", &cfg.AltIssuers)
+		lookupList("CLIENT_IDS", &cfg.ClientIDs)
+		lookup("CLIENT_SECRET", &cfg.ClientSecret)
+		lookup("REDIRECT_URL", &cfg.RedirectURL)
+		lookup("JWKS_URL", &cfg.JWKSURL)
+		lookupList("SCOPES", &cfg.Scopes)
+
+		if cfg.Issuer == "" || len(cfg.ClientIDs) == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrIncompleteProvider, name)
+		}
+
+		if cfg.RedirectURL == "" {
+			cfg.RedirectURL = fmt.Sprintf("%s/oidc/%s/callback", frontendURL, name)
+		}
+
+		if len(cfg.Scopes) == 0 {
+			cfg.Scopes = []string{"openid", "email", "profile"}
+		}
+
+		configs = append(configs, cfg)
+	}
+
+	return configs, nil
+}
+
+func splitList(s string) []string {
+	var list []string
+
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
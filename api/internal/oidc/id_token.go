@@ -0,0 +1,131 @@
+package oidc
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrInvalidIDToken = errors.New("invalid id token")
+	ErrInvalidIssuer  = errors.New("id token issuer is invalid")
+	ErrInvalidAud     = errors.New("id token audience is invalid")
+	ErrExpiredIDToken = errors.New("id token has expired")
+	ErrInvalidNonce   = errors.New("id token nonce is invalid")
+)
+
+// audience accepts the aud claim both as a single string and as an array.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+
+	*a = list
+
+	return nil
+}
+
+func (a audience) contains(values []string) bool {
+	for _, aud := range a {
+		for _, v := range values {
+			if aud == v {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// flexBool accepts booleans that some providers send as strings.
+type flexBool bool
+
+func (f *flexBool) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		v, err := strconv.ParseBool(s)
+		*f = flexBool(v)
+		return err
+	}
+
+	var v bool
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	*f = flexBool(v)
+
+	return nil
+}
+
+type IDTokenClaims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	ExpiresAt     int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	NotBefore     int64    `json:"nbf"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified flexBool `json:"email_verified"`
+	Name          string   `json:"name"`
+}
+
+// Valid is called by the jwt parser. The claims are checked by validate instead
+// because that needs the provider configuration.
+func (c *IDTokenClaims) Valid() error {
+	return nil
+}
+
+func (c *IDTokenClaims) validate(cfg ProviderConfig, nonce string, leeway time.Duration, now time.Time) error {
+	if c.Subject == "" || c.ExpiresAt == 0 {
+		return ErrInvalidIDToken
+	}
+
+	issuers := append([]string{cfg.Issuer}, cfg.AltIssuers...)
+
+	validIssuer := false
+	for _, iss := range issuers {
+		if c.Issuer == iss {
+			validIssuer = true
+		}
+	}
+
+	if !validIssuer {
+		return ErrInvalidIssuer
+	}
+
+	if !c.Audience.contains(cfg.ClientIDs) {
+		return ErrInvalidAud
+	}
+
+	skew := int64(leeway / time.Second)
+
+	if now.Unix() > c.ExpiresAt+skew {
+		return ErrExpiredIDToken
+	}
+
+	if c.NotBefore != 0 && now.Unix() < c.NotBefore-skew {
+		return ErrInvalidIDToken
+	}
+
+	if c.IssuedAt != 0 && now.Unix() < c.IssuedAt-skew {
+		return ErrInvalidIDToken
+	}
+
+	if nonce != "" && c.Nonce != nonce {
+		return ErrInvalidNonce
+	}
+
+	return nil
+}
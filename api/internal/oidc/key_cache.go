@@ -0,0 +1,314 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "interviews/pkg/logger"
+)
+
+const (
+	// used when the provider sends no max-age
+	defaultKeyTTL = time.Hour
+	// unknown kids trigger a refetch, but not more often than this
+	minKeyRefetch = time.Minute
+
+	keyBackoffBase = time.Second
+	keyBackoffMax  = 5 * time.Minute
+)
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// KeyCache holds the signing keys of a provider. Keys are kept for as long as
+// the provider's Cache-Control max-age allows and refreshed in the background.
+// When the provider can't be reached the last known keys keep being used and
+// the refresh is retried with exponential backoff.
+type KeyCache struct {
+	client  *http.Client
+	jwksURL func(ctx context.Context) (string, error)
+	now     func() time.Time
+
+	// serializes fetches so concurrent logins don't all hit the provider
+	fetchMu sync.Mutex
+
+	mu          sync.RWMutex
+	keys        map[string]interface{}
+	fetchedAt   time.Time
+	expiry      time.Time
+	failures    int
+	nextAttempt time.Time
+}
+
+func NewKeyCache(client *http.Client, jwksURL func(ctx context.Context) (string, error)) *KeyCache {
+	return &KeyCache{
+		client:  client,
+		jwksURL: jwksURL,
+		now:     time.Now,
+	}
+}
+
+// Key returns the key with the kid, fetching the key set if it is stale or
+// doesn't contain the kid yet.
+func (c *KeyCache) Key(ctx context.Context, kid string) (interface{}, error) {
+	c.mu.RLock()
+	key, ok := c.keys[kid]
+	fresh := c.now().Before(c.expiry)
+	fetchedAt := c.fetchedAt
+	canFetch := !c.now().Before(c.nextAttempt)
+	c.mu.RUnlock()
+
+	if ok && fresh {
+		return key, nil
+	}
+
+	if canFetch {
+		err := c.refreshSince(ctx, fetchedAt)
+		if err != nil {
+			log.WarnCtx("unable to refresh oidc signing keys", log.Ctx{
+				"error": err.Error(),
+			})
+		}
+
+		c.mu.RLock()
+		key, ok = c.keys[kid]
+		c.mu.RUnlock()
+	}
+
+	// stale keys are still better than failing every login while the
+	// provider is unreachable
+	if ok {
+		return key, nil
+	}
+
+	return nil, ErrUnknownKey
+}
+
+// StartRefresh keeps the keys fresh until the context is cancelled.
+func (c *KeyCache) StartRefresh(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(c.untilRefresh()):
+		}
+
+		err := c.Refresh(ctx)
+		if err != nil {
+			log.WarnCtx("unable to refresh oidc signing keys", log.Ctx{
+				"error": err.Error(),
+			})
+		}
+	}
+}
+
+func (c *KeyCache) untilRefresh() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	next := c.expiry
+	if c.failures > 0 || c.keys == nil {
+		next = c.nextAttempt
+	}
+
+	if wait := next.Sub(c.now()); wait > 0 {
+		return wait
+	}
+
+	return 0
+}
+
+func (c *KeyCache) Refresh(ctx context.Context) error {
+	c.mu.RLock()
+	fetchedAt := c.fetchedAt
+	c.mu.RUnlock()
+
+	return c.refreshSince(ctx, fetchedAt)
+}
+
+// refreshSince fetches the keys unless another caller already did so after
+// the given time while we were waiting for the lock.
+func (c *KeyCache) refreshSince(ctx context.Context, fetchedAt time.Time) error {
+	c.fetchMu.Lock()
+	defer c.fetchMu.Unlock()
+
+	c.mu.RLock()
+	refreshed := c.fetchedAt.After(fetchedAt)
+	c.mu.RUnlock()
+
+	if refreshed {
+		return nil
+	}
+
+	keys, ttl, err := c.fetch(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+
+	if err != nil {
+		c.failures++
+		c.nextAttempt = now.Add(backoff(c.failures))
+
+		return err
+	}
+
+	c.keys = keys
+	c.fetchedAt = now
+	c.expiry = now.Add(ttl)
+	c.failures = 0
+	c.nextAttempt = now.Add(minKeyRefetch)
+
+	return nil
+}
+
+func (c *KeyCache) fetch(ctx context.Context) (map[string]interface{}, time.Duration, error) {
+	target, err := c.jwksURL(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("%s responded with %d", target, res.StatusCode)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+
+	err = json.NewDecoder(io.LimitReader(res.Body, maxResponseSize)).Decode(&set)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		pub, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+
+		keys[k.Kid] = pub
+	}
+
+	if len(keys) == 0 {
+		return nil, 0, fmt.Errorf("%s contains no usable signing keys", target)
+	}
+
+	return keys, maxAge(res.Header.Get("Cache-Control")), nil
+}
+
+// maxAge returns how long the response may be cached, at least minKeyRefetch.
+func maxAge(cacheControl string) time.Duration {
+	ttl := defaultKeyTTL
+
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+
+		switch {
+		case directive == "no-cache" || directive == "no-store":
+			return minKeyRefetch
+		case strings.HasPrefix(directive, "max-age="):
+			seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err == nil {
+				ttl = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+
+	if ttl < minKeyRefetch {
+		return minKeyRefetch
+	}
+
+	return ttl
+}
+
+func backoff(failures int) time.Duration {
+	delay := keyBackoffBase
+	for i := 1; i < failures; i++ {
+		delay *= 2
+		if delay >= keyBackoffMax {
+			return keyBackoffMax
+		}
+	}
+
+	return delay
+}
+
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
@@ -0,0 +1,234 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type jwksServer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu           sync.Mutex
+	kid          string
+	status       int
+	cacheControl string
+	hits         int
+}
+
+func newJWKSServer(t *testing.T) *jwksServer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	s := &jwksServer{key: key, kid: "key-1", status: http.StatusOK, cacheControl: "public, max-age=120"}
+
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.hits++
+
+		if s.status != http.StatusOK {
+			w.WriteHeader(s.status)
+			return
+		}
+
+		w.Header().Set("Cache-Control", s.cacheControl)
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": s.kid,
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	}))
+	t.Cleanup(s.server.Close)
+
+	return s
+}
+
+func (s *jwksServer) set(fn func(s *jwksServer)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fn(s)
+}
+
+func (s *jwksServer) hitCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.hits
+}
+
+// newTestKeyCache returns a cache whose clock is moved by the returned func.
+func newTestKeyCache(s *jwksServer) (*KeyCache, func(d time.Duration)) {
+	var mu sync.Mutex
+	now := time.Now()
+
+	cache := NewKeyCache(s.server.Client(), func(ctx context.Context) (string, error) {
+		return s.server.URL, nil
+	})
+	cache.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return now
+	}
+
+	return cache, func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		now = now.Add(d)
+	}
+}
+
+func TestKeyCache_HonorsMaxAge(t *testing.T) {
+	s := newJWKSServer(t)
+	cache, advance := newTestKeyCache(s)
+	ctx := context.Background()
+
+	key, err := cache.Key(ctx, "key-1")
+	require.NoError(t, err)
+	assert.Equal(t, &s.key.PublicKey, key)
+
+	advance(100 * time.Second)
+	_, err = cache.Key(ctx, "key-1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, s.hitCount())
+
+	advance(30 * time.Second)
+	_, err = cache.Key(ctx, "key-1")
+	require.NoError(t, err)
+	assert.Equal(t, 2, s.hitCount())
+}
+
+func TestKeyCache_FallsBackToLastKnownKeys(t *testing.T) {
+	s := newJWKSServer(t)
+	cache, advance := newTestKeyCache(s)
+	ctx := context.Background()
+
+	_, err := cache.Key(ctx, "key-1")
+	require.NoError(t, err)
+
+	s.set(func(s *jwksServer) { s.status = http.StatusServiceUnavailable })
+	advance(time.Hour)
+
+	key, err := cache.Key(ctx, "key-1")
+	require.NoError(t, err, "stale keys are used while the provider is down")
+	assert.Equal(t, &s.key.PublicKey, key)
+	assert.Equal(t, 2, s.hitCount())
+
+	// the failed refresh backs off instead of hitting the provider on every login
+	_, err = cache.Key(ctx, "key-1")
+	require.NoError(t, err)
+	assert.Equal(t, 2, s.hitCount())
+
+	advance(keyBackoffBase)
+	_, err = cache.Key(ctx, "key-1")
+	require.NoError(t, err)
+	assert.Equal(t, 3, s.hitCount())
+
+	// the second failure doubles the delay
+	advance(keyBackoffBase)
+	_, err = cache.Key(ctx, "key-1")
+	require.NoError(t, err)
+	assert.Equal(t, 3, s.hitCount())
+
+	s.set(func(s *jwksServer) { s.status = http.StatusOK })
+	advance(keyBackoffBase)
+	_, err = cache.Key(ctx, "key-1")
+	require.NoError(t, err)
+	assert.Equal(t, 4, s.hitCount())
+	assert.Zero(t, cache.failures)
+}
+
+func TestKeyCache_FetchesRotatedKeys(t *testing.T) {
+	s := newJWKSServer(t)
+	cache, advance := newTestKeyCache(s)
+	ctx := context.Background()
+
+	_, err := cache.Key(ctx, "key-1")
+	require.NoError(t, err)
+
+	s.set(func(s *jwksServer) { s.kid = "key-2" })
+
+	// unknown kids don't let anyone make us hammer the provider
+	_, err = cache.Key(ctx, "key-2")
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	assert.Equal(t, 1, s.hitCount())
+
+	advance(minKeyRefetch)
+	_, err = cache.Key(ctx, "key-2")
+	require.NoError(t, err)
+	assert.Equal(t, 2, s.hitCount())
+}
+
+func TestKeyCache_ConcurrentMissesFetchOnce(t *testing.T) {
+	s := newJWKSServer(t)
+	cache, _ := newTestKeyCache(s)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := cache.Key(context.Background(), "key-1")
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, s.hitCount())
+}
+
+func TestKeyCache_StartRefresh(t *testing.T) {
+	s := newJWKSServer(t)
+	cache := NewKeyCache(s.server.Client(), func(ctx context.Context) (string, error) {
+		return s.server.URL, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go cache.StartRefresh(ctx)
+
+	assert.Eventually(t, func() bool { return s.hitCount() == 1 }, time.Second, 10*time.Millisecond)
+
+	_, err := cache.Key(context.Background(), "key-1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, s.hitCount(), "logins use the keys loaded in the background")
+}
+
+func TestMaxAge(t *testing.T) {
+	testCases := []struct {
+		header string
+		ttl    time.Duration
+	}{
+		{header: "", ttl: defaultKeyTTL},
+		{header: "public, max-age=21600, must-revalidate, no-transform", ttl: 6 * time.Hour},
+		{header: "max-age=5", ttl: minKeyRefetch},
+		{header: "no-store", ttl: minKeyRefetch},
+		{header: "max-age=abc", ttl: defaultKeyTTL},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.ttl, maxAge(tc.header), "header: %q", tc.header)
+	}
+}
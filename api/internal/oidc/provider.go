@@ -0,0 +1,224 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var (
+	ErrDiscovery      = errors.New("oidc discovery failed")
+	ErrUnknownKey     = errors.New("id token signed with an unknown key")
+	ErrTokenExchange  = errors.New("authorization code exchange failed")
+	ErrMissingIDToken = errors.New("token response contains no id token")
+)
+
+// maxResponseSize limits what is read from a provider.
+const maxResponseSize = 1 << 20
+
+// Metadata is the part of the discovery document this service uses.
+type Metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type Provider struct {
+	cfg    ProviderConfig
+	client *http.Client
+	leeway time.Duration
+
+	keys *KeyCache
+
+	mu       sync.Mutex
+	metadata *Metadata
+}
+
+func NewProvider(cfg ProviderConfig, client *http.Client, leeway time.Duration) *Provider {
+	p := &Provider{
+		cfg:    cfg,
+		client: client,
+		leeway: leeway,
+	}
+	p.keys = NewKeyCache(client, p.jwksURL)
+
+	return p
+}
+
+func (p *Provider) Name() string {
+	return p.cfg.Name
+}
+
+func (p *Provider) getJSON(ctx context.Context, target string, dst any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded with %d", target, res.StatusCode)
+	}
+
+	return json.NewDecoder(io.LimitReader(res.Body, maxResponseSize)).Decode(dst)
+}
+
+// discover fetches the discovery document once. Providers configured with a
+// JWKS URL don't need it for ID-token logins.
+func (p *Provider) discover(ctx context.Context) (*Metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	var m Metadata
+
+	err := p.getJSON(ctx, strings.TrimSuffix(p.cfg.Issuer, "/")+"/.well-known/openid-configuration", &m)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrDiscovery, err)
+	}
+
+	if m.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("%w: issuer %q does not match %q", ErrDiscovery, m.Issuer, p.cfg.Issuer)
+	}
+
+	if p.cfg.JWKSURL != "" {
+		m.JWKSURI = p.cfg.JWKSURL
+	}
+
+	p.metadata = &m
+
+	return p.metadata, nil
+}
+
+func (p *Provider) jwksURL(ctx context.Context) (string, error) {
+	if p.cfg.JWKSURL != "" {
+		return p.cfg.JWKSURL, nil
+	}
+
+	m, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return m.JWKSURI, nil
+}
+
+// Verify checks the signature and the claims of an ID token. The nonce is only
+// checked when one is given, i.e. for tokens obtained through the code flow.
+func (p *Provider) Verify(ctx context.Context, rawIDToken, nonce string) (*IDTokenClaims, error) {
+	var claims IDTokenClaims
+
+	parser := &jwt.Parser{ValidMethods: []string{"RS256", "ES256"}}
+
+	_, err := parser.ParseWithClaims(rawIDToken, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+
+		return p.keys.Key(ctx, kid)
+	})
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	err = claims.validate(p.cfg, nonce, p.leeway, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return &claims, nil
+}
+
+// AuthCodeURL builds the URL the user is sent to for the authorization code
+// flow with PKCE.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.cfg.ClientID())
+	v.Set("redirect_uri", p.cfg.RedirectURL)
+	v.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", codeChallenge)
+	v.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(m.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+
+	return m.AuthorizationEndpoint + sep + v.Encode(), nil
+}
+
+// Exchange trades the authorization code for the raw ID token.
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier string) (string, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("client_id", p.cfg.ClientID())
+	form.Set("code_verifier", codeVerifier)
+
+	if p.cfg.ClientSecret != "" {
+		form.Set("client_secret", p.cfg.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrTokenExchange, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: token endpoint responded with %d", ErrTokenExchange, res.StatusCode)
+	}
+
+	var body struct {
+		IDToken string `json:"id_token"`
+	}
+
+	err = json.NewDecoder(io.LimitReader(res.Body, maxResponseSize)).Decode(&body)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrTokenExchange, err)
+	}
+
+	if body.IDToken == "" {
+		return "", ErrMissingIDToken
+	}
+
+	return body.IDToken, nil
+}
@@ -0,0 +1,330 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubIdP is a minimal identity provider serving discovery, JWKS and a token
+// endpoint that checks the PKCE verifier.
+type stubIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	kid    string
+
+	mu         sync.Mutex
+	challenges map[string]string // code -> code challenge
+	nonces     map[string]string // code -> nonce
+	jwksHits   int
+}
+
+func newStubIdP(t *testing.T) *stubIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	idp := &stubIdP{
+		key:        key,
+		kid:        "stub-1",
+		challenges: make(map[string]string),
+		nonces:     make(map[string]string),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		idp.jwksHits++
+		idp.mu.Unlock()
+
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": idp.kid,
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+
+		idp.mu.Lock()
+		challenge, ok := idp.challenges[r.Form.Get("code")]
+		nonce := idp.nonces[r.Form.Get("code")]
+		idp.mu.Unlock()
+
+		if !ok || codeChallenge(r.Form.Get("code_verifier")) != challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "access",
+			"id_token":     idp.sign(t, idp.claims(r.Form.Get("client_id"), nonce)),
+		})
+	})
+
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+
+	return idp
+}
+
+// authorize simulates the user logging in at the provider.
+func (idp *stubIdP) authorize(t *testing.T, authURL string) (code, state string) {
+	u, err := url.Parse(authURL)
+	require.NoError(t, err)
+
+	q := u.Query()
+	require.Equal(t, "S256", q.Get("code_challenge_method"))
+
+	code = "code-" + q.Get("state")
+
+	idp.mu.Lock()
+	idp.challenges[code] = q.Get("code_challenge")
+	idp.nonces[code] = q.Get("nonce")
+	idp.mu.Unlock()
+
+	return code, q.Get("state")
+}
+
+func (idp *stubIdP) claims(aud, nonce string) jwt.MapClaims {
+	now := time.Now()
+
+	return jwt.MapClaims{
+		"iss":            idp.server.URL,
+		"sub":            "248289761001",
+		"aud":            aud,
+		"exp":            now.Add(time.Hour).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          nonce,
+		"email":          "jane@corp.test",
+		"email_verified": true,
+		"name":           "Jane Doe",
+	}
+}
+
+func (idp *stubIdP) sign(t *testing.T, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = idp.kid
+
+	s, err := token.SignedString(idp.key)
+	require.NoError(t, err)
+
+	return s
+}
+
+func (idp *stubIdP) config() ProviderConfig {
+	return ProviderConfig{
+		Name:        "corp",
+		Issuer:      idp.server.URL,
+		ClientIDs:   []string{"interviews", "interviews-mobile"},
+		RedirectURL: "http://localhost:5173/oidc/corp/callback",
+		Scopes:      []string{"openid", "email"},
+	}
+}
+
+func TestProvider_Verify(t *testing.T) {
+	idp := newStubIdP(t)
+	provider := NewProvider(idp.config(), idp.server.Client(), 30*time.Second)
+
+	testCases := []struct {
+		name   string
+		modify func(c jwt.MapClaims)
+		nonce  string
+		err    error
+	}{
+		{
+			name:   "valid token",
+			modify: func(c jwt.MapClaims) {},
+		},
+		{
+			name:   "second client id with array audience",
+			modify: func(c jwt.MapClaims) { c["aud"] = []string{"other", "interviews-mobile"} },
+		},
+		{
+			name:   "email_verified sent as string",
+			modify: func(c jwt.MapClaims) { c["email_verified"] = "true" },
+		},
+		{
+			name:   "token for another client",
+			modify: func(c jwt.MapClaims) { c["aud"] = "someone-else" },
+			err:    ErrInvalidAud,
+		},
+		{
+			name:   "token from another issuer",
+			modify: func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" },
+			err:    ErrInvalidIssuer,
+		},
+		{
+			name:   "expired token",
+			modify: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+			err:    ErrExpiredIDToken,
+		},
+		{
+			name:   "nonce mismatch",
+			modify: func(c jwt.MapClaims) { c["nonce"] = "replayed" },
+			nonce:  "expected",
+			err:    ErrInvalidNonce,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			claims := idp.claims("interviews", "")
+			tc.modify(claims)
+
+			got, err := provider.Verify(context.Background(), idp.sign(t, claims), tc.nonce)
+			assert.ErrorIs(t, err, tc.err)
+
+			if tc.err == nil {
+				assert.Equal(t, "248289761001", got.Subject)
+				assert.Equal(t, "jane@corp.test", got.Email)
+				assert.True(t, bool(got.EmailVerified))
+			}
+		})
+	}
+
+	assert.Equal(t, 1, idp.jwksHits, "keys are fetched once")
+}
+
+func TestProvider_VerifyRejectsForeignKey(t *testing.T) {
+	idp := newStubIdP(t)
+	provider := NewProvider(idp.config(), idp.server.Client(), 0)
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, idp.claims("interviews", ""))
+	token.Header["kid"] = idp.kid
+	forged, err := token.SignedString(other)
+	require.NoError(t, err)
+
+	_, err = provider.Verify(context.Background(), forged, "")
+	assert.ErrorIs(t, err, ErrInvalidIDToken)
+
+	// HS256 signed with the public key must not be accepted either
+	hs := jwt.NewWithClaims(jwt.SigningMethodHS256, idp.claims("interviews", ""))
+	hs.Header["kid"] = idp.kid
+	forged, err = hs.SignedString(idp.key.N.Bytes())
+	require.NoError(t, err)
+
+	_, err = provider.Verify(context.Background(), forged, "")
+	assert.ErrorIs(t, err, ErrInvalidIDToken)
+}
+
+type memoryStates struct {
+	states map[string]*AuthState
+}
+
+func (m *memoryStates) Save(ctx context.Context, state *AuthState) error {
+	m.states[state.State] = state
+
+	return nil
+}
+
+func (m *memoryStates) Consume(ctx context.Context, state string) (*AuthState, error) {
+	s, ok := m.states[state]
+	if !ok || time.Now().After(s.Expiry) {
+		return nil, ErrInvalidState
+	}
+
+	delete(m.states, state)
+
+	return s, nil
+}
+
+func TestService_AuthorizationCodeFlow(t *testing.T) {
+	idp := newStubIdP(t)
+	states := &memoryStates{states: make(map[string]*AuthState)}
+	service := NewService([]ProviderConfig{idp.config()}, states, idp.server.Client(), 0, time.Minute)
+	ctx := context.Background()
+
+	_, err := service.AuthCodeURL(ctx, "unknown")
+	assert.ErrorIs(t, err, ErrUnknownProvider)
+
+	authURL, err := service.AuthCodeURL(ctx, "corp")
+	require.NoError(t, err)
+	assert.Contains(t, authURL, idp.server.URL+"/authorize?")
+	assert.Contains(t, authURL, "client_id=interviews&")
+
+	code, state := idp.authorize(t, authURL)
+
+	identity, err := service.Exchange(ctx, "corp", code, state)
+	require.NoError(t, err)
+	assert.Equal(t, &Identity{
+		Provider:      "corp",
+		Subject:       "248289761001",
+		Email:         "jane@corp.test",
+		EmailVerified: true,
+		Name:          "Jane Doe",
+	}, identity)
+
+	// the state is single-use
+	_, err = service.Exchange(ctx, "corp", code, state)
+	assert.ErrorIs(t, err, ErrInvalidState)
+}
+
+func TestService_ExchangeRejectsWrongVerifier(t *testing.T) {
+	idp := newStubIdP(t)
+	states := &memoryStates{states: make(map[string]*AuthState)}
+	service := NewService([]ProviderConfig{idp.config()}, states, idp.server.Client(), 0, time.Minute)
+	ctx := context.Background()
+
+	authURL, err := service.AuthCodeURL(ctx, "corp")
+	require.NoError(t, err)
+
+	code, state := idp.authorize(t, authURL)
+	states.states[state].CodeVerifier = "stolen-code-without-the-verifier"
+
+	_, err = service.Exchange(ctx, "corp", code, state)
+	assert.ErrorIs(t, err, ErrTokenExchange)
+}
+
+func TestLoadProviderConfigs(t *testing.T) {
+	t.Setenv("OIDC_GOOGLE_CLIENT_IDS", "web-client, mobile-client")
+	t.Setenv("OIDC_CORP_SSO_ISSUER", "https://sso.corp.test")
+	t.Setenv("OIDC_CORP_SSO_CLIENT_IDS", "interviews")
+	t.Setenv("OIDC_CORP_SSO_CLIENT_SECRET", "secret")
+
+	configs, err := LoadProviderConfigs([]string{"google", "corp-sso"}, "http://localhost:5173")
+	require.NoError(t, err)
+	require.Len(t, configs, 2)
+
+	assert.Equal(t, "https://accounts.google.com", configs[0].Issuer)
+	assert.Equal(t, []string{"accounts.google.com"}, configs[0].AltIssuers)
+	assert.Equal(t, []string{"web-client", "mobile-client"}, configs[0].ClientIDs)
+	assert.Equal(t, "http://localhost:5173/oidc/google/callback", configs[0].RedirectURL)
+
+	assert.Equal(t, "corp-sso", configs[1].Name)
+	assert.Equal(t, "secret", configs[1].ClientSecret)
+	assert.Equal(t, []string{"openid", "email", "profile"}, configs[1].Scopes)
+
+	_, err = LoadProviderConfigs([]string{"microsoft"}, "http://localhost:5173")
+	assert.ErrorIs(t, err, ErrIncompleteProvider)
+}
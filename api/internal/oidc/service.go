@@ -0,0 +1,176 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"time"
+)
+
+var (
+	ErrUnknownProvider = errors.New("unknown identity provider")
+)
+
+// Identity is the verified user as reported by a provider. Subject is only
+// unique per provider.
+type Identity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+type StateRepository interface {
+	Save(ctx context.Context, state *AuthState) error
+	Consume(ctx context.Context, state string) (*AuthState, error)
+}
+
+type Service struct {
+	providers map[string]*Provider
+	states    StateRepository
+	stateTTL  time.Duration
+}
+
+func (s *Service) provider(name string) (*Provider, error) {
+	p, ok := s.providers[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+
+	return p, nil
+}
+
+// StartRefresh keeps the signing keys of every provider fresh in the
+// background until the context is cancelled.
+func (s *Service) StartRefresh(ctx context.Context) {
+	for _, p := range s.providers {
+		go p.keys.StartRefresh(ctx)
+	}
+}
+
+// AuthCodeURL starts the authorization code flow. The state, nonce and PKCE
+// verifier are stored until the callback.
+func (s *Service) AuthCodeURL(ctx context.Context, provider string) (string, error) {
+	p, err := s.provider(provider)
+	if err != nil {
+		return "", err
+	}
+
+	state, err := randomString()
+	if err != nil {
+		return "", err
+	}
+
+	nonce, err := randomString()
+	if err != nil {
+		return "", err
+	}
+
+	verifier, err := randomString()
+	if err != nil {
+		return "", err
+	}
+
+	err = s.states.Save(ctx, &AuthState{
+		State:        state,
+		Provider:     provider,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		Expiry:       time.Now().Add(s.stateTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return p.AuthCodeURL(ctx, state, nonce, codeChallenge(verifier))
+}
+
+// Exchange completes the authorization code flow.
+func (s *Service) Exchange(ctx context.Context, provider, code, state string) (*Identity, error) {
+	p, err := s.provider(provider)
+	if err != nil {
+		return nil, err
+	}
+
+	authState, err := s.states.Consume(ctx, state)
+	if err != nil {
+		return nil, err
+	}
+
+	if authState.Provider != provider {
+		return nil, ErrInvalidState
+	}
+
+	rawIDToken, err := p.Exchange(ctx, code, authState.CodeVerifier)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := p.Verify(ctx, rawIDToken, authState.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return identity(provider, claims), nil
+}
+
+// VerifyIDToken logs in with an ID token the client obtained itself, e.g.
+// through Google Sign-In.
+func (s *Service) VerifyIDToken(ctx context.Context, provider, rawIDToken string) (*Identity, error) {
+	p, err := s.provider(provider)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := p.Verify(ctx, rawIDToken, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return identity(provider, claims), nil
+}
+
+func identity(provider string, claims *IDTokenClaims) *Identity {
+	return &Identity{
+		Provider:      provider,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		Name:          claims.Name,
+	}
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func NewService(configs []ProviderConfig, states StateRepository, client *http.Client,
+	leeway, stateTTL time.Duration) *Service {
+	providers := make(map[string]*Provider, len(configs))
+	for _, cfg := range configs {
+		providers[cfg.Name] = NewProvider(cfg, client, leeway)
+	}
+
+	return &Service{
+		providers: providers,
+		states:    states,
+		stateTTL:  stateTTL,
+	}
+}
@@ -0,0 +1,59 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrInvalidState = errors.New("invalid or expired oidc state")
+)
+
+// AuthState is kept between sending the user to the provider and the callback.
+type AuthState struct {
+	State        string    `bson:"_id"`
+	Provider     string    `bson:"provider"`
+	Nonce        string    `bson:"nonce"`
+	CodeVerifier string    `bson:"codeVerifier"`
+	Expiry       time.Time `bson:"expiry"`
+}
+
+type StateRepo struct {
+	db         *mongo.Client
+	collection *mongo.Collection
+}
+
+func (r *StateRepo) Save(ctx context.Context, state *AuthState) error {
+	_, err := r.collection.InsertOne(ctx, state)
+
+	return err
+}
+
+// Consume deletes the state so a callback can't be replayed.
+func (r *StateRepo) Consume(ctx context.Context, state string) (*AuthState, error) {
+	filter := bson.M{
+		"_id":    state,
+		"expiry": bson.M{"$gt": time.Now()},
+	}
+
+	var s AuthState
+
+	err := r.collection.FindOneAndDelete(ctx, filter).Decode(&s)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrInvalidState
+		}
+
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+func NewStateRepository(client *mongo.Client, collection *mongo.Collection) *StateRepo {
+	return &StateRepo{db: client, collection: collection}
+}
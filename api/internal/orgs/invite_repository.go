@@ -0,0 +1,192 @@
+package orgs
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrInviteNotFound = errors.New("invite not found")
+
+// Invite asks someone by email to join an org with a role. It holds a seat
+// until it is accepted, revoked or expires. Only the SHA-256 hash of the token
+// is stored.
+type Invite struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	OrgID     primitive.ObjectID `json:"org_id" bson:"orgId"`
+	Email     string             `json:"email" bson:"email"`
+	Role      string             `json:"role" bson:"role"`
+	Plaintext string             `json:"-" bson:"-"`
+	Hash      []byte             `json:"-" bson:"hash"`
+	InvitedBy string             `json:"invited_by" bson:"invitedBy"`
+	CreatedAt time.Time          `json:"created_at" bson:"createdAt"`
+	Expiry    time.Time          `json:"expiry" bson:"expiry"`
+}
+
+type InviteRepo struct {
+	client     *mongo.Client
+	collection *mongo.Collection
+}
+
+func hashInviteToken(plaintext string) []byte {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	return hash[:]
+}
+
+func generateInvite(orgID primitive.ObjectID, email, role, invitedBy string, ttl time.Duration) (*Invite, error) {
+	randomBytes := make([]byte, 16)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	plaintext := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+
+	return &Invite{
+		OrgID:     orgID,
+		Email:     strings.ToLower(email),
+		Role:      role,
+		Plaintext: plaintext,
+		Hash:      hashInviteToken(plaintext),
+		InvitedBy: invitedBy,
+		CreatedAt: now,
+		Expiry:    now.Add(ttl),
+	}, nil
+}
+
+// New replaces an open invite of the email to the same org.
+func (m *InviteRepo) New(ctx context.Context, orgID primitive.ObjectID, email, role, invitedBy string,
+	ttl time.Duration) (*Invite, error) {
+	invite, err := generateInvite(orgID, email, role, invitedBy, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = m.collection.DeleteMany(ctx, bson.M{"orgId": orgID, "email": invite.Email})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := m.collection.InsertOne(ctx, invite)
+	if err != nil {
+		return nil, err
+	}
+
+	if id, ok := res.InsertedID.(primitive.ObjectID); ok {
+		invite.ID = id
+	}
+
+	return invite, nil
+}
+
+// Find returns the unexpired invite of the token if it was sent to the email,
+// without using it up.
+func (m *InviteRepo) Find(ctx context.Context, plaintext, email string) (*Invite, error) {
+	filter := bson.M{
+		"hash":   hashInviteToken(plaintext),
+		"email":  strings.ToLower(email),
+		"expiry": bson.M{"$gt": time.Now()},
+	}
+
+	var invite Invite
+
+	err := m.collection.FindOne(ctx, filter).Decode(&invite)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrInviteNotFound
+		}
+
+		return nil, err
+	}
+
+	return &invite, nil
+}
+
+// Consume deletes the unexpired invite of the token if it was sent to the
+// email. Deleting and reading in one operation makes it single-use.
+func (m *InviteRepo) Consume(ctx context.Context, plaintext, email string) (*Invite, error) {
+	filter := bson.M{
+		"hash":   hashInviteToken(plaintext),
+		"email":  strings.ToLower(email),
+		"expiry": bson.M{"$gt": time.Now()},
+	}
+
+	var invite Invite
+
+	err := m.collection.FindOneAndDelete(ctx, filter).Decode(&invite)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrInviteNotFound
+		}
+
+		return nil, err
+	}
+
+	return &invite, nil
+}
+
+func (m *InviteRepo) ListPending(ctx context.Context, orgID primitive.ObjectID) ([]Invite, error) {
+	opts := options.Find().SetSort(bson.M{"createdAt": -1})
+
+	cursor, err := m.collection.Find(ctx, bson.M{"orgId": orgID, "expiry": bson.M{"$gt": time.Now()}}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	invites := []Invite{}
+
+	err = cursor.All(ctx, &invites)
+	if err != nil {
+		return nil, err
+	}
+
+	return invites, nil
+}
+
+func (m *InviteRepo) CountPending(ctx context.Context, orgID primitive.ObjectID) (int64, error) {
+	return m.collection.CountDocuments(ctx, bson.M{"orgId": orgID, "expiry": bson.M{"$gt": time.Now()}})
+}
+
+func (m *InviteRepo) Revoke(ctx context.Context, orgID primitive.ObjectID, id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInviteNotFound
+	}
+
+	res, err := m.collection.DeleteOne(ctx, bson.M{"_id": objectID, "orgId": orgID})
+	if err != nil {
+		return err
+	}
+
+	if res.DeletedCount == 0 {
+		return ErrInviteNotFound
+	}
+
+	return nil
+}
+
+// DeleteForEmail withdraws every invite sent to the email.
+func (m *InviteRepo) DeleteForEmail(ctx context.Context, email string) error {
+	_, err := m.collection.DeleteMany(ctx, bson.M{"email": strings.ToLower(email)})
+
+	return err
+}
+
+func NewInviteRepository(client *mongo.Client, collection *mongo.Collection) *InviteRepo {
+	return &InviteRepo{
+		client:     client,
+		collection: collection,
+	}
+}
@@ -0,0 +1,166 @@
+package orgs
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	RoleOwner   = "owner"
+	RoleManager = "manager"
+	RoleMember  = "member"
+)
+
+var (
+	ErrMemberNotFound = errors.New("member not found")
+	ErrAlreadyMember  = errors.New("already a member of the organization")
+)
+
+type Member struct {
+	ID        primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	OrgID     primitive.ObjectID `json:"org_id" bson:"orgId"`
+	Email     string             `json:"email" bson:"email"`
+	Role      string             `json:"role" bson:"role"`
+	JoinedAt  time.Time          `json:"joined_at" bson:"joinedAt"`
+	InvitedBy string             `json:"invited_by,omitempty" bson:"invitedBy,omitempty"`
+}
+
+// ValidRole reports whether the role exists within an org.
+func ValidRole(role string) bool {
+	return role == RoleOwner || role == RoleManager || role == RoleMember
+}
+
+// CanManage reports whether the member may invite and remove members.
+func (m *Member) CanManage() bool {
+	return m.Role == RoleOwner || m.Role == RoleManager
+}
+
+type MemberRepo struct {
+	client     *mongo.Client
+	collection *mongo.Collection
+}
+
+// Add inserts the member unless the user already holds a seat of the org.
+func (m *MemberRepo) Add(ctx context.Context, member *Member) error {
+	member.Email = strings.ToLower(member.Email)
+	member.JoinedAt = time.Now()
+
+	filter := bson.M{"orgId": member.OrgID, "email": member.Email}
+	opts := options.Update().SetUpsert(true)
+
+	res, err := m.collection.UpdateOne(ctx, filter, bson.M{"$setOnInsert": member}, opts)
+	if err != nil {
+		return err
+	}
+
+	if res.UpsertedCount == 0 {
+		return ErrAlreadyMember
+	}
+
+	if id, ok := res.UpsertedID.(primitive.ObjectID); ok {
+		member.ID = id
+	}
+
+	return nil
+}
+
+func (m *MemberRepo) Get(ctx context.Context, orgID primitive.ObjectID, email string) (*Member, error) {
+	var member Member
+
+	err := m.collection.FindOne(ctx, bson.M{"orgId": orgID, "email": strings.ToLower(email)}).Decode(&member)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrMemberNotFound
+		}
+
+		return nil, err
+	}
+
+	return &member, nil
+}
+
+func (m *MemberRepo) ListForOrg(ctx context.Context, orgID primitive.ObjectID) ([]Member, error) {
+	return m.list(ctx, bson.M{"orgId": orgID})
+}
+
+func (m *MemberRepo) ListForUser(ctx context.Context, email string) ([]Member, error) {
+	return m.list(ctx, bson.M{"email": strings.ToLower(email)})
+}
+
+func (m *MemberRepo) list(ctx context.Context, filter bson.M) ([]Member, error) {
+	opts := options.Find().SetSort(bson.M{"joinedAt": 1})
+
+	cursor, err := m.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	members := []Member{}
+
+	err = cursor.All(ctx, &members)
+	if err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+func (m *MemberRepo) Count(ctx context.Context, orgID primitive.ObjectID) (int64, error) {
+	return m.collection.CountDocuments(ctx, bson.M{"orgId": orgID})
+}
+
+// CountOwners is checked before an owner leaves so every org keeps one.
+func (m *MemberRepo) CountOwners(ctx context.Context, orgID primitive.ObjectID) (int64, error) {
+	return m.collection.CountDocuments(ctx, bson.M{"orgId": orgID, "role": RoleOwner})
+}
+
+func (m *MemberRepo) UpdateRole(ctx context.Context, orgID primitive.ObjectID, email, role string) error {
+	res, err := m.collection.UpdateOne(ctx,
+		bson.M{"orgId": orgID, "email": strings.ToLower(email)},
+		bson.M{"$set": bson.M{"role": role}})
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrMemberNotFound
+	}
+
+	return nil
+}
+
+// ChangeEmail moves every membership of the user to their new address.
+func (m *MemberRepo) ChangeEmail(ctx context.Context, email, newEmail string) error {
+	_, err := m.collection.UpdateMany(ctx,
+		bson.M{"email": strings.ToLower(email)},
+		bson.M{"$set": bson.M{"email": strings.ToLower(newEmail)}})
+
+	return err
+}
+
+func (m *MemberRepo) Remove(ctx context.Context, orgID primitive.ObjectID, email string) error {
+	res, err := m.collection.DeleteOne(ctx, bson.M{"orgId": orgID, "email": strings.ToLower(email)})
+	if err != nil {
+		return err
+	}
+
+	if res.DeletedCount == 0 {
+		return ErrMemberNotFound
+	}
+
+	return nil
+}
+
+func NewMemberRepository(client *mongo.Client, collection *mongo.Collection) *MemberRepo {
+	return &MemberRepo{
+		client:     client,
+		collection: collection,
+	}
+}
@@ -0,0 +1,188 @@
+package orgs
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ErrOrgNotFound = errors.New("organization not found")
+
+// Org is a team whose access was bought together. Seats limit how many
+// members and open invites it can have.
+type Org struct {
+	ID    primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name  string             `json:"name" bson:"name"`
+	Seats int                `json:"seats" bson:"seats"`
+	// Members counts the seats taken, it changes together with the members
+	// so a seat can be checked and taken in one update
+	Members      int          `json:"members" bson:"members"`
+	Entitlements Entitlements `json:"entitlements" bson:"entitlements"`
+	CreatedBy    string       `json:"created_by" bson:"createdBy"`
+	CreatedAt    time.Time    `json:"created_at" bson:"createdAt"`
+}
+
+// Entitlements are the subscription courses every member of the org can
+// access.
+type Entitlements struct {
+	AllSubscriptionCourses bool     `json:"all_subscription_courses" bson:"allSubscriptionCourses"`
+	Courses                []string `json:"courses" bson:"courses"`
+}
+
+// Includes reports whether the course with the id is covered.
+func (e Entitlements) Includes(courseID string) bool {
+	if e.AllSubscriptionCourses {
+		return true
+	}
+
+	for _, id := range e.Courses {
+		if id == courseID {
+			return true
+		}
+	}
+
+	return false
+}
+
+type OrgRepo struct {
+	client     *mongo.Client
+	collection *mongo.Collection
+}
+
+func (m *OrgRepo) New(ctx context.Context, org *Org) error {
+	org.CreatedAt = time.Now()
+
+	res, err := m.collection.InsertOne(ctx, org)
+	if err != nil {
+		return err
+	}
+
+	if id, ok := res.InsertedID.(primitive.ObjectID); ok {
+		org.ID = id
+	}
+
+	return nil
+}
+
+func (m *OrgRepo) Get(ctx context.Context, id string) (*Org, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrOrgNotFound
+	}
+
+	var org Org
+
+	err = m.collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&org)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrOrgNotFound
+		}
+
+		return nil, err
+	}
+
+	return &org, nil
+}
+
+// ListByIDs returns the orgs in no particular order, unknown ids are skipped.
+func (m *OrgRepo) ListByIDs(ctx context.Context, ids []primitive.ObjectID) ([]Org, error) {
+	orgs := []Org{}
+
+	if len(ids) == 0 {
+		return orgs, nil
+	}
+
+	cursor, err := m.collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+
+	err = cursor.All(ctx, &orgs)
+	if err != nil {
+		return nil, err
+	}
+
+	return orgs, nil
+}
+
+func (m *OrgRepo) Update(ctx context.Context, org *Org) error {
+	res, err := m.collection.UpdateOne(ctx, bson.M{"_id": org.ID}, bson.M{"$set": bson.M{
+		"name":         org.Name,
+		"seats":        org.Seats,
+		"entitlements": org.Entitlements,
+	}})
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrOrgNotFound
+	}
+
+	return nil
+}
+
+// TakeSeat counts one more member if the org has a free seat. Checking and
+// counting in a single update keeps concurrent joins within the seats.
+func (m *OrgRepo) TakeSeat(ctx context.Context, id primitive.ObjectID) error {
+	filter := bson.M{
+		"_id":   id,
+		"$expr": bson.M{"$lt": bson.A{"$members", "$seats"}},
+	}
+
+	res, err := m.collection.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"members": 1}})
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrNoSeats
+	}
+
+	return nil
+}
+
+func (m *OrgRepo) FreeSeat(ctx context.Context, id primitive.ObjectID) error {
+	_, err := m.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "members": bson.M{"$gt": 0}},
+		bson.M{"$inc": bson.M{"members": -1}})
+
+	return err
+}
+
+// ListUncounted returns the orgs stored before their members were counted.
+func (m *OrgRepo) ListUncounted(ctx context.Context) ([]Org, error) {
+	cursor, err := m.collection.Find(ctx, bson.M{"members": bson.M{"$exists": false}})
+	if err != nil {
+		return nil, err
+	}
+
+	orgs := []Org{}
+
+	err = cursor.All(ctx, &orgs)
+	if err != nil {
+		return nil, err
+	}
+
+	return orgs, nil
+}
+
+// SetMembers starts counting the members of an org that wasn't counted yet.
+func (m *OrgRepo) SetMembers(ctx context.Context, id primitive.ObjectID, members int64) error {
+	_, err := m.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "members": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"members": members}})
+
+	return err
+}
+
+func NewOrgRepository(client *mongo.Client, collection *mongo.Collection) *OrgRepo {
+	return &OrgRepo{
+		client:     client,
+		collection: collection,
+	}
+}
@@ -0,0 +1,826 @@
+package orgs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"interviews/internal/auth"
+	"interviews/internal/config"
+	data "interviews/internal/users"
+	"interviews/pkg"
+	log "interviews/pkg/logger"
+	validator "interviews/pkg/vaildator"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNoSeats        = errors.New("the organization has no free seats")
+	ErrLastOwner      = errors.New("the organization must keep at least one owner")
+	ErrRoleNotAllowed = errors.New("only owners can grant this role")
+	ErrNotManager     = errors.New("only owners and managers can manage members")
+)
+
+type OrgRepository interface {
+	New(ctx context.Context, org *Org) error
+	Get(ctx context.Context, id string) (*Org, error)
+	ListByIDs(ctx context.Context, ids []primitive.ObjectID) ([]Org, error)
+	Update(ctx context.Context, org *Org) error
+	TakeSeat(ctx context.Context, id primitive.ObjectID) error
+	FreeSeat(ctx context.Context, id primitive.ObjectID) error
+	ListUncounted(ctx context.Context) ([]Org, error)
+	SetMembers(ctx context.Context, id primitive.ObjectID, members int64) error
+}
+
+type MemberRepository interface {
+	Add(ctx context.Context, member *Member) error
+	Get(ctx context.Context, orgID primitive.ObjectID, email string) (*Member, error)
+	ListForOrg(ctx context.Context, orgID primitive.ObjectID) ([]Member, error)
+	ListForUser(ctx context.Context, email string) ([]Member, error)
+	Count(ctx context.Context, orgID primitive.ObjectID) (int64, error)
+	CountOwners(ctx context.Context, orgID primitive.ObjectID) (int64, error)
+	UpdateRole(ctx context.Context, orgID primitive.ObjectID, email, role string) error
+	Remove(ctx context.Context, orgID primitive.ObjectID, email string) error
+}
+
+type InviteRepository interface {
+	New(ctx context.Context, orgID primitive.ObjectID, email, role, invitedBy string, ttl time.Duration) (*Invite, error)
+	Find(ctx context.Context, plaintext, email string) (*Invite, error)
+	Consume(ctx context.Context, plaintext, email string) (*Invite, error)
+	ListPending(ctx context.Context, orgID primitive.ObjectID) ([]Invite, error)
+	CountPending(ctx context.Context, orgID primitive.ObjectID) (int64, error)
+	Revoke(ctx context.Context, orgID primitive.ObjectID, id string) error
+}
+
+type Mailer interface {
+	Send(recipient, templateFile string, data any) error
+}
+
+type Orgs struct {
+	helper  pkg.Helper
+	e       pkg.CustomErrors
+	orgs    OrgRepository
+	members MemberRepository
+	invites InviteRepository
+	mailer  Mailer
+	userCtx data.UsersContext
+	cfg     config.Config
+}
+
+type envelope map[string]any
+
+// CreateOrgHandler lets an admin set up an org for a customer. The owner gets
+// an invite, so they don't need an account yet.
+func (o *Orgs) CreateOrgHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	var input struct {
+		Name         string       `json:"name"`
+		Seats        int          `json:"seats"`
+		Owner        string       `json:"owner"`
+		Entitlements Entitlements `json:"entitlements"`
+	}
+
+	err := o.helper.ReadJSON(w, r, &input)
+	if err != nil {
+		o.e.BadRequestResponse(w, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	validateOrg(v, input.Name, input.Seats)
+	validateEmail(v, "owner", input.Owner)
+
+	if !v.Valid() {
+		o.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	admin := o.userCtx.ContextGetUser(r)
+
+	org := &Org{
+		Name:         input.Name,
+		Seats:        input.Seats,
+		Entitlements: input.Entitlements,
+		CreatedBy:    admin.Email,
+	}
+
+	err = o.orgs.New(ctx, org)
+	if err != nil {
+		o.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	invite, err := o.invite(ctx, org, input.Owner, RoleOwner, admin.Email)
+	if err != nil {
+		o.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = o.helper.WriteJSON(w, http.StatusCreated, envelope{"org": org, "invite": invite}, nil)
+	if err != nil {
+		o.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+// UpdateOrgHandler lets an admin change the seats and entitlements that were
+// bought.
+func (o *Orgs) UpdateOrgHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	org, err := o.orgs.Get(ctx, mux.Vars(r)["id"])
+	if err != nil {
+		o.orgErrorResponse(w, r, err)
+
+		return
+	}
+
+	var input struct {
+		Name         *string       `json:"name"`
+		Seats        *int          `json:"seats"`
+		Entitlements *Entitlements `json:"entitlements"`
+	}
+
+	err = o.helper.ReadJSON(w, r, &input)
+	if err != nil {
+		o.e.BadRequestResponse(w, r, err)
+
+		return
+	}
+
+	if input.Name != nil {
+		org.Name = *input.Name
+	}
+
+	if input.Seats != nil {
+		org.Seats = *input.Seats
+	}
+
+	if input.Entitlements != nil {
+		org.Entitlements = *input.Entitlements
+	}
+
+	v := validator.New()
+
+	validateOrg(v, org.Name, org.Seats)
+
+	if !v.Valid() {
+		o.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	members, err := o.members.Count(ctx, org.ID)
+	if err != nil {
+		o.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	// members keep their seat, they have to be removed first
+	v.Check(int64(org.Seats) >= members, "seats", fmt.Sprintf("must not be less than the %d current members", members))
+
+	if !v.Valid() {
+		o.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	err = o.orgs.Update(ctx, org)
+	if err != nil {
+		o.orgErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = o.helper.WriteJSON(w, http.StatusOK, envelope{"org": org}, nil)
+	if err != nil {
+		o.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+// ListMyOrgsHandler lists the orgs of the current user with their role.
+func (o *Orgs) ListMyOrgsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	user := o.userCtx.ContextGetUser(r)
+
+	members, err := o.members.ListForUser(ctx, user.Email)
+	if err != nil {
+		o.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(members))
+	roles := make(map[primitive.ObjectID]string, len(members))
+
+	for _, m := range members {
+		ids = append(ids, m.OrgID)
+		roles[m.OrgID] = m.Role
+	}
+
+	orgs, err := o.orgs.ListByIDs(ctx, ids)
+	if err != nil {
+		o.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	type membership struct {
+		Org  Org    `json:"org"`
+		Role string `json:"role"`
+	}
+
+	memberships := make([]membership, 0, len(orgs))
+	for _, org := range orgs {
+		memberships = append(memberships, membership{Org: org, Role: roles[org.ID]})
+	}
+
+	err = o.helper.WriteJSON(w, http.StatusOK, envelope{"orgs": memberships}, nil)
+	if err != nil {
+		o.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+// GetOrgHandler shows the org to its members. Owners and managers also see
+// the members, open invites and how many seats are used.
+func (o *Orgs) GetOrgHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	org, member, err := o.membership(ctx, r)
+	if err != nil {
+		o.orgErrorResponse(w, r, err)
+
+		return
+	}
+
+	env := envelope{"org": org, "role": member.Role}
+
+	if member.CanManage() {
+		members, err := o.members.ListForOrg(ctx, org.ID)
+		if err != nil {
+			o.e.ServerErrorResponse(w, r, err)
+
+			return
+		}
+
+		invites, err := o.invites.ListPending(ctx, org.ID)
+		if err != nil {
+			o.e.ServerErrorResponse(w, r, err)
+
+			return
+		}
+
+		env["members"] = members
+		env["invites"] = invites
+		env["seats_used"] = len(members) + len(invites)
+	}
+
+	err = o.helper.WriteJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		o.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+// InviteHandler emails an invite that holds a seat until it is accepted.
+// Managers can only invite members.
+func (o *Orgs) InviteHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	org, member, err := o.membership(ctx, r)
+	if err != nil {
+		o.orgErrorResponse(w, r, err)
+
+		return
+	}
+
+	if !member.CanManage() {
+		o.orgErrorResponse(w, r, ErrNotManager)
+
+		return
+	}
+
+	var input struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+
+	err = o.helper.ReadJSON(w, r, &input)
+	if err != nil {
+		o.e.BadRequestResponse(w, r, err)
+
+		return
+	}
+
+	if input.Role == "" {
+		input.Role = RoleMember
+	}
+
+	v := validator.New()
+
+	validateEmail(v, "email", input.Email)
+	v.Check(ValidRole(input.Role), "role", "must be one of owner, manager or member")
+
+	if !v.Valid() {
+		o.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	if input.Role != RoleMember && member.Role != RoleOwner {
+		o.orgErrorResponse(w, r, ErrRoleNotAllowed)
+
+		return
+	}
+
+	_, err = o.members.Get(ctx, org.ID, input.Email)
+	if err == nil {
+		v.AddError("email", "is already a member of the organization")
+		o.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	if !errors.Is(err, ErrMemberNotFound) {
+		o.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	used, err := o.seatsUsed(ctx, org.ID)
+	if err != nil {
+		o.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	if used >= int64(org.Seats) {
+		o.orgErrorResponse(w, r, ErrNoSeats)
+
+		return
+	}
+
+	invite, err := o.invite(ctx, org, input.Email, input.Role, o.userCtx.ContextGetUser(r).Email)
+	if err != nil {
+		o.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = o.helper.WriteJSON(w, http.StatusCreated, envelope{"invite": invite}, nil)
+	if err != nil {
+		o.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (o *Orgs) RevokeInviteHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	org, member, err := o.membership(ctx, r)
+	if err != nil {
+		o.orgErrorResponse(w, r, err)
+
+		return
+	}
+
+	if !member.CanManage() {
+		o.orgErrorResponse(w, r, ErrNotManager)
+
+		return
+	}
+
+	err = o.invites.Revoke(ctx, org.ID, mux.Vars(r)["invite"])
+	if err != nil {
+		o.orgErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = o.helper.WriteJSON(w, http.StatusOK, envelope{"message": "invite revoked"}, nil)
+	if err != nil {
+		o.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+// AcceptInviteHandler adds the current user to the org of the invite. The
+// invite has to be addressed to their email.
+func (o *Orgs) AcceptInviteHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := o.helper.ReadJSON(w, r, &input)
+	if err != nil {
+		o.e.BadRequestResponse(w, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	auth.ValidateScopedTokenPlaintext(v, input.TokenPlaintext)
+
+	if !v.Valid() {
+		o.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	user := o.userCtx.ContextGetUser(r)
+
+	invite, err := o.invites.Find(ctx, input.TokenPlaintext, user.Email)
+	if err != nil {
+		o.inviteErrorResponse(w, r, v, err)
+
+		return
+	}
+
+	org, err := o.orgs.Get(ctx, invite.OrgID.Hex())
+	if err != nil {
+		o.orgErrorResponse(w, r, err)
+
+		return
+	}
+
+	// the invite held a seat, but the seats may have been reduced since. A
+	// full org leaves the invite in place, so it can be accepted once a seat
+	// is free.
+	err = o.orgs.TakeSeat(ctx, org.ID)
+	if err != nil {
+		o.orgErrorResponse(w, r, err)
+
+		return
+	}
+
+	member := &Member{
+		OrgID:     org.ID,
+		Email:     user.Email,
+		Role:      invite.Role,
+		InvitedBy: invite.InvitedBy,
+	}
+
+	err = o.members.Add(ctx, member)
+	if err != nil {
+		o.freeSeat(ctx, org.ID)
+		o.orgErrorResponse(w, r, err)
+
+		return
+	}
+
+	// the invite is used up last, it may have been revoked in the meantime
+	_, err = o.invites.Consume(ctx, input.TokenPlaintext, user.Email)
+	if err != nil {
+		removeErr := o.members.Remove(ctx, org.ID, member.Email)
+		if removeErr != nil {
+			log.ErrorCtx(removeErr, log.Ctx{
+				"msg": "unable to undo joining an org",
+				"org": org.ID.Hex(),
+			})
+		} else {
+			o.freeSeat(ctx, org.ID)
+		}
+
+		o.inviteErrorResponse(w, r, v, err)
+
+		return
+	}
+
+	org.Members++
+
+	err = o.helper.WriteJSON(w, http.StatusOK, envelope{"org": org, "role": member.Role}, nil)
+	if err != nil {
+		o.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+// UpdateMemberRoleHandler lets owners change the role of a member.
+func (o *Orgs) UpdateMemberRoleHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	org, member, err := o.membership(ctx, r)
+	if err != nil {
+		o.orgErrorResponse(w, r, err)
+
+		return
+	}
+
+	if member.Role != RoleOwner {
+		o.orgErrorResponse(w, r, ErrRoleNotAllowed)
+
+		return
+	}
+
+	var input struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+
+	err = o.helper.ReadJSON(w, r, &input)
+	if err != nil {
+		o.e.BadRequestResponse(w, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	validateEmail(v, "email", input.Email)
+	v.Check(ValidRole(input.Role), "role", "must be one of owner, manager or member")
+
+	if !v.Valid() {
+		o.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	target, err := o.members.Get(ctx, org.ID, input.Email)
+	if err != nil {
+		o.orgErrorResponse(w, r, err)
+
+		return
+	}
+
+	if target.Role == RoleOwner && input.Role != RoleOwner {
+		err = o.keepOwner(ctx, org.ID)
+		if err != nil {
+			o.orgErrorResponse(w, r, err)
+
+			return
+		}
+	}
+
+	err = o.members.UpdateRole(ctx, org.ID, target.Email, input.Role)
+	if err != nil {
+		o.orgErrorResponse(w, r, err)
+
+		return
+	}
+
+	target.Role = input.Role
+
+	err = o.helper.WriteJSON(w, http.StatusOK, envelope{"member": target}, nil)
+	if err != nil {
+		o.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+// RemoveMemberHandler frees the seat of a member. Anyone can leave, managers
+// can remove members and owners anyone, as long as an owner remains.
+func (o *Orgs) RemoveMemberHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	org, member, err := o.membership(ctx, r)
+	if err != nil {
+		o.orgErrorResponse(w, r, err)
+
+		return
+	}
+
+	email := mux.Vars(r)["email"]
+
+	target, err := o.members.Get(ctx, org.ID, email)
+	if err != nil {
+		o.orgErrorResponse(w, r, err)
+
+		return
+	}
+
+	leaving := strings.EqualFold(target.Email, member.Email)
+
+	switch {
+	case leaving, member.Role == RoleOwner:
+	case member.Role == RoleManager && target.Role == RoleMember:
+	default:
+		o.orgErrorResponse(w, r, ErrNotManager)
+
+		return
+	}
+
+	if target.Role == RoleOwner {
+		err = o.keepOwner(ctx, org.ID)
+		if err != nil {
+			o.orgErrorResponse(w, r, err)
+
+			return
+		}
+	}
+
+	err = o.members.Remove(ctx, org.ID, target.Email)
+	if err != nil {
+		o.orgErrorResponse(w, r, err)
+
+		return
+	}
+
+	o.freeSeat(ctx, org.ID)
+
+	err = o.helper.WriteJSON(w, http.StatusOK, envelope{"message": "member removed"}, nil)
+	if err != nil {
+		o.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+// Entitled reports whether an org of the user covers the subscription course.
+func (o *Orgs) Entitled(ctx context.Context, email, courseID string) (bool, error) {
+	members, err := o.members.ListForUser(ctx, email)
+	if err != nil {
+		return false, err
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(members))
+	for _, m := range members {
+		ids = append(ids, m.OrgID)
+	}
+
+	orgs, err := o.orgs.ListByIDs(ctx, ids)
+	if err != nil {
+		return false, err
+	}
+
+	for _, org := range orgs {
+		if org.Entitlements.Includes(courseID) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// membership returns the org of the request and the current user's
+// membership. Admins can manage every org as if they owned it.
+func (o *Orgs) membership(ctx context.Context, r *http.Request) (*Org, *Member, error) {
+	org, err := o.orgs.Get(ctx, mux.Vars(r)["id"])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user := o.userCtx.ContextGetUser(r)
+
+	member, err := o.members.Get(ctx, org.ID, user.Email)
+	if err == nil {
+		return org, member, nil
+	}
+
+	if errors.Is(err, ErrMemberNotFound) && user.HasPermission(data.PermissionUsersAdmin) {
+		return org, &Member{OrgID: org.ID, Email: user.Email, Role: RoleOwner}, nil
+	}
+
+	// orgs of others look like they don't exist
+	if errors.Is(err, ErrMemberNotFound) {
+		return nil, nil, ErrOrgNotFound
+	}
+
+	return nil, nil, err
+}
+
+func (o *Orgs) seatsUsed(ctx context.Context, orgID primitive.ObjectID) (int64, error) {
+	members, err := o.members.Count(ctx, orgID)
+	if err != nil {
+		return 0, err
+	}
+
+	invites, err := o.invites.CountPending(ctx, orgID)
+	if err != nil {
+		return 0, err
+	}
+
+	return members + invites, nil
+}
+
+// freeSeat gives back the seat of a member that left. A failure only leaves
+// the org looking fuller than it is, so it is logged.
+func (o *Orgs) freeSeat(ctx context.Context, orgID primitive.ObjectID) {
+	err := o.orgs.FreeSeat(ctx, orgID)
+	if err != nil {
+		log.ErrorCtx(err, log.Ctx{
+			"msg": "unable to free seat",
+			"org": orgID.Hex(),
+		})
+	}
+}
+
+// CountMembers starts counting the members of orgs created before seats were
+// taken with the member counter.
+func (o *Orgs) CountMembers(ctx context.Context) (int, error) {
+	orgs, err := o.orgs.ListUncounted(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, org := range orgs {
+		members, err := o.members.Count(ctx, org.ID)
+		if err != nil {
+			return 0, err
+		}
+
+		err = o.orgs.SetMembers(ctx, org.ID, members)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(orgs), nil
+}
+
+func (o *Orgs) keepOwner(ctx context.Context, orgID primitive.ObjectID) error {
+	owners, err := o.members.CountOwners(ctx, orgID)
+	if err != nil {
+		return err
+	}
+
+	if owners <= 1 {
+		return ErrLastOwner
+	}
+
+	return nil
+}
+
+// invite creates the invite and emails it in the background.
+func (o *Orgs) invite(ctx context.Context, org *Org, email, role, invitedBy string) (*Invite, error) {
+	clog := log.GetLoggerFromContext(ctx)
+
+	ttl := o.cfg.Orgs.InviteTTL
+
+	invite, err := o.invites.New(ctx, org.ID, email, role, invitedBy, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	o.helper.Background(func() {
+		data := map[string]any{
+			"orgName":   org.Name,
+			"role":      role,
+			"invitedBy": invitedBy,
+			"inviteURL": fmt.Sprintf("%s/orgs/invites/accept?token=%s", o.cfg.FrontendURL, invite.Plaintext),
+			"expiresIn": ttl.String(),
+		}
+
+		err := o.mailer.Send(invite.Email, "org_invite.tmpl", data)
+		if err != nil {
+			clog.ErrorCtx(err, log.Ctx{
+				"msg": "unable to send organization invite email",
+			})
+		}
+	})
+
+	return invite, nil
+}
+
+func (o *Orgs) orgErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, ErrOrgNotFound), errors.Is(err, ErrMemberNotFound), errors.Is(err, ErrInviteNotFound):
+		o.e.NotFoundResponse(w, r)
+	case errors.Is(err, ErrNotManager), errors.Is(err, ErrRoleNotAllowed):
+		o.e.NotPermittedResponse(w, r)
+	case errors.Is(err, ErrNoSeats), errors.Is(err, ErrLastOwner), errors.Is(err, ErrAlreadyMember):
+		o.e.BadRequestResponse(w, r, err)
+	default:
+		o.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (o *Orgs) inviteErrorResponse(w http.ResponseWriter, r *http.Request, v *validator.Validator, err error) {
+	switch {
+	case errors.Is(err, ErrInviteNotFound):
+		v.AddError("token", "invalid or expired invite")
+		o.e.FailedValidationResponse(w, r, v.Errors)
+	default:
+		o.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+func validateOrg(v *validator.Validator, name string, seats int) {
+	v.Check(strings.TrimSpace(name) != "", "name", "must be provided")
+	v.Check(len(name) <= 200, "name", "must not be more than 200 bytes long")
+	v.Check(seats > 0, "seats", "must be greater than zero")
+}
+
+func validateEmail(v *validator.Validator, key, email string) {
+	v.Check(email != "", key, "must be provided")
+	v.Check(validator.Matches(email, validator.EmailRX), key, "must be a valid email address")
+}
+
+func NewOrgsService(orgs OrgRepository, members MemberRepository, invites InviteRepository,
+	mailer Mailer, cfg config.Config) *Orgs {
+	return &Orgs{
+		helper:  pkg.Helper{},
+		e:       pkg.CustomErrors{},
+		orgs:    orgs,
+		members: members,
+		invites: invites,
+		mailer:  mailer,
+		userCtx: data.UsersContext{},
+		cfg:     cfg,
+	}
+}
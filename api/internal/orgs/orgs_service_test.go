@@ -0,0 +1,605 @@
+package orgs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"interviews/internal/config"
+	data "interviews/internal/users"
+	"interviews/internal/users/userstest"
+)
+
+type MockOrgRepository struct {
+	mu   sync.Mutex
+	orgs []*Org
+}
+
+func (m *MockOrgRepository) New(ctx context.Context, org *Org) error {
+	org.ID = primitive.NewObjectID()
+	m.orgs = append(m.orgs, org)
+
+	return nil
+}
+
+func (m *MockOrgRepository) Get(ctx context.Context, id string) (*Org, error) {
+	for _, org := range m.orgs {
+		if org.ID.Hex() == id {
+			copied := *org
+
+			return &copied, nil
+		}
+	}
+
+	return nil, ErrOrgNotFound
+}
+
+func (m *MockOrgRepository) ListByIDs(ctx context.Context, ids []primitive.ObjectID) ([]Org, error) {
+	orgs := []Org{}
+
+	for _, org := range m.orgs {
+		for _, id := range ids {
+			if org.ID == id {
+				orgs = append(orgs, *org)
+			}
+		}
+	}
+
+	return orgs, nil
+}
+
+func (m *MockOrgRepository) Update(ctx context.Context, org *Org) error {
+	for i := range m.orgs {
+		if m.orgs[i].ID == org.ID {
+			*m.orgs[i] = *org
+
+			return nil
+		}
+	}
+
+	return ErrOrgNotFound
+}
+
+func (m *MockOrgRepository) TakeSeat(ctx context.Context, id primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, org := range m.orgs {
+		if org.ID == id {
+			if org.Members >= org.Seats {
+				return ErrNoSeats
+			}
+
+			org.Members++
+
+			return nil
+		}
+	}
+
+	return ErrNoSeats
+}
+
+func (m *MockOrgRepository) FreeSeat(ctx context.Context, id primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, org := range m.orgs {
+		if org.ID == id && org.Members > 0 {
+			org.Members--
+		}
+	}
+
+	return nil
+}
+
+func (m *MockOrgRepository) ListUncounted(ctx context.Context) ([]Org, error) {
+	return []Org{}, nil
+}
+
+func (m *MockOrgRepository) SetMembers(ctx context.Context, id primitive.ObjectID, members int64) error {
+	return nil
+}
+
+type MockMemberRepository struct {
+	mu      sync.Mutex
+	members []Member
+}
+
+func (m *MockMemberRepository) Add(ctx context.Context, member *Member) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.get(member.OrgID, member.Email); err == nil {
+		return ErrAlreadyMember
+	}
+
+	member.Email = strings.ToLower(member.Email)
+	m.members = append(m.members, *member)
+
+	return nil
+}
+
+func (m *MockMemberRepository) Get(ctx context.Context, orgID primitive.ObjectID, email string) (*Member, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.get(orgID, email)
+}
+
+func (m *MockMemberRepository) get(orgID primitive.ObjectID, email string) (*Member, error) {
+	for _, member := range m.members {
+		if member.OrgID == orgID && strings.EqualFold(member.Email, email) {
+			copied := member
+
+			return &copied, nil
+		}
+	}
+
+	return nil, ErrMemberNotFound
+}
+
+func (m *MockMemberRepository) ListForOrg(ctx context.Context, orgID primitive.ObjectID) ([]Member, error) {
+	var members []Member
+
+	for _, member := range m.members {
+		if member.OrgID == orgID {
+			members = append(members, member)
+		}
+	}
+
+	return members, nil
+}
+
+func (m *MockMemberRepository) ListForUser(ctx context.Context, email string) ([]Member, error) {
+	var members []Member
+
+	for _, member := range m.members {
+		if strings.EqualFold(member.Email, email) {
+			members = append(members, member)
+		}
+	}
+
+	return members, nil
+}
+
+func (m *MockMemberRepository) Count(ctx context.Context, orgID primitive.ObjectID) (int64, error) {
+	members, _ := m.ListForOrg(ctx, orgID)
+
+	return int64(len(members)), nil
+}
+
+func (m *MockMemberRepository) CountOwners(ctx context.Context, orgID primitive.ObjectID) (int64, error) {
+	var owners int64
+
+	for _, member := range m.members {
+		if member.OrgID == orgID && member.Role == RoleOwner {
+			owners++
+		}
+	}
+
+	return owners, nil
+}
+
+func (m *MockMemberRepository) UpdateRole(ctx context.Context, orgID primitive.ObjectID, email, role string) error {
+	for i := range m.members {
+		if m.members[i].OrgID == orgID && strings.EqualFold(m.members[i].Email, email) {
+			m.members[i].Role = role
+
+			return nil
+		}
+	}
+
+	return ErrMemberNotFound
+}
+
+func (m *MockMemberRepository) Remove(ctx context.Context, orgID primitive.ObjectID, email string) error {
+	for i := range m.members {
+		if m.members[i].OrgID == orgID && strings.EqualFold(m.members[i].Email, email) {
+			m.members = append(m.members[:i], m.members[i+1:]...)
+
+			return nil
+		}
+	}
+
+	return ErrMemberNotFound
+}
+
+type MockInviteRepository struct {
+	mu      sync.Mutex
+	invites []*Invite
+}
+
+func (m *MockInviteRepository) New(ctx context.Context, orgID primitive.ObjectID, email, role, invitedBy string,
+	ttl time.Duration) (*Invite, error) {
+	invite, err := generateInvite(orgID, email, role, invitedBy, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	invite.ID = primitive.NewObjectID()
+	m.invites = append(m.invites, invite)
+
+	return invite, nil
+}
+
+func (m *MockInviteRepository) Find(ctx context.Context, plaintext, email string) (*Invite, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, invite := range m.invites {
+		if bytes.Equal(invite.Hash, hashInviteToken(plaintext)) && invite.Email == strings.ToLower(email) {
+			return invite, nil
+		}
+	}
+
+	return nil, ErrInviteNotFound
+}
+
+func (m *MockInviteRepository) Consume(ctx context.Context, plaintext, email string) (*Invite, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, invite := range m.invites {
+		if bytes.Equal(invite.Hash, hashInviteToken(plaintext)) && invite.Email == strings.ToLower(email) {
+			m.invites = append(m.invites[:i], m.invites[i+1:]...)
+
+			return invite, nil
+		}
+	}
+
+	return nil, ErrInviteNotFound
+}
+
+func (m *MockInviteRepository) ListPending(ctx context.Context, orgID primitive.ObjectID) ([]Invite, error) {
+	invites := []Invite{}
+
+	for _, invite := range m.invites {
+		if invite.OrgID == orgID {
+			invites = append(invites, *invite)
+		}
+	}
+
+	return invites, nil
+}
+
+func (m *MockInviteRepository) CountPending(ctx context.Context, orgID primitive.ObjectID) (int64, error) {
+	invites, _ := m.ListPending(ctx, orgID)
+
+	return int64(len(invites)), nil
+}
+
+func (m *MockInviteRepository) Revoke(ctx context.Context, orgID primitive.ObjectID, id string) error {
+	for i, invite := range m.invites {
+		if invite.OrgID == orgID && invite.ID.Hex() == id {
+			m.invites = append(m.invites[:i], m.invites[i+1:]...)
+
+			return nil
+		}
+	}
+
+	return ErrInviteNotFound
+}
+
+type MockMailer struct {
+	sent chan string
+}
+
+func (m *MockMailer) Send(recipient, templateFile string, data any) error {
+	if m.sent != nil {
+		m.sent <- recipient
+	}
+
+	return nil
+}
+
+type testOrg struct {
+	service *Orgs
+	org     *Org
+	members *MockMemberRepository
+	invites *MockInviteRepository
+	mailer  *MockMailer
+}
+
+// newTestOrg sets up an org with an owner, a manager and a member.
+func newTestOrg(seats int) *testOrg {
+	orgRepo := &MockOrgRepository{}
+	org := &Org{Name: "Acme", Seats: seats, Members: 3, Entitlements: Entitlements{Courses: []string{"go-101"}}}
+	orgRepo.New(context.Background(), org)
+
+	members := &MockMemberRepository{members: []Member{
+		{OrgID: org.ID, Email: "owner@acme.com", Role: RoleOwner},
+		{OrgID: org.ID, Email: "manager@acme.com", Role: RoleManager},
+		{OrgID: org.ID, Email: "member@acme.com", Role: RoleMember},
+	}}
+	invites := &MockInviteRepository{}
+	mailer := &MockMailer{sent: make(chan string, 1)}
+
+	var cfg config.Config
+	cfg.Orgs.InviteTTL = 7 * 24 * time.Hour
+
+	return &testOrg{
+		service: NewOrgsService(orgRepo, members, invites, mailer, cfg),
+		org:     org,
+		members: members,
+		invites: invites,
+		mailer:  mailer,
+	}
+}
+
+func orgRequest(t *testing.T, method, target string, vars map[string]string, user *data.User, body any) *http.Request {
+	return mux.SetURLVars(userstest.NewRequest(t, method, target, user, body), vars)
+}
+
+func TestOrgs_InviteHandler(t *testing.T) {
+	testCases := []struct {
+		name       string
+		seats      int
+		user       string
+		body       map[string]string
+		statusCode int
+	}{
+		{name: "manager invites member", seats: 5, user: "manager@acme.com", body: map[string]string{"email": "new@acme.com"}, statusCode: http.StatusCreated},
+		{name: "owner invites manager", seats: 5, user: "owner@acme.com", body: map[string]string{"email": "new@acme.com", "role": RoleManager}, statusCode: http.StatusCreated},
+		{name: "manager invites manager", seats: 5, user: "manager@acme.com", body: map[string]string{"email": "new@acme.com", "role": RoleManager}, statusCode: http.StatusForbidden},
+		{name: "member invites", seats: 5, user: "member@acme.com", body: map[string]string{"email": "new@acme.com"}, statusCode: http.StatusForbidden},
+		{name: "outsider", seats: 5, user: "someone@else.com", body: map[string]string{"email": "new@acme.com"}, statusCode: http.StatusNotFound},
+		{name: "no free seats", seats: 3, user: "owner@acme.com", body: map[string]string{"email": "new@acme.com"}, statusCode: http.StatusBadRequest},
+		{name: "already a member", seats: 5, user: "owner@acme.com", body: map[string]string{"email": "Member@acme.com"}, statusCode: http.StatusUnprocessableEntity},
+		{name: "unknown role", seats: 5, user: "owner@acme.com", body: map[string]string{"email": "new@acme.com", "role": "boss"}, statusCode: http.StatusUnprocessableEntity},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			o := newTestOrg(tc.seats)
+
+			req := orgRequest(t, http.MethodPost, "/v1/orgs/"+o.org.ID.Hex()+"/invites",
+				map[string]string{"id": o.org.ID.Hex()}, &data.User{Email: tc.user, Activated: true}, tc.body)
+
+			recorder := httptest.NewRecorder()
+			o.service.InviteHandler(recorder, req)
+
+			assert.Equal(t, tc.statusCode, recorder.Code)
+
+			if tc.statusCode != http.StatusCreated {
+				assert.Empty(t, o.invites.invites)
+
+				return
+			}
+
+			select {
+			case recipient := <-o.mailer.sent:
+				assert.Equal(t, "new@acme.com", recipient)
+			case <-time.After(time.Second):
+				t.Fatal("invite email was not sent")
+			}
+		})
+	}
+}
+
+func TestOrgs_AcceptInviteHandler(t *testing.T) {
+	o := newTestOrg(4)
+
+	invite, err := o.invites.New(context.Background(), o.org.ID, "new@acme.com", RoleMember, "owner@acme.com", time.Hour)
+	require.NoError(t, err)
+
+	accept := func(email string) int {
+		req := orgRequest(t, http.MethodPost, "/v1/orgs/invites/accept", nil,
+			&data.User{Email: email, Activated: true}, map[string]string{"token": invite.Plaintext})
+
+		recorder := httptest.NewRecorder()
+		o.service.AcceptInviteHandler(recorder, req)
+
+		return recorder.Code
+	}
+
+	// the invite only works for the address it was sent to
+	assert.Equal(t, http.StatusUnprocessableEntity, accept("other@acme.com"))
+	assert.Equal(t, http.StatusOK, accept("new@acme.com"))
+
+	member, err := o.members.Get(context.Background(), o.org.ID, "new@acme.com")
+	require.NoError(t, err)
+	assert.Equal(t, RoleMember, member.Role)
+	assert.Equal(t, "owner@acme.com", member.InvitedBy)
+
+	assert.Equal(t, 4, o.org.Members)
+
+	// single-use
+	assert.Equal(t, http.StatusUnprocessableEntity, accept("new@acme.com"))
+	assert.Equal(t, 4, o.org.Members)
+}
+
+func TestOrgs_AcceptInviteHandlerNoSeats(t *testing.T) {
+	o := newTestOrg(3)
+
+	invite, err := o.invites.New(context.Background(), o.org.ID, "new@acme.com", RoleMember, "owner@acme.com", time.Hour)
+	require.NoError(t, err)
+
+	accept := func() int {
+		req := orgRequest(t, http.MethodPost, "/v1/orgs/invites/accept", nil,
+			&data.User{Email: "new@acme.com", Activated: true}, map[string]string{"token": invite.Plaintext})
+
+		recorder := httptest.NewRecorder()
+		o.service.AcceptInviteHandler(recorder, req)
+
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusBadRequest, accept())
+
+	// the invite is kept for when a seat is free
+	pending, _ := o.invites.CountPending(context.Background(), o.org.ID)
+	assert.EqualValues(t, 1, pending)
+
+	o.org.Seats = 4
+
+	assert.Equal(t, http.StatusOK, accept())
+	assert.Equal(t, 4, o.org.Members)
+}
+
+func TestOrgs_AcceptInviteHandlerConcurrent(t *testing.T) {
+	o := newTestOrg(4)
+
+	emails := []string{"first@acme.com", "second@acme.com"}
+	tokens := make([]string, len(emails))
+
+	for i, email := range emails {
+		invite, err := o.invites.New(context.Background(), o.org.ID, email, RoleMember, "owner@acme.com", time.Hour)
+		require.NoError(t, err)
+
+		tokens[i] = invite.Plaintext
+	}
+
+	codes := make([]int, len(emails))
+
+	var wg sync.WaitGroup
+
+	for i := range emails {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			req := orgRequest(t, http.MethodPost, "/v1/orgs/invites/accept", nil,
+				&data.User{Email: emails[i], Activated: true}, map[string]string{"token": tokens[i]})
+
+			recorder := httptest.NewRecorder()
+			o.service.AcceptInviteHandler(recorder, req)
+
+			codes[i] = recorder.Code
+		}(i)
+	}
+
+	wg.Wait()
+
+	// only one of them gets the last seat
+	assert.ElementsMatch(t, []int{http.StatusOK, http.StatusBadRequest}, codes)
+
+	members, _ := o.members.Count(context.Background(), o.org.ID)
+	assert.EqualValues(t, 4, members)
+	assert.Equal(t, 4, o.org.Members)
+}
+
+func TestOrgs_RemoveMemberHandler(t *testing.T) {
+	testCases := []struct {
+		name       string
+		user       string
+		target     string
+		statusCode int
+	}{
+		{name: "manager removes member", user: "manager@acme.com", target: "member@acme.com", statusCode: http.StatusOK},
+		{name: "member leaves", user: "member@acme.com", target: "member@acme.com", statusCode: http.StatusOK},
+		{name: "owner removes manager", user: "owner@acme.com", target: "manager@acme.com", statusCode: http.StatusOK},
+		{name: "manager removes owner", user: "manager@acme.com", target: "owner@acme.com", statusCode: http.StatusForbidden},
+		{name: "member removes member", user: "member@acme.com", target: "manager@acme.com", statusCode: http.StatusForbidden},
+		{name: "last owner leaves", user: "owner@acme.com", target: "owner@acme.com", statusCode: http.StatusBadRequest},
+		{name: "unknown member", user: "owner@acme.com", target: "nobody@acme.com", statusCode: http.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			o := newTestOrg(5)
+
+			req := orgRequest(t, http.MethodDelete, "/v1/orgs/"+o.org.ID.Hex()+"/members/"+tc.target,
+				map[string]string{"id": o.org.ID.Hex(), "email": tc.target}, &data.User{Email: tc.user, Activated: true}, nil)
+
+			recorder := httptest.NewRecorder()
+			o.service.RemoveMemberHandler(recorder, req)
+
+			assert.Equal(t, tc.statusCode, recorder.Code)
+
+			_, err := o.members.Get(context.Background(), o.org.ID, tc.target)
+			if tc.statusCode == http.StatusOK {
+				assert.ErrorIs(t, err, ErrMemberNotFound)
+				assert.Equal(t, 2, o.org.Members)
+			} else {
+				assert.Equal(t, 3, o.org.Members)
+			}
+		})
+	}
+}
+
+func TestOrgs_UpdateMemberRoleHandler(t *testing.T) {
+	o := newTestOrg(5)
+
+	update := func(user, email, role string) int {
+		req := orgRequest(t, http.MethodPut, "/v1/orgs/"+o.org.ID.Hex()+"/members/role",
+			map[string]string{"id": o.org.ID.Hex()}, &data.User{Email: user, Activated: true},
+			map[string]string{"email": email, "role": role})
+
+		recorder := httptest.NewRecorder()
+		o.service.UpdateMemberRoleHandler(recorder, req)
+
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusForbidden, update("manager@acme.com", "member@acme.com", RoleManager))
+	assert.Equal(t, http.StatusBadRequest, update("owner@acme.com", "owner@acme.com", RoleMember))
+	assert.Equal(t, http.StatusOK, update("owner@acme.com", "manager@acme.com", RoleOwner))
+
+	// with a second owner the first one can step down
+	assert.Equal(t, http.StatusOK, update("owner@acme.com", "owner@acme.com", RoleMember))
+}
+
+func TestOrgs_GetOrgHandler(t *testing.T) {
+	o := newTestOrg(5)
+
+	get := func(user *data.User) (int, map[string]any) {
+		req := orgRequest(t, http.MethodGet, "/v1/orgs/"+o.org.ID.Hex(),
+			map[string]string{"id": o.org.ID.Hex()}, user, nil)
+
+		recorder := httptest.NewRecorder()
+		o.service.GetOrgHandler(recorder, req)
+
+		var body map[string]any
+		json.NewDecoder(recorder.Body).Decode(&body)
+
+		return recorder.Code, body
+	}
+
+	code, body := get(&data.User{Email: "manager@acme.com"})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, body["members"], 3)
+	assert.EqualValues(t, 3, body["seats_used"])
+
+	code, body = get(&data.User{Email: "member@acme.com"})
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotContains(t, body, "members")
+
+	// admins can look after any org
+	code, body = get(&data.User{Email: "admin@test.com", Role: data.RoleAdmin})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, RoleOwner, body["role"])
+
+	code, _ = get(&data.User{Email: "someone@else.com"})
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestOrgs_Entitled(t *testing.T) {
+	o := newTestOrg(5)
+
+	entitled, err := o.service.Entitled(context.Background(), "member@acme.com", "go-101")
+	require.NoError(t, err)
+	assert.True(t, entitled)
+
+	entitled, err = o.service.Entitled(context.Background(), "member@acme.com", "rust-101")
+	require.NoError(t, err)
+	assert.False(t, entitled)
+
+	entitled, err = o.service.Entitled(context.Background(), "someone@else.com", "go-101")
+	require.NoError(t, err)
+	assert.False(t, entitled)
+
+	assert.True(t, Entitlements{AllSubscriptionCourses: true}.Includes("rust-101"))
+}
@@ -0,0 +1,92 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const prefixLen = 5
+
+var ErrInvalidBreachedList = errors.New("invalid breached password list")
+
+// BreachedList holds SHA-1 hashes of passwords known from data breaches,
+// grouped by the first five hex characters like the k-anonymity range files
+// of Have I Been Pwned. Passwords are only ever hashed, never stored.
+type BreachedList struct {
+	// prefix -> sorted hash suffixes
+	ranges map[string][]string
+	size   int
+}
+
+// LoadBreachedList reads a file with one uppercase or lowercase hex SHA-1 hash
+// per line, optionally followed by ":count" as in the Have I Been Pwned
+// downloads. Empty lines and lines starting with # are skipped.
+func LoadBreachedList(path string) (*BreachedList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	list := &BreachedList{ranges: make(map[string][]string)}
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		hash, _, _ := strings.Cut(text, ":")
+		hash = strings.ToUpper(hash)
+
+		if _, err := hex.DecodeString(hash); err != nil || len(hash) != sha1.Size*2 {
+			return nil, fmt.Errorf("%w: line %d of %s", ErrInvalidBreachedList, line, path)
+		}
+
+		list.ranges[hash[:prefixLen]] = append(list.ranges[hash[:prefixLen]], hash[prefixLen:])
+		list.size++
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, suffixes := range list.ranges {
+		sort.Strings(suffixes)
+	}
+
+	return list, nil
+}
+
+// Contains reports whether the password is in the list. A nil list contains
+// nothing.
+func (b *BreachedList) Contains(password string) bool {
+	if b == nil {
+		return false
+	}
+
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	suffixes := b.ranges[hash[:prefixLen]]
+	i := sort.SearchStrings(suffixes, hash[prefixLen:])
+
+	return i < len(suffixes) && suffixes[i] == hash[prefixLen:]
+}
+
+// Len returns how many hashes were loaded.
+func (b *BreachedList) Len() int {
+	if b == nil {
+		return 0
+	}
+
+	return b.size
+}
@@ -0,0 +1,221 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrUnknownHash = errors.New("unknown password hash format")
+	ErrInvalidHash = errors.New("invalid password hash")
+	ErrInvalidCost = errors.New("argon2id memory, iterations, parallelism and key length must be greater than zero")
+)
+
+// Hasher hashes passwords into self-describing strings that carry their
+// algorithm and parameters.
+type Hasher interface {
+	Hash(password string) (string, error)
+	// Verify reports whether the password matches the hash and whether the
+	// hash should be replaced because it was made with other parameters.
+	Verify(password, encoded string) (match, rehash bool, err error)
+	// Supports reports whether the hash was made by this kind of hasher.
+	Supports(encoded string) bool
+}
+
+// Argon2id hashes into the PHC string format, e.g.
+// $argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>.
+type Argon2id struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2id follows the OWASP recommendation for Argon2id.
+var DefaultArgon2id = Argon2id{
+	Memory:      19 * 1024,
+	Iterations:  2,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// Validate checks the parameters, argon2 panics when some of them are zero.
+func (a Argon2id) Validate() error {
+	if a.Memory == 0 || a.Iterations == 0 || a.Parallelism == 0 || a.KeyLength == 0 {
+		return ErrInvalidCost
+	}
+
+	return nil
+}
+
+func (a Argon2id) Hash(password string) (string, error) {
+	err := a.Validate()
+	if err != nil {
+		return "", err
+	}
+
+	salt := make([]byte, a.SaltLength)
+
+	_, err = rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, a.Iterations, a.Memory, a.Parallelism, a.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, a.Memory, a.Iterations, a.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (a Argon2id) Verify(password, encoded string) (bool, bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, false, err
+	}
+
+	other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return false, false, nil
+	}
+
+	rehash := params.Memory != a.Memory || params.Iterations != a.Iterations ||
+		params.Parallelism != a.Parallelism || params.KeyLength != a.KeyLength || params.SaltLength != a.SaltLength
+
+	return true, rehash, nil
+}
+
+func (a Argon2id) Supports(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func decodeArgon2id(encoded string) (Argon2id, []byte, []byte, error) {
+	var params Argon2id
+
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	var version int
+
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+
+	if params.Validate() != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	return params, salt, key, nil
+}
+
+// Bcrypt is kept to verify hashes from before Argon2id. It truncates
+// passwords after 72 bytes.
+type Bcrypt struct {
+	Cost int
+}
+
+func (b Bcrypt) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+func (b Bcrypt) Verify(password, encoded string) (bool, bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, false, nil
+		}
+
+		return false, false, err
+	}
+
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return false, false, err
+	}
+
+	return true, cost < b.Cost, nil
+}
+
+func (b Bcrypt) Supports(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+// Chain hashes new passwords with the current hasher and still verifies hashes
+// of the legacy ones, asking for a rehash so they are upgraded on login.
+type Chain struct {
+	current Hasher
+	legacy  []Hasher
+}
+
+func NewChain(current Hasher, legacy ...Hasher) *Chain {
+	return &Chain{current: current, legacy: legacy}
+}
+
+func (c *Chain) Hash(password string) (string, error) {
+	return c.current.Hash(password)
+}
+
+func (c *Chain) Verify(password, encoded string) (bool, bool, error) {
+	if c.current.Supports(encoded) {
+		return c.current.Verify(password, encoded)
+	}
+
+	for _, h := range c.legacy {
+		if h.Supports(encoded) {
+			match, _, err := h.Verify(password, encoded)
+
+			return match, match, err
+		}
+	}
+
+	return false, false, ErrUnknownHash
+}
+
+func (c *Chain) Supports(encoded string) bool {
+	if c.current.Supports(encoded) {
+		return true
+	}
+
+	for _, h := range c.legacy {
+		if h.Supports(encoded) {
+			return true
+		}
+	}
+
+	return false
+}
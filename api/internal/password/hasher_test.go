@@ -0,0 +1,78 @@
+package password
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestArgon2id(t *testing.T) {
+	hasher := Argon2id{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+	hash, err := hasher.Hash("correct horse")
+	require.NoError(t, err)
+	assert.Regexp(t, `^\$argon2id\$v=19\$m=64,t=1,p=1\$[A-Za-z0-9+/]{22}\$[A-Za-z0-9+/]{43}$`, hash)
+
+	other, err := hasher.Hash("correct horse")
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, other, "every hash gets its own salt")
+
+	match, rehash, err := hasher.Verify("correct horse", hash)
+	require.NoError(t, err)
+	assert.True(t, match)
+	assert.False(t, rehash)
+
+	match, _, err = hasher.Verify("battery staple", hash)
+	require.NoError(t, err)
+	assert.False(t, match)
+
+	// stronger parameters ask for the hash to be upgraded
+	stronger := hasher
+	stronger.Iterations = 2
+
+	match, rehash, err = stronger.Verify("correct horse", hash)
+	require.NoError(t, err)
+	assert.True(t, match)
+	assert.True(t, rehash)
+
+	_, _, err = hasher.Verify("correct horse", "$argon2id$v=19$m=64,t=1$salt$key")
+	assert.ErrorIs(t, err, ErrInvalidHash)
+
+	// argon2 panics on these, a stored hash must not bring down the login
+	for _, params := range []string{"m=64,t=0,p=1", "m=64,t=1,p=0", "m=0,t=1,p=1"} {
+		_, _, err = hasher.Verify("correct horse", "$argon2id$v=19$"+params+"$c2FsdHNhbHRzYWx0c2FsdA$a2V5")
+		assert.ErrorIs(t, err, ErrInvalidHash, params)
+	}
+
+	_, err = Argon2id{Memory: 64, Iterations: 0, Parallelism: 1, SaltLength: 16, KeyLength: 32}.Hash("correct horse")
+	assert.ErrorIs(t, err, ErrInvalidCost)
+	assert.ErrorIs(t, Argon2id{Memory: 64, Iterations: 1, SaltLength: 16, KeyLength: 32}.Validate(), ErrInvalidCost)
+	assert.NoError(t, DefaultArgon2id.Validate())
+}
+
+func TestChain_Verify(t *testing.T) {
+	current := Argon2id{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	chain := NewChain(current, Bcrypt{Cost: bcrypt.MinCost})
+
+	legacy, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	match, rehash, err := chain.Verify("correct horse", string(legacy))
+	require.NoError(t, err)
+	assert.True(t, match)
+	assert.True(t, rehash, "bcrypt hashes are upgraded")
+
+	match, rehash, err = chain.Verify("battery staple", string(legacy))
+	require.NoError(t, err)
+	assert.False(t, match)
+	assert.False(t, rehash)
+
+	hash, err := chain.Hash("correct horse")
+	require.NoError(t, err)
+	assert.True(t, current.Supports(hash))
+
+	_, _, err = chain.Verify("correct horse", "")
+	assert.ErrorIs(t, err, ErrUnknownHash)
+}
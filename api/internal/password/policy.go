@@ -0,0 +1,84 @@
+package password
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	validator "interviews/pkg/vaildator"
+)
+
+const (
+	// no policy can go below this
+	minLength = 8
+	// keeps hashing cheap enough that long inputs can't be used to load the
+	// server
+	MaxLength = 128
+)
+
+// Policy decides which new passwords are accepted. It isn't applied at login
+// so tightening it doesn't lock anyone out.
+type Policy struct {
+	MinLength int
+	// how many of lowercase letters, uppercase letters, digits and symbols
+	// the password has to mix
+	MinClasses int
+	// nil disables the breached password check
+	Breached *BreachedList
+}
+
+// Validate checks a new password of the user with the email. The email may be
+// empty when it isn't known yet.
+func (p Policy) Validate(v *validator.Validator, password, email string) {
+	length := p.MinLength
+	if length < minLength {
+		length = minLength
+	}
+
+	v.Check(password != "", "password", "must be provided")
+	v.Check(len(password) >= length, "password", fmt.Sprintf("must be at least %d bytes long", length))
+	v.Check(len(password) <= MaxLength, "password", fmt.Sprintf("must not be more than %d bytes long", MaxLength))
+	v.Check(characterClasses(password) >= p.MinClasses, "password",
+		fmt.Sprintf("must contain at least %d of lowercase letters, uppercase letters, digits and symbols", p.MinClasses))
+	v.Check(!containsEmail(password, email), "password", "must not contain your email address")
+	v.Check(!p.Breached.Contains(password), "password", "has appeared in a data breach, please choose another one")
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, symbol int
+
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+
+	return lower + upper + digit + symbol
+}
+
+// containsEmail reports whether the password contains the email or the part
+// before the @, ignoring case.
+func containsEmail(password, email string) bool {
+	if email == "" {
+		return false
+	}
+
+	password = strings.ToLower(password)
+	email = strings.ToLower(email)
+
+	local, _, _ := strings.Cut(email, "@")
+
+	// very short local parts would reject too many passwords
+	if len(local) < 3 {
+		return strings.Contains(password, email)
+	}
+
+	return strings.Contains(password, local)
+}
@@ -0,0 +1,81 @@
+package password
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	validator "interviews/pkg/vaildator"
+)
+
+func writeBreachedList(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	return path
+}
+
+func TestPolicy_Validate(t *testing.T) {
+	list, err := LoadBreachedList(writeBreachedList(t,
+		"# sha1 of password123 and Summer2024!\n"+
+			"CBFDAC6008F9CAB4083784CBD1874F76618D2A97:2462819\n"+
+			"7e8b0a3433f1210a9699d85420e363a1b162ecac\n"))
+	require.NoError(t, err)
+
+	policy := Policy{MinLength: 10, MinClasses: 3, Breached: list}
+
+	testCases := []struct {
+		name     string
+		password string
+		email    string
+		err      string
+	}{
+		{name: "strong password", password: "Correct-horse-42", email: "jane@test.com"},
+		{name: "missing", password: "", err: "must be provided"},
+		{name: "one character", password: "a", err: "must be at least 10 bytes long"},
+		{name: "too long", password: "Aa1" + strings.Repeat("x", 126), err: "must not be more than 128 bytes long"},
+		{name: "single class", password: "onlylowercaseletters", err: "must contain at least 3 of lowercase letters, uppercase letters, digits and symbols"},
+		{name: "contains email", password: "Jane.Doe-2024!", email: "jane.doe@test.com", err: "must not contain your email address"},
+		{name: "breached", password: "Summer2024!", err: "has appeared in a data breach, please choose another one"},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			v := validator.New()
+			policy.Validate(v, tc.password, tc.email)
+
+			if tc.err == "" {
+				assert.True(t, v.Valid(), v.Errors)
+				return
+			}
+
+			assert.Equal(t, map[string]string{"password": tc.err}, v.Errors)
+		})
+	}
+}
+
+func TestPolicy_ZeroValueKeepsMinimum(t *testing.T) {
+	v := validator.New()
+	Policy{}.Validate(v, "short", "")
+
+	assert.Equal(t, "must be at least 8 bytes long", v.Errors["password"])
+}
+
+func TestLoadBreachedList(t *testing.T) {
+	list, err := LoadBreachedList(writeBreachedList(t, "cbfdac6008f9cab4083784cbd1874f76618d2a97\n\n"))
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, list.Len())
+	assert.True(t, list.Contains("password123"))
+	assert.False(t, list.Contains("password1234"))
+
+	_, err = LoadBreachedList(writeBreachedList(t, "not-a-hash\n"))
+	assert.ErrorIs(t, err, ErrInvalidBreachedList)
+
+	var none *BreachedList
+	assert.False(t, none.Contains("password123"))
+}
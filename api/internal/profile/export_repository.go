@@ -0,0 +1,144 @@
+package profile
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+var ErrExportNotFound = errors.New("export not found")
+
+// Export is a copy of a user's data they asked for. The archive is kept until
+// the export expires.
+type Export struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Email       string             `json:"-" bson:"email"`
+	Status      string             `json:"status" bson:"status"`
+	CreatedAt   time.Time          `json:"created_at" bson:"createdAt"`
+	CompletedAt time.Time          `json:"completed_at,omitempty" bson:"completedAt,omitempty"`
+	Expiry      time.Time          `json:"expiry" bson:"expiry"`
+	Archive     []byte             `json:"-" bson:"archive,omitempty"`
+}
+
+type ExportRepo struct {
+	client     *mongo.Client
+	collection *mongo.Collection
+}
+
+func (m *ExportRepo) New(ctx context.Context, email string, ttl time.Duration) (*Export, error) {
+	now := time.Now()
+
+	export := &Export{
+		Email:     email,
+		Status:    ExportPending,
+		CreatedAt: now,
+		Expiry:    now.Add(ttl),
+	}
+
+	res, err := m.collection.InsertOne(ctx, export)
+	if err != nil {
+		return nil, err
+	}
+
+	export.ID = res.InsertedID.(primitive.ObjectID)
+
+	return export, nil
+}
+
+// Get returns the user's export unless it expired. The archive is only loaded
+// when asked for.
+func (m *ExportRepo) Get(ctx context.Context, email, id string, withArchive bool) (*Export, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrExportNotFound
+	}
+
+	filter := bson.M{"_id": objectID, "email": email, "expiry": bson.M{"$gt": time.Now()}}
+
+	opts := options.FindOne()
+	if !withArchive {
+		opts.SetProjection(bson.M{"archive": 0})
+	}
+
+	var export Export
+
+	err = m.collection.FindOne(ctx, filter, opts).Decode(&export)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrExportNotFound
+		}
+
+		return nil, err
+	}
+
+	return &export, nil
+}
+
+// Latest returns the user's most recent export, or nil if there is none.
+func (m *ExportRepo) Latest(ctx context.Context, email string) (*Export, error) {
+	opts := options.FindOne().SetSort(bson.M{"createdAt": -1}).SetProjection(bson.M{"archive": 0})
+
+	var export Export
+
+	err := m.collection.FindOne(ctx, bson.M{"email": email}, opts).Decode(&export)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return &export, nil
+}
+
+func (m *ExportRepo) Complete(ctx context.Context, id primitive.ObjectID, archive []byte) error {
+	update := bson.M{
+		"$set": bson.M{
+			"status":      ExportReady,
+			"archive":     archive,
+			"completedAt": time.Now(),
+		},
+	}
+
+	_, err := m.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+
+	return err
+}
+
+func (m *ExportRepo) Fail(ctx context.Context, id primitive.ObjectID) error {
+	_, err := m.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"status": ExportFailed}})
+
+	return err
+}
+
+func (m *ExportRepo) DeleteAllForUser(ctx context.Context, email string) error {
+	_, err := m.collection.DeleteMany(ctx, bson.M{"email": email})
+
+	return err
+}
+
+// ChangeEmail moves the exports of the user to their new address.
+func (m *ExportRepo) ChangeEmail(ctx context.Context, email, newEmail string) error {
+	_, err := m.collection.UpdateMany(ctx, bson.M{"email": email}, bson.M{"$set": bson.M{"email": newEmail}})
+
+	return err
+}
+
+func NewExportRepository(client *mongo.Client, collection *mongo.Collection) *ExportRepo {
+	return &ExportRepo{
+		client:     client,
+		collection: collection,
+	}
+}
@@ -0,0 +1,593 @@
+package profile
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"interviews/internal/audit"
+	"interviews/internal/auth"
+	"interviews/internal/orgs"
+	data "interviews/internal/users"
+	log "interviews/pkg/logger"
+	validator "interviews/pkg/vaildator"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// a pending export older than this died with the instance building it
+	exportTimeout = 15 * time.Minute
+	// how long an instance has to erase an account before another one may
+	// take over
+	deletionLease = 10 * time.Minute
+)
+
+// RequestExportHandler starts assembling everything stored about the user
+// into a zip archive. The user is emailed once it can be downloaded.
+func (p *Profile) RequestExportHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	user := p.userCtx.ContextGetUser(r)
+
+	if user.IsService() {
+		p.e.NotPermittedResponse(w, r)
+
+		return
+	}
+
+	latest, err := p.exports.Latest(ctx, user.Email)
+	if err != nil {
+		p.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	if latest != nil && !exportFailed(latest) {
+		if wait := p.cfg.Privacy.ExportInterval - time.Since(latest.CreatedAt); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			p.e.RateLimitExceededResponse(w, r)
+
+			return
+		}
+	}
+
+	export, err := p.exports.New(ctx, user.Email, p.cfg.Privacy.ExportTTL)
+	if err != nil {
+		p.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	p.recordAudit(ctx, r, audit.ActionDataExportRequested, user.Email, nil)
+
+	p.helper.Background(func() {
+		p.buildExport(context.Background(), export)
+	})
+
+	err = p.helper.WriteJSON(w, http.StatusAccepted, envelope{"export": export}, nil)
+	if err != nil {
+		p.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (p *Profile) GetExportHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	user := p.userCtx.ContextGetUser(r)
+
+	export, err := p.exports.Get(ctx, user.Email, mux.Vars(r)["id"], false)
+	if err != nil {
+		p.exportErrorResponse(w, r, err)
+
+		return
+	}
+
+	if exportFailed(export) {
+		export.Status = ExportFailed
+	}
+
+	err = p.helper.WriteJSON(w, http.StatusOK, envelope{"export": export}, nil)
+	if err != nil {
+		p.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (p *Profile) DownloadExportHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	user := p.userCtx.ContextGetUser(r)
+
+	export, err := p.exports.Get(ctx, user.Email, mux.Vars(r)["id"], true)
+	if err != nil {
+		p.exportErrorResponse(w, r, err)
+
+		return
+	}
+
+	if export.Status != ExportReady {
+		p.e.NotFoundResponse(w, r)
+
+		return
+	}
+
+	filename := fmt.Sprintf("data-export-%s.zip", export.CreatedAt.Format("2006-01-02"))
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.Header().Set("Content-Length", strconv.Itoa(len(export.Archive)))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(export.Archive)
+}
+
+// DeleteMeHandler schedules the account for erasure. The user can change their
+// mind until the grace period is over; after that their data is removed and
+// the audit log only keeps a pseudonym. The last owner of an org has to hand
+// it over first, as when leaving it.
+func (p *Profile) DeleteMeHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+	clog := log.GetLoggerFromContext(r.Context())
+
+	user := p.userCtx.ContextGetUser(r)
+
+	if user.IsService() {
+		p.e.NotPermittedResponse(w, r)
+
+		return
+	}
+
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := p.helper.ReadJSON(w, r, &input)
+	if err != nil {
+		p.e.BadRequestResponse(w, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(strings.EqualFold(strings.TrimSpace(input.Email), user.Email), "email", "must be your email address to confirm the deletion")
+
+	if !v.Valid() {
+		p.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	err = p.keepOwners(ctx, user.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, orgs.ErrLastOwner):
+			p.e.BadRequestResponse(w, r, err)
+		default:
+			p.e.ServerErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	at := user.DeletionScheduledFor
+
+	if at.IsZero() {
+		at = time.Now().Add(p.cfg.Privacy.DeletionGracePeriod)
+
+		err = p.users.ScheduleDeletion(ctx, user.Email, at)
+		if err != nil {
+			p.e.ServerErrorResponse(w, r, err)
+
+			return
+		}
+
+		p.recordAudit(ctx, r, audit.ActionDeletionScheduled, user.Email, map[string]string{
+			"scheduled_for": at.Format(time.RFC3339),
+		})
+
+		p.helper.Background(func() {
+			mail := map[string]any{
+				"deletionDate": at.Format("January 2, 2006"),
+				"cancelURL":    fmt.Sprintf("%s/account", p.cfg.FrontendURL),
+			}
+
+			err := p.mailer.Send(user.Email, "account_deletion.tmpl", mail)
+			if err != nil {
+				clog.ErrorCtx(err, log.Ctx{
+					"msg": "unable to send account deletion email",
+				})
+			}
+		})
+	}
+
+	env := envelope{
+		"message":                "your account and data will be deleted, you can cancel until then",
+		"deletion_scheduled_for": at,
+	}
+
+	err = p.helper.WriteJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		p.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (p *Profile) CancelDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	user := p.userCtx.ContextGetUser(r)
+
+	if user.DeletionScheduledFor.IsZero() {
+		p.e.NotFoundResponse(w, r)
+
+		return
+	}
+
+	err := p.users.CancelDeletion(ctx, user.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDeletionInProgress):
+			p.e.BadRequestResponse(w, r, err)
+		default:
+			p.e.ServerErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	p.recordAudit(ctx, r, audit.ActionDeletionCancelled, user.Email, nil)
+
+	err = p.helper.WriteJSON(w, http.StatusOK, envelope{"message": "your account will not be deleted"}, nil)
+	if err != nil {
+		p.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+// StartDeletionWorker erases the accounts whose grace period is over until the
+// context is cancelled. It also finishes the email changes that failed to move
+// every record, since an account must not be erased with records left behind
+// under its old address. Every instance can run it; each account is claimed by
+// one of them.
+func (p *Profile) StartDeletionWorker(ctx context.Context) {
+	for {
+		p.MoveChangedEmails(ctx)
+		p.EraseDueAccounts(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.cfg.Privacy.DeletionInterval):
+		}
+	}
+}
+
+// EraseDueAccounts erases every account that is due and returns how many it
+// erased.
+func (p *Profile) EraseDueAccounts(ctx context.Context) int {
+	erased := 0
+
+	for ctx.Err() == nil {
+		user, err := p.users.ClaimDueDeletion(ctx, deletionLease)
+		if err != nil {
+			if !errors.Is(err, mongo.ErrNoDocuments) {
+				log.ErrorCtx(err, log.Ctx{
+					"msg": "unable to claim an account for deletion",
+				})
+			}
+
+			return erased
+		}
+
+		// a failed account stays claimed until the lease runs out, so it is
+		// retried on a later run instead of blocking this one
+		err = p.erase(ctx, user)
+		if err != nil {
+			log.ErrorCtx(err, log.Ctx{
+				"msg": "unable to erase account",
+			})
+
+			continue
+		}
+
+		erased++
+	}
+
+	return erased
+}
+
+// erase removes the user's data from every collection. Each step can be
+// repeated, so a failed erasure is simply retried. The user document goes
+// last so the account stays claimable until everything else is gone. Records
+// still under an old address are moved first so none are left behind.
+func (p *Profile) erase(ctx context.Context, user *data.User) error {
+	email := user.Email
+
+	for _, from := range user.MovedFrom {
+		err := p.moveEmail(ctx, from, email)
+		if err != nil {
+			return err
+		}
+	}
+
+	pseudonym, err := newPseudonym()
+	if err != nil {
+		return err
+	}
+
+	_, err = p.sessions.DeleteAllForUser(ctx, email)
+	if err != nil {
+		return err
+	}
+
+	steps := []func(ctx context.Context, email string) error{
+		p.tokens.DeleteAllScopesForUser,
+		p.accessTokens.DeleteToken,
+		p.attempts.DeleteForEmail,
+		p.leaveOrgs,
+		p.invites.DeleteForEmail,
+		p.exports.DeleteAllForUser,
+		func(ctx context.Context, email string) error {
+			return p.subs.Anonymize(ctx, email, pseudonym)
+		},
+		func(ctx context.Context, email string) error {
+			return p.redemptions.Anonymize(ctx, email, pseudonym)
+		},
+		func(ctx context.Context, email string) error {
+			return p.audit.Anonymize(ctx, email, pseudonym)
+		},
+		p.users.Delete,
+	}
+
+	for _, step := range steps {
+		err = step(ctx, email)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return err
+		}
+	}
+
+	err = p.audit.Record(ctx, audit.Event{Action: audit.ActionAccountErased, Subject: pseudonym})
+	if err != nil {
+		log.ErrorCtx(err, log.Ctx{
+			"msg": "unable to write audit log",
+		})
+	}
+
+	return nil
+}
+
+// leaveOrgs ends the memberships of the user one by one, giving back the seat
+// of each. It fails if an org would be left without an owner, e.g. when the
+// other owners left during the grace period, so the erasure waits until the
+// user cancels it or another owner is added.
+func (p *Profile) leaveOrgs(ctx context.Context, email string) error {
+	err := p.keepOwners(ctx, email)
+	if err != nil {
+		return err
+	}
+
+	memberships, err := p.members.ListForUser(ctx, email)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range memberships {
+		err = p.members.Remove(ctx, m.OrgID, m.Email)
+		if err != nil {
+			if errors.Is(err, orgs.ErrMemberNotFound) {
+				continue
+			}
+
+			return err
+		}
+
+		err = p.seats.FreeSeat(ctx, m.OrgID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// keepOwners fails with orgs.ErrLastOwner if the user is the only owner of one
+// of their orgs.
+func (p *Profile) keepOwners(ctx context.Context, email string) error {
+	memberships, err := p.members.ListForUser(ctx, email)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range memberships {
+		if m.Role != orgs.RoleOwner {
+			continue
+		}
+
+		owners, err := p.members.CountOwners(ctx, m.OrgID)
+		if err != nil {
+			return err
+		}
+
+		if owners <= 1 {
+			return orgs.ErrLastOwner
+		}
+	}
+
+	return nil
+}
+
+// buildExport assembles the archive and tells the user it's ready.
+func (p *Profile) buildExport(ctx context.Context, export *Export) {
+	archive, err := p.archive(ctx, export.Email)
+	if err == nil {
+		err = p.exports.Complete(ctx, export.ID, archive)
+	}
+
+	if err != nil {
+		log.ErrorCtx(err, log.Ctx{
+			"msg": "unable to build data export",
+		})
+
+		err = p.exports.Fail(ctx, export.ID)
+		if err != nil {
+			log.ErrorCtx(err, log.Ctx{
+				"msg": "unable to mark data export as failed",
+			})
+		}
+
+		return
+	}
+
+	mail := map[string]any{
+		"downloadURL": fmt.Sprintf("%s/account/exports/%s", p.cfg.FrontendURL, export.ID.Hex()),
+		"expiresIn":   time.Until(export.Expiry).Round(time.Hour).String(),
+	}
+
+	err = p.mailer.Send(export.Email, "data_export.tmpl", mail)
+	if err != nil {
+		log.ErrorCtx(err, log.Ctx{
+			"msg": "unable to send data export email",
+		})
+	}
+}
+
+// archive collects the user's data into a zip with one JSON file per kind.
+func (p *Profile) archive(ctx context.Context, email string) ([]byte, error) {
+	user, err := p.users.GetByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := p.sessions.ListForUser(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := p.tokens.ListForUser(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	type emailToken struct {
+		Scope     string    `json:"scope"`
+		CreatedAt time.Time `json:"created_at"`
+		Expiry    time.Time `json:"expiry"`
+	}
+
+	emailTokens := make([]emailToken, 0, len(tokens))
+	for _, t := range tokens {
+		emailTokens = append(emailTokens, emailToken{Scope: t.Scope, CreatedAt: t.CreatedAt, Expiry: t.Expiry})
+	}
+
+	memberships, err := p.members.ListForUser(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	// trials are subscriptions as well
+	subscriptions, err := p.subs.List(ctx, email, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	redemptions, err := p.redemptions.ListForUser(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := p.audit.ListForUser(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []struct {
+		name    string
+		content any
+	}{
+		{"profile.json", user.Public()},
+		{"sessions.json", sessions},
+		{"email_tokens.json", emailTokens},
+		{"org_memberships.json", memberships},
+		{"subscriptions.json", subscriptions},
+		{"coupon_redemptions.json", redemptions},
+		{"audit_log.json", events},
+	}
+
+	var buf bytes.Buffer
+
+	zw := zip.NewWriter(&buf)
+
+	for _, file := range files {
+		f, err := zw.Create(file.name)
+		if err != nil {
+			return nil, err
+		}
+
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "\t")
+
+		err = enc.Encode(file.content)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = zw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (p *Profile) recordAudit(ctx context.Context, r *http.Request, action, subject string, details map[string]string) {
+	device := auth.DeviceFromRequest(r)
+
+	err := p.audit.Record(ctx, audit.Event{
+		Action:    action,
+		Actor:     p.userCtx.ContextGetRealUser(r).Email,
+		Subject:   subject,
+		IP:        device.IP,
+		UserAgent: device.UserAgent,
+		Details:   details,
+	})
+	if err != nil {
+		log.ErrorCtx(err, log.Ctx{
+			"msg":    "unable to write audit log",
+			"action": action,
+		})
+	}
+}
+
+func (p *Profile) exportErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, ErrExportNotFound):
+		p.e.NotFoundResponse(w, r)
+	default:
+		p.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+func exportFailed(export *Export) bool {
+	return export.Status == ExportFailed ||
+		(export.Status == ExportPending && time.Since(export.CreatedAt) > exportTimeout)
+}
+
+func newPseudonym() (string, error) {
+	b := make([]byte, 8)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return "deleted-user-" + hex.EncodeToString(b), nil
+}
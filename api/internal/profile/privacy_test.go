@@ -0,0 +1,233 @@
+package profile
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"interviews/internal/audit"
+	"interviews/internal/auth"
+	"interviews/internal/orgs"
+	data "interviews/internal/users"
+	"interviews/internal/users/userstest"
+)
+
+func TestProfile_Export(t *testing.T) {
+	user := &data.User{Name: "Jane", Email: "jane@test.com", PassHash: "$argon2id$secret", Activated: true}
+	other := &data.User{Name: "John", Email: "john@test.com", Activated: true}
+
+	p := newTestProfile(user, other)
+	p.tokens.tokens["token"] = &auth.ScopedToken{Plaintext: "token", Email: user.Email, Scope: auth.ScopePasswordReset}
+
+	recorder := httptest.NewRecorder()
+	p.service.RequestExportHandler(recorder, userstest.NewRequest(t, http.MethodPost, "/v1/me/export", user, nil))
+
+	require.Equal(t, http.StatusAccepted, recorder.Code)
+
+	var res struct {
+		Export struct {
+			ID     string `json:"id"`
+			Status string `json:"status"`
+		} `json:"export"`
+	}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+	assert.Equal(t, ExportPending, res.Export.Status)
+
+	// the email goes out once the archive is stored
+	assert.Equal(t, "jane@test.com data_export.tmpl", <-p.mailer.sent)
+	assert.Equal(t, audit.ActionDataExportRequested, p.audit.events[0].Action)
+
+	// only one export a day
+	recorder = httptest.NewRecorder()
+	p.service.RequestExportHandler(recorder, userstest.NewRequest(t, http.MethodPost, "/v1/me/export", user, nil))
+
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+	assert.NotEmpty(t, recorder.Header().Get("Retry-After"))
+
+	download := func(user *data.User, id string) *httptest.ResponseRecorder {
+		req := userstest.NewRequest(t, http.MethodGet, "/v1/me/exports/"+id+"/download", user, nil)
+		req = mux.SetURLVars(req, map[string]string{"id": id})
+
+		recorder := httptest.NewRecorder()
+		p.service.DownloadExportHandler(recorder, req)
+
+		return recorder
+	}
+
+	assert.Equal(t, http.StatusNotFound, download(other, res.Export.ID).Code)
+	assert.Equal(t, http.StatusNotFound, download(user, "not-an-id").Code)
+
+	recorder = download(user, res.Export.ID)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "application/zip", recorder.Header().Get("Content-Type"))
+
+	archive, err := zip.NewReader(bytes.NewReader(recorder.Body.Bytes()), int64(recorder.Body.Len()))
+	require.NoError(t, err)
+
+	files := map[string]string{}
+	for _, f := range archive.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+
+		content, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		rc.Close()
+
+		files[f.Name] = string(content)
+	}
+
+	assert.Len(t, files, 7)
+	assert.Contains(t, files["profile.json"], `"email": "jane@test.com"`)
+	assert.NotContains(t, files["profile.json"], "argon2id")
+	assert.Contains(t, files["sessions.json"], "Firefox")
+	assert.Contains(t, files["email_tokens.json"], auth.ScopePasswordReset)
+	assert.NotContains(t, files["email_tokens.json"], `"token"`)
+	assert.Contains(t, files["org_memberships.json"], `"role": "member"`)
+	assert.Contains(t, files["subscriptions.json"], `"status": "trialing"`)
+	assert.Contains(t, files["coupon_redemptions.json"], "WELCOME10")
+	assert.Contains(t, files["audit_log.json"], audit.ActionDataExportRequested)
+}
+
+func TestProfile_DeleteMe(t *testing.T) {
+	user := &data.User{Name: "Jane", Email: "jane@test.com", Activated: true}
+
+	p := newTestProfile(user)
+
+	deleteMe := func(email string) int {
+		recorder := httptest.NewRecorder()
+		p.service.DeleteMeHandler(recorder, userstest.NewRequest(t, http.MethodDelete, "/v1/me", user,
+			map[string]string{"email": email}))
+
+		return recorder.Code
+	}
+
+	cancel := func() int {
+		recorder := httptest.NewRecorder()
+		p.service.CancelDeletionHandler(recorder, userstest.NewRequest(t, http.MethodDelete, "/v1/me/deletion", user, nil))
+
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusNotFound, cancel())
+
+	assert.Equal(t, http.StatusUnprocessableEntity, deleteMe("john@test.com"))
+	assert.True(t, user.DeletionScheduledFor.IsZero())
+
+	assert.Equal(t, http.StatusAccepted, deleteMe(" Jane@Test.com "))
+	assert.WithinDuration(t, time.Now().Add(30*24*time.Hour), user.DeletionScheduledFor, time.Minute)
+	assert.Equal(t, "jane@test.com account_deletion.tmpl", <-p.mailer.sent)
+
+	// asking again keeps the date
+	scheduled := user.DeletionScheduledFor
+	assert.Equal(t, http.StatusAccepted, deleteMe("jane@test.com"))
+	assert.Equal(t, scheduled, user.DeletionScheduledFor)
+
+	assert.Equal(t, http.StatusOK, cancel())
+	assert.True(t, user.DeletionScheduledFor.IsZero())
+
+	actions := []string{}
+	for _, event := range p.audit.events {
+		actions = append(actions, event.Action)
+	}
+
+	assert.Equal(t, []string{audit.ActionDeletionScheduled, audit.ActionDeletionCancelled}, actions)
+
+	// api keys can't delete anything
+	service := &data.User{Name: "reporting", Activated: true, Service: &data.ServicePrincipal{Name: "reporting"}}
+	recorder := httptest.NewRecorder()
+	p.service.DeleteMeHandler(recorder, userstest.NewRequest(t, http.MethodDelete, "/v1/me", service,
+		map[string]string{"email": ""}))
+
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+}
+
+func TestProfile_DeleteMeLastOwner(t *testing.T) {
+	user := &data.User{Name: "Jane", Email: "jane@test.com", Activated: true}
+
+	p := newTestProfile(user)
+	p.members.role, p.members.owners = orgs.RoleOwner, 1
+
+	deleteMe := func() int {
+		recorder := httptest.NewRecorder()
+		p.service.DeleteMeHandler(recorder, userstest.NewRequest(t, http.MethodDelete, "/v1/me", user,
+			map[string]string{"email": user.Email}))
+
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusBadRequest, deleteMe())
+	assert.True(t, user.DeletionScheduledFor.IsZero())
+
+	p.members.owners = 2
+
+	assert.Equal(t, http.StatusAccepted, deleteMe())
+	assert.Equal(t, "jane@test.com account_deletion.tmpl", <-p.mailer.sent)
+
+	// the other owner left during the grace period
+	p.members.owners = 1
+	user.DeletionScheduledFor = time.Now().Add(-time.Minute)
+
+	assert.Equal(t, 0, p.service.EraseDueAccounts(context.Background()))
+	assert.Empty(t, p.members.removed)
+
+	_, err := p.users.GetByEmail(user.Email)
+	assert.NoError(t, err)
+}
+
+func TestProfile_EraseDueAccounts(t *testing.T) {
+	due := &data.User{Name: "Jane", Email: "jane@test.com", DeletionScheduledFor: time.Now().Add(-time.Minute)}
+	later := &data.User{Name: "John", Email: "john@test.com", DeletionScheduledFor: time.Now().Add(time.Hour)}
+
+	p := newTestProfile(due, later)
+	p.tokens.tokens["token"] = &auth.ScopedToken{Plaintext: "token", Email: due.Email, Scope: auth.ScopePasswordReset}
+	p.audit.events = []audit.Event{
+		{Action: audit.ActionMagicLinkLogin, Actor: due.Email, IP: "10.0.0.1", UserAgent: "Firefox"},
+		{Action: audit.ActionUserSuspended, Actor: "admin@test.com", Subject: due.Email},
+	}
+
+	_, err := p.exports.New(context.Background(), due.Email, time.Hour)
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, p.service.EraseDueAccounts(context.Background()))
+
+	_, err = p.users.GetByEmail(due.Email)
+	assert.Error(t, err)
+	_, err = p.users.GetByEmail(later.Email)
+	assert.NoError(t, err)
+
+	assert.Empty(t, p.tokens.tokens)
+	assert.Empty(t, p.exports.exports)
+	assert.Equal(t, []string{due.Email}, p.sessions.deleted)
+	assert.Equal(t, []string{due.Email}, p.accessTokens.deleted)
+	assert.Equal(t, []string{due.Email}, p.attempts.deleted)
+	assert.Equal(t, []string{due.Email}, p.members.removed)
+	assert.Equal(t, []primitive.ObjectID{memberOrgID}, p.seats.freed)
+	assert.Equal(t, []string{due.Email}, p.invites.deleted)
+
+	// the audit trail stays, but can't be tied to the person anymore
+	require.Len(t, p.audit.events, 3)
+
+	pseudonym := p.audit.events[0].Actor
+	assert.NotEqual(t, due.Email, pseudonym)
+	assert.Empty(t, p.audit.events[0].IP)
+	assert.Empty(t, p.audit.events[0].UserAgent)
+	assert.Equal(t, pseudonym, p.audit.events[1].Subject)
+	assert.Equal(t, "admin@test.com", p.audit.events[1].Actor)
+	assert.Equal(t, audit.Event{Action: audit.ActionAccountErased, Subject: pseudonym}, p.audit.events[2])
+
+	// subscriptions and redemptions are kept under the same pseudonym
+	assert.Equal(t, map[string]string{due.Email: pseudonym}, p.subs.anonymized)
+	assert.Equal(t, map[string]string{due.Email: pseudonym}, p.redemptions.anonymized)
+
+	assert.Equal(t, 0, p.service.EraseDueAccounts(context.Background()))
+}
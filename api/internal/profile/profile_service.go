@@ -0,0 +1,484 @@
+package profile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"interviews/internal/audit"
+	"interviews/internal/auth"
+	"interviews/internal/billing"
+	"interviews/internal/config"
+	"interviews/internal/orgs"
+	data "interviews/internal/users"
+	"interviews/pkg"
+	log "interviews/pkg/logger"
+	validator "interviews/pkg/vaildator"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type UserRepository interface {
+	GetByEmail(email string) (*data.User, error)
+	UpdateProfile(ctx context.Context, user *data.User) error
+	SetPendingEmail(ctx context.Context, email, pending string) error
+	ConfirmEmail(ctx context.Context, email string) (string, error)
+	ListEmailMoves(ctx context.Context) ([]data.User, error)
+	EmailMoved(ctx context.Context, email, from string) error
+	ScheduleDeletion(ctx context.Context, email string, at time.Time) error
+	CancelDeletion(ctx context.Context, email string) error
+	ClaimDueDeletion(ctx context.Context, lease time.Duration) (*data.User, error)
+	Delete(ctx context.Context, email string) error
+	ValidateEmail(v *validator.Validator, email string)
+	ValidateProfile(v *validator.Validator, user *data.User)
+}
+
+type ScopedTokenRepository interface {
+	New(ctx context.Context, email string, ttl time.Duration, scope string) (*auth.ScopedToken, error)
+	Consume(ctx context.Context, scope, tokenPlaintext string) (string, error)
+	DeleteAllForUser(ctx context.Context, scope, email string) error
+	DeleteAllScopesForUser(ctx context.Context, email string) error
+	ListForUser(ctx context.Context, email string) ([]auth.ScopedToken, error)
+}
+
+// AccessTokenRepository holds the access tokens saved for the token cache.
+type AccessTokenRepository interface {
+	DeleteToken(ctx context.Context, email string) error
+}
+
+type SessionRepository interface {
+	ListForUser(ctx context.Context, email string) ([]auth.Session, error)
+	DeleteAllForUser(ctx context.Context, email string) (int64, error)
+}
+
+type MemberRepository interface {
+	ListForUser(ctx context.Context, email string) ([]orgs.Member, error)
+	CountOwners(ctx context.Context, orgID primitive.ObjectID) (int64, error)
+	ChangeEmail(ctx context.Context, email, newEmail string) error
+	Remove(ctx context.Context, orgID primitive.ObjectID, email string) error
+}
+
+// SubscriptionRepository holds the subscriptions and trials of the user.
+type SubscriptionRepository interface {
+	List(ctx context.Context, email string, orgIDs []primitive.ObjectID) ([]billing.Subscription, error)
+	ChangeEmail(ctx context.Context, email, newEmail string) error
+	Anonymize(ctx context.Context, email, pseudonym string) error
+}
+
+// RedemptionRepository holds the coupons the user redeemed.
+type RedemptionRepository interface {
+	ListForUser(ctx context.Context, email string) ([]billing.Redemption, error)
+	ChangeEmail(ctx context.Context, email, newEmail string) error
+	Anonymize(ctx context.Context, email, pseudonym string) error
+}
+
+// SeatRepository gives back the seat of a member that left an org.
+type SeatRepository interface {
+	FreeSeat(ctx context.Context, id primitive.ObjectID) error
+}
+
+type InviteRepository interface {
+	DeleteForEmail(ctx context.Context, email string) error
+}
+
+type AttemptsRepository interface {
+	DeleteForEmail(ctx context.Context, email string) error
+}
+
+type ExportRepository interface {
+	New(ctx context.Context, email string, ttl time.Duration) (*Export, error)
+	Get(ctx context.Context, email, id string, withArchive bool) (*Export, error)
+	Latest(ctx context.Context, email string) (*Export, error)
+	Complete(ctx context.Context, id primitive.ObjectID, archive []byte) error
+	Fail(ctx context.Context, id primitive.ObjectID) error
+	ChangeEmail(ctx context.Context, email, newEmail string) error
+	DeleteAllForUser(ctx context.Context, email string) error
+}
+
+type AuditLog interface {
+	Record(ctx context.Context, event audit.Event) error
+	ListForUser(ctx context.Context, email string) ([]audit.Event, error)
+	ChangeEmail(ctx context.Context, email, newEmail string) error
+	Anonymize(ctx context.Context, email, pseudonym string) error
+}
+
+type Mailer interface {
+	Send(recipient, templateFile string, data any) error
+}
+
+type Profile struct {
+	helper       pkg.Helper
+	e            pkg.CustomErrors
+	users        UserRepository
+	tokens       ScopedTokenRepository
+	accessTokens AccessTokenRepository
+	sessions     SessionRepository
+	members      MemberRepository
+	seats        SeatRepository
+	invites      InviteRepository
+	subs         SubscriptionRepository
+	redemptions  RedemptionRepository
+	attempts     AttemptsRepository
+	exports      ExportRepository
+	audit        AuditLog
+	mailer       Mailer
+	userCtx      data.UsersContext
+	cfg          config.Config
+}
+
+type envelope map[string]any
+
+func (p *Profile) GetMeHandler(w http.ResponseWriter, r *http.Request) {
+	user := p.userCtx.ContextGetUser(r)
+
+	if user.IsService() {
+		p.e.NotPermittedResponse(w, r)
+
+		return
+	}
+
+	err := p.helper.WriteJSON(w, http.StatusOK, envelope{"user": user.Public()}, nil)
+	if err != nil {
+		p.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+// UpdateMeHandler changes the fields that are sent and leaves the others as
+// they are. An empty string clears an optional field.
+func (p *Profile) UpdateMeHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	user := p.userCtx.ContextGetUser(r)
+
+	if user.IsService() {
+		p.e.NotPermittedResponse(w, r)
+
+		return
+	}
+
+	var input struct {
+		Name      *string `json:"name"`
+		AvatarURL *string `json:"avatar_url"`
+		Timezone  *string `json:"timezone"`
+		Bio       *string `json:"bio"`
+	}
+
+	err := p.helper.ReadJSON(w, r, &input)
+	if err != nil {
+		p.e.BadRequestResponse(w, r, err)
+
+		return
+	}
+
+	updated := *user
+
+	if input.Name != nil {
+		updated.Name = strings.TrimSpace(*input.Name)
+	}
+
+	if input.AvatarURL != nil {
+		updated.AvatarURL = strings.TrimSpace(*input.AvatarURL)
+	}
+
+	if input.Timezone != nil {
+		updated.Timezone = strings.TrimSpace(*input.Timezone)
+	}
+
+	if input.Bio != nil {
+		updated.Bio = strings.TrimSpace(*input.Bio)
+	}
+
+	v := validator.New()
+
+	p.users.ValidateProfile(v, &updated)
+
+	if !v.Valid() {
+		p.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	err = p.users.UpdateProfile(ctx, &updated)
+	if err != nil {
+		p.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = p.helper.WriteJSON(w, http.StatusOK, envelope{"user": updated.Public()}, nil)
+	if err != nil {
+		p.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+// ChangeEmailHandler sends a confirmation token to the new address. The
+// account keeps the old address until the token is used, and the old address
+// is told about the request.
+func (p *Profile) ChangeEmailHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+	clog := log.GetLoggerFromContext(r.Context())
+
+	user := p.userCtx.ContextGetUser(r)
+
+	if user.IsService() {
+		p.e.NotPermittedResponse(w, r)
+
+		return
+	}
+
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := p.helper.ReadJSON(w, r, &input)
+	if err != nil {
+		p.e.BadRequestResponse(w, r, err)
+
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(input.Email))
+
+	v := validator.New()
+
+	p.users.ValidateEmail(v, email)
+	v.Check(email != strings.ToLower(user.Email), "email", "must be different from your current address")
+
+	if v.Valid() {
+		_, err = p.users.GetByEmail(email)
+		v.Check(err != nil, "email", "a user with this email address already exists")
+	}
+
+	if !v.Valid() {
+		p.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	err = p.users.SetPendingEmail(ctx, user.Email, email)
+	if err != nil {
+		p.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = p.tokens.DeleteAllForUser(ctx, auth.ScopeEmailChange, user.Email)
+	if err != nil {
+		p.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	ttl := p.cfg.TokenConfig.EmailChangeTTL
+
+	token, err := p.tokens.New(ctx, user.Email, ttl, auth.ScopeEmailChange)
+	if err != nil {
+		p.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	p.helper.Background(func() {
+		confirm := map[string]any{
+			"confirmToken": token.Plaintext,
+			"confirmURL":   fmt.Sprintf("%s/confirm-email?token=%s", p.cfg.FrontendURL, token.Plaintext),
+			"expiresIn":    ttl.String(),
+		}
+
+		err := p.mailer.Send(email, "email_change.tmpl", confirm)
+		if err != nil {
+			clog.ErrorCtx(err, log.Ctx{
+				"msg": "unable to send email change confirmation",
+			})
+		}
+
+		err = p.mailer.Send(user.Email, "email_changed.tmpl", map[string]any{"newEmail": email})
+		if err != nil {
+			clog.ErrorCtx(err, log.Ctx{
+				"msg": "unable to send email change notice",
+			})
+		}
+	})
+
+	env := envelope{"message": "an email will be sent to the new address containing confirmation instructions"}
+
+	err = p.helper.WriteJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		p.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+// ConfirmEmailHandler switches the account to the new address. The token is
+// proof enough, so the link also works in a browser the user isn't logged in
+// on. Every session is logged out since the tokens name the old address.
+func (p *Profile) ConfirmEmailHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+	clog := log.GetLoggerFromContext(r.Context())
+
+	var input struct {
+		Token string `json:"token"`
+	}
+
+	err := p.helper.ReadJSON(w, r, &input)
+	if err != nil {
+		p.e.BadRequestResponse(w, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	auth.ValidateScopedTokenPlaintext(v, input.Token)
+
+	if !v.Valid() {
+		p.e.FailedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	email, err := p.tokens.Consume(ctx, auth.ScopeEmailChange, input.Token)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidToken) {
+			v.AddError("token", "invalid or expired confirmation token")
+			p.e.FailedValidationResponse(w, r, v.Errors)
+
+			return
+		}
+
+		p.e.ServerErrorResponse(w, r, err)
+
+		return
+	}
+
+	newEmail, err := p.users.ConfirmEmail(ctx, email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			p.e.FailedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrNoPendingEmail), errors.Is(err, mongo.ErrNoDocuments):
+			v.AddError("token", "invalid or expired confirmation token")
+			p.e.FailedValidationResponse(w, r, v.Errors)
+		default:
+			p.e.ServerErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	// the address is switched at this point, a failed move is retried by the
+	// worker since the user keeps the old address until it is done
+	err = p.moveEmail(ctx, email, newEmail)
+	if err != nil {
+		clog.ErrorCtx(err, log.Ctx{
+			"msg": "unable to move the records of the old email, will be retried",
+		})
+	}
+
+	_, err = p.sessions.DeleteAllForUser(ctx, email)
+	if err != nil {
+		clog.ErrorCtx(err, log.Ctx{
+			"msg": "unable to delete the sessions of the old email",
+		})
+	}
+
+	env := envelope{"message": "your email address was changed, please log in again", "email": newEmail}
+
+	err = p.helper.WriteJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		p.e.ServerErrorResponse(w, r, err)
+	}
+}
+
+func NewProfileService(users UserRepository, tokens ScopedTokenRepository, accessTokens AccessTokenRepository,
+	sessions SessionRepository, members MemberRepository, seats SeatRepository, invites InviteRepository,
+	subs SubscriptionRepository, redemptions RedemptionRepository, attempts AttemptsRepository,
+	exports ExportRepository, audit AuditLog, mailer Mailer, cfg config.Config) *Profile {
+	return &Profile{
+		helper:       pkg.Helper{},
+		e:            pkg.CustomErrors{},
+		users:        users,
+		tokens:       tokens,
+		accessTokens: accessTokens,
+		sessions:     sessions,
+		members:      members,
+		seats:        seats,
+		invites:      invites,
+		subs:         subs,
+		redemptions:  redemptions,
+		attempts:     attempts,
+		exports:      exports,
+		audit:        audit,
+		mailer:       mailer,
+		userCtx:      data.UsersContext{},
+		cfg:          cfg,
+	}
+}
+
+// moveEmail moves the records kept by email from the old address to the new
+// one, so exporting and erasing the account by its current address covers all
+// of them. Every move can be repeated, so it is simply run again after a
+// failure.
+func (p *Profile) moveEmail(ctx context.Context, email, newEmail string) error {
+	moves := []struct {
+		what string
+		move func(ctx context.Context, email, newEmail string) error
+	}{
+		{"org memberships", p.members.ChangeEmail},
+		{"subscriptions", p.subs.ChangeEmail},
+		{"coupon redemptions", p.redemptions.ChangeEmail},
+		{"data exports", p.exports.ChangeEmail},
+		{"audit log entries", p.audit.ChangeEmail},
+		// tokens and login counters of the old address are of no use anymore
+		{"email tokens", func(ctx context.Context, email, _ string) error {
+			return p.tokens.DeleteAllScopesForUser(ctx, email)
+		}},
+		{"login attempts", func(ctx context.Context, email, _ string) error {
+			return p.attempts.DeleteForEmail(ctx, email)
+		}},
+	}
+
+	for _, m := range moves {
+		err := m.move(ctx, email, newEmail)
+		if err != nil {
+			return fmt.Errorf("move %s: %w", m.what, err)
+		}
+	}
+
+	return p.users.EmailMoved(ctx, newEmail, email)
+}
+
+// MoveChangedEmails finishes the email changes whose records weren't all moved
+// and returns how many old addresses it finished.
+func (p *Profile) MoveChangedEmails(ctx context.Context) int {
+	users, err := p.users.ListEmailMoves(ctx)
+	if err != nil {
+		log.ErrorCtx(err, log.Ctx{
+			"msg": "unable to list unfinished email changes",
+		})
+
+		return 0
+	}
+
+	moved := 0
+
+	for _, user := range users {
+		for _, from := range user.MovedFrom {
+			err = p.moveEmail(ctx, from, user.Email)
+			if err != nil {
+				log.ErrorCtx(err, log.Ctx{
+					"msg": "unable to move the records of the old email",
+				})
+
+				continue
+			}
+
+			moved++
+		}
+	}
+
+	return moved
+}
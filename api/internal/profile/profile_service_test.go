@@ -0,0 +1,730 @@
+package profile
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"interviews/internal/audit"
+	"interviews/internal/auth"
+	"interviews/internal/billing"
+	"interviews/internal/config"
+	"interviews/internal/orgs"
+	data "interviews/internal/users"
+	"interviews/internal/users/userstest"
+)
+
+type MockUserRepository struct {
+	*data.UserRepo
+	users map[string]*data.User
+}
+
+func newMockUserRepository(users ...*data.User) *MockUserRepository {
+	m := &MockUserRepository{UserRepo: &data.UserRepo{}, users: map[string]*data.User{}}
+
+	for _, user := range users {
+		m.users[user.Email] = user
+	}
+
+	return m
+}
+
+func (m *MockUserRepository) GetByEmail(email string) (*data.User, error) {
+	user, ok := m.users[email]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	return user, nil
+}
+
+func (m *MockUserRepository) UpdateProfile(ctx context.Context, user *data.User) error {
+	stored, ok := m.users[user.Email]
+	if !ok {
+		return mongo.ErrNoDocuments
+	}
+
+	stored.Name = user.Name
+	stored.AvatarURL = user.AvatarURL
+	stored.Timezone = user.Timezone
+	stored.Bio = user.Bio
+
+	return nil
+}
+
+func (m *MockUserRepository) SetPendingEmail(ctx context.Context, email, pending string) error {
+	m.users[email].PendingEmail = pending
+
+	return nil
+}
+
+func (m *MockUserRepository) ConfirmEmail(ctx context.Context, email string) (string, error) {
+	user := m.users[email]
+	if user.PendingEmail == "" {
+		return "", data.ErrNoPendingEmail
+	}
+
+	if _, ok := m.users[user.PendingEmail]; ok {
+		return "", data.ErrDuplicateEmail
+	}
+
+	delete(m.users, email)
+	user.Email, user.PendingEmail = user.PendingEmail, ""
+	user.MovedFrom = append(user.MovedFrom, email)
+	m.users[user.Email] = user
+
+	return user.Email, nil
+}
+
+func (m *MockUserRepository) ListEmailMoves(ctx context.Context) ([]data.User, error) {
+	users := []data.User{}
+
+	for _, user := range m.users {
+		if len(user.MovedFrom) > 0 {
+			users = append(users, *user)
+		}
+	}
+
+	return users, nil
+}
+
+func (m *MockUserRepository) EmailMoved(ctx context.Context, email, from string) error {
+	user, ok := m.users[email]
+	if !ok {
+		return mongo.ErrNoDocuments
+	}
+
+	movedFrom := []string{}
+
+	for _, f := range user.MovedFrom {
+		if f != from {
+			movedFrom = append(movedFrom, f)
+		}
+	}
+
+	user.MovedFrom = movedFrom
+
+	return nil
+}
+
+func (m *MockUserRepository) ScheduleDeletion(ctx context.Context, email string, at time.Time) error {
+	m.users[email].DeletionScheduledFor = at
+
+	return nil
+}
+
+func (m *MockUserRepository) CancelDeletion(ctx context.Context, email string) error {
+	m.users[email].DeletionScheduledFor = time.Time{}
+
+	return nil
+}
+
+func (m *MockUserRepository) ClaimDueDeletion(ctx context.Context, lease time.Duration) (*data.User, error) {
+	for _, user := range m.users {
+		if !user.DeletionScheduledFor.IsZero() && user.DeletionScheduledFor.Before(time.Now()) {
+			user.DeletionScheduledFor = time.Now().Add(lease)
+
+			return user, nil
+		}
+	}
+
+	return nil, mongo.ErrNoDocuments
+}
+
+func (m *MockUserRepository) Delete(ctx context.Context, email string) error {
+	delete(m.users, email)
+
+	return nil
+}
+
+type MockScopedTokenRepository struct {
+	tokens map[string]*auth.ScopedToken
+}
+
+func (m *MockScopedTokenRepository) New(ctx context.Context, email string, ttl time.Duration, scope string) (*auth.ScopedToken, error) {
+	token := &auth.ScopedToken{Plaintext: "ABCDEFGHIJKLMNOPQRSTUVWXYZ", Email: email, Scope: scope, Expiry: time.Now().Add(ttl)}
+	m.tokens[token.Plaintext] = token
+
+	return token, nil
+}
+
+func (m *MockScopedTokenRepository) Consume(ctx context.Context, scope, tokenPlaintext string) (string, error) {
+	token, ok := m.tokens[tokenPlaintext]
+	if !ok || token.Scope != scope {
+		return "", auth.ErrInvalidToken
+	}
+
+	delete(m.tokens, tokenPlaintext)
+
+	return token.Email, nil
+}
+
+func (m *MockScopedTokenRepository) DeleteAllForUser(ctx context.Context, scope, email string) error {
+	for plaintext, token := range m.tokens {
+		if token.Scope == scope && token.Email == email {
+			delete(m.tokens, plaintext)
+		}
+	}
+
+	return nil
+}
+
+func (m *MockScopedTokenRepository) DeleteAllScopesForUser(ctx context.Context, email string) error {
+	for plaintext, token := range m.tokens {
+		if token.Email == email {
+			delete(m.tokens, plaintext)
+		}
+	}
+
+	return nil
+}
+
+func (m *MockScopedTokenRepository) ListForUser(ctx context.Context, email string) ([]auth.ScopedToken, error) {
+	var tokens []auth.ScopedToken
+
+	for _, token := range m.tokens {
+		if token.Email == email {
+			tokens = append(tokens, *token)
+		}
+	}
+
+	return tokens, nil
+}
+
+type MockAccessTokenRepository struct {
+	deleted []string
+}
+
+func (m *MockAccessTokenRepository) DeleteToken(ctx context.Context, email string) error {
+	m.deleted = append(m.deleted, email)
+
+	return nil
+}
+
+type MockSessionRepository struct {
+	deleted []string
+}
+
+func (m *MockSessionRepository) ListForUser(ctx context.Context, email string) ([]auth.Session, error) {
+	return []auth.Session{{Email: email, UserAgent: "Firefox"}}, nil
+}
+
+func (m *MockSessionRepository) DeleteAllForUser(ctx context.Context, email string) (int64, error) {
+	m.deleted = append(m.deleted, email)
+
+	return 1, nil
+}
+
+type MockMemberRepository struct {
+	moved   map[string]string
+	removed []string
+	// role of the user in memberOrgID and how many owners it has
+	role   string
+	owners int64
+}
+
+func (m *MockMemberRepository) ListForUser(ctx context.Context, email string) ([]orgs.Member, error) {
+	for _, removed := range m.removed {
+		if removed == email {
+			return []orgs.Member{}, nil
+		}
+	}
+
+	role := m.role
+	if role == "" {
+		role = orgs.RoleMember
+	}
+
+	return []orgs.Member{{OrgID: memberOrgID, Email: email, Role: role}}, nil
+}
+
+func (m *MockMemberRepository) CountOwners(ctx context.Context, orgID primitive.ObjectID) (int64, error) {
+	return m.owners, nil
+}
+
+func (m *MockMemberRepository) Remove(ctx context.Context, orgID primitive.ObjectID, email string) error {
+	m.removed = append(m.removed, email)
+
+	return nil
+}
+
+var memberOrgID = primitive.NewObjectID()
+
+type MockSubscriptionRepository struct {
+	moved      map[string]string
+	anonymized map[string]string
+	moveErr    error
+}
+
+func (m *MockSubscriptionRepository) List(ctx context.Context, email string, orgIDs []primitive.ObjectID) ([]billing.Subscription, error) {
+	return []billing.Subscription{{Plan: billing.TrialPlan, Email: email, Status: billing.StatusTrialing}}, nil
+}
+
+func (m *MockSubscriptionRepository) Anonymize(ctx context.Context, email, pseudonym string) error {
+	m.anonymized[email] = pseudonym
+
+	return nil
+}
+
+func (m *MockSubscriptionRepository) ChangeEmail(ctx context.Context, email, newEmail string) error {
+	if m.moveErr != nil {
+		return m.moveErr
+	}
+
+	m.moved[email] = newEmail
+
+	return nil
+}
+
+type MockRedemptionRepository struct {
+	moved      map[string]string
+	anonymized map[string]string
+}
+
+func (m *MockRedemptionRepository) ListForUser(ctx context.Context, email string) ([]billing.Redemption, error) {
+	return []billing.Redemption{{Coupon: "WELCOME10", Email: email, Plan: "monthly"}}, nil
+}
+
+func (m *MockRedemptionRepository) Anonymize(ctx context.Context, email, pseudonym string) error {
+	m.anonymized[email] = pseudonym
+
+	return nil
+}
+
+func (m *MockRedemptionRepository) ChangeEmail(ctx context.Context, email, newEmail string) error {
+	m.moved[email] = newEmail
+
+	return nil
+}
+
+type MockSeatRepository struct {
+	freed []primitive.ObjectID
+}
+
+func (m *MockSeatRepository) FreeSeat(ctx context.Context, id primitive.ObjectID) error {
+	m.freed = append(m.freed, id)
+
+	return nil
+}
+
+type MockInviteRepository struct {
+	deleted []string
+}
+
+func (m *MockInviteRepository) DeleteForEmail(ctx context.Context, email string) error {
+	m.deleted = append(m.deleted, email)
+
+	return nil
+}
+
+type MockAttemptsRepository struct {
+	deleted []string
+}
+
+func (m *MockAttemptsRepository) DeleteForEmail(ctx context.Context, email string) error {
+	m.deleted = append(m.deleted, email)
+
+	return nil
+}
+
+type MockExportRepository struct {
+	exports []*Export
+}
+
+func (m *MockExportRepository) New(ctx context.Context, email string, ttl time.Duration) (*Export, error) {
+	export := &Export{
+		ID:        primitive.NewObjectID(),
+		Email:     email,
+		Status:    ExportPending,
+		CreatedAt: time.Now(),
+		Expiry:    time.Now().Add(ttl),
+	}
+	m.exports = append(m.exports, export)
+
+	return export, nil
+}
+
+func (m *MockExportRepository) Get(ctx context.Context, email, id string, withArchive bool) (*Export, error) {
+	for _, export := range m.exports {
+		if export.ID.Hex() == id && export.Email == email {
+			found := *export
+			if !withArchive {
+				found.Archive = nil
+			}
+
+			return &found, nil
+		}
+	}
+
+	return nil, ErrExportNotFound
+}
+
+func (m *MockExportRepository) Latest(ctx context.Context, email string) (*Export, error) {
+	var latest *Export
+
+	for _, export := range m.exports {
+		if export.Email == email {
+			found := *export
+			latest = &found
+		}
+	}
+
+	return latest, nil
+}
+
+func (m *MockExportRepository) Complete(ctx context.Context, id primitive.ObjectID, archive []byte) error {
+	for _, export := range m.exports {
+		if export.ID == id {
+			export.Status, export.Archive = ExportReady, archive
+		}
+	}
+
+	return nil
+}
+
+func (m *MockExportRepository) Fail(ctx context.Context, id primitive.ObjectID) error {
+	for _, export := range m.exports {
+		if export.ID == id {
+			export.Status = ExportFailed
+		}
+	}
+
+	return nil
+}
+
+func (m *MockExportRepository) ChangeEmail(ctx context.Context, email, newEmail string) error {
+	for _, export := range m.exports {
+		if export.Email == email {
+			export.Email = newEmail
+		}
+	}
+
+	return nil
+}
+
+func (m *MockExportRepository) DeleteAllForUser(ctx context.Context, email string) error {
+	kept := m.exports[:0]
+
+	for _, export := range m.exports {
+		if export.Email != email {
+			kept = append(kept, export)
+		}
+	}
+
+	m.exports = kept
+
+	return nil
+}
+
+type MockAuditLog struct {
+	events []audit.Event
+}
+
+func (m *MockAuditLog) Record(ctx context.Context, event audit.Event) error {
+	m.events = append(m.events, event)
+
+	return nil
+}
+
+func (m *MockAuditLog) ListForUser(ctx context.Context, email string) ([]audit.Event, error) {
+	var events []audit.Event
+
+	for _, event := range m.events {
+		if event.Actor == email || event.Subject == email {
+			events = append(events, event)
+		}
+	}
+
+	return events, nil
+}
+
+func (m *MockAuditLog) ChangeEmail(ctx context.Context, email, newEmail string) error {
+	for i, event := range m.events {
+		if event.Actor == email {
+			m.events[i].Actor = newEmail
+		}
+
+		if event.Subject == email {
+			m.events[i].Subject = newEmail
+		}
+	}
+
+	return nil
+}
+
+func (m *MockAuditLog) Anonymize(ctx context.Context, email, pseudonym string) error {
+	for i, event := range m.events {
+		if event.Actor != email && event.Subject != email {
+			continue
+		}
+
+		if event.Actor == email {
+			m.events[i].Actor = pseudonym
+		}
+
+		if event.Subject == email {
+			m.events[i].Subject = pseudonym
+		}
+
+		m.events[i].IP, m.events[i].UserAgent = "", ""
+	}
+
+	return nil
+}
+
+func (m *MockMemberRepository) ChangeEmail(ctx context.Context, email, newEmail string) error {
+	m.moved[email] = newEmail
+
+	return nil
+}
+
+type MockMailer struct {
+	sent chan string
+}
+
+func (m *MockMailer) Send(recipient, templateFile string, data any) error {
+	m.sent <- recipient + " " + templateFile
+
+	return nil
+}
+
+type testProfile struct {
+	service      *Profile
+	users        *MockUserRepository
+	tokens       *MockScopedTokenRepository
+	accessTokens *MockAccessTokenRepository
+	sessions     *MockSessionRepository
+	members      *MockMemberRepository
+	seats        *MockSeatRepository
+	invites      *MockInviteRepository
+	subs         *MockSubscriptionRepository
+	redemptions  *MockRedemptionRepository
+	attempts     *MockAttemptsRepository
+	exports      *MockExportRepository
+	audit        *MockAuditLog
+	mailer       *MockMailer
+}
+
+func newTestProfile(users ...*data.User) *testProfile {
+	p := &testProfile{
+		users:        newMockUserRepository(users...),
+		tokens:       &MockScopedTokenRepository{tokens: map[string]*auth.ScopedToken{}},
+		accessTokens: &MockAccessTokenRepository{},
+		sessions:     &MockSessionRepository{},
+		members:      &MockMemberRepository{moved: map[string]string{}},
+		seats:        &MockSeatRepository{},
+		invites:      &MockInviteRepository{},
+		subs:         &MockSubscriptionRepository{moved: map[string]string{}, anonymized: map[string]string{}},
+		redemptions:  &MockRedemptionRepository{moved: map[string]string{}, anonymized: map[string]string{}},
+		attempts:     &MockAttemptsRepository{},
+		exports:      &MockExportRepository{},
+		audit:        &MockAuditLog{},
+		mailer:       &MockMailer{sent: make(chan string, 2)},
+	}
+
+	var cfg config.Config
+	cfg.TokenConfig.EmailChangeTTL = 24 * time.Hour
+	cfg.Privacy.ExportTTL = 7 * 24 * time.Hour
+	cfg.Privacy.ExportInterval = 24 * time.Hour
+	cfg.Privacy.DeletionGracePeriod = 30 * 24 * time.Hour
+
+	p.service = NewProfileService(p.users, p.tokens, p.accessTokens, p.sessions, p.members, p.seats,
+		p.invites, p.subs, p.redemptions, p.attempts, p.exports, p.audit, p.mailer, cfg)
+
+	return p
+}
+
+func TestProfile_GetMeHandler(t *testing.T) {
+	user := &data.User{
+		Name:          "Test",
+		Email:         "test@test.com",
+		PassHash:      "$argon2id$secret",
+		Activated:     true,
+		TOTPSecret:    "totp-secret",
+		RecoveryCodes: []string{"recovery"},
+	}
+
+	p := newTestProfile(user)
+
+	recorder := httptest.NewRecorder()
+	p.service.GetMeHandler(recorder, userstest.NewRequest(t, http.MethodGet, "/v1/me", user, nil))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	body := recorder.Body.String()
+	assert.Contains(t, body, `"email": "test@test.com"`)
+	assert.NotContains(t, body, "argon2id")
+	assert.NotContains(t, body, "totp-secret")
+	assert.NotContains(t, body, "recovery")
+	assert.NotContains(t, body, "pass")
+
+	// api keys have no profile
+	recorder = httptest.NewRecorder()
+	service := &data.User{Name: "reporting", Activated: true, Service: &data.ServicePrincipal{Name: "reporting"}}
+	p.service.GetMeHandler(recorder, userstest.NewRequest(t, http.MethodGet, "/v1/me", service, nil))
+
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+}
+
+func TestProfile_UpdateMeHandler(t *testing.T) {
+	testCases := []struct {
+		name       string
+		body       map[string]any
+		statusCode int
+		expected   data.User
+	}{
+		{
+			name:       "fix the name",
+			body:       map[string]any{"name": " Jane Doe "},
+			statusCode: http.StatusOK,
+			expected:   data.User{Name: "Jane Doe", Timezone: "Europe/Berlin", Bio: "Hi"},
+		},
+		{
+			name:       "all fields",
+			body:       map[string]any{"avatar_url": "https://cdn.test/a.png", "timezone": "America/New_York", "bio": "Go developer"},
+			statusCode: http.StatusOK,
+			expected:   data.User{Name: "Jane", AvatarURL: "https://cdn.test/a.png", Timezone: "America/New_York", Bio: "Go developer"},
+		},
+		{
+			name:       "clear the bio",
+			body:       map[string]any{"bio": ""},
+			statusCode: http.StatusOK,
+			expected:   data.User{Name: "Jane", Timezone: "Europe/Berlin"},
+		},
+		{name: "empty name", body: map[string]any{"name": "  "}, statusCode: http.StatusUnprocessableEntity},
+		{name: "unknown timezone", body: map[string]any{"timezone": "Mars/Olympus"}, statusCode: http.StatusUnprocessableEntity},
+		{name: "avatar is not a url", body: map[string]any{"avatar_url": "javascript:alert(1)"}, statusCode: http.StatusUnprocessableEntity},
+		{name: "bio too long", body: map[string]any{"bio": string(bytes.Repeat([]byte("a"), 1001))}, statusCode: http.StatusUnprocessableEntity},
+		{name: "unknown field", body: map[string]any{"role": "admin"}, statusCode: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			user := &data.User{Name: "Jane", Email: "jane@test.com", Activated: true, Timezone: "Europe/Berlin", Bio: "Hi"}
+			p := newTestProfile(user)
+
+			contextUser := *user
+
+			recorder := httptest.NewRecorder()
+			p.service.UpdateMeHandler(recorder, userstest.NewRequest(t, http.MethodPatch, "/v1/me", &contextUser, tc.body))
+
+			assert.Equal(t, tc.statusCode, recorder.Code)
+
+			if tc.statusCode != http.StatusOK {
+				assert.Equal(t, "Jane", user.Name)
+				assert.Equal(t, "Hi", user.Bio)
+
+				return
+			}
+
+			assert.Equal(t, tc.expected.Name, user.Name)
+			assert.Equal(t, tc.expected.AvatarURL, user.AvatarURL)
+			assert.Equal(t, tc.expected.Timezone, user.Timezone)
+			assert.Equal(t, tc.expected.Bio, user.Bio)
+		})
+	}
+}
+
+func TestProfile_ChangeEmail(t *testing.T) {
+	user := &data.User{Name: "Jane", Email: "jane@test.com", Activated: true}
+	taken := &data.User{Name: "John", Email: "john@test.com", Activated: true}
+
+	p := newTestProfile(user, taken)
+	p.audit.events = []audit.Event{{Action: audit.ActionMagicLinkLogin, Actor: user.Email}}
+
+	_, err := p.exports.New(context.Background(), user.Email, time.Hour)
+	require.NoError(t, err)
+
+	request := func(email string) int {
+		recorder := httptest.NewRecorder()
+		p.service.ChangeEmailHandler(recorder, userstest.NewRequest(t, http.MethodPost, "/v1/me/email", user,
+			map[string]string{"email": email}))
+
+		return recorder.Code
+	}
+
+	confirm := func(token string) int {
+		recorder := httptest.NewRecorder()
+		p.service.ConfirmEmailHandler(recorder, userstest.NewRequest(t, http.MethodPut, "/v1/me/email/confirm", nil,
+			map[string]string{"token": token}))
+
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusUnprocessableEntity, request("not-an-email"))
+	assert.Equal(t, http.StatusUnprocessableEntity, request("Jane@test.com"))
+	assert.Equal(t, http.StatusUnprocessableEntity, request("john@test.com"))
+	assert.Empty(t, p.tokens.tokens)
+
+	assert.Equal(t, http.StatusAccepted, request("Jane.Doe@test.com"))
+	assert.Equal(t, "jane.doe@test.com", user.PendingEmail)
+
+	// the new address gets the token, the old one a notice
+	sent := []string{<-p.mailer.sent, <-p.mailer.sent}
+	assert.ElementsMatch(t, []string{"jane.doe@test.com email_change.tmpl", "jane@test.com email_changed.tmpl"}, sent)
+
+	// nothing changes until the new address is confirmed
+	_, err = p.users.GetByEmail("jane@test.com")
+	require.NoError(t, err)
+
+	p.tokens.tokens["reset"] = &auth.ScopedToken{Plaintext: "reset", Email: "jane@test.com", Scope: auth.ScopePasswordReset}
+
+	assert.Equal(t, http.StatusUnprocessableEntity, confirm("ZZZZZZZZZZZZZZZZZZZZZZZZZZ"))
+	assert.Equal(t, http.StatusOK, confirm("ABCDEFGHIJKLMNOPQRSTUVWXYZ"))
+
+	changed, err := p.users.GetByEmail("jane.doe@test.com")
+	require.NoError(t, err)
+	assert.Empty(t, changed.PendingEmail)
+	assert.Equal(t, []string{"jane@test.com"}, p.sessions.deleted)
+	assert.Equal(t, "jane.doe@test.com", p.members.moved["jane@test.com"])
+	assert.Equal(t, "jane.doe@test.com", p.subs.moved["jane@test.com"])
+	assert.Equal(t, "jane.doe@test.com", p.redemptions.moved["jane@test.com"])
+	assert.Empty(t, changed.MovedFrom)
+
+	// everything exported and erased by email follows the account
+	assert.Equal(t, "jane.doe@test.com", p.exports.exports[0].Email)
+	assert.Equal(t, "jane.doe@test.com", p.audit.events[0].Actor)
+	assert.Empty(t, p.tokens.tokens)
+	assert.Equal(t, []string{"jane@test.com"}, p.attempts.deleted)
+
+	// single-use
+	assert.Equal(t, http.StatusUnprocessableEntity, confirm("ABCDEFGHIJKLMNOPQRSTUVWXYZ"))
+}
+
+func TestProfile_ConfirmEmailRetriesMoves(t *testing.T) {
+	user := &data.User{Name: "Jane", Email: "jane@test.com", Activated: true, PendingEmail: "jane.doe@test.com"}
+
+	p := newTestProfile(user)
+	p.subs.moveErr = errors.New("connection reset")
+
+	_, err := p.tokens.New(context.Background(), "jane@test.com", time.Hour, auth.ScopeEmailChange)
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	p.service.ConfirmEmailHandler(recorder, userstest.NewRequest(t, http.MethodPut, "/v1/me/email/confirm", nil,
+		map[string]string{"token": "ABCDEFGHIJKLMNOPQRSTUVWXYZ"}))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	// the old address is kept until everything was moved
+	assert.Equal(t, []string{"jane@test.com"}, user.MovedFrom)
+	assert.Empty(t, p.subs.moved)
+	assert.Zero(t, p.service.MoveChangedEmails(context.Background()))
+	assert.Equal(t, []string{"jane@test.com"}, user.MovedFrom)
+
+	p.subs.moveErr = nil
+
+	assert.Equal(t, 1, p.service.MoveChangedEmails(context.Background()))
+	assert.Empty(t, user.MovedFrom)
+	assert.Equal(t, "jane.doe@test.com", p.subs.moved["jane@test.com"])
+	assert.Zero(t, p.service.MoveChangedEmails(context.Background()))
+}
@@ -0,0 +1,93 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrDeletionInProgress = errors.New("the account is already being deleted")
+
+// ScheduleDeletion marks the account for erasure at the given time.
+func (u *UserRepo) ScheduleDeletion(ctx context.Context, email string, at time.Time) error {
+	res, err := u.collection.UpdateOne(ctx, bson.M{"email": email},
+		bson.M{"$set": bson.M{"deletionScheduledFor": at}})
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// CancelDeletion keeps the account. It fails with ErrDeletionInProgress once
+// the erasure has started.
+func (u *UserRepo) CancelDeletion(ctx context.Context, email string) error {
+	filter := bson.M{
+		"email": email,
+		"$or": bson.A{
+			bson.M{"deletionClaimedUntil": bson.M{"$exists": false}},
+			bson.M{"deletionClaimedUntil": bson.M{"$lt": time.Now()}},
+		},
+	}
+
+	res, err := u.collection.UpdateOne(ctx, filter,
+		bson.M{"$unset": bson.M{"deletionScheduledFor": "", "deletionClaimedUntil": ""}})
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrDeletionInProgress
+	}
+
+	return nil
+}
+
+// ClaimDueDeletion hands out an account whose grace period is over. The claim
+// keeps other instances from erasing the same account for the lease, and
+// lets another instance retry once it ran out, e.g. after a crash.
+func (u *UserRepo) ClaimDueDeletion(ctx context.Context, lease time.Duration) (*User, error) {
+	now := time.Now()
+
+	filter := bson.M{
+		"deletionScheduledFor": bson.M{"$lte": now},
+		"$or": bson.A{
+			bson.M{"deletionClaimedUntil": bson.M{"$exists": false}},
+			bson.M{"deletionClaimedUntil": bson.M{"$lt": now}},
+		},
+	}
+
+	update := bson.M{"$set": bson.M{"deletionClaimedUntil": now.Add(lease)}}
+
+	var user User
+
+	err := u.collection.FindOneAndUpdate(ctx, filter, update,
+		options.FindOneAndUpdate().SetSort(bson.M{"deletionScheduledFor": 1})).Decode(&user)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// Delete removes the user document.
+func (u *UserRepo) Delete(ctx context.Context, email string) error {
+	res, err := u.collection.DeleteOne(ctx, bson.M{"email": email})
+	if err != nil {
+		return err
+	}
+
+	if res.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
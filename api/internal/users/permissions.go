@@ -0,0 +1,52 @@
+package data
+
+const (
+	RoleUser       = "user"
+	RoleInstructor = "instructor"
+)
+
+const (
+	PermissionCoursesWrite = "courses:write"
+	PermissionUsersAdmin   = "users:admin"
+	// act as another user to see what they see
+	PermissionUsersImpersonate = "users:impersonate"
+)
+
+var rolePermissions = map[string][]string{
+	RoleUser:       {},
+	RoleInstructor: {PermissionCoursesWrite},
+	RoleAdmin:      {PermissionCoursesWrite, PermissionUsersAdmin, PermissionUsersImpersonate},
+}
+
+// ValidRole reports whether the role can be assigned to a user.
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+
+	return ok
+}
+
+// ValidPermission reports whether any role grants the permission.
+func ValidPermission(permission string) bool {
+	return contains(rolePermissions[RoleAdmin], permission)
+}
+
+// HasPermission reports whether the user's role, or the API key of a service,
+// grants the permission. Users created before roles were assigned have an
+// empty role and no permissions.
+func (u *User) HasPermission(permission string) bool {
+	if u.IsService() {
+		return contains(u.Service.Permissions, permission)
+	}
+
+	return contains(rolePermissions[u.Role], permission)
+}
+
+func contains(permissions []string, permission string) bool {
+	for _, p := range permissions {
+		if p == permission {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,214 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	validator "interviews/pkg/vaildator"
+)
+
+var ErrNoPendingEmail = errors.New("no email change pending")
+
+// PublicUser is what the API returns about a user. It never contains
+// credentials or second factor secrets.
+type PublicUser struct {
+	Name         string     `json:"name"`
+	Email        string     `json:"email"`
+	PendingEmail string     `json:"pending_email,omitempty"`
+	AvatarURL    string     `json:"avatar_url"`
+	Timezone     string     `json:"timezone"`
+	Bio          string     `json:"bio"`
+	Role         string     `json:"role"`
+	Activated    bool       `json:"activated"`
+	TOTPEnabled  bool       `json:"totp_enabled"`
+	Identities   []Identity `json:"identities"`
+	CreatedAt    time.Time  `json:"created_at"`
+
+	DeletionScheduledFor *time.Time `json:"deletion_scheduled_for,omitempty"`
+}
+
+func (u *User) Public() PublicUser {
+	identities := u.Identities
+	if identities == nil {
+		identities = []Identity{}
+	}
+
+	public := PublicUser{
+		Name:         u.Name,
+		Email:        u.Email,
+		PendingEmail: u.PendingEmail,
+		AvatarURL:    u.AvatarURL,
+		Timezone:     u.Timezone,
+		Bio:          u.Bio,
+		Role:         u.Role,
+		Activated:    u.Activated,
+		TOTPEnabled:  u.TOTPEnabled,
+		Identities:   identities,
+		CreatedAt:    u.CreatedAt,
+	}
+
+	if !u.DeletionScheduledFor.IsZero() {
+		public.DeletionScheduledFor = &u.DeletionScheduledFor
+	}
+
+	return public
+}
+
+// UpdateProfile stores the fields the user can edit themselves.
+func (u *UserRepo) UpdateProfile(ctx context.Context, user *User) error {
+	update := bson.M{
+		"$set": bson.M{
+			"name":      user.Name,
+			"avatarUrl": user.AvatarURL,
+			"timezone":  user.Timezone,
+			"bio":       user.Bio,
+		},
+	}
+
+	res, err := u.collection.UpdateOne(ctx, bson.M{"email": user.Email}, update)
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// SetPendingEmail remembers the address the user wants to switch to until they
+// confirmed it. A newer request replaces an older one.
+func (u *UserRepo) SetPendingEmail(ctx context.Context, email, pending string) error {
+	res, err := u.collection.UpdateOne(ctx, bson.M{"email": email}, bson.M{"$set": bson.M{"pendingEmail": pending}})
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// ConfirmEmail switches the user to the pending address and revokes every
+// access token, since those still carry the old one. The old address is kept
+// in MovedFrom until EmailMoved is called for it. It returns the new address.
+func (u *UserRepo) ConfirmEmail(ctx context.Context, email string) (string, error) {
+	var user User
+
+	err := u.collection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return "", err
+	}
+
+	if user.PendingEmail == "" {
+		return "", ErrNoPendingEmail
+	}
+
+	count, err := u.collection.CountDocuments(ctx, bson.M{"email": user.PendingEmail})
+	if err != nil {
+		return "", err
+	}
+
+	if count > 0 {
+		return "", ErrDuplicateEmail
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"email":             user.PendingEmail,
+			"sessionsRevokedAt": time.Now(),
+		},
+		"$unset": bson.M{
+			"pendingEmail": "",
+		},
+		"$addToSet": bson.M{
+			"movedFrom": email,
+		},
+	}
+
+	// the filter on the pending address fails the switch if it was changed
+	// in the meantime
+	res, err := u.collection.UpdateOne(ctx, bson.M{"email": email, "pendingEmail": user.PendingEmail}, update)
+	if err != nil {
+		return "", err
+	}
+
+	if res.MatchedCount == 0 {
+		return "", ErrNoPendingEmail
+	}
+
+	return user.PendingEmail, nil
+}
+
+// ListEmailMoves returns the users whose records weren't moved to their new
+// address yet.
+func (u *UserRepo) ListEmailMoves(ctx context.Context) ([]User, error) {
+	cursor, err := u.collection.Find(ctx, bson.M{"movedFrom.0": bson.M{"$exists": true}})
+	if err != nil {
+		return nil, err
+	}
+
+	users := []User{}
+
+	err = cursor.All(ctx, &users)
+	if err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// EmailMoved records that every record of the old address was moved to the
+// user's current one.
+func (u *UserRepo) EmailMoved(ctx context.Context, email, from string) error {
+	res, err := u.collection.UpdateOne(ctx, bson.M{"email": email}, bson.M{"$pull": bson.M{"movedFrom": from}})
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// PurgePlaintextPasswords removes the plaintext passwords older versions stored
+// next to the hash.
+func (u *UserRepo) PurgePlaintextPasswords(ctx context.Context) (int64, error) {
+	res, err := u.collection.UpdateMany(ctx,
+		bson.M{"passPlain": bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{"passPlain": ""}})
+	if err != nil {
+		return 0, err
+	}
+
+	return res.ModifiedCount, nil
+}
+
+func (u *UserRepo) ValidateProfile(v *validator.Validator, user *User) {
+	v.Check(user.Name != "", "name", "must be provided")
+	v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes long")
+
+	if user.AvatarURL != "" {
+		avatar, err := url.Parse(user.AvatarURL)
+		v.Check(err == nil && avatar.Host != "" && (avatar.Scheme == "https" || avatar.Scheme == "http"),
+			"avatar_url", "must be a valid http or https URL")
+		v.Check(len(user.AvatarURL) <= 2048, "avatar_url", "must not be more than 2048 bytes long")
+	}
+
+	if user.Timezone != "" {
+		_, err := time.LoadLocation(user.Timezone)
+		v.Check(err == nil && user.Timezone != "Local", "timezone", "must be an IANA time zone, e.g. Europe/Berlin")
+	}
+
+	v.Check(utf8.RuneCountInString(user.Bio) <= 1000, "bio", "must not be more than 1000 characters long")
+}
@@ -9,7 +9,12 @@ type UsersContext struct {
 }
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey        = contextKey("user")
+	authMethodsContextKey = contextKey("authMethods")
+	sessionContextKey     = contextKey("session")
+	actorContextKey       = contextKey("actor")
+)
 
 func (m *UsersContext) ContextSetUser(r *http.Request, user *User) *http.Request {
 	ctx := context.WithValue(r.Context(), userContextKey, user)
@@ -24,3 +29,53 @@ func (m *UsersContext) ContextGetUser(r *http.Request) *User {
 
 	return user
 }
+
+// ContextSetAuthMethods stores the amr claim of the access token so handlers
+// can tell how the user authenticated.
+func (m *UsersContext) ContextSetAuthMethods(r *http.Request, methods []string) *http.Request {
+	ctx := context.WithValue(r.Context(), authMethodsContextKey, methods)
+	return r.WithContext(ctx)
+}
+
+func (m *UsersContext) ContextGetAuthMethods(r *http.Request) []string {
+	methods, _ := r.Context().Value(authMethodsContextKey).([]string)
+
+	return methods
+}
+
+// ContextSetSessionID stores the session of the access token so handlers can
+// tell it apart from the user's other sessions.
+func (m *UsersContext) ContextSetSessionID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), sessionContextKey, id)
+	return r.WithContext(ctx)
+}
+
+func (m *UsersContext) ContextGetSessionID(r *http.Request) string {
+	id, _ := r.Context().Value(sessionContextKey).(string)
+
+	return id
+}
+
+// ContextSetActor stores the admin behind an impersonation token. The user of
+// the context stays the impersonated one, so handlers act as them.
+func (m *UsersContext) ContextSetActor(r *http.Request, actor *User) *http.Request {
+	ctx := context.WithValue(r.Context(), actorContextKey, actor)
+	return r.WithContext(ctx)
+}
+
+// ContextGetActor returns the admin acting as the user, or nil when the
+// request isn't impersonated.
+func (m *UsersContext) ContextGetActor(r *http.Request) *User {
+	actor, _ := r.Context().Value(actorContextKey).(*User)
+
+	return actor
+}
+
+// ContextGetRealUser returns who is really making the request.
+func (m *UsersContext) ContextGetRealUser(r *http.Request) *User {
+	if actor := m.ContextGetActor(r); actor != nil {
+		return actor
+	}
+
+	return m.ContextGetUser(r)
+}
@@ -0,0 +1,161 @@
+package data
+
+import (
+	"context"
+	"math"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	validator "interviews/pkg/vaildator"
+)
+
+const MaxPageSize = 100
+
+// UserFilter narrows down the user list of the admin API. Zero values don't
+// filter.
+type UserFilter struct {
+	// matches part of the email or name, ignoring case
+	Search        string
+	Role          string
+	Activated     *bool
+	Suspended     *bool
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Page          int
+	PageSize      int
+}
+
+type Metadata struct {
+	CurrentPage  int   `json:"current_page,omitempty"`
+	PageSize     int   `json:"page_size,omitempty"`
+	LastPage     int   `json:"last_page,omitempty"`
+	TotalRecords int64 `json:"total_records"`
+}
+
+func (f UserFilter) query() bson.M {
+	query := bson.M{}
+
+	if f.Search != "" {
+		search := bson.M{"$regex": regexp.QuoteMeta(f.Search), "$options": "i"}
+		query["$or"] = bson.A{bson.M{"email": search}, bson.M{"name": search}}
+	}
+
+	if f.Role != "" {
+		query["role"] = f.Role
+	}
+
+	if f.Activated != nil {
+		query["activated"] = *f.Activated
+	}
+
+	// users stored before suspension existed have no field
+	if f.Suspended != nil {
+		if *f.Suspended {
+			query["suspended"] = true
+		} else {
+			query["suspended"] = bson.M{"$ne": true}
+		}
+	}
+
+	created := bson.M{}
+
+	if !f.CreatedAfter.IsZero() {
+		created["$gte"] = f.CreatedAfter
+	}
+
+	if !f.CreatedBefore.IsZero() {
+		created["$lt"] = f.CreatedBefore
+	}
+
+	if len(created) > 0 {
+		query["createdAt"] = created
+	}
+
+	return query
+}
+
+// List returns a page of the users matching the filter, newest first.
+func (u *UserRepo) List(ctx context.Context, filter UserFilter) ([]User, Metadata, error) {
+	query := filter.query()
+
+	total, err := u.collection.CountDocuments(ctx, query)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "email", Value: 1}}).
+		SetSkip(int64((filter.Page - 1) * filter.PageSize)).
+		SetLimit(int64(filter.PageSize))
+
+	cursor, err := u.collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	users := []User{}
+
+	err = cursor.All(ctx, &users)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := Metadata{TotalRecords: total}
+
+	if total > 0 {
+		metadata.CurrentPage = filter.Page
+		metadata.PageSize = filter.PageSize
+		metadata.LastPage = int(math.Ceil(float64(total) / float64(filter.PageSize)))
+	}
+
+	return users, metadata, nil
+}
+
+// SetSuspended suspends or reinstates the user. Suspending doesn't revoke the
+// sessions, so reinstating the user lets them carry on where they were.
+func (u *UserRepo) SetSuspended(ctx context.Context, email string, suspended bool, reason string) error {
+	update := bson.M{
+		"$set": bson.M{
+			"suspended":       true,
+			"suspendedAt":     time.Now(),
+			"suspendedReason": reason,
+		},
+	}
+
+	if !suspended {
+		update = bson.M{
+			"$set":   bson.M{"suspended": false},
+			"$unset": bson.M{"suspendedAt": "", "suspendedReason": ""},
+		}
+	}
+
+	res, err := u.collection.UpdateOne(ctx, bson.M{"email": email}, update)
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+func ValidateUserFilter(v *validator.Validator, f UserFilter) {
+	v.Check(f.Page > 0, "page", "must be greater than zero")
+	v.Check(f.Page <= 10_000_000, "page", "must be a maximum of 10 million")
+	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
+	v.Check(f.PageSize <= MaxPageSize, "page_size", "must be a maximum of 100")
+	v.Check(len(f.Search) <= 200, "search", "must not be more than 200 bytes long")
+
+	if f.Role != "" {
+		v.Check(ValidRole(f.Role), "role", "must be one of user, instructor or admin")
+	}
+
+	if !f.CreatedAfter.IsZero() && !f.CreatedBefore.IsZero() {
+		v.Check(f.CreatedAfter.Before(f.CreatedBefore), "created_after", "must be before created_before")
+	}
+}
@@ -3,13 +3,10 @@ package data
 import (
 	"context"
 	"errors"
-	"github.com/dgrijalva/jwt-go"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	validator "interviews/pkg/vaildator"
 	"time"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
 var (
@@ -17,6 +14,8 @@ var (
 	ErrInvalidEmail   = errors.New("invalid email")
 )
 
+const RoleAdmin = "admin"
+
 var AnonymousUser = &User{}
 
 type User struct {
@@ -24,38 +23,79 @@ type User struct {
 	CreatedAt time.Time `json:"created_at" bson:"createdAt"`
 	Name      string    `json:"name" bson:"name"`
 	Email     string    `json:"email" bson:"email"`
-	PassPlain string    `json:"passPlain" bson:"passPlain"`
-	PassHash  string    `json:"passHash" bson:"passHash"`
+	PassHash  string    `json:"-" bson:"passHash"`
 	Role      string    `json:"role" bson:"role"`
 	Activated bool      `json:"activated" bson:"activated"`
 	Version   int       `json:"-" bson:"version"`
+
+	AvatarURL string `json:"avatar_url" bson:"avatarUrl"`
+	Timezone  string `json:"timezone" bson:"timezone"`
+	Bio       string `json:"bio" bson:"bio"`
+	// address the user wants to switch to once they confirmed they own it
+	PendingEmail string `json:"-" bson:"pendingEmail"`
+	// old addresses whose memberships, subscriptions and redemptions still
+	// have to be moved to this one after an email change
+	MovedFrom []string `json:"-" bson:"movedFrom,omitempty"`
+	// the account and its data are erased after this time unless the user
+	// changes their mind
+	DeletionScheduledFor time.Time `json:"-" bson:"deletionScheduledFor,omitempty"`
+
+	// a suspended user can't log in and every request with their token is rejected
+	Suspended       bool      `json:"suspended" bson:"suspended"`
+	SuspendedAt     time.Time `json:"suspended_at,omitempty" bson:"suspendedAt,omitempty"`
+	SuspendedReason string    `json:"suspended_reason,omitempty" bson:"suspendedReason,omitempty"`
+	// access tokens issued before this time are rejected, e.g. after a password reset
+	SessionsRevokedAt time.Time `json:"-" bson:"sessionsRevokedAt"`
+
+	TOTPEnabled       bool   `json:"totp_enabled" bson:"totpEnabled"`
+	TOTPSecret        string `json:"-" bson:"totpSecret"`
+	TOTPPendingSecret string `json:"-" bson:"totpPendingSecret"`
+	// time step of the last accepted code so a code can't be used twice
+	TOTPLastStep  int64    `json:"-" bson:"totpLastStep"`
+	RecoveryCodes []string `json:"-" bson:"recoveryCodes"`
+
+	// accounts at external identity providers the user can sign in with
+	Identities []Identity `json:"identities" bson:"identities"`
+
+	// set when the request is authenticated with an API key instead of a login
+	Service *ServicePrincipal `json:"-" bson:"-"`
+}
+
+// ServicePrincipal is an internal service calling the API with an API key. It
+// only has the permissions the key was created with.
+type ServicePrincipal struct {
+	KeyID       string
+	Name        string
+	Permissions []string
+}
+
+type Identity struct {
+	Provider string    `json:"provider" bson:"provider"`
+	Subject  string    `json:"-" bson:"subject"`
+	Email    string    `json:"email" bson:"email"`
+	LinkedAt time.Time `json:"linked_at" bson:"linkedAt"`
 }
 
 type UserRepo struct {
 	db         *mongo.Client
 	collection *mongo.Collection
-	tokenRepo  TokenRepository
-	valid      *validator.Validator
-}
-
-type TokenRepository interface {
-	ValidateBearerToken(bearerToken string) (*jwt.Token, error)
 }
 
 func (u *User) IsAnonymous() bool {
 	return u == AnonymousUser
 }
 
-type Password struct {
-	Plaintext *string
-	Hash      []byte
+func (u *User) IsService() bool {
+	return u.Service != nil
 }
 
 func (u *UserRepo) GetByEmail(email string) (*User, error) {
 	ctx := context.Background()
 
-	u.ValidateEmail(u.valid, email)
-	if !u.valid.Valid() {
+	v := validator.New()
+
+	u.ValidateEmail(v, email)
+	if !v.Valid() {
 		return &User{}, ErrInvalidEmail
 	}
 
@@ -74,7 +114,6 @@ func (u *UserRepo) Update(ctx context.Context, user *User) error {
 		"$set": bson.M{
 			"name":      user.Name,
 			"email":     user.Email,
-			"passPlain": user.PassPlain,
 			"passHash":  user.PassHash,
 			"role":      user.Role,
 			"activated": user.Activated,
@@ -109,32 +148,6 @@ func (u *UserRepo) UpdateRole(ctx context.Context, user *User) error {
 	return nil
 }
 
-func (p *Password) Set(plaintextPassword string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
-	if err != nil {
-		return err
-	}
-
-	p.Plaintext = &plaintextPassword
-	p.Hash = hash
-
-	return nil
-}
-
-func (p *Password) Matches(plaintextPassword string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword(p.Hash, []byte(plaintextPassword))
-	if err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			return false, nil
-		default:
-			return false, err
-		}
-	}
-
-	return true, nil
-}
-
 func (u *UserRepo) ValidateEmail(v *validator.Validator, email string) {
 	v.Check(email != "", "email", "must be provided")
 	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
@@ -151,19 +164,11 @@ func (u *UserRepo) ValidateUser(v *validator.Validator, user *User) {
 	v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes long")
 
 	u.ValidateEmail(v, user.Email)
-
-	if user.PassPlain != "" {
-		u.ValidatePasswordPlaintext(v, user.PassPlain)
-	}
-
 }
 
-func NewUserRepository(client *mongo.Client, collection *mongo.Collection,
-	tokenRepo TokenRepository) *UserRepo {
+func NewUserRepository(client *mongo.Client, collection *mongo.Collection) *UserRepo {
 	return &UserRepo{
 		db:         client,
 		collection: collection,
-		tokenRepo:  tokenRepo,
-		valid:      validator.New(),
 	}
 }
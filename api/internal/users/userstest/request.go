@@ -0,0 +1,31 @@
+// Package userstest helps testing handlers that act on behalf of a user.
+package userstest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	data "interviews/internal/users"
+)
+
+// NewRequest returns a request with body encoded as JSON, made by user. A nil
+// user leaves the request without one, like before authentication.
+func NewRequest(t *testing.T, method, target string, user *data.User, body any) *http.Request {
+	t.Helper()
+
+	requestBodyBytes, err := json.Marshal(body)
+	require.NoError(t, err)
+
+	req, _ := http.NewRequest(method, target, bytes.NewReader(requestBodyBytes))
+
+	if user == nil {
+		return req
+	}
+
+	userCtx := data.UsersContext{}
+
+	return userCtx.ContextSetUser(req, user)
+}
@@ -3,7 +3,10 @@ package pkg
 import (
 	"fmt"
 	clogger "interviews/pkg/logger"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 type CustomErrors struct {
@@ -37,7 +40,7 @@ func (e *CustomErrors) ServerErrorResponse(w http.ResponseWriter, r *http.Reques
 	e.errorResponse(w, r, http.StatusInternalServerError, message)
 }
 
-func (e *CustomErrors) notFoundResponse(w http.ResponseWriter, r *http.Request) {
+func (e *CustomErrors) NotFoundResponse(w http.ResponseWriter, r *http.Request) {
 	message := "the requested resource could not be found"
 	e.errorResponse(w, r, http.StatusNotFound, message)
 }
@@ -47,11 +50,11 @@ func (e *CustomErrors) methodNotAllowedResponse(w http.ResponseWriter, r *http.R
 	e.errorResponse(w, r, http.StatusMethodNotAllowed, message)
 }
 
-func (e *CustomErrors) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
+func (e *CustomErrors) BadRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
 	e.errorResponse(w, r, http.StatusBadRequest, err.Error())
 }
 
-func (e *CustomErrors) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
+func (e *CustomErrors) FailedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
 	e.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
 }
 
@@ -65,11 +68,18 @@ func (e *CustomErrors) RateLimitExceededResponse(w http.ResponseWriter, r *http.
 	e.errorResponse(w, r, http.StatusTooManyRequests, message)
 }
 
-func (e *CustomErrors) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
+func (e *CustomErrors) InvalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
 	message := "invalid authentication credentials"
 	e.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+func (e *CustomErrors) LoginThrottledResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+
+	message := "too many failed login attempts, please try again later"
+	e.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
 func (e *CustomErrors) InvalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("WWW-Authenticate", "Bearer")
 
@@ -87,7 +97,27 @@ func (e *CustomErrors) InactiveAccountResponse(w http.ResponseWriter, r *http.Re
 	e.errorResponse(w, r, http.StatusForbidden, message)
 }
 
-func (e *CustomErrors) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
+func (e *CustomErrors) AccountSuspendedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account has been suspended, please contact support"
+	e.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (e *CustomErrors) NotPermittedResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your user account doesn't have the necessary permissions to access this resource"
 	e.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+func (e *CustomErrors) TwoFactorRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account must sign in with two-factor authentication to access this resource"
+	e.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (e *CustomErrors) InvalidCSRFTokenResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid or missing CSRF token"
+	e.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (e *CustomErrors) ImpersonationNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this action is not available while impersonating a user"
+	e.errorResponse(w, r, http.StatusForbidden, message)
+}